@@ -1,10 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 
+	"www.velocidex.com/golang/cloudvelo/ingestion"
 	"www.velocidex.com/golang/cloudvelo/schema"
 	"www.velocidex.com/golang/cloudvelo/services"
+	"www.velocidex.com/golang/velociraptor/json"
 )
 
 var (
@@ -22,8 +27,129 @@ var (
 
 	elastic_command_reset_filter = elastic_command_reset.Arg(
 		"index_filter", "If specified only re-create these indexes").String()
+
+	elastic_command_info = elastic_command.Command(
+		"info", "Show the effective elastic client configuration")
+
+	elastic_command_forcemerge = elastic_command.Command(
+		"forcemerge", "Merge index segments after a bulk load (expensive!)")
+
+	elastic_command_forcemerge_index = elastic_command_forcemerge.Arg(
+		"index", "The index to merge").Required().String()
+
+	elastic_command_forcemerge_max_segments = elastic_command_forcemerge.Flag(
+		"max_segments", "Merge down to this many segments per shard").
+		Default("1").Int()
+
+	elastic_command_ingest = elastic_command.Command(
+		"ingest", "Bulk load NDJSON documents directly into an index")
+
+	elastic_command_ingest_index = elastic_command_ingest.Arg(
+		"index", "The index to load into (e.g. persisted)").Required().String()
+
+	elastic_command_ingest_file = elastic_command_ingest.Arg(
+		"file", "The NDJSON file to load (- for stdin)").Required().String()
 )
 
+func doElasticIngest() error {
+	config_obj, err := loadConfig(makeDefaultConfigLoader())
+	if err != nil {
+		return fmt.Errorf("loading config file: %w", err)
+	}
+
+	if *elastic_command_reset_org_id == "" {
+		return errors.New("An --org_id is required")
+	}
+
+	ctx, cancel := install_sig_handler()
+	defer cancel()
+
+	err = services.StartElasticSearchService(ctx, config_obj)
+	if err != nil {
+		return err
+	}
+
+	wg := &sync.WaitGroup{}
+	err = services.StartBulkIndexService(ctx, wg, config_obj)
+	if err != nil {
+		return err
+	}
+
+	reader := os.Stdin
+	if *elastic_command_ingest_file != "-" {
+		reader, err = os.Open(*elastic_command_ingest_file)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+	}
+
+	result, err := ingestion.IngestNDJSON(ctx, *elastic_command_reset_org_id,
+		*elastic_command_ingest_index, reader)
+	if err != nil {
+		return err
+	}
+
+	err = services.FlushBulkIndexer()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(result.String())
+	for line, err := range result.Errors {
+		fmt.Printf("Line %v: %v\n", line, err)
+	}
+	return nil
+}
+
+func doElasticForceMerge() error {
+	config_obj, err := loadConfig(makeDefaultConfigLoader())
+	if err != nil {
+		return fmt.Errorf("loading config file: %w", err)
+	}
+
+	ctx, cancel := install_sig_handler()
+	defer cancel()
+
+	err = services.StartElasticSearchService(ctx, config_obj)
+	if err != nil {
+		return err
+	}
+
+	stats, err := services.ForceMergeWithStats(ctx,
+		*elastic_command_forcemerge_index,
+		*elastic_command_forcemerge_max_segments)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(json.MustMarshalIndent(stats)))
+	return nil
+}
+
+func doElasticInfo() error {
+	config_obj, err := loadConfig(makeDefaultConfigLoader())
+	if err != nil {
+		return fmt.Errorf("loading config file: %w", err)
+	}
+
+	ctx, cancel := install_sig_handler()
+	defer cancel()
+
+	err = services.StartElasticSearchService(ctx, config_obj)
+	if err != nil {
+		return err
+	}
+
+	info, err := services.GetClientInfo()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(json.MustMarshalIndent(info)))
+	return nil
+}
+
 func doResetElastic() error {
 	config_obj, err := loadConfig(makeDefaultConfigLoader())
 	if err != nil {
@@ -62,6 +188,21 @@ func init() {
 			FatalIfError(elastic_command_reset, doResetElastic)
 			return true
 		}
+
+		if command == elastic_command_info.FullCommand() {
+			FatalIfError(elastic_command_info, doElasticInfo)
+			return true
+		}
+
+		if command == elastic_command_forcemerge.FullCommand() {
+			FatalIfError(elastic_command_forcemerge, doElasticForceMerge)
+			return true
+		}
+
+		if command == elastic_command_ingest.FullCommand() {
+			FatalIfError(elastic_command_ingest, doElasticIngest)
+			return true
+		}
 		return false
 	})
 }
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	crypto_server "www.velocidex.com/golang/cloudvelo/crypto/server"
 
@@ -16,13 +18,14 @@ var (
 )
 
 func makeElasticBackend(
+	ctx context.Context, wg *sync.WaitGroup,
 	config_obj *config.Config,
 	crypto_manager *crypto_server.ServerCryptoManager) (server.CommunicatorBackend, error) {
 
 	if *communicator_mock {
 		return server.NewMockElasticBackend(config_obj)
 	}
-	return server.NewElasticBackend(config_obj, crypto_manager)
+	return server.NewPooledElasticBackend(ctx, wg, config_obj, crypto_manager)
 }
 
 func doCommunicator() error {
@@ -47,7 +50,8 @@ func doCommunicator() error {
 		return err
 	}
 
-	backend, err = makeElasticBackend(config_obj, crypto_manager)
+	backend, err = makeElasticBackend(
+		sm.Ctx, sm.Wg, config_obj, crypto_manager)
 	if err != nil {
 		return err
 	}
@@ -18,6 +18,60 @@ type Tool struct {
 	URL  string `json:"url"`
 }
 
+// Client event artifacts which represent state (e.g. the latest
+// value per key) rather than a stream of events. Rows from these
+// artifacts are upserted using the value of KeyField so only the
+// latest state is kept for each key.
+type MonitoringUpsertArtifact struct {
+	Artifact string `json:"artifact"`
+	KeyField string `json:"key_field"`
+
+	// If set, state which is not updated for this long expires and
+	// is removed by the expiry sweeper (if expiry_sweep_seconds is
+	// set).
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+// A remote cluster (e.g. an archive cluster) which may be searched
+// together with the local cluster.
+type RemoteCluster struct {
+	Name  string   `json:"name"`
+	Seeds []string `json:"seeds"`
+}
+
+// An ingest pipeline installed on the cluster at startup.
+type IngestPipeline struct {
+	Name string `json:"name"`
+
+	// The pipeline definition, e.g. {"processors": [...]}
+	Definition json.RawMessage `json:"definition"`
+}
+
+// A storage policy for the indexes behind rollover targets (data
+// streams or write aliases). An index is written while it is the
+// write index (hot), is rolled over once it grows too old or large,
+// is then force merged and made read only, and is finally deleted
+// once it is older than the retention period.
+type IndexLifecyclePolicy struct {
+	// The data streams or write aliases the policy applies to,
+	// e.g. "*transient" for the transient index of every org.
+	Pattern string `json:"pattern"`
+
+	// Roll over once the write index reaches any of these (0
+	// disables the threshold).
+	RolloverMaxAgeSeconds int   `json:"rollover_max_age_seconds"`
+	RolloverMaxDocs       int64 `json:"rollover_max_docs"`
+	RolloverMaxSizeBytes  int64 `json:"rollover_max_size_bytes"`
+
+	// Rolled over indexes are merged down to this many segments
+	// (default 1).
+	ForceMergeSegments int `json:"force_merge_segments"`
+
+	// Delete indexes this long after they were created. If 0
+	// indexes are kept forever.
+	RetentionSeconds int `json:"retention_seconds"`
+}
+
 type ElasticConfiguration struct {
 	Username           string   `json:"username"`
 	Password           string   `json:"password"`
@@ -41,7 +95,149 @@ type ElasticConfiguration struct {
 
 	ForemanIntervalSeconds int `json:"foreman_interval_seconds"`
 
+	// Number of workers used to process incoming messages. Messages
+	// for the same client session are always processed in order. If
+	// 0 messages are processed inline.
+	IngestionWorkers int `json:"ingestion_workers"`
+
 	ApprovedTools []Tool `json:"approved_tools"`
+
+	MonitoringUpsertArtifacts []MonitoringUpsertArtifact `json:"monitoring_upsert_artifacts"`
+
+	// A retry budget shared by all operations. At most RetryBudget
+	// retries may be issued in a burst, refilled at
+	// RetryBudgetPerSecond (defaults 100 and 10).
+	RetryBudget          int `json:"retry_budget"`
+	RetryBudgetPerSecond int `json:"retry_budget_per_second"`
+
+	// The hash used to derive document IDs: sha1 (default), sha256
+	// or xxhash. Changing this on an existing deployment changes the
+	// IDs of existing documents so they will no longer be found or
+	// updated - only change it on a fresh deployment or after
+	// reindexing. xxhash IDs are only 64 bits and may collide, which
+	// silently replaces a document with another. Retransmitted
+	// results are not deduplicated with xxhash.
+	IdStrategy string `json:"id_strategy"`
+
+	// When set, writes fail immediately while the cluster health is
+	// red instead of being attempted and retried. The health is
+	// polled every ClusterHealthPollSeconds (default 10).
+	RejectWritesWhenRed      bool `json:"reject_writes_when_red"`
+	ClusterHealthPollSeconds int  `json:"cluster_health_poll_seconds"`
+
+	// Ping the cluster health every KeepaliveSeconds so idle
+	// connections stay warm and an unreachable cluster is noticed
+	// before the next query. Shares the health poller, which then
+	// runs at the shorter of the two periods. Disabled if 0.
+	KeepaliveSeconds int `json:"keepalive_seconds"`
+
+	// Remote clusters to register for cross cluster search. Searches
+	// only include these when they opt in.
+	RemoteClusters []RemoteCluster `json:"remote_clusters"`
+
+	// A unique field appended as the final sort key when paging
+	// through results so pages never skip or repeat documents with
+	// the same sort value (default _id).
+	SortTiebreakerField string `json:"sort_tiebreaker_field"`
+
+	// How long to wait for an index refresh before giving up with a
+	// timeout error (default 30).
+	RefreshTimeoutSeconds int `json:"refresh_timeout_seconds"`
+
+	// Override how long operations (by metric name, e.g.
+	// GetElasticRecord or UpdateIndex) may take in seconds when the
+	// caller did not set a deadline. Reads default to 30, writes to
+	// 60 and queries to 120.
+	OperationTimeoutSeconds map[string]int `json:"operation_timeout_seconds"`
+
+	// Buffered bulk writes which still can not be flushed after
+	// retrying for 30 seconds on shutdown are saved to this file and
+	// replayed on the next start. If not set they are lost.
+	BulkSpillFile string `json:"bulk_spill_file"`
+
+	// Remove documents whose expiry time passed every
+	// ExpirySweepSeconds (e.g. 600). This runs a delete by query
+	// over the persisted and transient indexes of all orgs. Disabled
+	// if 0, so expired documents are kept.
+	ExpirySweepSeconds int `json:"expiry_sweep_seconds"`
+
+	// Override ignore_above for keyword fields (by dotted field
+	// name). Longer values are not indexed so term queries can not
+	// find them. Existing indexes are updated on startup, but this
+	// only affects documents indexed from then on.
+	KeywordIgnoreAbove map[string]int `json:"keyword_ignore_above"`
+
+	// Bulk indexing errors are logged for the first
+	// BulkErrorLogFirst errors in each BulkErrorSummarySeconds, then
+	// one in every BulkErrorLogEvery (defaults 10, 100 and 60). The
+	// number of errors not logged is summarized at the end of each
+	// period.
+	BulkErrorLogFirst       int `json:"bulk_error_log_first"`
+	BulkErrorLogEvery       int `json:"bulk_error_log_every"`
+	BulkErrorSummarySeconds int `json:"bulk_error_summary_seconds"`
+
+	// Flush the bulk indexer as soon as this many documents are
+	// buffered for any one index, even if they are too small to
+	// reach the size threshold. 0 only flushes on size and time.
+	BulkFlushDocuments int `json:"bulk_flush_documents"`
+
+	// Recount the flows of running hunts every
+	// HuntStatsReconcileSeconds and correct hunt stats which have
+	// drifted. At most HuntStatsReconcileMaxHunts hunts are checked
+	// in each org per run (default 100), the next run continues with
+	// the following hunts. Disabled if 0.
+	HuntStatsReconcileSeconds  int `json:"hunt_stats_reconcile_seconds"`
+	HuntStatsReconcileMaxHunts int `json:"hunt_stats_reconcile_max_hunts"`
+
+	// Every ArchivedHuntCompactSeconds move hunts archived
+	// more than ArchivedHuntRetentionSeconds ago (default 30 days)
+	// from the persisted index to the archived_hunts index so they
+	// are not scanned when listing hunts. Disabled if 0.
+	ArchivedHuntCompactSeconds   int `json:"archived_hunt_compact_seconds"`
+	ArchivedHuntRetentionSeconds int `json:"archived_hunt_retention_seconds"`
+
+	// Path to a MaxMind GeoIP2/GeoLite2 City database. If set, IP
+	// addresses in client event rows are resolved and stored in the
+	// geo field so they can be searched by location. Only the
+	// GeoIPFields columns are checked (default all columns).
+	GeoIPDatabase string   `json:"geoip_database"`
+	GeoIPFields   []string `json:"geoip_fields"`
+
+	// Remove these top level fields from collected rows before
+	// they are stored (e.g. to drop sensitive data).
+	RedactFields []string `json:"redact_fields"`
+
+	// Apply these policies to the matching indexes every
+	// IndexLifecycleSeconds (default 3600).
+	IndexLifecyclePolicies []IndexLifecyclePolicy `json:"index_lifecycle_policies"`
+	IndexLifecycleSeconds  int                    `json:"index_lifecycle_seconds"`
+
+	// Documents written for an org id which does not make a valid
+	// index name are stored in this index (with the original org
+	// id) instead of failing, so they can be triaged later.
+	QuarantineIndex string `json:"quarantine_index"`
+
+	// The number of documents deleted in each bulk request when
+	// deleting many documents by id (default 1000).
+	DeleteBatchSize int `json:"delete_batch_size"`
+
+	// Documents of these doc types are written to an index per time
+	// bucket (day, month or year) of their timestamp instead of the
+	// shared index, e.g. "monitoring: month" writes client event
+	// rows to <org>_monitoring-2024.06. Retention is then a cheap
+	// index delete.
+	TimeSeriesIndexes map[string]string `json:"time_series_indexes"`
+
+	// Ingest pipelines to install (or update) at startup so parsing
+	// and enrichment (e.g. grok or date parsing) can be done by the
+	// cluster for writes which ask for them.
+	IngestPipelines []IngestPipeline `json:"ingest_pipelines"`
+
+	// Important writes (e.g. hunt state changes) wait until this
+	// many shard copies are active ("all" or a number) so they are
+	// not only made on the primary in a degraded cluster. Empty
+	// (the default) does not wait.
+	WaitForActiveShards string `json:"wait_for_active_shards"`
 }
 
 // Create a new cloud config object which contains the original
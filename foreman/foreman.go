@@ -556,7 +556,9 @@ func (self Foreman) GetActiveHunts(
 	var result []*api_proto.Hunt
 
 	err = cvelo_services.ApplyFuncOnHuntsWithOptions(hunt_dispatcher, ctx,
-		cvelo_services.OnlyRunningHunts,
+		cvelo_services.HuntSearchOptions{
+			Filter: cvelo_services.OnlyRunningHunts,
+		},
 		func(hunt *api_proto.Hunt) error {
 
 			// Check if the hunt is expired and stop it if it is
@@ -153,8 +153,8 @@ func (self *Plan) ExecuteClientMonitoringUpdate(
 		// Update all the client records to the latest event table
 		// version
 		for _, client_id := range clients {
-			cvelo_services.SetElasticIndexAsync(
-				org_config_obj.OrgId,
+			cvelo_services.SetElasticIndexAsync(ctx,
+				utils.NormalizedOrgId(org_config_obj.OrgId),
 				"persisted", client_id+"_last_event_version",
 				cvelo_services.BulkUpdateIndex, &api.ClientRecord{
 					ClientId: client_id,
@@ -223,8 +223,8 @@ func (self *Plan) ExecuteHuntUpdate(
 		for _, client_id := range clients {
 
 			// This leaks data as we dont have a way to delete them.
-			cvelo_services.SetElasticIndexAsync(
-				org_config_obj.OrgId,
+			cvelo_services.SetElasticIndexAsync(ctx,
+				utils.NormalizedOrgId(org_config_obj.OrgId),
 				"persisted", cvelo_services.DocIdRandom,
 				cvelo_services.BulkUpdateIndex, &api.ClientRecord{
 					ClientId:      client_id,
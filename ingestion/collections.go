@@ -43,6 +43,11 @@ func (self Ingestor) HandleResponses(
 		_ = self.HandleSystemVfsUpload(ctx, config_obj, message)
 	}
 
+	// The special responses above update the client's state so see
+	// the rows as the client sent them. Only the stored rows are
+	// transformed.
+	self.transformResponse(config_obj, response)
+
 	// We do not verify that this is a real artifact in order to avoid
 	// having to maintain a full artifact repository and lookups. We
 	// just blindly write it in the client's space.
@@ -66,6 +71,7 @@ func (self Ingestor) HandleResponses(
 	elastic_writer, ok := rs_writer.(*simple.ElasticSimpleResultSetWriter)
 	if ok {
 		elastic_writer.SetStartRow(int64(message.VQLResponse.QueryStartRow))
+		elastic_writer.SetIdempotencyKey(messageIdempotencyKey(message))
 	}
 
 	rs_writer.WriteJSONL([]byte(message.VQLResponse.JSONLResponse),
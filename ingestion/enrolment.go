@@ -8,6 +8,7 @@ import (
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
 	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
 	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/utils"
 )
 
 func (self Ingestor) HandleEnrolment(
@@ -47,8 +48,8 @@ func (self Ingestor) HandleInterrogation(
 	ctx context.Context, config_obj *config_proto.Config,
 	message *crypto_proto.VeloMessage) error {
 
-	services.SetElasticIndexAsync(
-		config_obj.OrgId,
+	services.SetElasticIndexAsync(ctx,
+		utils.NormalizedOrgId(config_obj.OrgId),
 		"persisted", message.Source+"_interrogate",
 		services.BulkUpdateIndex,
 		&api.ClientRecord{
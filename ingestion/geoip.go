@@ -0,0 +1,111 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/oschwald/maxminddb-golang"
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+)
+
+// Resolves IP addresses to their location.
+type GeoIPResolver interface {
+	// Returns false if the address is not in the database
+	// (e.g. private addresses).
+	Lookup(ip net.IP) (*cvelo_services.GeoLocation, bool)
+}
+
+// The fields we need from a MaxMind GeoIP2/GeoLite2 City database.
+type maxMindCity struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+type MaxMindResolver struct {
+	db *maxminddb.Reader
+}
+
+func NewMaxMindResolver(filename string) (*MaxMindResolver, error) {
+	db, err := maxminddb.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &MaxMindResolver{db: db}, nil
+}
+
+func (self *MaxMindResolver) Lookup(
+	ip net.IP) (*cvelo_services.GeoLocation, bool) {
+	city := &maxMindCity{}
+	_, ok, err := self.db.LookupNetwork(ip, city)
+	if err != nil || !ok {
+		return nil, false
+	}
+
+	return &cvelo_services.GeoLocation{
+		IP:      ip.String(),
+		Country: city.Country.ISOCode,
+		Location: cvelo_services.GeoPoint{
+			Lat: city.Location.Latitude,
+			Lon: city.Location.Longitude,
+		},
+	}, true
+}
+
+func (self *MaxMindResolver) Close() error {
+	return self.db.Close()
+}
+
+// Resolve the IP addresses in the fields of each row. If no fields
+// are given all top level string columns are checked. Each address
+// is only reported once.
+func resolveGeoIP(resolver GeoIPResolver,
+	fields []string, jsonl []byte) []cvelo_services.GeoLocation {
+	var result []cvelo_services.GeoLocation
+	seen := make(map[string]bool)
+
+	reader := bufio.NewReader(bytes.NewReader(jsonl))
+	for {
+		row_data, err := reader.ReadBytes('\n')
+		if err != nil && len(row_data) == 0 {
+			break
+		}
+
+		row := ordereddict.NewDict()
+		err = row.UnmarshalJSON(row_data)
+		if err != nil {
+			continue
+		}
+
+		columns := fields
+		if len(columns) == 0 {
+			columns = row.Keys()
+		}
+
+		for _, column := range columns {
+			value, ok := row.GetString(column)
+			if !ok || seen[value] {
+				continue
+			}
+
+			ip := net.ParseIP(value)
+			if ip == nil {
+				continue
+			}
+			seen[value] = true
+
+			location, ok := resolver.Lookup(ip)
+			if ok {
+				result = append(result, *location)
+			}
+		}
+	}
+
+	return result
+}
@@ -37,7 +37,7 @@ func (self Ingestor) maybeHandleHuntResponse(
 			ClientId:  message.Source,
 			FlowId:    message.SessionId,
 			Timestamp: velo_utils.GetTime().Now().Unix(),
-			Status:    "started",
+			Status:    hunt_dispatcher.HuntFlowStarted,
 			DocType:   "hunt_flow",
 		}
 		return services.SetElasticIndex(ctx,
@@ -91,8 +91,11 @@ func (self Ingestor) maybeHandleHuntFlowStats(
 		return nil
 	}
 
+	status := hunt_dispatcher.HuntFlowCompleted
+
 	// Increment the failed flow counter
 	if failed {
+		status = hunt_dispatcher.HuntFlowError
 		ingestor_services.HuntStatsManager.Update(hunt_id).IncError()
 	} else {
 
@@ -100,5 +103,17 @@ func (self Ingestor) maybeHandleHuntFlowStats(
 		ingestor_services.HuntStatsManager.Update(hunt_id).IncCompleted()
 	}
 
-	return nil
+	// Record the outcome so the stats can be reconciled with the
+	// flows.
+	return services.SetElasticIndex(ctx,
+		config_obj.OrgId,
+		"transient", services.DocIdRandom,
+		&hunt_dispatcher.HuntFlowEntry{
+			HuntId:    hunt_id,
+			ClientId:  collection_context.ClientId,
+			FlowId:    collection_context.SessionId,
+			Timestamp: velo_utils.GetTime().Now().Unix(),
+			Status:    status,
+			DocType:   "hunt_flow",
+		})
 }
@@ -0,0 +1,26 @@
+package ingestion
+
+import (
+	"fmt"
+
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
+)
+
+// Derive a key which identifies the message. When the client
+// retransmits a message (e.g. because it did not see our response)
+// the key is the same so the resulting documents can be deduplicated.
+func messageIdempotencyKey(message *crypto_proto.VeloMessage) string {
+	identity := fmt.Sprintf("%s/%s/%s/%d/%d", message.OrgId,
+		message.Source, message.SessionId,
+		message.RequestId, message.ResponseId)
+
+	if message.VQLResponse != nil {
+		if message.VQLResponse.Query != nil {
+			identity += "/" + message.VQLResponse.Query.Name
+		}
+		identity += fmt.Sprintf("/%d", message.VQLResponse.QueryStartRow)
+	}
+
+	return cvelo_services.MakeId(identity)
+}
@@ -12,6 +12,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/opensearch-project/opensearch-go/v2"
 	"www.velocidex.com/golang/cloudvelo/config"
@@ -38,6 +39,21 @@ type Ingestor struct {
 	crypto_manager *server.ServerCryptoManager
 
 	index string
+
+	// Map of artifact name to key field for monitoring artifacts
+	// which are upserted rather than appended.
+	upsert_artifacts map[string]string
+
+	// How long upserted state lives without being updated, by
+	// artifact name. State for artifacts not listed never expires.
+	upsert_ttls map[string]time.Duration
+
+	// Set when GeoIP enrichment is enabled.
+	geoip        GeoIPResolver
+	geoip_fields []string
+
+	// Applied to each row before it is written.
+	transforms []DocumentTransform
 }
 
 // Log messages to a file - used to generate test data.
@@ -56,6 +72,12 @@ func (self Ingestor) Process(
 	ctx context.Context, message *crypto_proto.VeloMessage) error {
 	//self.LogMessage(message)
 
+	// Hold the message back while ingestion is paused.
+	err := gIngestionGate.wait(ctx)
+	if err != nil {
+		return err
+	}
+
 	org_manager, err := services.GetOrgManager()
 	if err != nil {
 		return err
@@ -66,6 +88,8 @@ func (self Ingestor) Process(
 		return err
 	}
 
+	defer observeIngestion(ingestionMessageType(message), time.Now())
+
 	// Only accept unauthenticated enrolment requests. Everything
 	// below is authenticated.
 	if message.AuthState == crypto_proto.VeloMessage_UNAUTHENTICATED {
@@ -123,8 +147,33 @@ func NewIngestor(
 		return nil, err
 	}
 
-	return &Ingestor{
-		client:         client,
-		crypto_manager: crypto_manager,
-	}, nil
+	upsert_artifacts := make(map[string]string)
+	upsert_ttls := make(map[string]time.Duration)
+	for _, a := range config_obj.Cloud.MonitoringUpsertArtifacts {
+		upsert_artifacts[a.Artifact] = a.KeyField
+		if a.TTLSeconds > 0 {
+			upsert_ttls[a.Artifact] = time.Duration(a.TTLSeconds) * time.Second
+		}
+	}
+
+	result := &Ingestor{
+		client:           client,
+		crypto_manager:   crypto_manager,
+		upsert_artifacts: upsert_artifacts,
+		upsert_ttls:      upsert_ttls,
+		geoip_fields:     config_obj.Cloud.GeoIPFields,
+	}
+
+	if len(config_obj.Cloud.RedactFields) > 0 {
+		result.AddTransform(RedactFields(config_obj.Cloud.RedactFields...))
+	}
+
+	if config_obj.Cloud.GeoIPDatabase != "" {
+		result.geoip, err = NewMaxMindResolver(config_obj.Cloud.GeoIPDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("Opening GeoIP database: %w", err)
+		}
+	}
+
+	return result, nil
 }
@@ -3,26 +3,35 @@ package ingestion
 import (
 	"context"
 	"io/fs"
+	"net"
 	"path"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/Velocidex/ordereddict"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sebdah/goldie"
 	"github.com/stretchr/testify/suite"
 	crypto_server "www.velocidex.com/golang/cloudvelo/crypto/server"
 	"www.velocidex.com/golang/cloudvelo/ingestion/testdata"
+	"www.velocidex.com/golang/cloudvelo/result_sets/simple"
+	"www.velocidex.com/golang/cloudvelo/result_sets/timed"
 	"www.velocidex.com/golang/cloudvelo/schema/api"
 	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
 	"www.velocidex.com/golang/cloudvelo/testsuite"
+	actions_proto "www.velocidex.com/golang/velociraptor/actions/proto"
 	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	"www.velocidex.com/golang/velociraptor/constants"
 	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
 	flows_proto "www.velocidex.com/golang/velociraptor/flows/proto"
 	"www.velocidex.com/golang/velociraptor/json"
 	"www.velocidex.com/golang/velociraptor/services"
 	"www.velocidex.com/golang/velociraptor/utils"
+	"www.velocidex.com/golang/velociraptor/vtesting"
 	"www.velocidex.com/golang/velociraptor/vtesting/assert"
 )
 
@@ -107,6 +116,9 @@ func (self *IngestionTestSuite) ingestGoldenMessages(
 }
 
 func (self *IngestionTestSuite) TestEnrollment() {
+	// Internal queries are not transformed so the client record
+	// still gets its hostname.
+	self.ingestor.AddTransform(RedactFields("hostname"))
 
 	self.ingestGoldenMessages(self.ctx, self.ingestor, "Enrollment")
 
@@ -269,6 +281,456 @@ func (self *IngestionTestSuite) TestClientEventMonitoring() {
 		json.MustMarshalIndent(self.golden))
 }
 
+func (self *IngestionTestSuite) TestClientEventMonitoringUpserts() {
+	// Keep only the latest stats row for each client.
+	self.ingestor.upsert_artifacts["Generic.Client.Stats"] = "ClientId"
+	defer delete(self.ingestor.upsert_artifacts, "Generic.Client.Stats")
+
+	query := `{"query": {"match": {"doc_type": "monitoring_state"}}}`
+
+	// Replaying the messages multiple times should update the same
+	// record rather than append new ones.
+	for i := 0; i < 2; i++ {
+		self.ingestGoldenMessages(self.ctx, self.ingestor, "Generic.Client.Stats")
+
+		records, total, err := cvelo_services.QueryElasticRaw(self.ctx,
+			"test", "persisted", query)
+		assert.NoError(self.T(), err)
+		assert.Equal(self.T(), 1, total)
+
+		record := &MonitoringStateRecord{}
+		err = json.Unmarshal(records[0], record)
+		assert.NoError(self.T(), err)
+		assert.Equal(self.T(), "C.1352adc54e292a23", record.Key)
+		assert.Equal(self.T(), cvelo_services.CurrentSchemaVersion,
+			record.SchemaVersion)
+	}
+
+	// No rows should be appended to the transient index.
+	records, _, err := cvelo_services.QueryElasticRaw(self.ctx,
+		"test", "transient", json.Format(
+			`{"query": {"match": {"artifact": %q}}}`, "Generic.Client.Stats"))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 0, len(records))
+}
+
+func (self *IngestionTestSuite) TestClientEventMonitoringPaging() {
+	closer := utils.MockTime(&utils.IncClock{NowTime: 1661391000})
+	defer closer()
+
+	for i := 0; i < 25; i++ {
+		err := self.ingestor.Process(self.ctx, &crypto_proto.VeloMessage{
+			Source:    "C.1352adc54e292a23",
+			SessionId: constants.MONITORING_WELL_KNOWN_FLOW,
+			OrgId:     "test",
+			VQLResponse: &actions_proto.VQLResponse{
+				Query: &actions_proto.VQLRequest{
+					Name: "Generic.Client.Stats"},
+				JSONLResponse: json.Format("{\"Row\":%q}\n", i),
+				TotalRows:     1,
+			},
+		})
+		assert.NoError(self.T(), err)
+	}
+
+	// Page through the rows by the canonical timestamp field using
+	// a small page size.
+	hits, err := cvelo_services.QueryChan(self.ctx,
+		self.ConfigObj.VeloConf(), 10, "test", "transient",
+		json.Format(`{"query": {"match": {"artifact": %q}}}`,
+			"Generic.Client.Stats"),
+		timed.MonitoringTimestampField)
+	assert.NoError(self.T(), err)
+
+	var last int64
+	count := 0
+	for hit := range hits {
+		record := &timed.TimedResultSetRecord{}
+		err = json.Unmarshal(hit, record)
+		assert.NoError(self.T(), err)
+		assert.True(self.T(), record.Timestamp > last)
+		last = record.Timestamp
+		count++
+	}
+	assert.Equal(self.T(), 25, count)
+}
+
+func (self *IngestionTestSuite) TestRetransmittedResponses() {
+	message := &crypto_proto.VeloMessage{
+		Source:     "C.1352adc54e292a23",
+		SessionId:  "F.1234",
+		OrgId:      "test",
+		ResponseId: 5,
+		VQLResponse: &actions_proto.VQLResponse{
+			Query:         &actions_proto.VQLRequest{Name: "Test.Artifact"},
+			JSONLResponse: "{\"A\":1}\n{\"A\":2}\n",
+			TotalRows:     2,
+		},
+	}
+
+	// The client retransmits the same message.
+	for i := 0; i < 2; i++ {
+		err := self.ingestor.Process(self.ctx, message)
+		assert.NoError(self.T(), err)
+	}
+
+	err := cvelo_services.FlushBulkIndexer()
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.FlushIndex(self.ctx, "test", "transient")
+	assert.NoError(self.T(), err)
+
+	vfs_path := getFSPathSpec(message, "Test.Artifact").AsClientPath()
+	records, _, err := cvelo_services.QueryElasticRaw(self.ctx,
+		"test", "transient", json.Format(
+			`{"query": {"match": {"vfs_path": %q}}}`, vfs_path))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, len(records))
+}
+
+func (self *IngestionTestSuite) TestGetCollectionResults() {
+	// The client sends the results in two packets which arrive out
+	// of order.
+	for _, part := range []struct {
+		response_id uint64
+		start_row   uint64
+		jsonl       string
+	}{
+		{2, 2, "{\"A\":3}\n{\"A\":4}\n"},
+		{1, 0, "{\"A\":1}\n{\"A\":2}\n"},
+	} {
+		err := self.ingestor.Process(self.ctx, &crypto_proto.VeloMessage{
+			Source:     "C.1352adc54e292a23",
+			SessionId:  "F.1234",
+			OrgId:      "test",
+			ResponseId: part.response_id,
+			VQLResponse: &actions_proto.VQLResponse{
+				Query:         &actions_proto.VQLRequest{Name: "Test.Artifact/Source"},
+				JSONLResponse: part.jsonl,
+				TotalRows:     2,
+				QueryStartRow: part.start_row,
+			},
+		})
+		assert.NoError(self.T(), err)
+	}
+
+	err := cvelo_services.FlushBulkIndexer()
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.FlushIndex(self.ctx, "test", "transient")
+	assert.NoError(self.T(), err)
+
+	rows, err := simple.GetCollectionResults(self.ctx,
+		"test", "C.1352adc54e292a23", "F.1234", "Test.Artifact/Source")
+	assert.NoError(self.T(), err)
+
+	assert.Equal(self.T(), []string{
+		`{"A":1}`, `{"A":2}`, `{"A":3}`, `{"A":4}`}, rawStrings(rows))
+}
+
+func (self *IngestionTestSuite) TestGetHuntCollectionResults() {
+	// Every client in a hunt runs a flow with the same flow id.
+	flow_id := "F.1234.H"
+	for _, part := range []struct {
+		client_id string
+		jsonl     string
+	}{
+		{"C.1352adc54e292a23", "{\"A\":1}\n{\"A\":2}\n"},
+		{"C.77ad4285690698d9", "{\"B\":1}\n{\"B\":2}\n"},
+	} {
+		err := self.ingestor.Process(self.ctx, &crypto_proto.VeloMessage{
+			Source:     part.client_id,
+			SessionId:  flow_id,
+			OrgId:      "test",
+			ResponseId: 1,
+			VQLResponse: &actions_proto.VQLResponse{
+				Query:         &actions_proto.VQLRequest{Name: "Test.Artifact/Source"},
+				JSONLResponse: part.jsonl,
+				TotalRows:     2,
+			},
+		})
+		assert.NoError(self.T(), err)
+	}
+
+	err := cvelo_services.FlushBulkIndexer()
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.FlushIndex(self.ctx, "test", "transient")
+	assert.NoError(self.T(), err)
+
+	// Each client only gets its own rows.
+	rows, err := simple.GetCollectionResults(self.ctx,
+		"test", "C.1352adc54e292a23", flow_id, "Test.Artifact/Source")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string{`{"A":1}`, `{"A":2}`}, rawStrings(rows))
+
+	rows, err = simple.GetCollectionResults(self.ctx,
+		"test", "C.77ad4285690698d9", flow_id, "Test.Artifact/Source")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string{`{"B":1}`, `{"B":2}`}, rawStrings(rows))
+}
+
+func (self *IngestionTestSuite) TestDocumentTransforms() {
+	self.ingestor.AddTransform(RedactFields("Password"))
+
+	// Drop the rows of the noisy user.
+	self.ingestor.AddTransform(func(
+		artifact string, row *ordereddict.Dict) bool {
+		user, _ := row.GetString("User")
+		return artifact != "Test.Artifact/Users" || user != "noisy"
+	})
+
+	err := self.ingestor.Process(self.ctx, &crypto_proto.VeloMessage{
+		Source:    "C.1352adc54e292a23",
+		SessionId: "F.1234",
+		OrgId:     "test",
+		VQLResponse: &actions_proto.VQLResponse{
+			Query: &actions_proto.VQLRequest{Name: "Test.Artifact/Users"},
+			JSONLResponse: `{"User":"admin","Password":"hunter2","Uid":0}
+{"User":"noisy","Password":"secret","Uid":1}
+{"User":"guest","Uid":2}
+{"User":"broken","Password":
+`,
+			TotalRows: 4,
+		},
+	})
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.FlushBulkIndexer()
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.FlushIndex(self.ctx, "test", "transient")
+	assert.NoError(self.T(), err)
+
+	rows, err := simple.GetCollectionResults(self.ctx,
+		"test", "C.1352adc54e292a23", "F.1234", "Test.Artifact/Users")
+	assert.NoError(self.T(), err)
+
+	// The password is not stored and the dropped and invalid rows
+	// are gone.
+	assert.Equal(self.T(), []string{
+		`{"User":"admin","Uid":0}`, `{"User":"guest","Uid":2}`},
+		rawStrings(rows))
+
+	// But the part still covers all the rows the client sent, so
+	// the next part follows on from it.
+	hits, _, err := cvelo_services.QueryElasticRaw(self.ctx,
+		"test", "transient", json.Format(
+			`{"query": {"match": {"artifact": %q}}}`, "Test.Artifact/Users"))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, len(hits))
+
+	part := &simple.SimpleResultSetRecord{}
+	err = json.Unmarshal(hits[0], part)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), int64(0), part.StartRow)
+	assert.Equal(self.T(), int64(4), part.EndRow)
+}
+
+func rawStrings(rows []json.RawMessage) []string {
+	result := []string{}
+	for _, row := range rows {
+		result = append(result, string(row))
+	}
+	return result
+}
+
+func (self *IngestionTestSuite) TestPauseIngestion() {
+	client_id := "C.1352adc54e292a23"
+	getRecord := func() error {
+		_, err := cvelo_services.GetElasticRecord(self.ctx,
+			"test", "persisted", client_id+"-test_key")
+		return err
+	}
+
+	PauseIngestion()
+	defer ResumeIngestion()
+	assert.True(self.T(), IsIngestionPaused())
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		self.ingestGoldenMessages(self.ctx, self.ingestor, "Enrollment")
+	}()
+
+	// The message waits until ingestion resumes.
+	vtesting.WaitUntil(5*time.Second, self.T(), func() bool {
+		return BufferedMessages() == 1
+	})
+	assert.Error(self.T(), getRecord())
+
+	ResumeIngestion()
+	<-done
+
+	assert.True(self.T(), !IsIngestionPaused())
+	assert.Equal(self.T(), 0, BufferedMessages())
+	assert.NoError(self.T(), getRecord())
+}
+
+// Resolves addresses from a fixed table.
+type staticGeoIPResolver map[string]cvelo_services.GeoLocation
+
+func (self staticGeoIPResolver) Lookup(
+	ip net.IP) (*cvelo_services.GeoLocation, bool) {
+	location, pres := self[ip.String()]
+	if !pres {
+		return nil, false
+	}
+	return &location, true
+}
+
+func (self *IngestionTestSuite) TestGeoIPEnrichment() {
+	self.ingestor.geoip = staticGeoIPResolver{
+		"8.8.8.8": {
+			IP:       "8.8.8.8",
+			Country:  "US",
+			Location: cvelo_services.GeoPoint{Lat: 37.751, Lon: -97.822},
+		},
+	}
+	self.ingestor.geoip_fields = []string{"RemoteAddr"}
+	defer func() {
+		self.ingestor.geoip = nil
+		self.ingestor.geoip_fields = nil
+	}()
+
+	err := self.ingestor.Process(self.ctx, &crypto_proto.VeloMessage{
+		Source:    "C.1352adc54e292a23",
+		SessionId: constants.MONITORING_WELL_KNOWN_FLOW,
+		OrgId:     "test",
+		VQLResponse: &actions_proto.VQLResponse{
+			Query: &actions_proto.VQLRequest{
+				Name: "Custom.Network.Connections"},
+			// The private address is not in the database.
+			JSONLResponse: `{"RemoteAddr":"8.8.8.8","Pid":1}
+{"RemoteAddr":"10.1.1.1","Pid":2}
+{"RemoteAddr":"8.8.8.8","Pid":3}
+`,
+			TotalRows: 3,
+		},
+	})
+	assert.NoError(self.T(), err)
+
+	// Within 500km of Wichita.
+	records, _, err := cvelo_services.QueryElasticRaw(self.ctx,
+		"test", "transient", cvelo_services.GeoDistanceQuery("geo.location",
+			cvelo_services.GeoPoint{Lat: 37.687, Lon: -97.330}, "500km", 10))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, len(records))
+
+	record := &timed.TimedResultSetRecord{}
+	err = json.Unmarshal(records[0], record)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), "Custom.Network.Connections", record.Artifact)
+	assert.Equal(self.T(), 1, len(record.Geo))
+	assert.Equal(self.T(), "US", record.Geo[0].Country)
+
+	// Nothing near London.
+	records, _, err = cvelo_services.QueryElasticRaw(self.ctx,
+		"test", "transient", cvelo_services.GeoDistanceQuery("geo.location",
+			cvelo_services.GeoPoint{Lat: 51.507, Lon: -0.128}, "500km", 10))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 0, len(records))
+}
+
+func (self *IngestionTestSuite) TestIngestNDJSON() {
+	stream := `{"_id": "doc1", "doc_type": "backfill", "client_id": "C.1"}
+{"doc_type": "backfill", "client_id": "C.2"}
+
+not json
+{"_id": 5, "doc_type": "backfill"}
+{"doc_type": "backfill", "client_id": "C.3"}`
+
+	result, err := IngestNDJSON(self.ctx, "test", "persisted",
+		strings.NewReader(stream))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 3, result.Ingested)
+	assert.Equal(self.T(), 2, result.Malformed)
+	assert.Equal(self.T(), 0, result.Failed)
+	assert.Equal(self.T(), []int{4, 5}, sortedKeys(result.Errors))
+
+	// Loading the same data again does not duplicate it.
+	_, err = IngestNDJSON(self.ctx, "test", "persisted",
+		strings.NewReader(stream))
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.FlushBulkIndexer()
+	assert.NoError(self.T(), err)
+
+	_, total, err := cvelo_services.QueryElasticRaw(self.ctx,
+		"test", "persisted", `{"query": {"match": {"doc_type": "backfill"}}}`)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 3, total)
+
+	// The _id field sets the document id.
+	serialized, err := cvelo_services.GetElasticRecord(self.ctx,
+		"test", "persisted", "doc1")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), `{"schema_version":1,"doc_type":"backfill","client_id":"C.1"}`,
+		string(serialized))
+}
+
+func sortedKeys(in map[int]error) []int {
+	result := make([]int, 0, len(in))
+	for k := range in {
+		result = append(result, k)
+	}
+	sort.Ints(result)
+	return result
+}
+
+func (self *IngestionTestSuite) TestIngestionMetrics() {
+	types := []string{
+		MessageTypeEnrolment, MessageTypeUpload, MessageTypeResponse,
+		MessageTypeLog, MessageTypeStatus, MessageTypeMonitoringResponse,
+		MessageTypeMonitoringLog, MessageTypePing,
+	}
+
+	getCounts := func() map[string]uint64 {
+		result := make(map[string]uint64)
+		for _, message_type := range types {
+			metric := &dto.Metric{}
+			err := ingestionMessagesCounter.WithLabelValues(message_type).Write(metric)
+			assert.NoError(self.T(), err)
+			result[message_type] = uint64(metric.Counter.GetValue())
+
+			// Every counted message has its latency recorded.
+			err = ingestionLatencyHistogram.WithLabelValues(message_type).(prometheus.Metric).Write(metric)
+			assert.NoError(self.T(), err)
+			assert.Equal(self.T(), result[message_type],
+				metric.Histogram.GetSampleCount())
+		}
+		return result
+	}
+
+	before := getCounts()
+	for _, prefix := range []string{"Enrollment",
+		"System.VFS.DownloadFile", "Generic.Client.Stats"} {
+		self.ingestGoldenMessages(self.ctx, self.ingestor, prefix)
+	}
+	after := getCounts()
+
+	for _, message_type := range types {
+		after[message_type] -= before[message_type]
+	}
+
+	assert.Equal(self.T(), map[string]uint64{
+		MessageTypeEnrolment:          1,
+		MessageTypeUpload:             1,
+		MessageTypeResponse:           1,
+		MessageTypeLog:                1,
+		MessageTypeStatus:             1,
+		MessageTypeMonitoringResponse: 1,
+		MessageTypeMonitoringLog:      1,
+		MessageTypePing:               0,
+	}, after)
+
+	assert.Equal(self.T(), MessageTypePing,
+		ingestionMessageType(&crypto_proto.VeloMessage{
+			AuthState:      crypto_proto.VeloMessage_AUTHENTICATED,
+			ForemanCheckin: &actions_proto.ForemanCheckin{},
+		}))
+}
+
 func (self *IngestionTestSuite) SetupTest() {
 	self.CloudTestSuite.SetupTest()
 
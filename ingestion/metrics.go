@@ -0,0 +1,76 @@
+package ingestion
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"www.velocidex.com/golang/velociraptor/constants"
+	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
+)
+
+// The message types reported in the ingestion metrics.
+const (
+	MessageTypeEnrolment          = "enrolment"
+	MessageTypeMonitoringLog      = "monitoring_log"
+	MessageTypeMonitoringResponse = "monitoring_response"
+	MessageTypeLog                = "log"
+	MessageTypeResponse           = "response"
+	MessageTypeStatus             = "status"
+	MessageTypePing               = "ping"
+	MessageTypeUpload             = "upload"
+	MessageTypeOther              = "other"
+)
+
+var (
+	ingestionMessagesCounter = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ingestion_messages_total",
+			Help: "Number of messages ingested by message type.",
+		}, []string{"type"})
+
+	ingestionLatencyHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ingestion_handler_latency_seconds",
+			Help:    "Time taken to ingest a message by message type.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}, []string{"type"})
+)
+
+// Classify the message the same way Process dispatches it.
+func ingestionMessageType(message *crypto_proto.VeloMessage) string {
+	if message.AuthState == crypto_proto.VeloMessage_UNAUTHENTICATED {
+		return MessageTypeEnrolment
+	}
+
+	if message.SessionId == constants.MONITORING_WELL_KNOWN_FLOW {
+		switch {
+		case message.LogMessage != nil:
+			return MessageTypeMonitoringLog
+		case message.VQLResponse != nil:
+			return MessageTypeMonitoringResponse
+		}
+		return MessageTypeOther
+	}
+
+	switch {
+	case message.LogMessage != nil:
+		return MessageTypeLog
+	case message.VQLResponse != nil:
+		return MessageTypeResponse
+	case message.FlowStats != nil:
+		return MessageTypeStatus
+	case message.ForemanCheckin != nil:
+		return MessageTypePing
+	case message.FileBuffer != nil:
+		return MessageTypeUpload
+	}
+	return MessageTypeOther
+}
+
+// Count the message and record how long it took to handle.
+func observeIngestion(message_type string, start time.Time) {
+	ingestionMessagesCounter.WithLabelValues(message_type).Inc()
+	ingestionLatencyHistogram.WithLabelValues(message_type).Observe(
+		time.Since(start).Seconds())
+}
@@ -145,7 +145,8 @@ func (self *HTTPIngestor) Execute(
 				return errors.New("Plan unknown stored query")
 			}
 
-			return services.UpdateIndex(ctx, "",
+			// The plan index already includes the org prefix.
+			return services.UpdateIndex(services.AllowGlobalWrites(ctx), "",
 				normalize_index(plan.Index), plan.DocId, query)
 		}
 
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"www.velocidex.com/golang/cloudvelo/result_sets/timed"
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
 	"www.velocidex.com/golang/velociraptor/artifacts"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
 	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
@@ -14,6 +15,12 @@ import (
 	"www.velocidex.com/golang/velociraptor/utils"
 )
 
+// Implemented by the elastic timed result set writer.
+type geoResultSetWriter interface {
+	WriteJSONLWithGeo(serialized []byte, total_rows int,
+		geo []cvelo_services.GeoLocation)
+}
+
 func (self Ingestor) HandleMonitoringLogs(
 	ctx context.Context, config_obj *config_proto.Config,
 	message *crypto_proto.VeloMessage) error {
@@ -72,6 +79,14 @@ func (self Ingestor) HandleMonitoringResponses(
 		return self.HandleClientInfoUpdates(ctx, message)
 	}
 
+	self.transformResponse(config_obj, response)
+
+	// State like artifacts are updated in place.
+	key_field, pres := self.upsert_artifacts[message.VQLResponse.Query.Name]
+	if pres {
+		return self.HandleMonitoringUpserts(ctx, config_obj, message, key_field)
+	}
+
 	// Add the client id on the end of the record
 	new_json_response := json.AppendJsonlItem(
 		[]byte(message.VQLResponse.JSONLResponse), "ClientId", message.Source)
@@ -91,6 +106,16 @@ func (self Ingestor) HandleMonitoringResponses(
 	}
 	defer rs_writer.Close()
 
+	if self.geoip != nil {
+		geo_writer, ok := rs_writer.(geoResultSetWriter)
+		if ok {
+			geo_writer.WriteJSONLWithGeo(new_json_response,
+				int(message.VQLResponse.TotalRows),
+				resolveGeoIP(self.geoip, self.geoip_fields, new_json_response))
+			return nil
+		}
+	}
+
 	rs_writer.WriteJSONL(new_json_response, int(message.VQLResponse.TotalRows))
 
 	return nil
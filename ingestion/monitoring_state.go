@@ -0,0 +1,125 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/Velocidex/ordereddict"
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+// Some monitoring artifacts represent the state of the client
+// (e.g. the latest value of a key) rather than a log of events. For
+// these we keep a single document per key in the persisted index
+// which is updated in place.
+type MonitoringStateRecord struct {
+	ClientId  string `json:"client_id"`
+	Artifact  string `json:"artifact"`
+	Key       string `json:"key"`
+	Timestamp int64  `json:"timestamp"`
+	JSONData  string `json:"data"`
+	DocType   string `json:"doc_type"`
+
+	// Set when the state expires if it is not updated.
+	Expires int64 `json:"expires,omitempty"`
+
+	// Upserts are not stamped by the write path so we stamp them
+	// ourselves.
+	SchemaVersion int `json:"schema_version"`
+}
+
+const (
+	// Only replace the existing state if the new record is not
+	// older. This protects against out of order delivery.
+	upsertMonitoringStatePainless = `
+if (ctx._source.timestamp == null ||
+    ctx._source.timestamp <= params.record.timestamp) {
+  ctx._source.putAll(params.record);
+} else {
+  ctx.op = 'none';
+}
+`
+
+	upsertMonitoringStateQuery = `
+{
+  "scripted_upsert": true,
+  "script" : {
+    "source": %q,
+    "lang": "painless",
+    "params": {
+      "record": %q
+    }
+  },
+  "upsert": {}
+}
+`
+)
+
+func (self Ingestor) HandleMonitoringUpserts(
+	ctx context.Context, config_obj *config_proto.Config,
+	message *crypto_proto.VeloMessage, key_field string) error {
+
+	artifact_name := message.VQLResponse.Query.Name
+	now := utils.GetTime().Now().UnixNano()
+
+	reader := bufio.NewReader(bytes.NewReader(
+		[]byte(message.VQLResponse.JSONLResponse)))
+	for {
+		row_data, err := reader.ReadBytes('\n')
+		if err != nil && len(row_data) == 0 {
+			break
+		}
+
+		row := ordereddict.NewDict()
+		err = row.UnmarshalJSON(row_data)
+		if err != nil {
+			continue
+		}
+		row.Set("ClientId", message.Source)
+
+		key_any, pres := row.Get(key_field)
+		if !pres {
+			continue
+		}
+		key := fmt.Sprintf("%v", key_any)
+
+		serialized, err := json.Marshal(row)
+		if err != nil {
+			continue
+		}
+
+		record := &MonitoringStateRecord{
+			ClientId:  message.Source,
+			Artifact:  artifact_name,
+			Key:       key,
+			Timestamp: now,
+			JSONData:  string(serialized),
+			DocType:   "monitoring_state",
+
+			SchemaVersion: cvelo_services.CurrentSchemaVersion,
+		}
+
+		ttl, pres := self.upsert_ttls[artifact_name]
+		if pres {
+			record.Expires = cvelo_services.ExpiryTime(ttl)
+		}
+
+		id := cvelo_services.MakeId(
+			message.Source + "_" + artifact_name + "_" + key)
+
+		err = cvelo_services.UpdateIndex(ctx, config_obj.OrgId,
+			"persisted", id, json.Format(upsertMonitoringStateQuery,
+				upsertMonitoringStatePainless, record))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
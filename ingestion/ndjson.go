@@ -0,0 +1,111 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Velocidex/ordereddict"
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+)
+
+type NDJSONResult struct {
+	// Documents queued for writing.
+	Ingested int
+
+	// Lines which are not valid documents.
+	Malformed int
+
+	// Documents which could not be queued.
+	Failed int
+
+	// The error for each line which was not ingested, by line
+	// number (starting at 1).
+	Errors map[int]error
+}
+
+func (self *NDJSONResult) String() string {
+	return fmt.Sprintf("Ingested %v documents (%v malformed, %v failed)",
+		self.Ingested, self.Malformed, self.Failed)
+}
+
+// Write a stream of newline delimited JSON documents directly into
+// the org's index through the bulk indexer, for bulk loading
+// historical data (e.g. migrations and backfills).
+//
+// A document's _id field is used as its ID and removed from the
+// document. Otherwise the ID is derived from the document itself so
+// loading the same data again does not duplicate it - documents
+// which already exist are left alone. The bulk indexer must be
+// flushed to ensure all the documents are written.
+func IngestNDJSON(ctx context.Context,
+	org_id, index string, reader io.Reader) (*NDJSONResult, error) {
+
+	result := &NDJSONResult{Errors: make(map[int]error)}
+	buffered := bufio.NewReader(reader)
+
+	for line_number := 1; ; line_number++ {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		line, err := buffered.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return result, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			ingestNDJSONLine(ctx, org_id, index, line, line_number, result)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+	}
+}
+
+func ingestNDJSONLine(ctx context.Context, org_id, index string,
+	line []byte, line_number int, result *NDJSONResult) {
+	id, document, err := parseNDJSONLine(line)
+	if err != nil {
+		result.Malformed++
+		result.Errors[line_number] = err
+		return
+	}
+
+	err = cvelo_services.SetElasticIndexAsync(ctx, org_id, index, id,
+		cvelo_services.BulkUpdateCreate, document)
+	if err != nil {
+		result.Failed++
+		result.Errors[line_number] = err
+		return
+	}
+
+	result.Ingested++
+}
+
+// Get the document and its ID from the line.
+func parseNDJSONLine(line []byte) (string, *ordereddict.Dict, error) {
+	document := ordereddict.NewDict()
+	err := document.UnmarshalJSON(line)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id := cvelo_services.MakeId(string(line))
+	id_any, pres := document.Get("_id")
+	if pres {
+		explicit_id, ok := id_any.(string)
+		if !ok || explicit_id == "" {
+			return "", nil, fmt.Errorf("Invalid _id: %v", id_any)
+		}
+		id = explicit_id
+		document.Delete("_id")
+	}
+
+	return id, document, nil
+}
@@ -0,0 +1,135 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// At most this many messages wait while ingestion is paused.
+	// Further messages are rejected and the client retries them
+	// later.
+	maxPausedMessages = 10000
+)
+
+var (
+	ErrIngestionPaused = errors.New(
+		"Ingestion is paused: Too many buffered messages, retry later")
+
+	ingestionPausedGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ingestion_paused",
+			Help: "Set to 1 while ingestion is paused.",
+		})
+
+	ingestionBufferedGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ingestion_paused_buffered_messages",
+			Help: "Number of messages waiting for ingestion to resume.",
+		})
+
+	gIngestionGate = &ingestionGate{}
+)
+
+// Holds messages back while ingestion is paused (e.g. during a
+// cluster maintenance window).
+type ingestionGate struct {
+	mu sync.Mutex
+
+	// Closed when ingestion resumes. nil when not paused.
+	resumed  chan struct{}
+	buffered int
+}
+
+func (self *ingestionGate) pause() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.resumed == nil {
+		self.resumed = make(chan struct{})
+		ingestionPausedGauge.Set(1)
+	}
+}
+
+func (self *ingestionGate) resume() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.resumed != nil {
+		close(self.resumed)
+		self.resumed = nil
+		ingestionPausedGauge.Set(0)
+	}
+}
+
+func (self *ingestionGate) isPaused() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.resumed != nil
+}
+
+func (self *ingestionGate) bufferedCount() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.buffered
+}
+
+// Block while ingestion is paused. Returns ErrIngestionPaused if
+// too many messages are already waiting.
+func (self *ingestionGate) wait(ctx context.Context) error {
+	self.mu.Lock()
+	resumed := self.resumed
+	if resumed == nil {
+		self.mu.Unlock()
+		return nil
+	}
+
+	if self.buffered >= maxPausedMessages {
+		self.mu.Unlock()
+		return ErrIngestionPaused
+	}
+	self.buffered++
+	ingestionBufferedGauge.Set(float64(self.buffered))
+	self.mu.Unlock()
+
+	defer func() {
+		self.mu.Lock()
+		self.buffered--
+		ingestionBufferedGauge.Set(float64(self.buffered))
+		self.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resumed:
+		return nil
+	}
+}
+
+// Stop writing incoming messages to the backend. Messages received
+// while paused wait (up to a bound) and are processed when
+// ingestion resumes.
+func PauseIngestion() {
+	gIngestionGate.pause()
+}
+
+// Resume writing and process the buffered messages.
+func ResumeIngestion() {
+	gIngestionGate.resume()
+}
+
+func IsIngestionPaused() bool {
+	return gIngestionGate.isPaused()
+}
+
+// The number of messages waiting for ingestion to resume.
+func BufferedMessages() int {
+	return gIngestionGate.bufferedCount()
+}
@@ -0,0 +1,156 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
+)
+
+type ingestionRequest struct {
+	ctx     context.Context
+	message *crypto_proto.VeloMessage
+	done    chan error
+
+	// Called when the message fails to stop the rest of its batch.
+	cancel func()
+}
+
+// A bounded pool of workers in front of an ingestor. Messages for the
+// same client and session are always handled by the same worker so
+// they are processed in the order they were submitted (e.g. upload
+// chunks must be written in order), while messages for different
+// sessions are processed in parallel.
+type IngestorPool struct {
+	ctx      context.Context
+	delegate IngestorInterface
+	queues   []chan *ingestionRequest
+}
+
+func (self *IngestorPool) Process(
+	ctx context.Context, message *crypto_proto.VeloMessage) error {
+	done := make(chan error, 1)
+	self.submit(ctx, nil, message, done)
+	return <-done
+}
+
+// Submit all the messages to the pool and wait for them to be
+// processed. Like processing the messages inline, this returns at
+// the first error and the messages after it are not processed. As
+// messages for other sessions are processed in parallel, some of
+// them may already have been processed by then, but the messages
+// after the failed one in the same session never are.
+func (self *IngestorPool) ProcessBatch(
+	ctx context.Context, messages []*crypto_proto.VeloMessage) error {
+	sub_ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, len(messages))
+	submitted := 0
+	for _, message := range messages {
+		if sub_ctx.Err() != nil {
+			break
+		}
+		self.submit(sub_ctx, cancel, message, done)
+		submitted++
+	}
+
+	var skipped_err error
+	for i := 0; i < submitted; i++ {
+		err := <-done
+		if err == nil {
+			continue
+		}
+
+		// The message was skipped because another one failed -
+		// wait for the error of the failed message.
+		if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+			skipped_err = err
+			continue
+		}
+		return err
+	}
+
+	return skipped_err
+}
+
+func (self *IngestorPool) submit(
+	ctx context.Context, cancel func(),
+	message *crypto_proto.VeloMessage, done chan error) {
+	request := &ingestionRequest{
+		ctx:     ctx,
+		message: message,
+		done:    done,
+		cancel:  cancel,
+	}
+
+	select {
+	case <-ctx.Done():
+		done <- ctx.Err()
+
+	case <-self.ctx.Done():
+		done <- errors.New("IngestorPool: pool is closed")
+
+	case self.queues[self.shard(message)] <- request:
+	}
+}
+
+// Messages from the same client and session go to the same worker.
+func (self *IngestorPool) shard(message *crypto_proto.VeloMessage) int {
+	h := fnv.New32a()
+	h.Write([]byte(message.Source))
+	h.Write([]byte{0})
+	h.Write([]byte(message.SessionId))
+	return int(h.Sum32() % uint32(len(self.queues)))
+}
+
+func (self *IngestorPool) worker(queue chan *ingestionRequest) {
+	for {
+		select {
+		case <-self.ctx.Done():
+			return
+
+		case request := <-queue:
+			// An earlier message of the batch failed.
+			err := request.ctx.Err()
+			if err != nil {
+				request.done <- err
+				continue
+			}
+
+			err = self.delegate.Process(request.ctx, request.message)
+			if err != nil && request.cancel != nil {
+				request.cancel()
+			}
+			request.done <- err
+		}
+	}
+}
+
+func NewIngestorPool(
+	ctx context.Context, wg *sync.WaitGroup,
+	delegate IngestorInterface, size int) *IngestorPool {
+	if size <= 0 {
+		size = 1
+	}
+
+	self := &IngestorPool{
+		ctx:      ctx,
+		delegate: delegate,
+	}
+
+	for i := 0; i < size; i++ {
+		queue := make(chan *ingestionRequest)
+		self.queues = append(self.queues, queue)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			self.worker(queue)
+		}()
+	}
+
+	return self
+}
@@ -0,0 +1,124 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
+)
+
+// Records the order messages are processed in for each session.
+type recordingIngestor struct {
+	mu   sync.Mutex
+	seen map[string][]uint64
+
+	// Fail this message of the session.
+	fail_session string
+	fail_id      uint64
+}
+
+func (self *recordingIngestor) Process(
+	ctx context.Context, message *crypto_proto.VeloMessage) error {
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	key := message.Source + message.SessionId
+	self.seen[key] = append(self.seen[key], message.ResponseId)
+
+	if message.SessionId == self.fail_session &&
+		message.ResponseId == self.fail_id {
+		return errors.New("Failed")
+	}
+	return nil
+}
+
+func TestIngestorPoolOrdering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	defer cancel()
+
+	delegate := &recordingIngestor{seen: make(map[string][]uint64)}
+	pool := NewIngestorPool(ctx, wg, delegate, 4)
+
+	// Interleave messages from many sessions in a single batch.
+	var messages []*crypto_proto.VeloMessage
+	for i := uint64(0); i < 50; i++ {
+		for j := 0; j < 10; j++ {
+			messages = append(messages, &crypto_proto.VeloMessage{
+				Source:     "C.1234",
+				SessionId:  fmt.Sprintf("F.%d", j),
+				ResponseId: i,
+			})
+		}
+	}
+
+	err := pool.ProcessBatch(ctx, messages)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 10, len(delegate.seen))
+	for key, ids := range delegate.seen {
+		assert.Equal(t, 50, len(ids), key)
+		for i, id := range ids {
+			assert.Equal(t, uint64(i), id, key)
+		}
+	}
+}
+
+func TestIngestorPoolFailFast(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	defer cancel()
+
+	delegate := &recordingIngestor{
+		seen:         make(map[string][]uint64),
+		fail_session: "F.3",
+		fail_id:      20,
+	}
+	pool := NewIngestorPool(ctx, wg, delegate, 4)
+
+	var messages []*crypto_proto.VeloMessage
+	for i := uint64(0); i < 50; i++ {
+		for j := 0; j < 10; j++ {
+			messages = append(messages, &crypto_proto.VeloMessage{
+				Source:     "C.1234",
+				SessionId:  fmt.Sprintf("F.%d", j),
+				ResponseId: i,
+			})
+		}
+	}
+
+	// The error of the failed message is returned, not the
+	// cancellation of the messages skipped after it.
+	err := pool.ProcessBatch(ctx, messages)
+	assert.Error(t, err)
+	assert.Equal(t, "Failed", err.Error())
+
+	// Nothing after the failed message is processed for its
+	// session.
+	delegate.mu.Lock()
+	ids := delegate.seen["C.1234F.3"]
+	delegate.mu.Unlock()
+
+	assert.Equal(t, 21, len(ids))
+	for i, id := range ids {
+		assert.Equal(t, uint64(i), id)
+	}
+
+	// The pool is still usable for the next batch.
+	delegate.mu.Lock()
+	delegate.fail_session = ""
+	delegate.mu.Unlock()
+
+	err = pool.ProcessBatch(ctx, messages[:10])
+	assert.NoError(t, err)
+}
@@ -0,0 +1,107 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+var (
+	loggedMessageRegex = regexp.MustCompile(`^Msg_(\d+)\.json$`)
+)
+
+type ReplayResult struct {
+	// Messages processed successfully.
+	Replayed int
+
+	// Files which could not be read or parsed.
+	Malformed int
+
+	// Messages the ingestor failed to process.
+	Failed int
+
+	// The first error for each file which was not replayed, by
+	// filename.
+	Errors map[string]error
+}
+
+func (self *ReplayResult) String() string {
+	return fmt.Sprintf("Replayed %v messages (%v malformed, %v failed)",
+		self.Replayed, self.Malformed, self.Failed)
+}
+
+// Feed the messages written by Ingestor.LogMessage (Msg_N.json) in
+// dir back through the ingestor, in the order they were logged.
+// Files which can not be parsed or processed are skipped and
+// reported in the result so one bad message does not stop the
+// replay.
+func ReplayMessages(ctx context.Context,
+	ingestor IngestorInterface, dir string) (*ReplayResult, error) {
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type loggedMessage struct {
+		idx      int
+		filename string
+	}
+
+	var messages []loggedMessage
+	for _, file := range files {
+		match := loggedMessageRegex.FindStringSubmatch(file.Name())
+		if match == nil || file.IsDir() {
+			continue
+		}
+
+		idx, _ := strconv.Atoi(match[1])
+		messages = append(messages, loggedMessage{
+			idx: idx, filename: file.Name()})
+	}
+
+	// Msg_100.json comes after Msg_99.json
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].idx < messages[j].idx
+	})
+
+	result := &ReplayResult{Errors: make(map[string]error)}
+	for _, logged := range messages {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, logged.filename))
+		if err != nil {
+			result.Malformed++
+			result.Errors[logged.filename] = err
+			continue
+		}
+
+		message := &crypto_proto.VeloMessage{}
+		err = json.Unmarshal(data, message)
+		if err != nil {
+			result.Malformed++
+			result.Errors[logged.filename] = err
+			continue
+		}
+
+		err = ingestor.Process(ctx, message)
+		if err != nil {
+			result.Failed++
+			result.Errors[logged.filename] = err
+			continue
+		}
+
+		result.Replayed++
+	}
+
+	return result, nil
+}
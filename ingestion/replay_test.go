@@ -0,0 +1,85 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/cloudvelo/ingestion/testdata"
+	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// Records the messages it is given and fails those with a response
+// id of fail_id.
+type replayIngestor struct {
+	messages []*crypto_proto.VeloMessage
+	fail_id  uint64
+}
+
+func (self *replayIngestor) Process(
+	ctx context.Context, message *crypto_proto.VeloMessage) error {
+	if message.ResponseId == self.fail_id {
+		return errors.New("Failed")
+	}
+	self.messages = append(self.messages, message)
+	return nil
+}
+
+func TestReplayMessages(t *testing.T) {
+	dir := t.TempDir()
+
+	// The sample messages as logged by LogMessage.
+	prefix := "System.VFS.ListDirectory"
+	files, err := testdata.FS.ReadDir(prefix)
+	assert.NoError(t, err)
+	for _, file := range files {
+		data, err := fs.ReadFile(testdata.FS, path.Join(prefix, file.Name()))
+		assert.NoError(t, err)
+		err = ioutil.WriteFile(filepath.Join(dir, file.Name()), data, 0600)
+		assert.NoError(t, err)
+	}
+
+	// Logged after the samples.
+	err = ioutil.WriteFile(filepath.Join(dir, "Msg_100.json"),
+		[]byte(json.MustMarshalIndent(&crypto_proto.VeloMessage{
+			SessionId: "F.Last",
+		})), 0600)
+	assert.NoError(t, err)
+
+	// Fails to process.
+	err = ioutil.WriteFile(filepath.Join(dir, "Msg_20.json"),
+		[]byte(json.MustMarshalIndent(&crypto_proto.VeloMessage{
+			SessionId:  "F.Fail",
+			ResponseId: 1234,
+		})), 0600)
+	assert.NoError(t, err)
+
+	// Malformed files are skipped and unrelated files are ignored.
+	err = ioutil.WriteFile(filepath.Join(dir, "Msg_10.json"),
+		[]byte(`{"session_id": `), 0600)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, "notes.txt"),
+		[]byte(`hello`), 0600)
+	assert.NoError(t, err)
+
+	ingestor := &replayIngestor{fail_id: 1234}
+	result, err := ReplayMessages(context.Background(), ingestor, dir)
+	assert.NoError(t, err)
+
+	assert.Equal(t, len(files)+1, result.Replayed)
+	assert.Equal(t, 1, result.Malformed)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, 2, len(result.Errors))
+	assert.Contains(t, result.Errors, "Msg_10.json")
+	assert.Contains(t, result.Errors, "Msg_20.json")
+
+	// Messages are replayed in the order they were logged.
+	assert.Equal(t, len(files)+1, len(ingestor.messages))
+	assert.Equal(t, "F.Last", ingestor.messages[len(files)].SessionId)
+}
@@ -0,0 +1,117 @@
+package ingestion
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	actions_proto "www.velocidex.com/golang/velociraptor/actions/proto"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/logging"
+)
+
+var (
+	transformDroppedRows = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ingestion_transform_dropped_rows_total",
+			Help: "Number of rows dropped by the document transforms, by reason.",
+		}, []string{"reason"})
+)
+
+// Transforms each row the ingestor writes for the artifact before it
+// is indexed, e.g. to redact sensitive fields or normalize paths. The
+// row may be modified in place. Return false to drop the row.
+type DocumentTransform func(artifact string, row *ordereddict.Dict) bool
+
+// Add a transform to the end of the pipeline. Transforms run in the
+// order they were added and must be added before the ingestor
+// processes messages.
+func (self *Ingestor) AddTransform(transform DocumentTransform) {
+	self.transforms = append(self.transforms, transform)
+}
+
+// A transform which removes the top level fields from every row.
+func RedactFields(fields ...string) DocumentTransform {
+	return func(artifact string, row *ordereddict.Dict) bool {
+		for _, field := range fields {
+			row.Delete(field)
+		}
+		return true
+	}
+}
+
+// Run the rows of the response through the transforms, replacing
+// the response's rows. Rows which are not valid JSON are dropped
+// since they can not be redacted.
+//
+// The row count of the response is left alone: The client numbers
+// the rows of later responses after all the rows it sent, so the
+// dropped rows keep their row numbers and the row ranges of the
+// stored parts stay contiguous.
+func (self Ingestor) transformResponse(
+	config_obj *config_proto.Config, response *actions_proto.VQLResponse) {
+	if len(self.transforms) == 0 || response.Query == nil {
+		return
+	}
+
+	artifact := response.Query.Name
+	result := &bytes.Buffer{}
+	var filtered, invalid int
+
+	reader := bufio.NewReader(bytes.NewReader([]byte(response.JSONLResponse)))
+	for {
+		row_data, err := reader.ReadBytes('\n')
+		if err != nil && len(row_data) == 0 {
+			break
+		}
+
+		row_data = bytes.TrimSpace(row_data)
+		if len(row_data) == 0 {
+			continue
+		}
+
+		row := ordereddict.NewDict()
+		if row.UnmarshalJSON(row_data) != nil {
+			invalid++
+			continue
+		}
+
+		if !self.applyTransforms(artifact, row) {
+			filtered++
+			continue
+		}
+
+		// Never write the row as it was - it may contain
+		// redacted data.
+		serialized, err := row.MarshalJSON()
+		if err != nil {
+			invalid++
+			continue
+		}
+		result.Write(serialized)
+		result.WriteString("\n")
+	}
+
+	response.JSONLResponse = result.String()
+
+	transformDroppedRows.WithLabelValues("filtered").Add(float64(filtered))
+	transformDroppedRows.WithLabelValues("invalid").Add(float64(invalid))
+
+	if invalid > 0 {
+		logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+		logger.Error("Ingestor: Dropped %v rows of %v which are not valid JSON",
+			invalid, artifact)
+	}
+}
+
+func (self Ingestor) applyTransforms(
+	artifact string, row *ordereddict.Dict) bool {
+	for _, transform := range self.transforms {
+		if !transform(artifact, row) {
+			return false
+		}
+	}
+	return true
+}
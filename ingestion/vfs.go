@@ -68,8 +68,8 @@ func (self Ingestor) HandleSystemVfsListDirectory(
 			Timestamp:  utils.GetTime().Now().UnixNano(),
 		}
 
-		err = cvelo_services.SetElasticIndexAsync(
-			config_obj.OrgId,
+		err = cvelo_services.SetElasticIndexAsync(ctx,
+			utils.NormalizedOrgId(config_obj.OrgId),
 			"transient", cvelo_services.DocIdRandom,
 			cvelo_services.BulkUpdateCreate, record)
 
@@ -143,8 +143,8 @@ func (self Ingestor) HandleSystemVfsUpload(
 				Timestamp: utils.GetTime().Now().UnixNano(),
 			}
 
-			cvelo_services.SetElasticIndexAsync(
-				config_obj.OrgId,
+			cvelo_services.SetElasticIndexAsync(ctx,
+				utils.NormalizedOrgId(config_obj.OrgId),
 				"transient", cvelo_services.DocIdRandom,
 				cvelo_services.BulkUpdateCreate, stats)
 		}
@@ -0,0 +1,82 @@
+package simple
+
+import (
+	"bufio"
+	"context"
+	"strings"
+
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	collectionPartsPageSize = 1000
+
+	getCollectionPartsQuery = `
+{
+  "sort": [{"start_row": {"order": "asc"}}],
+  "size": %q,
+  "query": {"bool": {"must": [
+    {"match": {"client_id": %q}},
+    {"match": {"flow_id": %q}},
+    {"match": {"artifact": %q}},
+    {"match": {"type": "results"}},
+    {"range": {"start_row": {"gte": %q}}}
+  ]}}
+}
+`
+)
+
+// Reassemble all the rows an artifact produced in a collection. The
+// rows are spread over many part documents (one per response packet)
+// which are read in start_row order. The artifact name includes the
+// source if the artifact has sources (e.g. "Windows.Sys.Users/Users").
+func GetCollectionResults(
+	ctx context.Context,
+	org_id, client_id, session_id, artifact string) ([]json.RawMessage, error) {
+
+	var result []json.RawMessage
+	next_row := int64(0)
+
+	for {
+		hits, _, err := cvelo_services.QueryElasticRaw(ctx, org_id,
+			"transient", json.Format(getCollectionPartsQuery,
+				collectionPartsPageSize, client_id, session_id,
+				artifact, next_row))
+		if err != nil {
+			return nil, err
+		}
+
+		last_row := next_row
+		for _, hit := range hits {
+			part := &SimpleResultSetRecord{}
+			err = json.Unmarshal(hit, part)
+			if err != nil {
+				return nil, err
+			}
+
+			// Skip parts which overlap rows we already have.
+			if part.StartRow < next_row {
+				continue
+			}
+
+			reader := bufio.NewReader(strings.NewReader(part.JSONData))
+			for {
+				row_data, err := reader.ReadBytes('\n')
+				row_data = []byte(strings.TrimSpace(string(row_data)))
+				if len(row_data) > 0 {
+					result = append(result, json.RawMessage(row_data))
+				}
+				if err != nil {
+					break
+				}
+			}
+			next_row = part.EndRow
+		}
+
+		// A short page or no progress means we have all the parts.
+		if len(hits) < collectionPartsPageSize || next_row == last_row {
+			return result, nil
+		}
+	}
+}
@@ -20,7 +20,8 @@ func (self ResultSetFactory) NewResultSetWriter(
 	completion func(),
 	truncate result_sets.WriteMode) (result_sets.ResultSetWriter, error) {
 
-	org_id := filestore.GetOrgId(file_store_factory)
+	// The root org writes with the "root" org id.
+	org_id := utils.NormalizedOrgId(filestore.GetOrgId(file_store_factory))
 
 	if truncate {
 		base_record := NewSimpleResultSetRecord(log_path)
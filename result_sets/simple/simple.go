@@ -78,7 +78,25 @@ func NewSimpleResultSetRecord(
 		}
 	}
 
-	return &SimpleResultSetRecord{
+	record := &SimpleResultSetRecord{
 		VFSPath: log_path.AsClientPath(),
 	}
+
+	// Flow results are also tagged with the collection they belong
+	// to so all the parts can be found by GetCollectionResults().
+	// Hunt flows have the same flow id on every client so the client
+	// is needed too.
+	// /clients/<client_id>/artifacts/<artifact>/<flow_id>[/<source>]
+	if len(components) >= 5 && len(components) <= 6 &&
+		components[0] == "clients" && components[2] == "artifacts" {
+		record.ClientId = components[1]
+		record.FlowId = components[4]
+		record.Artifact = components[3]
+		record.Type = "results"
+		if len(components) == 6 {
+			record.Artifact += "/" + components[5]
+		}
+	}
+
+	return record
 }
@@ -3,6 +3,7 @@ package simple
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strconv"
 
 	"github.com/Velocidex/ordereddict"
@@ -32,6 +33,10 @@ type ElasticSimpleResultSetWriter struct {
 
 	// If this is set writes will be syncrounous
 	sync bool
+
+	// If set, background writes use a document id derived from this
+	// key so replaying the same data does not create duplicates.
+	idempotency_key string
 }
 
 // Not currently implemented but in future will be used to update
@@ -56,8 +61,16 @@ func (self *ElasticSimpleResultSetWriter) WriteJSONL(
 			self.ctx, self.org_id, "transient",
 			services.DocIdRandom, record)
 	} else {
-		services.SetElasticIndexAsync(
-			self.org_id, "transient", services.DocIdRandom,
+		// Replays are skipped when the id already exists, so the
+		// id must not be shared with different rows.
+		id := services.DocIdRandom
+		if self.idempotency_key != "" && services.CollisionResistantIds() {
+			id = services.MakeId(fmt.Sprintf("%s_%d",
+				self.idempotency_key, record.StartRow))
+		}
+
+		services.SetElasticIndexAsync(self.ctx,
+			self.org_id, "transient", id,
 			cvelo_services.BulkUpdateCreate, record)
 	}
 }
@@ -146,6 +159,13 @@ func (self *ElasticSimpleResultSetWriter) Close() {
 	self.Flush()
 }
 
+// Derive document ids from the key so writing the same data again
+// (e.g. a retransmitted message) results in a single document. This
+// is not done with the xxhash id strategy, whose ids may collide.
+func (self *ElasticSimpleResultSetWriter) SetIdempotencyKey(key string) {
+	self.idempotency_key = key
+}
+
 func (self *ElasticSimpleResultSetWriter) SetSync() {
 	self.sync = true
 }
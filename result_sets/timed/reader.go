@@ -53,8 +53,8 @@ const getTimedRowsQuery = `
          {"match": {"flow_id": %q}},
          {"match": {"artifact": %q}},
          {"match": {"type": %q}},
-         {"range": {"timestamp": {"gte": %q}}},
-         {"range": {"timestamp": {"lt": %q}}}
+         {"range": {%q: {"gte": %q}}},
+         {"range": {%q: {"lt": %q}}}
       ]
     }
   }
@@ -88,8 +88,8 @@ func (self *TimedResultSetReader) Rows(
 			record.FlowId,
 			record.Artifact,
 			record.Type,
-			start.UnixNano(),
-			end.UnixNano(),
+			MonitoringTimestampField, start.UnixNano(),
+			MonitoringTimestampField, end.UnixNano(),
 		)
 		subctx, cancel := context.WithCancel(ctx)
 		defer cancel()
@@ -98,8 +98,9 @@ func (self *TimedResultSetReader) Rows(
 
 		hits_chan, err := cvelo_services.QueryChan(
 			subctx, self.config_obj.VeloConf(), 1000,
-			self.config_obj.OrgId, "transient", query,
-			"timestamp")
+			self.config_obj.OrgId,
+			cvelo_services.ResolveIndexPattern("transient", MonitoringDocType),
+			query, MonitoringTimestampField)
 		if err != nil {
 			logger := logging.GetLogger(
 				self.config_obj.VeloConf(), &logging.FrontendComponent)
@@ -14,17 +14,35 @@ import (
 	"www.velocidex.com/golang/velociraptor/utils"
 )
 
+// The canonical timestamp field of timed result set documents (the
+// monitoring data). Readers page through the results sorted on this
+// field so every document must set it - it is always filled in by
+// NewTimedResultSetRecord. It is also the data stream timestamp
+// field of the transient index.
+const MonitoringTimestampField = "timestamp"
+
+// Timed result set documents may be written to time bucketed indexes
+// by configuring this doc type as a time series. Otherwise they are
+// stored in the transient index.
+const MonitoringDocType = services.MonitoringDocType
+
 // This is the record we store in the elastic datastore. Timed Results
 // are usually written from event artifacts.
 type TimedResultSetRecord struct {
-	ClientId  string `json:"client_id"`
-	FlowId    string `json:"flow_id"`
-	Artifact  string `json:"artifact"`
-	Type      string `json:"type"`
+	ClientId string `json:"client_id"`
+	FlowId   string `json:"flow_id"`
+	Artifact string `json:"artifact"`
+	Type     string `json:"type"`
+
+	// Stored in MonitoringTimestampField (nanoseconds).
 	Timestamp int64  `json:"timestamp"`
 	Date      int64  `json:"date"` // Timestamp rounded down to the UTC day
 	VFSPath   string `json:"vfs_path"`
 	JSONData  string `json:"data"`
+
+	// The locations of IP addresses in the rows when GeoIP
+	// enrichment is enabled.
+	Geo []services.GeoLocation `json:"geo,omitempty"`
 }
 
 // Examine the pathspec and construct a new Elastic record.
@@ -80,13 +98,23 @@ type ElasticTimedResultSetWriter struct {
 
 func (self ElasticTimedResultSetWriter) WriteJSONL(
 	serialized []byte, total_rows int) {
+	self.WriteJSONLWithGeo(serialized, total_rows, nil)
+}
+
+// Write the rows together with the locations of the IP addresses
+// they contain so they can be found with geo queries.
+func (self ElasticTimedResultSetWriter) WriteJSONLWithGeo(
+	serialized []byte, total_rows int, geo []services.GeoLocation) {
 
 	record := NewTimedResultSetRecord(self.path_manager)
 	record.JSONData = string(serialized)
+	record.Geo = geo
 
+	index := services.ResolveIndex("transient", MonitoringDocType,
+		time.Unix(0, record.Timestamp))
 	services.SetElasticIndex(self.ctx,
 		filestore.GetOrgId(self.file_store_factory),
-		"transient", services.DocIdRandom, record)
+		index, services.DocIdRandom, record)
 }
 
 func (self ElasticTimedResultSetWriter) Write(row *ordereddict.Dict) {
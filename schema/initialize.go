@@ -104,6 +104,9 @@ func DeleteAllOrgs(ctx context.Context,
 	return nil
 }
 
+// Install the index templates. Templates installed by an older
+// release (i.e. with a lower version) are replaced and their new
+// fields are mapped in the existing indexes.
 func InstallIndexTemplates(
 	ctx context.Context,
 	config_obj *config_proto.Config) error {
@@ -117,23 +120,48 @@ func InstallIndexTemplates(
 	for _, filename := range files {
 		name := strings.Split(filename.Name(), ".")[0]
 
-		// Check if the template is defined.
-		err := services.DoesTemplateExist(ctx, name)
-		if err == nil {
-			continue
+		data, err := fs.ReadFile(path.Join("templates", filename.Name()))
+		if err != nil {
+			return err
 		}
 
-		data, err := fs.ReadFile(path.Join("templates", filename.Name()))
+		version, err := services.GetTemplateBodyVersion(string(data))
 		if err != nil {
 			return err
 		}
 
-		logger.Info("Creating index template %v\n", name)
-		err = services.PutTemplate(ctx, name, string(data))
+		installed_version, exists, err := services.GetTemplateVersion(ctx, name)
+		if err != nil {
+			logger.Error("While checking index template %v: %v", name, err)
+			continue
+		}
+
+		switch {
+		case !exists:
+			logger.Info("Creating index template %v\n", name)
+			err = services.PutTemplate(ctx, name, string(data))
+			if err != nil {
+				logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+				logger.Error("While creating index template %v: %v",
+					name, err)
+			}
+
+		case installed_version < version:
+			logger.Info("Updating index template %v from version %v to %v\n",
+				name, installed_version, version)
+			err = services.UpdateTemplate(ctx, name, string(data))
+			if err != nil {
+				logger.Error("While updating index template %v: %v",
+					name, err)
+			}
+		}
+
+		// Existing indexes keep the types they were created with,
+		// warn about fields which need a reindex to match the
+		// template.
+		err = services.CheckTemplateMappingDrift(ctx, string(data))
 		if err != nil {
-			logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
-			logger.Error("While creating index template %v: %v",
-				name, err)
+			logger.Warn("While checking index template %v: %v", name, err)
 		}
 	}
 
@@ -4,6 +4,7 @@ import (
 	"www.velocidex.com/golang/cloudvelo/config"
 	"www.velocidex.com/golang/cloudvelo/crypto/server"
 	"www.velocidex.com/golang/cloudvelo/filestore"
+	"www.velocidex.com/golang/cloudvelo/vql/uploads"
 )
 
 func NewCommunicator(
@@ -13,9 +14,10 @@ func NewCommunicator(
 
 	sess, err := filestore.GetS3Session(config_obj)
 	return &Communicator{
-		session:        sess,
-		config_obj:     config_obj,
-		backend:        backend,
-		crypto_manager: crypto_manager,
+		session:         sess,
+		config_obj:      config_obj,
+		backend:         backend,
+		crypto_manager:  crypto_manager,
+		upload_sessions: uploads.NewSessionTracker(),
 	}, err
 }
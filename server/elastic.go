@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"sync"
 
 	"www.velocidex.com/golang/cloudvelo/config"
 	"www.velocidex.com/golang/cloudvelo/crypto/server"
@@ -37,9 +38,36 @@ func NewElasticBackend(
 	return &ElasticBackend{ingestor: ingestor}, nil
 }
 
+// Process the messages with a pool of config_obj.Cloud.IngestionWorkers
+// workers which stop when ctx is done. Without workers this is the
+// same as NewElasticBackend.
+func NewPooledElasticBackend(
+	ctx context.Context, wg *sync.WaitGroup,
+	config_obj *config.Config,
+	crypto_manager *server.ServerCryptoManager) (
+	*ElasticBackend, error) {
+	backend, err := NewElasticBackend(config_obj, crypto_manager)
+	if err != nil {
+		return nil, err
+	}
+
+	if config_obj.Cloud.IngestionWorkers > 0 {
+		backend.ingestor = ingestion.NewIngestorPool(ctx, wg,
+			backend.ingestor, config_obj.Cloud.IngestionWorkers)
+	}
+	return backend, nil
+}
+
 // For accepting messages FROM client to SERVER
 func (self ElasticBackend) Send(
 	ctx context.Context, messages []*crypto_proto.VeloMessage) error {
+
+	// Process the messages in parallel if possible.
+	pool, ok := self.ingestor.(*ingestion.IngestorPool)
+	if ok {
+		return pool.ProcessBatch(ctx, messages)
+	}
+
 	for _, msg := range messages {
 		err := self.ingestor.Process(ctx, msg)
 		if err != nil {
@@ -15,6 +15,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"www.velocidex.com/golang/cloudvelo/config"
 	"www.velocidex.com/golang/cloudvelo/crypto/server"
+	"www.velocidex.com/golang/cloudvelo/vql/uploads"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
 	"www.velocidex.com/golang/velociraptor/constants"
 	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
@@ -42,6 +43,9 @@ type Communicator struct {
 	parts []*s3.CompletedPart
 
 	crypto_manager *server.ServerCryptoManager
+
+	// Tracks the progress of multipart uploads so they can resume.
+	upload_sessions *uploads.SessionTracker
 }
 
 // Receive a POST message from the client with the VeloMessage in
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -79,8 +80,36 @@ func (self *Communicator) StartMultipartUpload(
 	}
 
 	// Formulate the filestore path from the upload request.
-	svc := s3.New(self.session)
 	key := filestore.S3KeyForClientUpload(org_id, request)
+
+	// If the client was interrupted while uploading this file, tell
+	// it where to continue from. The upload may be gone from S3
+	// (e.g. it was completed but the session could not be removed)
+	// in which case a new upload is started.
+	upload_session, err := self.upload_sessions.Get(r.Context(), org_id, key)
+	if err == nil && !self.uploadExists(key, upload_session.UploadId) {
+		self.log("Upload %v of %v is gone, starting again",
+			upload_session.UploadId, key)
+		err = self.upload_sessions.Abort(r.Context(), org_id, key)
+		if err == nil {
+			err = os.ErrNotExist
+		}
+	}
+
+	if err == nil {
+		self.log("Resuming upload %v at %v", key, upload_session.Offset)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(json.MustMarshalString(uploads.UploadResponse{
+			Key:      key,
+			UploadId: upload_session.UploadId,
+			Offset:   upload_session.Offset,
+			Parts:    upload_session.Parts,
+		})))
+		return
+	}
+
+	svc := s3.New(self.session)
 	s3_request := &s3.CreateMultipartUploadInput{
 		Bucket:      aws.String(self.config_obj.Cloud.Bucket),
 		Key:         aws.String(key),
@@ -102,6 +131,15 @@ func (self *Communicator) StartMultipartUpload(
 		return
 	}
 
+	// The upload works without tracking, it just can not be
+	// resumed.
+	err = self.upload_sessions.Start(r.Context(), org_id, key, *resp.UploadId)
+	if err != nil {
+		logger := logging.GetLogger(
+			self.config_obj.VeloConf(), &logging.FrontendComponent)
+		logger.Error("While tracking upload %v: %v", *resp.UploadId, err)
+	}
+
 	response := uploads.UploadResponse{
 		Key:      key,
 		UploadId: *resp.UploadId,
@@ -135,7 +173,7 @@ func extractUploadRequest(in url.Values) (
 
 func (self *Communicator) GetUploadPart(
 	w http.ResponseWriter, r *http.Request) {
-	_, err := self.verifyToken(r)
+	org_id, err := self.verifyToken(r)
 	if err != nil {
 		w.WriteHeader(http.StatusForbidden)
 		return
@@ -169,6 +207,22 @@ func (self *Communicator) GetUploadPart(
 		PartNumber: aws.Int64(int64(req.Part)),
 	}
 
+	err = self.upload_sessions.AddPart(r.Context(), org_id, req.Key,
+		req.UploadId, req.Offset, len(serialized), completed_part)
+	if errors.Is(err, uploads.ErrNotContiguous) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// The part is stored so the upload can still complete - it just
+	// can not be resumed from this part if it is interrupted.
+	if err != nil {
+		logger := logging.GetLogger(
+			self.config_obj.VeloConf(), &logging.FrontendComponent)
+		logger.Error("While tracking upload %v: %v", req.UploadId, err)
+	}
+
 	w.WriteHeader(http.StatusOK)
 	w.Write(json.MustMarshalIndent(completed_part))
 }
@@ -203,6 +257,18 @@ func (self *Communicator) uploadPart(
 	return nil, err
 }
 
+// Is the multipart upload still in progress in S3?
+func (self *Communicator) uploadExists(key, upload_id string) bool {
+	svc := s3.New(self.session)
+	_, err := svc.ListParts(&s3.ListPartsInput{
+		Bucket:   aws.String(self.config_obj.Cloud.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(upload_id),
+		MaxParts: aws.Int64(1),
+	})
+	return err == nil
+}
+
 func (self *Communicator) completeUpload(
 	key, upload_id string, parts []*s3.CompletedPart) error {
 	svc := s3.New(self.session)
@@ -223,7 +289,7 @@ func (self *Communicator) completeUpload(
 
 func (self *Communicator) CompleteMultipartUpload(
 	w http.ResponseWriter, r *http.Request) {
-	_, err := self.verifyToken(r)
+	org_id, err := self.verifyToken(r)
 	if err != nil {
 		w.WriteHeader(http.StatusForbidden)
 		return
@@ -258,10 +324,68 @@ func (self *Communicator) CompleteMultipartUpload(
 		return
 	}
 
+	// The file is stored now so the client must not retry. A
+	// session left behind is dropped when the key is uploaded again
+	// or when it expires.
+	err = self.upload_sessions.Complete(r.Context(), org_id, request.Key)
+	if err != nil {
+		logger := logging.GetLogger(
+			self.config_obj.VeloConf(), &logging.FrontendComponent)
+		logger.Error("While tracking upload %v: %v", request.UploadId, err)
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
-func (self Communicator) AbortMultipartUpload(
+// The client gave up on the upload. Release the parts stored so far
+// and forget the session so the next upload of the file starts
+// again.
+func (self *Communicator) AbortMultipartUpload(
 	w http.ResponseWriter, r *http.Request) {
+	org_id, err := self.verifyToken(r)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	serialized, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer r.Body.Close()
+
+	request := &uploads.UploadAbortRequest{}
+	err = json.Unmarshal(serialized, &request)
+	if err != nil || request.UploadId == "" || request.Key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid request"))
+		return
+	}
 
+	svc := s3.New(self.session)
+	abort_input := &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(self.config_obj.Cloud.Bucket),
+		Key:      aws.String(request.Key),
+		UploadId: aws.String(request.UploadId),
+	}
+
+	self.log("AbortMultipartUpload %v", abort_input)
+
+	_, err = svc.AbortMultipartUpload(abort_input)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = self.upload_sessions.Abort(r.Context(), org_id, request.Key)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
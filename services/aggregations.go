@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// A parsed aggregation result. Bucket aggregations (e.g. terms,
+// date_histogram) have Buckets, while metric aggregations (e.g. sum,
+// cardinality) have a Value.
+type AggResult struct {
+	Value   interface{}  `json:"value,omitempty"`
+	Buckets []*AggBucket `json:"buckets,omitempty"`
+}
+
+// A single bucket and the results of any sub-aggregations within it.
+type AggBucket struct {
+	Key          interface{}           `json:"key"`
+	KeyAsString  string                `json:"key_as_string,omitempty"`
+	Count        int                   `json:"doc_count"`
+	Aggregations map[string]*AggResult `json:"aggregations,omitempty"`
+}
+
+// Run an aggregation query and return the full tree of aggregation
+// results, including nested sub-aggregations.
+func QueryElasticAggregationTree(
+	ctx context.Context, org_id, index, query string) (
+	map[string]*AggResult, error) {
+
+	defer Instrument("QueryElasticAggregationTree")()
+	defer Debug("QueryElasticAggregationTree %v", index)()
+
+	es, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+	res, err := es.Search(
+		QueryOptions{}.searchOptions(ctx, es, org_id, index, query)...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// There was an error so we need to relay it
+	if res.IsError() {
+		return nil, makeReadElasticError(data)
+	}
+
+	return ParseAggregations(data)
+}
+
+// Run an aggregation query and return the aggregations node of the
+// response as is, for callers which need to parse aggregations we
+// have no helper for (e.g. composite or top_hits).
+func QueryElasticRawAggregations(
+	ctx context.Context, org_id, index, query string) (
+	json.RawMessage, error) {
+
+	defer Instrument("QueryElasticRawAggregations")()
+	defer Debug("QueryElasticRawAggregations %v", index)()
+
+	es, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+	res, err := es.Search(
+		QueryOptions{}.searchOptions(ctx, es, org_id, index, query)...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// There was an error so we need to relay it
+	if res.IsError() {
+		return nil, makeReadElasticError(data)
+	}
+
+	response := &struct {
+		Aggregations json.RawMessage `json:"aggregations"`
+	}{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return nil, makeReadElasticError(data)
+	}
+
+	if len(response.Aggregations) == 0 {
+		// Nothing was searched so there is nothing to aggregate.
+		if searchedNoShards(data) {
+			return nil, nil
+		}
+		return nil, errors.New(
+			"QueryElasticRawAggregations: no aggregations in response")
+	}
+
+	return response.Aggregations, nil
+}
+
+// Parse the aggregations from a search response.
+func ParseAggregations(data []byte) (map[string]*AggResult, error) {
+	response := &struct {
+		Aggregations map[string]json.RawMessage `json:"aggregations"`
+	}{}
+	err := json.Unmarshal(data, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseAggregationMap(response.Aggregations), nil
+}
+
+func parseAggregationMap(
+	aggs map[string]json.RawMessage) map[string]*AggResult {
+	result := make(map[string]*AggResult)
+	for name, raw := range aggs {
+		agg := parseAggregation(raw)
+		if agg != nil {
+			result[name] = agg
+		}
+	}
+	return result
+}
+
+// Returns nil if the raw message is not an aggregation result.
+func parseAggregation(raw json.RawMessage) *AggResult {
+	fields := make(map[string]json.RawMessage)
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return nil
+	}
+
+	value, has_value := fields["value"]
+	buckets, has_buckets := fields["buckets"]
+	if !has_value && !has_buckets {
+		return nil
+	}
+
+	result := &AggResult{}
+	if has_value {
+		_ = json.Unmarshal(value, &result.Value)
+	}
+
+	if has_buckets {
+		raw_buckets := []json.RawMessage{}
+		err := json.Unmarshal(buckets, &raw_buckets)
+		if err != nil {
+			return result
+		}
+
+		for _, raw_bucket := range raw_buckets {
+			bucket := parseBucket(raw_bucket)
+			if bucket != nil {
+				result.Buckets = append(result.Buckets, bucket)
+			}
+		}
+	}
+
+	return result
+}
+
+func parseBucket(raw json.RawMessage) *AggBucket {
+	fields := make(map[string]json.RawMessage)
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return nil
+	}
+
+	bucket := &AggBucket{}
+	sub_aggs := make(map[string]json.RawMessage)
+	for k, v := range fields {
+		switch k {
+		case "key":
+			_ = json.Unmarshal(v, &bucket.Key)
+		case "key_as_string":
+			_ = json.Unmarshal(v, &bucket.KeyAsString)
+		case "doc_count":
+			_ = json.Unmarshal(v, &bucket.Count)
+		default:
+			sub_aggs[k] = v
+		}
+	}
+
+	if len(sub_aggs) > 0 {
+		bucket.Aggregations = parseAggregationMap(sub_aggs)
+	}
+
+	return bucket
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// Count of documents per client per day.
+const nestedAggResponse = `
+{
+  "took": 1,
+  "hits": {"total": {"value": 5}, "hits": []},
+  "aggregations": {
+    "clients": {
+      "doc_count_error_upper_bound": 0,
+      "buckets": [
+        {
+          "key": "C.1",
+          "doc_count": 3,
+          "days": {
+            "buckets": [
+              {"key": 1661385600000, "key_as_string": "2022-08-25", "doc_count": 2},
+              {"key": 1661472000000, "key_as_string": "2022-08-26", "doc_count": 1}
+            ]
+          },
+          "total": {"value": 30}
+        },
+        {
+          "key": "C.2",
+          "doc_count": 2,
+          "days": {
+            "buckets": [
+              {"key": 1661385600000, "key_as_string": "2022-08-25", "doc_count": 2}
+            ]
+          },
+          "total": {"value": 5}
+        }
+      ]
+    },
+    "count": {"value": 2}
+  }
+}
+`
+
+func TestParseNestedAggregations(t *testing.T) {
+	aggs, err := ParseAggregations([]byte(nestedAggResponse))
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(2), aggs["count"].Value)
+
+	clients := aggs["clients"].Buckets
+	assert.Equal(t, 2, len(clients))
+	assert.Equal(t, "C.1", clients[0].Key)
+	assert.Equal(t, 3, clients[0].Count)
+	assert.Equal(t, float64(30), clients[0].Aggregations["total"].Value)
+
+	days := clients[0].Aggregations["days"].Buckets
+	assert.Equal(t, 2, len(days))
+	assert.Equal(t, "2022-08-26", days[1].KeyAsString)
+	assert.Equal(t, 1, days[1].Count)
+
+	days = clients[1].Aggregations["days"].Buckets
+	assert.Equal(t, 1, len(days))
+	assert.Equal(t, 2, days[0].Count)
+}
+
+func TestQueryElasticAggregationsMissingName(t *testing.T) {
+	response := nestedAggResponse
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	query := `{"size": 0, "aggs": {"clients": {"terms": {"field": "client_id"}}}}`
+
+	// The response has no "genres" aggregation.
+	_, err := QueryElasticAggregations(ctx, "test", "transient", query)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "genres")
+
+	// Name the aggregation explicitly.
+	hits, err := QueryElasticAggregationsWithOptions(ctx, "test", "transient",
+		query, QueryOptions{AggregationName: "clients"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"C.1", "C.2"}, hits)
+
+	// Nothing was searched so there is nothing to aggregate.
+	response = `{"_shards": {"total": 0}, "hits": {"hits": []}}`
+	hits, err = QueryElasticAggregations(ctx, "test", "transient", query)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(hits))
+}
+
+func TestQueryElasticRawAggregations(t *testing.T) {
+	response := `
+{
+  "hits": {"total": {"value": 3}, "hits": []},
+  "aggregations": {
+    "clients": {
+      "after_key": {"client_id": "C.2", "artifact": "Generic.Client.Stats"},
+      "buckets": [
+        {"key": {"client_id": "C.1", "artifact": "Generic.Client.Stats"}, "doc_count": 2},
+        {"key": {"client_id": "C.2", "artifact": "Generic.Client.Stats"}, "doc_count": 1}
+      ]
+    }
+  }
+}`
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	query := `{"size": 0, "aggs": {"clients": {"composite": {"sources": [
+  {"client_id": {"terms": {"field": "client_id"}}},
+  {"artifact": {"terms": {"field": "artifact"}}}
+]}}}}`
+
+	raw, err := QueryElasticRawAggregations(ctx, "test", "transient", query)
+	assert.NoError(t, err)
+
+	// The caller gets the whole aggregations node, including parts
+	// the typed helpers drop such as the composite after_key.
+	parsed := &struct {
+		Clients struct {
+			AfterKey map[string]string `json:"after_key"`
+			Buckets  []struct {
+				Key   map[string]string `json:"key"`
+				Count int               `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"clients"`
+	}{}
+	err = json.Unmarshal(raw, parsed)
+	assert.NoError(t, err)
+	assert.Equal(t, "C.2", parsed.Clients.AfterKey["client_id"])
+	assert.Equal(t, 2, len(parsed.Clients.Buckets))
+	assert.Equal(t, "C.1", parsed.Clients.Buckets[0].Key["client_id"])
+	assert.Equal(t, 2, parsed.Clients.Buckets[0].Count)
+
+	// No aggregations in the response.
+	response = `{"_shards": {"total": 1}, "hits": {"hits": []}}`
+	_, err = QueryElasticRawAggregations(ctx, "test", "transient", query)
+	assert.Error(t, err)
+}
+
+func TestCountByDocType(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`
+{
+  "hits": {"total": {"value": 6}, "hits": []},
+  "aggregations": {
+    "doc_types": {
+      "buckets": [
+        {"key": "clients", "doc_count": 3},
+        {"key": "hunts", "doc_count": 2},
+        {"key": "notebooks", "doc_count": 1}
+      ]
+    }
+  }
+}`))
+	})
+	defer closer()
+
+	counts, err := CountByDocType(context.Background(), "test", "persisted")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"clients":   3,
+		"hunts":     2,
+		"notebooks": 1,
+	}, counts)
+}
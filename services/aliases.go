@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type _AliasAction map[string]interface{}
+
+type _AliasActionDetails struct {
+	Index  string          `json:"index"`
+	Alias  string          `json:"alias"`
+	Filter json.RawMessage `json:"filter,omitempty"`
+}
+
+// Create a read only view over the index (e.g. "persisted") of each
+// of the orgs. Reads through the alias only see the documents of
+// these orgs which match the filter (a query clause, or empty for
+// all of them) so the cluster enforces the scope, e.g. for an admin
+// who may only see some orgs. Read through the alias with the root
+// org (e.g. QueryElasticRaw(ctx, "root", alias, query)) as it is not
+// prefixed.
+//
+// If the alias already exists it is replaced atomically so readers
+// never see a mix of the old and new views. The org indexes must
+// exist and may not be data streams.
+func CreateFilteredAlias(ctx context.Context,
+	alias string, org_ids []string, index, filter string) error {
+	defer Instrument("CreateFilteredAlias")()
+	defer Debug("CreateFilteredAlias %v %v %v", alias, index, org_ids)()
+
+	if alias == "" || len(org_ids) == 0 {
+		return errors.New(
+			"CreateFilteredAlias: an alias and at least one org are required")
+	}
+
+	if filter != "" && !stdjson.Valid([]byte(filter)) {
+		return fmt.Errorf("CreateFilteredAlias: %w: filter is not valid JSON",
+			ErrInvalidQuery)
+	}
+
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	existing, err := getAliasIndexes(ctx, alias)
+	if err != nil {
+		return err
+	}
+
+	var actions []_AliasAction
+	for _, name := range existing {
+		actions = append(actions, _AliasAction{
+			"remove": &_AliasActionDetails{Index: name, Alias: alias},
+		})
+	}
+
+	for _, org_id := range org_ids {
+		details := &_AliasActionDetails{
+			Index: GetIndex(org_id, index),
+			Alias: alias,
+		}
+		if filter != "" {
+			details.Filter = json.RawMessage(filter)
+		}
+		actions = append(actions, _AliasAction{"add": details})
+	}
+
+	return updateAliases(ctx, actions)
+}
+
+// Remove the alias from all the indexes it covers. Removing an alias
+// which does not exist is not an error.
+func DeleteFilteredAlias(ctx context.Context, alias string) error {
+	defer Instrument("DeleteFilteredAlias")()
+	defer Debug("DeleteFilteredAlias %v", alias)()
+
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	existing, err := getAliasIndexes(ctx, alias)
+	if err != nil {
+		return err
+	}
+
+	if len(existing) == 0 {
+		return nil
+	}
+
+	var actions []_AliasAction
+	for _, name := range existing {
+		actions = append(actions, _AliasAction{
+			"remove": &_AliasActionDetails{Index: name, Alias: alias},
+		})
+	}
+
+	return updateAliases(ctx, actions)
+}
+
+// The indexes the alias currently covers.
+func getAliasIndexes(ctx context.Context, alias string) ([]string, error) {
+	client, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := opensearchapi.IndicesGetAliasRequest{
+		Name: []string{alias},
+	}.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.IsError() {
+		return nil, makeReadElasticError(data)
+	}
+
+	aliases := make(_IndexAliases)
+	err = json.Unmarshal(data, &aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(aliases))
+	for name := range aliases {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Apply all the actions in one request so they take effect together.
+func updateAliases(ctx context.Context, actions []_AliasAction) error {
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IndicesUpdateAliasesRequest{
+		Body: strings.NewReader(json.MustMarshalString(
+			map[string]interface{}{"actions": actions})),
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return makeElasticError(data)
+	}
+	return nil
+}
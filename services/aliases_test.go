@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateFilteredAlias(t *testing.T) {
+	var update string
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// The alias currently covers an org which is no longer in
+		// the view.
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"org3_persisted": {"aliases": {"admin_view": {}}}}`))
+			return
+		}
+
+		data, _ := ioutil.ReadAll(r.Body)
+		update = string(data)
+		w.Write([]byte(`{"acknowledged": true}`))
+	})
+	defer closer()
+
+	err := CreateFilteredAlias(context.Background(), "admin_view",
+		[]string{"org1", "ORG2"}, "persisted",
+		`{"term": {"doc_type": "clients"}}`)
+	assert.NoError(t, err)
+
+	// The old org is removed and the new ones added in one request.
+	assert.Equal(t, `{"actions":[`+
+		`{"remove":{"index":"org3_persisted","alias":"admin_view"}},`+
+		`{"add":{"index":"org1_persisted","alias":"admin_view","filter":{"term":{"doc_type":"clients"}}}},`+
+		`{"add":{"index":"org2_persisted","alias":"admin_view","filter":{"term":{"doc_type":"clients"}}}}]}`,
+		strings.TrimSpace(update))
+
+	err = CreateFilteredAlias(context.Background(), "admin_view",
+		[]string{"org1"}, "persisted", `{"term": `)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	// Convert the old time to nanoseconds since the epoch. Numbers
+	// may be in seconds, milliseconds, microseconds or nanoseconds
+	// which we tell apart by their magnitude (any time after 1973 in
+	// seconds is less than any time in milliseconds). Strings may be
+	// numbers or ISO 8601 times with a zone (e.g. RFC3339).
+	// Documents which already have the canonical field, or whose old
+	// time can not be parsed, are left alone.
+	backfillTimestampPainless = `
+def value = ctx._source[params.source];
+if (value == null || ctx._source[params.dest] != null) {
+  ctx.op = 'noop';
+  return;
+}
+
+double number = 0;
+if (value instanceof Number) {
+  number = value.doubleValue();
+} else if (value instanceof String) {
+  try {
+    number = Double.parseDouble(value);
+  } catch (NumberFormatException e) {
+    try {
+      Instant instant = ZonedDateTime.parse(value).toInstant();
+      ctx._source[params.dest] =
+        instant.getEpochSecond() * 1000000000L + instant.getNano();
+      return;
+    } catch (Exception e2) {
+      ctx.op = 'noop';
+      return;
+    }
+  }
+} else {
+  ctx.op = 'noop';
+  return;
+}
+
+double abs = Math.abs(number);
+if (abs < 1e11) {
+  number = number * 1e9;
+} else if (abs < 1e14) {
+  number = number * 1e6;
+} else if (abs < 1e17) {
+  number = number * 1e3;
+}
+ctx._source[params.dest] = (long)number;
+`
+
+	backfillTimestampQuery = `{
+  "query": {
+    "bool": {
+      "must_not": [{"exists": {"field": %q}}]
+    }
+  },
+  "script": {
+    "source": %q,
+    "lang": "painless",
+    "params": {"source": %q, "dest": %q}
+  }
+}`
+)
+
+// Fill in the canonical timestamp field (dest_field, in nanoseconds)
+// of documents in the index which do not have it yet from their old
+// time field (source_field). Older documents can then be sorted
+// together with new ones. Safe to run again - documents which
+// already have the field are not changed. Returns the number of
+// documents that were updated.
+func BackfillTimestamps(
+	ctx context.Context, index, source_field, dest_field string) (int, error) {
+	defer Instrument("BackfillTimestamps")()
+	defer Debug("BackfillTimestamps %v %v -> %v",
+		index, source_field, dest_field)()
+
+	if source_field == "" || dest_field == "" {
+		return 0, errors.New(
+			"BackfillTimestamps: source and destination fields must be specified")
+	}
+
+	err := checkWritable()
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return 0, err
+	}
+
+	// The source field may not be mapped so we can not search for
+	// it - the script skips documents without it.
+	query := json.Format(backfillTimestampQuery, dest_field,
+		backfillTimestampPainless, source_field, dest_field)
+
+	res, err := opensearchapi.UpdateByQueryRequest{
+		Index:     []string{index},
+		Body:      strings.NewReader(query),
+		Refresh:   &TRUE,
+		Conflicts: "proceed",
+	}.Do(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.IsError() {
+		return 0, makeElasticError(data)
+	}
+
+	response := &_UpdateByQueryResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return 0, err
+	}
+
+	return response.Updated, nil
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestBackfillTimestamps(t *testing.T) {
+	var path, conflicts, body string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		path = r.URL.Path
+		conflicts = r.URL.Query().Get("conflicts")
+		body = string(data)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"updated": 2, "noops": 1}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	count, err := BackfillTimestamps(ctx, "test_persisted", "created", "timestamp")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, "/test_persisted/_update_by_query", path)
+	assert.Equal(t, "proceed", conflicts)
+
+	// Only documents missing the canonical field are updated.
+	request := &struct {
+		Query struct {
+			Bool struct {
+				MustNot []map[string]map[string]string `json:"must_not"`
+			} `json:"bool"`
+		} `json:"query"`
+		Script struct {
+			Params map[string]string `json:"params"`
+		} `json:"script"`
+	}{}
+	err = json.Unmarshal([]byte(body), request)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]map[string]string{
+		{"exists": {"field": "timestamp"}},
+	}, request.Query.Bool.MustNot)
+	assert.Equal(t, map[string]string{
+		"source": "created", "dest": "timestamp",
+	}, request.Script.Params)
+
+	_, err = BackfillTimestamps(ctx, "test_persisted", "", "timestamp")
+	assert.Error(t, err)
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type batchWritesKey int
+
+// The indexes written during a batch which need to be refreshed
+// when it ends.
+type batchWrites struct {
+	mu      sync.Mutex
+	indexes map[string]bool
+}
+
+func (self *batchWrites) add(indexes ...string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for _, index := range indexes {
+		self.indexes[index] = true
+	}
+}
+
+func (self *batchWrites) take() []string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	result := make([]string, 0, len(self.indexes))
+	for index := range self.indexes {
+		result = append(result, index)
+	}
+	sort.Strings(result)
+
+	self.indexes = make(map[string]bool)
+	return result
+}
+
+// Writes which normally refresh the index so they are visible
+// immediately (e.g. SetElasticIndex) skip the refresh when made with
+// the returned context. Instead all the indexes written are refreshed
+// once when the returned function is called, which is much faster
+// for many writes. Until then the writes may not be visible to
+// searches.
+//
+// A nested batch joins the outer one and its end function does
+// nothing - the outer batch refreshes.
+func BatchWrites(ctx context.Context) (context.Context, func() error) {
+	_, pres := ctx.Value(batchWritesKey(0)).(*batchWrites)
+	if pres {
+		return ctx, func() error { return nil }
+	}
+
+	batch := &batchWrites{indexes: make(map[string]bool)}
+	return context.WithValue(ctx, batchWritesKey(0), batch), func() error {
+		indexes := batch.take()
+		if len(indexes) == 0 {
+			return nil
+		}
+		return refreshIndexes(ctx, indexes...)
+	}
+}
+
+func getBatchWrites(ctx context.Context) (*batchWrites, bool) {
+	batch, pres := ctx.Value(batchWritesKey(0)).(*batchWrites)
+	return batch, pres
+}
+
+// The refresh parameter for a write to the index which should be
+// visible immediately. Within a batch the index is refreshed when
+// the batch ends instead.
+func getWriteRefresh(ctx context.Context, index string) string {
+	batch, pres := getBatchWrites(ctx)
+	if !pres {
+		return "true"
+	}
+
+	batch.add(index)
+	return "false"
+}
+
+// Refresh the indexes after a write, or leave it to the batch.
+func refreshAfterWrite(ctx context.Context, indexes ...string) error {
+	batch, pres := getBatchWrites(ctx)
+	if !pres {
+		return refreshIndexes(ctx, indexes...)
+	}
+
+	batch.add(indexes...)
+	return nil
+}
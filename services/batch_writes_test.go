@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchWrites(t *testing.T) {
+	var mu sync.Mutex
+	var refreshes []string
+	var write_refresh []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if strings.HasSuffix(r.URL.Path, "/_refresh") {
+			refreshes = append(refreshes, r.URL.Path)
+			w.Write([]byte(`{"_shards": {"total": 1, "successful": 1, "failed": 0}}`))
+			return
+		}
+
+		write_refresh = append(write_refresh, r.URL.Query().Get("refresh"))
+		w.Write([]byte(`{"_index": "test_persisted", "_id": "1", "_version": 1,
+  "result": "updated"}`))
+	})
+	defer closer()
+
+	ctx, end := BatchWrites(context.Background())
+
+	// A nested batch joins the outer one.
+	nested_ctx, nested_end := BatchWrites(ctx)
+	for _, id := range []string{"1", "2", "3"} {
+		err := SetElasticIndex(nested_ctx, "test", "persisted", id,
+			map[string]string{"id": id})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, nested_end())
+
+	err := UpdateIndex(ctx, "test", "transient", "4", `{"doc": {}}`)
+	assert.NoError(t, err)
+
+	err = DeleteDocument(ctx, "test", "persisted", "1", true)
+	assert.NoError(t, err)
+
+	// None of the writes refreshed by themselves.
+	assert.Equal(t, 0, len(refreshes))
+	assert.Equal(t, []string{"false", "false", "false", "false", ""},
+		write_refresh)
+
+	// All the written indexes are refreshed once at the end.
+	assert.NoError(t, end())
+	assert.Equal(t, []string{"/test_persisted,test_transient/_refresh"},
+		refreshes)
+
+	// Outside a batch every write refreshes.
+	err = SetElasticIndex(context.Background(), "test", "persisted", "5",
+		map[string]string{"id": "5"})
+	assert.NoError(t, err)
+	assert.Equal(t, "true", write_refresh[len(write_refresh)-1])
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/logging"
+)
+
+const (
+	defaultBulkErrorLogFirst      = 10
+	defaultBulkErrorLogEvery      = 100
+	defaultBulkErrorSummaryPeriod = time.Minute
+)
+
+var (
+	bulk_error_sampler = newErrorSampler(
+		defaultBulkErrorLogFirst, defaultBulkErrorLogEvery)
+
+	bulkDuplicateCreates = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opensearch_bulk_duplicate_creates",
+			Help: "Number of bulk creates skipped because the document already exists.",
+		})
+)
+
+// When the cluster is misconfigured every bulk item fails. To avoid
+// flooding the logs we only log the first few errors in each period
+// and then one in every so many, and summarize the rest.
+type errorSampler struct {
+	mu    sync.Mutex
+	first int
+	every int
+
+	// Errors seen and not logged in the current period.
+	count      int
+	suppressed int
+}
+
+func newErrorSampler(first, every int) *errorSampler {
+	return &errorSampler{first: first, every: every}
+}
+
+// Should this error be logged?
+func (self *errorSampler) sample() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.count++
+	if self.count <= self.first ||
+		(self.every > 0 && (self.count-self.first)%self.every == 0) {
+		return true
+	}
+
+	self.suppressed++
+	return false
+}
+
+// Start a new period. Returns the number of errors which were not
+// logged in the last period.
+func (self *errorSampler) reset() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	suppressed := self.suppressed
+	self.count = 0
+	self.suppressed = 0
+
+	return suppressed
+}
+
+// Log the first errors in each period, then one in every. Zero
+// values keep the defaults.
+func SetBulkErrorSampling(first, every int) {
+	if first == 0 {
+		first = defaultBulkErrorLogFirst
+	}
+	if every == 0 {
+		every = defaultBulkErrorLogEvery
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	bulk_error_sampler = newErrorSampler(first, every)
+}
+
+func getBulkErrorSampler() *errorSampler {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return bulk_error_sampler
+}
+
+func logBulkError(config_obj *config_proto.Config,
+	format string, args ...interface{}) {
+	if !getBulkErrorSampler().sample() {
+		return
+	}
+
+	logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+	logger.Error(format, args...)
+}
+
+// Periodically report how many bulk errors were not logged.
+func startBulkErrorSummary(ctx context.Context,
+	config_obj *config_proto.Config, period time.Duration) {
+	if period == 0 {
+		period = defaultBulkErrorSummaryPeriod
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(period):
+			}
+
+			suppressed := getBulkErrorSampler().reset()
+			if suppressed > 0 {
+				logger := logging.GetLogger(
+					config_obj, &logging.FrontendComponent)
+				logger.Error("BulkIndexer: %v more errors in the last %v were not logged",
+					suppressed, period)
+			}
+		}
+	}()
+}
+
+// A create of a document which already exists is skipped. This is
+// normally a replay of an earlier write, but may also be a different
+// document whose id collided, so it is logged and counted.
+func logDuplicateCreate(config_obj *config_proto.Config, index, id string) {
+	bulkDuplicateCreates.Inc()
+
+	logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+	logger.Debug("BulkIndexer: Skipped create of existing document %v in %v",
+		id, index)
+}
@@ -0,0 +1,30 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorSampler(t *testing.T) {
+	sampler := newErrorSampler(3, 10)
+
+	logged := 0
+	for i := 0; i < 1000; i++ {
+		if sampler.sample() {
+			logged++
+		}
+	}
+
+	// The first 3 then one in every 10.
+	assert.Equal(t, 3+99, logged)
+	assert.Equal(t, 1000-logged, sampler.reset())
+
+	// A new period logs the first errors again.
+	for i := 0; i < 3; i++ {
+		assert.True(t, sampler.sample())
+	}
+	assert.False(t, sampler.sample())
+	assert.Equal(t, 1, sampler.reset())
+	assert.Equal(t, 0, sampler.reset())
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// One action of a bulk request on the document with the id: the
+// action line and, except for deletes, the document. Both must be on
+// a single line.
+type bulkItem struct {
+	id     string
+	action string
+	source []byte
+}
+
+// Items which fail with these statuses were not applied and may
+// succeed if sent again.
+func isRetryableBulkStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Send the items in a bulk request. When only some of the items fail
+// with a transient error (e.g. 429 when the cluster is overloaded)
+// only those are sent again, so items which succeeded are not
+// repeated - create actions would otherwise fail with a conflict.
+// Returns the outcome of each item, in the same order as the items.
+func submitBulk(ctx context.Context, index string, items []bulkItem,
+	refresh string) ([]*_BulkResponseItemDetails, error) {
+	results := make([]*_BulkResponseItemDetails, len(items))
+
+	// The positions of the items still to send.
+	pending := make([]int, 0, len(items))
+	for i := range items {
+		pending = append(pending, i)
+	}
+
+	for attempt := 1; ; attempt++ {
+		responses, err := sendBulk(ctx, index, items, pending, refresh)
+		if err != nil {
+			return nil, err
+		}
+
+		var failed []int
+		for i, idx := range pending {
+			details := responses[i]
+			results[idx] = details
+			if isRetryableBulkStatus(details.Status) {
+				failed = append(failed, idx)
+			}
+		}
+
+		if len(failed) == 0 || attempt >= maxRetryAttempts {
+			return results, nil
+		}
+
+		if !getRetryBudget().take() {
+			retryBudgetExhausted.Inc()
+			return results, nil
+		}
+
+		pending = failed
+		time.Sleep(retryDelay)
+	}
+}
+
+// Send the pending items and return their outcomes in order. If the
+// whole request is rejected with a transient error none of the items
+// were applied.
+func sendBulk(ctx context.Context, index string, items []bulkItem,
+	pending []int, refresh string) ([]*_BulkResponseItemDetails, error) {
+	client, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+
+	body := &bytes.Buffer{}
+	for _, idx := range pending {
+		body.WriteString(items[idx].action)
+		body.WriteString("\n")
+		if items[idx].source != nil {
+			body.Write(items[idx].source)
+			body.WriteString("\n")
+		}
+	}
+
+	res, err := opensearchapi.BulkRequest{
+		Index:    index,
+		Body:     body,
+		Refresh:  refresh,
+		Pipeline: getIngestPipeline(ctx),
+
+		WaitForActiveShards: getWaitForActiveShards(ctx),
+	}.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if isRetryableBulkStatus(res.StatusCode) {
+		results := make([]*_BulkResponseItemDetails, 0, len(pending))
+		for _, idx := range pending {
+			results = append(results, &_BulkResponseItemDetails{
+				Id:     items[idx].id,
+				Status: res.StatusCode,
+				Error:  json.RawMessage(data),
+			})
+		}
+		return results, nil
+	}
+
+	if res.IsError() {
+		return nil, makeElasticError(data)
+	}
+
+	response := &_BulkResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return nil, err
+	}
+
+	if !response.Errors {
+		results := make([]*_BulkResponseItemDetails, 0, len(pending))
+		for _, idx := range pending {
+			results = append(results, &_BulkResponseItemDetails{
+				Id:     items[idx].id,
+				Status: http.StatusOK,
+			})
+		}
+		return results, nil
+	}
+
+	// Items are returned in the order they were sent.
+	results := make([]*_BulkResponseItemDetails, 0, len(pending))
+	for _, item := range response.Items {
+		for _, details := range item {
+			results = append(results, details)
+		}
+	}
+
+	if len(results) != len(pending) {
+		return nil, fmt.Errorf(
+			"Bulk response has %v items but %v were sent",
+			len(results), len(pending))
+	}
+	return results, nil
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkRetriesOnlyFailedItems(t *testing.T) {
+	old_delay := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = old_delay }()
+
+	var mu sync.Mutex
+	var bulk_requests []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		data, _ := ioutil.ReadAll(r.Body)
+		bulk_requests = append(bulk_requests, string(data))
+
+		// The first request partially fails: B is rejected because
+		// the cluster is overloaded and C can never be written.
+		if len(bulk_requests) == 1 {
+			w.Write([]byte(`{"errors": true, "items": [
+  {"create": {"_id": "A", "status": 201}},
+  {"create": {"_id": "B", "status": 429, "error": {"type": "es_rejected_execution_exception"}}},
+  {"create": {"_id": "C", "status": 400, "error": {"type": "mapper_parsing_exception"}}}
+]}`))
+			return
+		}
+		w.Write([]byte(`{"errors": false, "items": [
+  {"create": {"_id": "B", "status": 201}}
+]}`))
+	})
+	defer closer()
+
+	var items []bulkItem
+	for _, id := range []string{"A", "B", "C"} {
+		items = append(items, bulkItem{
+			id:     id,
+			action: `{"create": {"_id": "` + id + `"}}`,
+			source: []byte(`{"client_id": "` + id + `"}`),
+		})
+	}
+
+	results, err := submitBulk(context.Background(), "test_persisted", items, "")
+	assert.NoError(t, err)
+
+	// Only the rejected item was sent again - A was already created.
+	assert.Equal(t, 2, len(bulk_requests))
+	assert.Equal(t, `{"create": {"_id": "B"}}
+{"client_id": "B"}
+`, bulk_requests[1])
+
+	// The outcome of each item is in order.
+	assert.Equal(t, 3, len(results))
+	assert.Equal(t, 201, results[0].Status)
+	assert.Equal(t, 201, results[1].Status)
+	assert.Equal(t, 400, results[2].Status)
+	assert.Contains(t, string(results[2].Error), "mapper_parsing")
+}
+
+func TestBulkRetriesAreBounded(t *testing.T) {
+	old_delay := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = old_delay }()
+
+	var mu sync.Mutex
+	var attempts int
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"errors": true, "items": [
+  {"delete": {"_id": "A", "status": 429}}
+]}`))
+	})
+	defer closer()
+
+	err := DeleteDocuments(context.Background(), "test", "persisted",
+		[]string{"A"}, false)
+
+	delete_errors, ok := err.(*DeleteErrors)
+	assert.True(t, ok)
+	assert.Contains(t, delete_errors.Errors["A"].Error(), "429")
+	assert.Equal(t, maxRetryAttempts, attempts)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"strconv"
 
+	"www.velocidex.com/golang/cloudvelo/result_sets/timed"
 	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
 	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
@@ -95,9 +96,10 @@ func listAvailableEventArtifacts(
 		query = json.Format(getAvailableArtifactsQuery, in.ClientId, "logs", OPENSEARCH_MAX_BUCKETS)
 	}
 
-	hits, err := cvelo_services.QueryElasticAggregations(ctx,
-		config_obj.OrgId, "transient",
-		query)
+	hits, err := cvelo_services.QueryElasticAggregationsWithOptions(ctx,
+		config_obj.OrgId, cvelo_services.ResolveIndexPattern(
+			"transient", timed.MonitoringDocType), query,
+		cvelo_services.QueryOptions{RequestCache: true})
 	if err != nil {
 		return nil, err
 	}
@@ -179,7 +181,8 @@ func listAvailableEventTimestamps(
 	}
 
 	hits, err := cvelo_services.QueryElasticAggregations(ctx,
-		config_obj.OrgId, "transient", query)
+		config_obj.OrgId, cvelo_services.ResolveIndexPattern(
+			"transient", timed.MonitoringDocType), query)
 	if err != nil {
 		return nil, err
 	}
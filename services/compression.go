@@ -0,0 +1,111 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	// Large field values may be stored gzip compressed and base64
+	// encoded with this prefix. Readers decompress them
+	// transparently.
+	compressedPrefix = "gzip:"
+)
+
+var (
+	compressedMarker = []byte(`"` + compressedPrefix)
+)
+
+// Compress a value for storage in a document field.
+func CompressValue(data []byte) (string, error) {
+	builder := &strings.Builder{}
+	builder.WriteString(compressedPrefix)
+
+	encoder := base64.NewEncoder(base64.StdEncoding, builder)
+	writer := gzip.NewWriter(encoder)
+
+	_, err := writer.Write(data)
+	if err != nil {
+		return "", err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = encoder.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return builder.String(), nil
+}
+
+// Decompress a stored field value. The value is streamed through the
+// base64 decoder and gzip reader without intermediate copies.
+func decompressValue(value string) (string, error) {
+	decoder := base64.NewDecoder(base64.StdEncoding,
+		strings.NewReader(strings.TrimPrefix(value, compressedPrefix)))
+	reader, err := gzip.NewReader(decoder)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	builder := &strings.Builder{}
+	_, err = io.Copy(builder, reader)
+	if err != nil {
+		return "", err
+	}
+
+	return builder.String(), nil
+}
+
+// Replace any compressed top level fields in the document source
+// with their decompressed value. Sources without compressed fields
+// are returned unchanged.
+func decompressSource(source json.RawMessage) json.RawMessage {
+	if !bytes.Contains(source, compressedMarker) {
+		return source
+	}
+
+	doc := ordereddict.NewDict()
+	err := doc.UnmarshalJSON(source)
+	if err != nil {
+		return source
+	}
+
+	changed := false
+	for _, k := range doc.Keys() {
+		v, _ := doc.Get(k)
+		value, ok := v.(string)
+		if !ok || !strings.HasPrefix(value, compressedPrefix) {
+			continue
+		}
+
+		decompressed, err := decompressValue(value)
+		if err != nil {
+			continue
+		}
+
+		doc.Update(k, decompressed)
+		changed = true
+	}
+
+	if !changed {
+		return source
+	}
+
+	serialized, err := doc.MarshalJSON()
+	if err != nil {
+		return source
+	}
+	return serialized
+}
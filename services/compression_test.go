@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type compressedRecord struct {
+	Id   string `json:"id"`
+	Data string `json:"data"`
+}
+
+func TestCompressedFieldRoundTrip(t *testing.T) {
+	var mu sync.Mutex
+	var stored []byte
+
+	// A mock server which returns the last document written.
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "PUT", "POST":
+			stored, _ = ioutil.ReadAll(r.Body)
+			w.Write([]byte(`{"result": "created"}`))
+
+		case "GET":
+			fmt.Fprintf(w, `{"_id": "1", "found": true, "_source": %s}`, stored)
+		}
+	})
+	defer closer()
+
+	ctx := context.Background()
+	data := strings.Repeat("Hello world ", 1000)
+
+	compressed, err := CompressValue([]byte(data))
+	assert.NoError(t, err)
+	assert.True(t, len(compressed) < len(data))
+
+	err = SetElasticIndex(ctx, "test", "persisted", "1",
+		&compressedRecord{Id: "1", Data: compressed})
+	assert.NoError(t, err)
+
+	// The stored document is compressed.
+	assert.False(t, strings.Contains(string(stored), "Hello world"))
+
+	// But readers get the original data back.
+	serialized, err := GetElasticRecord(ctx, "test", "persisted", "1")
+	assert.NoError(t, err)
+
+	record := &compressedRecord{}
+	err = json.Unmarshal(serialized, record)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", record.Id)
+	assert.Equal(t, data, record.Data)
+}
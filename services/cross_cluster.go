@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	cloud_velo_config "www.velocidex.com/golang/cloudvelo/config"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+var (
+	// The names of the registered remote clusters.
+	remote_clusters []string
+)
+
+// Register the configured remote clusters with the local cluster so
+// they may be used for cross cluster search. Remotes are marked as
+// skip_unavailable so an unreachable remote does not fail searches.
+func RegisterRemoteClusters(
+	ctx context.Context, remotes []cloud_velo_config.RemoteCluster) error {
+	if len(remotes) == 0 {
+		return nil
+	}
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	settings := ordereddict.NewDict()
+	names := make([]string, 0, len(remotes))
+	for _, remote := range remotes {
+		settings.Set(fmt.Sprintf("cluster.remote.%s.seeds", remote.Name),
+			remote.Seeds)
+		settings.Set(fmt.Sprintf("cluster.remote.%s.skip_unavailable",
+			remote.Name), true)
+		names = append(names, remote.Name)
+	}
+
+	body := ordereddict.NewDict().Set("persistent", settings)
+	res, err := opensearchapi.ClusterPutSettingsRequest{
+		Body: strings.NewReader(json.MustMarshalString(body)),
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return makeElasticError(data)
+	}
+
+	SetRemoteClusters(names)
+	return nil
+}
+
+func SetRemoteClusters(names []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	remote_clusters = append([]string{}, names...)
+}
+
+func getRemoteClusters() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return remote_clusters
+}
+
+// Get the list of indexes to search. The index may be a pattern (see
+// GetIndexes). When searching across clusters the same indexes on
+// each remote cluster are also included.
+func getSearchIndexes(org_id, index string, cross_cluster bool) []string {
+	local := GetIndexes(org_id, index)
+	if !cross_cluster {
+		return local
+	}
+
+	result := append([]string{}, local...)
+	for _, remote := range getRemoteClusters() {
+		for _, name := range local {
+			if strings.HasPrefix(name, "-") {
+				result = append(result, "-"+remote+":"+name[1:])
+				continue
+			}
+			result = append(result, remote+":"+name)
+		}
+	}
+	return result
+}
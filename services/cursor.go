@@ -0,0 +1,39 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	stdjson "encoding/json"
+	"fmt"
+)
+
+// Encode a search_after cursor (the sort values of the last hit) as
+// an opaque page token.
+func EncodeCursor(cursor []interface{}) (string, error) {
+	serialized, err := stdjson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(serialized), nil
+}
+
+// Decode a page token back into the search_after cursor. Numbers
+// are decoded as json.Number so they are sent back exactly as the
+// server returned them - large longs do not survive a round trip
+// through float64.
+func DecodeCursor(token string) ([]interface{}, error) {
+	serialized, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid page token: %w", err)
+	}
+
+	decoder := stdjson.NewDecoder(bytes.NewReader(serialized))
+	decoder.UseNumber()
+
+	var cursor []interface{}
+	err = decoder.Decode(&cursor)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid page token: %w", err)
+	}
+	return cursor, nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursors := [][]interface{}{
+		// Nanosecond timestamps do not fit in a float64.
+		{int64(1661391000123456789), "C.1"},
+		{"doc", []interface{}{int64(1), "a", []interface{}{int64(2)}}},
+		{-5, 1.5, true, nil},
+	}
+
+	for _, cursor := range cursors {
+		token, err := EncodeCursor(cursor)
+		assert.NoError(t, err)
+
+		decoded, err := DecodeCursor(token)
+		assert.NoError(t, err)
+
+		// Re-encoding gives exactly what the server sent.
+		expected, _ := stdjson.Marshal(cursor)
+		actual, _ := stdjson.Marshal(decoded)
+		assert.Equal(t, string(expected), string(actual))
+	}
+
+	token, err := EncodeCursor([]interface{}{int64(1661391000123456789)})
+	assert.NoError(t, err)
+	decoded, err := DecodeCursor(token)
+	assert.NoError(t, err)
+	assert.Equal(t, stdjson.Number("1661391000123456789"), decoded[0])
+
+	_, err = DecodeCursor("not a token!")
+	assert.Error(t, err)
+}
+
+func TestQueryPage(t *testing.T) {
+	var requests []string
+	closer := mockPagedIndex(t, 25, &requests)
+	defer closer()
+
+	ctx := context.Background()
+	query := `{"query": {"match_all": {}}}`
+	sort := `[{"i": "asc"}]`
+
+	var all []int
+	token := ""
+	for i := 0; i < 10; i++ {
+		hits, next_token, err := QueryPage(ctx, "test", "persisted",
+			query, sort, token, 10)
+		assert.NoError(t, err)
+		all = append(all, pageValues(t, hits)...)
+
+		if next_token == "" {
+			break
+		}
+		token = next_token
+	}
+
+	assert.Equal(t, 25, len(all))
+	for i, value := range all {
+		assert.Equal(t, i, value)
+	}
+	assert.Equal(t, 3, len(requests))
+	assert.Contains(t, requests[1], `"search_after": [9,"9"]`)
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchutil"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+// Records that can not be serialized are routed to the org's error
+// index so they can be inspected later instead of crashing the
+// ingestor.
+type deadLetterRecord struct {
+	Index     string `json:"index"`
+	Id        string `json:"id"`
+	Error     string `json:"error"`
+	Data      string `json:"data"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Serialize a record for writing, routing failures to the dead
+// letter index. The record is serialized as compact JSON and stamped
+// with the current schema version.
+func marshalRecord(
+	org_id, index, id string, record interface{}) ([]byte, error) {
+	serialized, err := json.Marshal(record)
+	if err != nil {
+		deadLetter(org_id, index, id, record, err)
+		return nil, fmt.Errorf("Unable to serialize record for %v: %w",
+			index, err)
+	}
+	return stampSchemaVersion(serialized), nil
+}
+
+func deadLetter(org_id, index, id string, record interface{}, err error) {
+	mu.Lock()
+	l_bulk_indexer := bulk_indexer
+	l_logger := logger
+	mu.Unlock()
+
+	if l_logger != nil {
+		l_logger.Error("Unable to serialize record for %v (%v): %v",
+			index, id, err)
+	}
+
+	if l_bulk_indexer == nil {
+		return
+	}
+
+	serialized, json_err := json.Marshal(&deadLetterRecord{
+		Index:     index,
+		Id:        id,
+		Error:     err.Error(),
+		Data:      fmt.Sprintf("%+v", record),
+		Timestamp: utils.GetTime().Now().UnixNano(),
+	})
+	if json_err != nil {
+		return
+	}
+
+	l_bulk_indexer.Add(context.Background(),
+		opensearchutil.BulkIndexerItem{
+			Index:  GetIndex(org_id, "error"),
+			Action: BulkUpdateCreate,
+			Body:   strings.NewReader(string(serialized)),
+		})
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnserializableRecordsDoNotPanic(t *testing.T) {
+	// Channels can not be serialized to JSON.
+	record := map[string]interface{}{
+		"bad": make(chan int),
+	}
+
+	assert.NotPanics(t, func() {
+		err := SetElasticIndex(context.Background(),
+			"test", "persisted", "id", record)
+		assert.Error(t, err)
+	})
+}
+
+func TestStoredDocumentsAreCompact(t *testing.T) {
+	var body []byte
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "created"}`))
+	})
+	defer closer()
+
+	err := SetElasticIndex(context.Background(),
+		"test", "persisted", "id", map[string]interface{}{
+			"client_id": "C.1",
+			"labels":    []string{"a", "b"},
+			"nested":    map[string]int{"x": 1},
+		})
+	assert.NoError(t, err)
+
+	// All write paths serialize with marshalRecord which produces
+	// compact JSON - indentation only bloats the index.
+	assert.Equal(t,
+		`{"schema_version":1,"client_id":"C.1","labels":["a","b"],"nested":{"x":1}}`,
+		string(body))
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/logging"
+)
+
+func setDebugLevel(level logrus.Level) func() {
+	old := debug_logger.Load()
+	logger := &logging.LogContext{Logger: logrus.New()}
+	logger.SetLevel(level)
+	if logger.IsLevelEnabled(logrus.DebugLevel) {
+		debug_logger.Store(logger)
+	} else {
+		debug_logger.Store((*logging.LogContext)(nil))
+	}
+
+	return func() {
+		if old != nil {
+			debug_logger.Store(old)
+		}
+	}
+}
+
+// When debug logging is off Debug() should not build a closure.
+func TestDebugDisabledAllocates(t *testing.T) {
+	defer setDebugLevel(logrus.InfoLevel)()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		Debug("Operation")()
+	})
+	assert.Equal(t, float64(0), allocs)
+
+	defer setDebugLevel(logrus.DebugLevel)()
+
+	allocs = testing.AllocsPerRun(100, func() {
+		Debug("Operation")()
+	})
+	assert.True(t, allocs > 0)
+}
+
+func BenchmarkDebugDisabled(b *testing.B) {
+	defer setDebugLevel(logrus.InfoLevel)()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Debug("Operation %v", "index")()
+	}
+}
+
+func BenchmarkDebugEnabled(b *testing.B) {
+	defer setDebugLevel(logrus.DebugLevel)()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Debug("Operation %v", "index")()
+	}
+}
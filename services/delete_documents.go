@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	defaultDeleteBatchSize = 1000
+)
+
+var (
+	// Guarded by mu
+	delete_batch_size = defaultDeleteBatchSize
+)
+
+// Some documents could not be deleted. The others were deleted.
+type DeleteErrors struct {
+	// By document id.
+	Errors map[string]error
+}
+
+func (self *DeleteErrors) Error() string {
+	ids := make([]string, 0, len(self.Errors))
+	for id := range self.Errors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	messages := make([]string, 0, len(ids))
+	for _, id := range ids {
+		messages = append(messages, fmt.Sprintf("%v: %v", id, self.Errors[id]))
+	}
+
+	return fmt.Sprintf("Unable to delete %v documents: %v",
+		len(ids), strings.Join(messages, ", "))
+}
+
+func (self *DeleteErrors) add(id string, err error) {
+	if self.Errors == nil {
+		self.Errors = make(map[string]error)
+	}
+	self.Errors[id] = err
+}
+
+// The number of documents DeleteDocuments deletes in each bulk
+// request. A zero size restores the default.
+func SetDeleteBatchSize(size int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if size <= 0 {
+		size = defaultDeleteBatchSize
+	}
+	delete_batch_size = size
+}
+
+func getDeleteBatchSize() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return delete_batch_size
+}
+
+// Delete the documents with the given ids. Large lists are deleted in
+// batches so no request grows too large. A failing batch does not
+// stop the others - the ids which could not be deleted are reported
+// in a *DeleteErrors. Ids which do not exist are ignored. If sync is
+// set the index is refreshed once at the end.
+func DeleteDocuments(ctx context.Context,
+	org_id, index string, ids []string, sync bool) error {
+
+	defer Instrument("DeleteDocuments")()
+	defer Debug("DeleteDocuments %v %v", index, len(ids))()
+
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return err
+	}
+
+	batch_size := getDeleteBatchSize()
+	delete_errors := &DeleteErrors{}
+	for start := 0; start < len(ids); start += batch_size {
+		end := start + batch_size
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		err = deleteBatch(ctx, org_id, index, ids[start:end], delete_errors)
+		if err != nil {
+			for _, id := range ids[start:end] {
+				delete_errors.add(id, err)
+			}
+		}
+	}
+
+	if sync && len(ids) > 0 {
+		err = refreshAfterWrite(ctx, GetIndex(org_id, index))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(delete_errors.Errors) > 0 {
+		return delete_errors
+	}
+	return nil
+}
+
+// Delete one batch and record the items which failed. Items
+// rejected with a transient error are retried.
+func deleteBatch(ctx context.Context, org_id, index string,
+	ids []string, delete_errors *DeleteErrors) error {
+	items := make([]bulkItem, 0, len(ids))
+	for _, id := range ids {
+		items = append(items, bulkItem{
+			id:     id,
+			action: json.Format(`{"delete": {"_id": %q}}`, id),
+		})
+	}
+
+	results, err := submitBulk(ctx, GetIndex(org_id, index), items, "")
+	if err != nil {
+		return err
+	}
+
+	for _, details := range results {
+		// Deleting a missing document is not an error.
+		if details.Status >= 300 &&
+			details.Status != http.StatusNotFound {
+			delete_errors.add(details.Id, fmt.Errorf(
+				"Status %v: %v", details.Status, string(details.Error)))
+		}
+	}
+
+	return nil
+}
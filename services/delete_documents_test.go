@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestDeleteDocumentsInBatches(t *testing.T) {
+	var mu sync.Mutex
+	var batches []int
+	var refreshes int
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if strings.HasSuffix(r.URL.Path, "/_refresh") {
+			refreshes++
+			w.Write([]byte(`{"_shards": {"total": 1, "successful": 1, "failed": 0}}`))
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		batches = append(batches, len(lines))
+
+		// doc3 is missing and doc7 is rejected.
+		var items []string
+		for _, line := range lines {
+			action := &struct {
+				Delete struct {
+					Id string `json:"_id"`
+				} `json:"delete"`
+			}{}
+			json.Unmarshal([]byte(line), action)
+
+			status := 200
+			switch action.Delete.Id {
+			case "doc3":
+				status = 404
+			case "doc7":
+				status = 400
+			}
+			items = append(items, fmt.Sprintf(
+				`{"delete": {"_id": %q, "status": %d}}`, action.Delete.Id, status))
+		}
+		w.Write([]byte(`{"errors": true, "items": [` +
+			strings.Join(items, ",") + `]}`))
+	})
+	defer closer()
+
+	SetDeleteBatchSize(4)
+	defer SetDeleteBatchSize(0)
+
+	var ids []string
+	for i := 0; i < 10; i++ {
+		ids = append(ids, fmt.Sprintf("doc%d", i))
+	}
+
+	err := DeleteDocuments(context.Background(), "test", "persisted",
+		ids, SyncDelete)
+
+	// Only the rejected document is reported.
+	delete_errors, ok := err.(*DeleteErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(delete_errors.Errors))
+	assert.Contains(t, delete_errors.Errors["doc7"].Error(), "400")
+
+	// All the ids were sent in batches and refreshed once.
+	assert.Equal(t, []int{4, 4, 2}, batches)
+	assert.Equal(t, 1, refreshes)
+}
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type ChangeType string
+
+const (
+	FieldAdded   ChangeType = "added"
+	FieldRemoved ChangeType = "removed"
+	FieldChanged ChangeType = "changed"
+)
+
+// A field which differs between two versions of a document. The path
+// is dotted with array elements by their position
+// (e.g. "labels.1"), or empty for the whole document.
+type FieldChange struct {
+	Path string          `json:"path"`
+	Type ChangeType      `json:"type"`
+	Old  json.RawMessage `json:"old,omitempty"`
+	New  json.RawMessage `json:"new,omitempty"`
+}
+
+// Compare two versions of a document's source (e.g. a hunt before
+// and after it was modified) field by field. Objects and arrays are
+// compared recursively so only the fields which changed are
+// reported, sorted by path.
+func DiffDocuments(a, b json.RawMessage) ([]FieldChange, error) {
+	old_value, err := decodeForDiff(a)
+	if err != nil {
+		return nil, fmt.Errorf("DiffDocuments: old document: %w", err)
+	}
+
+	new_value, err := decodeForDiff(b)
+	if err != nil {
+		return nil, fmt.Errorf("DiffDocuments: new document: %w", err)
+	}
+
+	var changes []FieldChange
+	err = diffValues("", old_value, new_value, &changes)
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Numbers are kept as written so large ids and timestamps are
+// compared exactly.
+func decodeForDiff(data json.RawMessage) (interface{}, error) {
+	decoder := stdjson.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+func diffValues(path string, old_value, new_value interface{},
+	changes *[]FieldChange) error {
+	switch old_item := old_value.(type) {
+	case map[string]interface{}:
+		new_item, ok := new_value.(map[string]interface{})
+		if ok {
+			return diffObjects(path, old_item, new_item, changes)
+		}
+
+	case []interface{}:
+		new_item, ok := new_value.([]interface{})
+		if ok {
+			return diffArrays(path, old_item, new_item, changes)
+		}
+	}
+
+	if reflect.DeepEqual(old_value, new_value) {
+		return nil
+	}
+	return addChange(path, FieldChanged, old_value, new_value, changes)
+}
+
+func diffObjects(path string, old_item, new_item map[string]interface{},
+	changes *[]FieldChange) error {
+	keys := make([]string, 0, len(old_item)+len(new_item))
+	for k := range old_item {
+		keys = append(keys, k)
+	}
+	for k := range new_item {
+		_, pres := old_item[k]
+		if !pres {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		old_value, old_pres := old_item[k]
+		new_value, new_pres := new_item[k]
+		var err error
+
+		switch {
+		case !new_pres:
+			err = addChange(joinPath(path, k), FieldRemoved,
+				old_value, nil, changes)
+		case !old_pres:
+			err = addChange(joinPath(path, k), FieldAdded,
+				nil, new_value, changes)
+		default:
+			err = diffValues(joinPath(path, k), old_value, new_value, changes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Elements are compared by position so inserting an element reports
+// every later element as changed.
+func diffArrays(path string, old_item, new_item []interface{},
+	changes *[]FieldChange) error {
+	for i := 0; i < len(old_item) || i < len(new_item); i++ {
+		item_path := joinPath(path, strconv.Itoa(i))
+		var err error
+
+		switch {
+		case i >= len(new_item):
+			err = addChange(item_path, FieldRemoved, old_item[i], nil, changes)
+		case i >= len(old_item):
+			err = addChange(item_path, FieldAdded, nil, new_item[i], changes)
+		default:
+			err = diffValues(item_path, old_item[i], new_item[i], changes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addChange(path string, change_type ChangeType,
+	old_value, new_value interface{}, changes *[]FieldChange) error {
+	change := FieldChange{Path: path, Type: change_type}
+
+	var err error
+	if change_type != FieldAdded {
+		change.Old, err = stdjson.Marshal(old_value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if change_type != FieldRemoved {
+		change.New, err = stdjson.Marshal(new_value)
+		if err != nil {
+			return err
+		}
+	}
+
+	*changes = append(*changes, change)
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
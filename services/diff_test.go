@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	old_doc := json.RawMessage(`{
+  "hunt_id": "H.1",
+  "state": "RUNNING",
+  "expires": 1700000000000000001,
+  "stats": {"total_clients_scheduled": 5, "stopped": false},
+  "labels": ["a", "b", "c"],
+  "artifacts": [{"name": "Generic.Client.Info", "params": {"A": "1"}}],
+  "creator": "admin"
+}`)
+
+	new_doc := json.RawMessage(`{
+  "hunt_id": "H.1",
+  "state": "STOPPED",
+  "expires": 1700000000000000002,
+  "stats": {"total_clients_scheduled": 5, "stopped": true, "completed": 3},
+  "labels": ["a", "x"],
+  "artifacts": [{"name": "Generic.Client.Info", "params": {"A": "2"}}],
+  "description": "stopped by admin"
+}`)
+
+	changes, err := DiffDocuments(old_doc, new_doc)
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldChange{
+		{Path: "artifacts.0.params.A", Type: FieldChanged,
+			Old: json.RawMessage(`"1"`), New: json.RawMessage(`"2"`)},
+		{Path: "creator", Type: FieldRemoved,
+			Old: json.RawMessage(`"admin"`)},
+		{Path: "description", Type: FieldAdded,
+			New: json.RawMessage(`"stopped by admin"`)},
+		// Large numbers are compared exactly.
+		{Path: "expires", Type: FieldChanged,
+			Old: json.RawMessage(`1700000000000000001`),
+			New: json.RawMessage(`1700000000000000002`)},
+		{Path: "labels.1", Type: FieldChanged,
+			Old: json.RawMessage(`"b"`), New: json.RawMessage(`"x"`)},
+		{Path: "labels.2", Type: FieldRemoved,
+			Old: json.RawMessage(`"c"`)},
+		{Path: "state", Type: FieldChanged,
+			Old: json.RawMessage(`"RUNNING"`), New: json.RawMessage(`"STOPPED"`)},
+		{Path: "stats.completed", Type: FieldAdded,
+			New: json.RawMessage(`3`)},
+		{Path: "stats.stopped", Type: FieldChanged,
+			Old: json.RawMessage(`false`), New: json.RawMessage(`true`)},
+	}, changes)
+
+	// Identical documents have no changes, whatever the formatting.
+	changes, err = DiffDocuments(old_doc, json.RawMessage(`{"creator":"admin",
+"hunt_id":"H.1","state":"RUNNING","expires":1700000000000000001,
+"stats":{"stopped":false,"total_clients_scheduled":5},"labels":["a","b","c"],
+"artifacts":[{"params":{"A":"1"},"name":"Generic.Client.Info"}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+
+	// A field which changes type is replaced as a whole.
+	changes, err = DiffDocuments(json.RawMessage(`{"labels": ["a"]}`),
+		json.RawMessage(`{"labels": {"0": "a"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldChange{
+		{Path: "labels", Type: FieldChanged,
+			Old: json.RawMessage(`["a"]`), New: json.RawMessage(`{"0":"a"}`)},
+	}, changes)
+
+	_, err = DiffDocuments(old_doc, json.RawMessage(`{`))
+	assert.Error(t, err)
+}
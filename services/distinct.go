@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	// The default and maximum precision thresholds of the
+	// cardinality aggregation.
+	DefaultPrecisionThreshold = 3000
+	MaxPrecisionThreshold     = 40000
+
+	countDistinctQuery = `
+{
+  "size": 0,
+  "query": %s,
+  "aggs": {
+    "distinct": {
+      "cardinality": {"field": %q, "precision_threshold": %q}
+    }
+  }
+}
+`
+)
+
+type DistinctCount struct {
+	Count uint64
+
+	// The cardinality aggregation is close to exact below the
+	// precision threshold, but above it the count is an estimate
+	// (typically within a few percent).
+	Approximate bool
+}
+
+// Count the distinct values of field in the documents matching the
+// query clause (default all) in a single aggregation, rather than
+// fetching the documents. A precision_threshold of 0 uses the
+// default.
+func CountDistinct(ctx context.Context,
+	org_id, index, field, query string,
+	precision_threshold int) (*DistinctCount, error) {
+
+	defer Instrument("CountDistinct")()
+
+	if query == "" {
+		query = `{"match_all": {}}`
+	}
+
+	if precision_threshold <= 0 {
+		precision_threshold = DefaultPrecisionThreshold
+	}
+	if precision_threshold > MaxPrecisionThreshold {
+		precision_threshold = MaxPrecisionThreshold
+	}
+
+	aggs, err := QueryElasticAggregationTree(ctx, org_id, index,
+		json.Format(countDistinctQuery, query, field, precision_threshold))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DistinctCount{}
+	distinct, pres := aggs["distinct"]
+	if !pres {
+		return result, nil
+	}
+
+	value, _ := distinct.Value.(float64)
+	result.Count = uint64(value)
+	result.Approximate = result.Count > uint64(precision_threshold)
+
+	return result, nil
+}
+
+// Count the distinct clients seen in the documents matching the
+// query clause, e.g. a time range.
+func CountDistinctClients(ctx context.Context,
+	org_id, index, query string,
+	precision_threshold int) (*DistinctCount, error) {
+	return CountDistinct(ctx, org_id, index, "client_id", query,
+		precision_threshold)
+}
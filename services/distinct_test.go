@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestCountDistinctClients(t *testing.T) {
+	var request struct {
+		Aggs struct {
+			Distinct struct {
+				Cardinality struct {
+					Field              string `json:"field"`
+					PrecisionThreshold int    `json:"precision_threshold"`
+				} `json:"cardinality"`
+			} `json:"distinct"`
+		} `json:"aggs"`
+	}
+	value := 0
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &request)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(fmt.Sprintf(
+			`{"hits": {"hits": []}, "aggregations": {"distinct": {"value": %d}}}`,
+			value)))
+	})
+	defer closer()
+
+	ctx := context.Background()
+
+	value = 3
+	count, err := CountDistinctClients(ctx, "test", "transient", "", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, &DistinctCount{Count: 3}, count)
+	assert.Equal(t, "client_id", request.Aggs.Distinct.Cardinality.Field)
+	assert.Equal(t, DefaultPrecisionThreshold,
+		request.Aggs.Distinct.Cardinality.PrecisionThreshold)
+
+	// Above the threshold the count is only an estimate.
+	value = 150
+	count, err = CountDistinctClients(ctx, "test", "transient", "", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, &DistinctCount{Count: 150, Approximate: true}, count)
+	assert.Equal(t, 100, request.Aggs.Distinct.Cardinality.PrecisionThreshold)
+}
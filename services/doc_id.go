@@ -0,0 +1,122 @@
+package services
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// The strategy used by MakeId to derive document IDs. All strategies
+// are deterministic so the same item always maps to the same ID.
+//
+// NOTE: Changing the strategy on an existing deployment changes the
+// ID of every document derived with MakeId. Existing documents will
+// no longer be found by ID and new writes will create duplicates
+// rather than update them. The strategy should only be changed on a
+// fresh deployment, or after reindexing the existing documents.
+const (
+	IdStrategySHA1   = "sha1"
+	IdStrategySHA256 = "sha256"
+
+	// A fast non cryptographic hash for very high write rates. The
+	// IDs are only 64 bits, so different items may get the same ID
+	// and overwrite each other. This is unsafe for writes which are
+	// skipped when the ID exists, so idempotent writes are disabled
+	// with this strategy (see CollisionResistantIds). Never the
+	// default.
+	IdStrategyXXHash = "xxhash"
+)
+
+const (
+	// The longest document id the cluster accepts (in bytes).
+	maxDocumentIdLength = 512
+)
+
+var (
+	ErrDocumentIdTooLong = errors.New("Document id is too long")
+
+	id_hasher   = sha1Id
+	id_strategy = IdStrategySHA1
+)
+
+func sha1Id(item string) string {
+	hash := sha1.Sum([]byte(item))
+	return hex.EncodeToString(hash[:])
+}
+
+func sha256Id(item string) string {
+	hash := sha256.Sum256([]byte(item))
+	return hex.EncodeToString(hash[:])
+}
+
+func xxhashId(item string) string {
+	return fmt.Sprintf("%016x", xxhash.Sum64String(item))
+}
+
+func getIdHasher(strategy string) (func(string) string, error) {
+	switch strategy {
+	case "", IdStrategySHA1:
+		return sha1Id, nil
+	case IdStrategySHA256:
+		return sha256Id, nil
+	case IdStrategyXXHash:
+		return xxhashId, nil
+	default:
+		return nil, fmt.Errorf("Unknown document ID strategy %v", strategy)
+	}
+}
+
+// Set the strategy used to derive document IDs. An empty strategy
+// selects the default (sha1).
+func SetIdStrategy(strategy string) error {
+	hasher, err := getIdHasher(strategy)
+	if err != nil {
+		return err
+	}
+
+	if strategy == "" {
+		strategy = IdStrategySHA1
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	id_hasher = hasher
+	id_strategy = strategy
+	return nil
+}
+
+// Are different items unlikely enough to get the same id from MakeId
+// that a write can be skipped when its id already exists? This is
+// not the case for the short xxhash ids.
+func CollisionResistantIds() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return id_strategy != IdStrategyXXHash
+}
+
+// Convert the item into a unique document ID - This is needed when
+// the item can be longer than the maximum 512 bytes.
+func MakeId(item string) string {
+	mu.Lock()
+	hasher := id_hasher
+	mu.Unlock()
+
+	return hasher(item)
+}
+
+// Writing a document with a long id fails with an opaque error from
+// the cluster. Ids derived from items which may be long should be
+// made with MakeId.
+func checkDocumentId(id string) error {
+	if len(id) > maxDocumentIdLength {
+		return fmt.Errorf("%w: %v bytes is over the %v byte limit (use MakeId)",
+			ErrDocumentIdTooLong, len(id), maxDocumentIdLength)
+	}
+	return nil
+}
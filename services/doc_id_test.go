@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdStrategies(t *testing.T) {
+	defer SetIdStrategy(IdStrategySHA1)
+
+	for _, tc := range []struct {
+		strategy            string
+		length              int
+		collision_resistant bool
+	}{
+		{IdStrategySHA1, 40, true},
+		{IdStrategySHA256, 64, true},
+		{IdStrategyXXHash, 16, false},
+	} {
+		err := SetIdStrategy(tc.strategy)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.collision_resistant, CollisionResistantIds(),
+			tc.strategy)
+
+		// The same item always produces the same ID.
+		id := MakeId("C.1234/F.1234")
+		assert.Equal(t, id, MakeId("C.1234/F.1234"), tc.strategy)
+		assert.Equal(t, tc.length, len(id), tc.strategy)
+
+		// Different items produce different IDs.
+		assert.NotEqual(t, id, MakeId("C.1234/F.1235"), tc.strategy)
+	}
+
+	// The default strategy is sha1 and is stable across releases.
+	err := SetIdStrategy("")
+	assert.NoError(t, err)
+	assert.True(t, CollisionResistantIds())
+	assert.Equal(t, "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3", MakeId("test"))
+
+	err = SetIdStrategy("md5")
+	assert.Error(t, err)
+}
+
+func TestOversizedDocumentId(t *testing.T) {
+	var requests []string
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"_index": "test_persisted", "_id": "1", "_version": 1}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	long_id := strings.Repeat("A", maxDocumentIdLength+1)
+
+	// The write is rejected before it is sent and the error says
+	// how long the id is.
+	err := SetElasticIndex(ctx, "test", "persisted", long_id, map[string]int{})
+	assert.True(t, errors.Is(err, ErrDocumentIdTooLong))
+	assert.Contains(t, err.Error(), "513 bytes")
+
+	err = SetElasticIndexAsync(ctx, "test", "persisted", long_id,
+		BulkUpdateIndex, map[string]int{})
+	assert.True(t, errors.Is(err, ErrDocumentIdTooLong))
+
+	err = UpdateIndex(ctx, "test", "persisted", long_id, `{}`)
+	assert.True(t, errors.Is(err, ErrDocumentIdTooLong))
+	assert.Equal(t, 0, len(requests))
+
+	// Ids at the limit or derived with MakeId are fine.
+	err = SetElasticIndex(ctx, "test", "persisted",
+		long_id[:maxDocumentIdLength], map[string]int{})
+	assert.NoError(t, err)
+
+	err = SetElasticIndex(ctx, "test", "persisted",
+		MakeId(long_id), map[string]int{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(requests))
+}
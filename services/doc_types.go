@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	// There are only a handful of doc types so this is plenty.
+	countByDocTypeQuery = `
+{
+  "size": 0,
+  "aggs": {
+    "doc_types": {
+      "terms": {"field": "doc_type", "size": 1000}
+    }
+  }
+}
+`
+)
+
+// Count the documents of each doc_type in the index. Documents
+// without a doc_type are not counted.
+func CountByDocType(
+	ctx context.Context, org_id, index string) (map[string]int, error) {
+
+	aggs, err := QueryElasticAggregationTree(
+		ctx, org_id, index, countByDocTypeQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]int)
+	doc_types, pres := aggs["doc_types"]
+	if !pres {
+		return result, nil
+	}
+
+	for _, bucket := range doc_types.Buckets {
+		result[fmt.Sprintf("%v", bucket.Key)] = bucket.Count
+	}
+
+	return result, nil
+}
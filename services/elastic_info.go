@@ -0,0 +1,85 @@
+package services
+
+import (
+	"net/http"
+
+	opensearch "github.com/opensearch-project/opensearch-go/v2"
+)
+
+const (
+	AuthModeBasic     = "basic"
+	AuthModeAWSSigner = "aws_signer"
+
+	redacted = "<redacted>"
+)
+
+// A summary of the active opensearch client configuration. This is
+// used to diagnose connection issues so it must never contain
+// secrets.
+type ElasticClientInfo struct {
+	Addresses        []string `json:"addresses"`
+	AuthMode         string   `json:"auth_mode"`
+	Username         string   `json:"username,omitempty"`
+	Password         string   `json:"password,omitempty"`
+	TLSVerify        bool     `json:"tls_verify"`
+	CustomRootCerts  bool     `json:"custom_root_certs"`
+	CompressRequests bool     `json:"compress_requests"`
+	CompressResponse bool     `json:"compress_response"`
+}
+
+var (
+	gClientInfo *ElasticClientInfo
+)
+
+func newElasticClientInfo(
+	cfg opensearch.Config, custom_root_certs bool) *ElasticClientInfo {
+	result := &ElasticClientInfo{
+		Addresses:        append([]string{}, cfg.Addresses...),
+		AuthMode:         AuthModeBasic,
+		Username:         cfg.Username,
+		TLSVerify:        true,
+		CustomRootCerts:  custom_root_certs,
+		CompressRequests: cfg.CompressRequestBody,
+		CompressResponse: true,
+	}
+
+	if cfg.Password != "" {
+		result.Password = redacted
+	}
+
+	if cfg.Signer != nil {
+		result.AuthMode = AuthModeAWSSigner
+	}
+
+	transport, ok := cfg.Transport.(*http.Transport)
+	if ok {
+		result.CompressResponse = !transport.DisableCompression
+		if transport.TLSClientConfig != nil {
+			result.TLSVerify = !transport.TLSClientConfig.InsecureSkipVerify
+		}
+	}
+
+	return result
+}
+
+// Get a redacted summary of the active opensearch configuration.
+func GetClientInfo() (*ElasticClientInfo, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if gClientInfo == nil {
+		return nil, ErrClientNotInitialized
+	}
+
+	// Return a copy so callers can not modify our state.
+	result := *gClientInfo
+	result.Addresses = append([]string{}, gClientInfo.Addresses...)
+	return &result, nil
+}
+
+func setClientInfo(info *ElasticClientInfo) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	gClientInfo = info
+}
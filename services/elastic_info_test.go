@@ -0,0 +1,33 @@
+package services
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"testing"
+
+	opensearch "github.com/opensearch-project/opensearch-go/v2"
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestClientInfoRedactsCredentials(t *testing.T) {
+	info := newElasticClientInfo(opensearch.Config{
+		Addresses: []string{"https://127.0.0.1:9200/"},
+		Username:  "admin",
+		Password:  "SuperSecretPassword",
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+	}, false)
+
+	assert.Equal(t, AuthModeBasic, info.AuthMode)
+	assert.Equal(t, redacted, info.Password)
+	assert.False(t, info.TLSVerify)
+
+	serialized := json.MustMarshalString(info)
+	assert.False(t, strings.Contains(serialized, "SuperSecretPassword"))
+	assert.True(t, strings.Contains(serialized, "127.0.0.1:9200"))
+}
@@ -3,10 +3,8 @@ package services
 import (
 	"bytes"
 	"context"
-	"crypto/sha1"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -14,6 +12,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Velocidex/ordereddict"
@@ -22,6 +21,7 @@ import (
 	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
 	"github.com/opensearch-project/opensearch-go/v2/opensearchutil"
 	requestsigner "github.com/opensearch-project/opensearch-go/v2/signer/awsv2"
+	"github.com/sirupsen/logrus"
 	cloud_velo_config "www.velocidex.com/golang/cloudvelo/config"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
 	"www.velocidex.com/golang/velociraptor/crypto"
@@ -43,28 +43,60 @@ const (
 	BulkUpdateCreate = "create" // Create new record if no existing record.
 
 	DocIdRandom = ""
+
+	DefaultSortTiebreaker = "_id"
+
+	// How often QueryChan requests a page again when its search
+	// context is lost.
+	maxSearchContextRetries = 3
 )
 
 var (
+	// Returned when the client is used before
+	// StartElasticSearchService or after CloseElasticClient.
+	ErrClientNotInitialized = errors.New("Elastic configuration not initialized")
+
 	mu             sync.Mutex
 	gElasticClient *opensearch.Client
+	gTransport     *http.Transport
 	TRUE           = true
 	True           = "true"
 
+	// Appended to the sort clause in QueryChan to guarantee a total
+	// order of the results.
+	sort_tiebreaker = DefaultSortTiebreaker
+
 	logger *logging.LogContext
 
+	// Holds a *logging.LogContext only while debug logging is
+	// enabled so Debug() can check it cheaply.
+	debug_logger atomic.Value
+
 	bulk_indexer *BulkIndexer
 )
 
-// The logger is normally installed in the start up sequence with
-// SetDebugLogger() below.
+func noopDebug() {}
+
+// Time an operation and log it when it completes. The logger is
+// normally installed in the start up sequence with SetDebugLogger()
+// below. When debug logging is disabled this does nothing.
 func Debug(format string, args ...interface{}) func() {
+	logger, _ := debug_logger.Load().(*logging.LogContext)
+	if logger == nil {
+		return noopDebug
+	}
+
+	return debugTimer(logger, format, args)
+}
+
+// Kept separate from Debug() so the captured arguments are only
+// moved to the heap when debug logging is enabled.
+func debugTimer(logger *logging.LogContext,
+	format string, args []interface{}) func() {
 	start := time.Now()
 	return func() {
-		if logger != nil {
-			args = append(args, time.Now().Sub(start))
-			logger.Debug(format+" in %v", args...)
-		}
+		args = append(args, time.Now().Sub(start))
+		logger.Debug(format+" in %v", args...)
 	}
 }
 
@@ -73,12 +105,23 @@ type IndexInfo struct {
 }
 
 func ListIndexes(ctx context.Context) ([]string, error) {
+	return listIndexes(ctx, nil)
+}
+
+// List all indexes matching the wildcard pattern. For example
+// "*_monitoring" will return the monitoring indexes across all orgs.
+func ListIndexesMatching(ctx context.Context, pattern string) ([]string, error) {
+	return listIndexes(ctx, []string{pattern})
+}
+
+func listIndexes(ctx context.Context, patterns []string) ([]string, error) {
 	client, err := GetElasticClient()
 	if err != nil {
 		return nil, err
 	}
 
 	res, err := opensearchapi.CatIndicesRequest{
+		Index:  patterns,
 		Format: "json",
 	}.Do(ctx, client)
 	if err != nil {
@@ -91,19 +134,22 @@ func ListIndexes(ctx context.Context) ([]string, error) {
 		return nil, err
 	}
 
+	if res.IsError() {
+		return nil, makeReadElasticError(data)
+	}
+
 	indexes := []*IndexInfo{}
 	err = json.Unmarshal(data, &indexes)
 	if err != nil {
 		return nil, err
 	}
 
-	results := make([]string, len(indexes))
+	results := make([]string, 0, len(indexes))
 	for _, i := range indexes {
 		results = append(results, i.Index)
 	}
 
 	return results, nil
-
 }
 
 func GetIndex(org_id, index string) string {
@@ -118,20 +164,57 @@ func GetIndex(org_id, index string) string {
 		"%s_%s", strings.ToLower(org_id), index)
 }
 
+// Resolve an index pattern for the org. The pattern may contain
+// wildcards (e.g. "monitoring-*" matches all the rollover indexes
+// behind the monitoring alias) and be a comma separated list, where
+// entries starting with "-" exclude matching indexes. Each entry is
+// prefixed for the org separately.
+func GetIndexes(org_id, pattern string) []string {
+	var result []string
+	for _, part := range strings.Split(pattern, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if strings.HasPrefix(part, "-") {
+			result = append(result, "-"+GetIndex(org_id, part[1:]))
+			continue
+		}
+		result = append(result, GetIndex(org_id, part))
+	}
+	return result
+}
+
 func DeleteDocument(
 	ctx context.Context, org_id, index string, id string, sync bool) error {
 
 	defer Instrument("DeleteDocument")()
 
 	defer Debug("DeleteDocument %v", id)()
+
+	ctx, cancel := withOperationTimeout(ctx, "DeleteDocument")
+	defer cancel()
+
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return err
+	}
+
 	client, err := GetElasticClient()
 	if err != nil {
 		return err
 	}
 
 	res, err := opensearchapi.DeleteRequest{
-		Index:      GetIndex(org_id, index),
-		DocumentID: id,
+		Index:               GetIndex(org_id, index),
+		DocumentID:          id,
+		WaitForActiveShards: getWaitForActiveShards(ctx),
 	}.Do(ctx, client)
 	if err != nil {
 		return err
@@ -139,19 +222,27 @@ func DeleteDocument(
 	defer res.Body.Close()
 
 	if sync {
-		res, err = opensearchapi.IndicesRefreshRequest{
-			Index: []string{GetIndex(org_id, index)},
-		}.Do(ctx, client)
-		defer res.Body.Close()
+		return refreshAfterWrite(ctx, GetIndex(org_id, index))
 	}
 
-	return err
+	return nil
 }
 
 func DeleteDocumentByQuery(
 	ctx context.Context, org_id, index string, query string, sync bool) error {
 
 	defer Instrument("DeleteDocument")()
+
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return err
+	}
+
 	expanded_index := GetIndex(org_id, index)
 	client, err := GetElasticClient()
 	if err != nil {
@@ -165,76 +256,51 @@ func DeleteDocumentByQuery(
 	defer res.Body.Close()
 
 	if sync {
-		res, err = opensearchapi.IndicesRefreshRequest{
-			Index: []string{expanded_index},
-		}.Do(ctx, client)
-		defer res.Body.Close()
+		return refreshAfterWrite(ctx, expanded_index)
 	}
 
-	return err
+	return nil
 }
 
 // Should be called to force the index to synchronize.
 func FlushIndex(
 	ctx context.Context, org_id, index string) error {
-	client, err := GetElasticClient()
-	if err != nil {
-		return err
-	}
-
-	res, err := opensearchapi.IndicesRefreshRequest{
-		Index: []string{GetIndex(org_id, index)},
-	}.Do(ctx, client)
-
-	if err != nil {
-		return err
-	}
-
-	defer res.Body.Close()
-
-	return err
+	return refreshIndexes(ctx, GetIndex(org_id, index))
 }
 
 func UpdateIndex(
 	ctx context.Context, org_id, index, id string, query string) error {
 	defer Instrument("UpdateIndex")()
 	defer Debug("UpdateIndex %v %v", index, id)()
-	return retry(func() error {
-		return _UpdateIndex(ctx, org_id, index, id, query)
-	})
-}
 
-func _UpdateIndex(
-	ctx context.Context, org_id, index, id string, query string) error {
-	client, err := GetElasticClient()
+	ctx, cancel := withOperationTimeout(ctx, "UpdateIndex")
+	defer cancel()
+
+	err := checkWritable()
 	if err != nil {
 		return err
 	}
 
-	es_req := opensearchapi.UpdateRequest{
-		Index:      GetIndex(org_id, index),
-		DocumentID: id,
-		Body:       strings.NewReader(query),
-		Refresh:    "true",
-	}
-
-	res, err := es_req.Do(ctx, client)
+	err = checkDocumentId(id)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
 
-	data, err := ioutil.ReadAll(res.Body)
+	err = checkOrgWrite(ctx, org_id)
 	if err != nil {
 		return err
 	}
 
-	// All is well we dont need to parse the results
-	if !res.IsError() {
-		return nil
-	}
+	return retry(func() error {
+		return _UpdateIndex(ctx, org_id, index, id, query)
+	})
+}
 
-	return makeElasticError(data)
+func _UpdateIndex(
+	ctx context.Context, org_id, index, id string, query string) error {
+	_, err := updateIndex(ctx, org_id, index, id, query,
+		getWriteRefresh(ctx, GetIndex(org_id, index)))
+	return err
 }
 
 func DoesTemplateExist(ctx context.Context, name string) error {
@@ -299,16 +365,40 @@ func PutTemplate(
 	return makeElasticError(data)
 }
 
-func SetElasticIndexAsync(org_id, index, id string,
+func SetElasticIndexAsync(ctx context.Context, org_id, index, id string,
 	action BulkUpdateType, record interface{}) error {
 
 	defer Debug("SetElasticIndexAsync %v %v", index, id)()
 
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	org_id, index, id, record, err = quarantineInvalidOrg(
+		org_id, index, id, record)
+	if err != nil {
+		return err
+	}
+
+	err = checkDocumentId(id)
+	if err != nil {
+		return err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return err
+	}
+
 	mu.Lock()
 	l_bulk_indexer := bulk_indexer
 	mu.Unlock()
 
-	serialized := json.MustMarshalString(record)
+	serialized, err := marshalRecord(org_id, index, id, record)
+	if err != nil {
+		return err
+	}
 
 	// Add with background context which might outlive our caller.
 	return l_bulk_indexer.Add(context.Background(),
@@ -316,60 +406,142 @@ func SetElasticIndexAsync(org_id, index, id string,
 			Index:      GetIndex(org_id, index),
 			Action:     string(action),
 			DocumentID: id,
-			Body:       strings.NewReader(serialized),
+			Body:       bytes.NewReader(serialized),
 			OnFailure: func(ctx context.Context,
 				item opensearchutil.BulkIndexerItem,
 				res opensearchutil.BulkIndexerResponseItem, err error) {
-				logger := logging.GetLogger(l_bulk_indexer.config_obj,
-					&logging.FrontendComponent)
-				logger.Error("BulkIndexer Error %v during: %v", res.Error.Reason,
-					json.MustMarshalString(record))
+				// Creating a document with an ID that already
+				// exists means this is a replay of an earlier
+				// write so there is nothing to do.
+				if isDuplicateCreate(action, id, res) {
+					logDuplicateCreate(l_bulk_indexer.config_obj,
+						item.Index, id)
+					return
+				}
+
+				logBulkError(l_bulk_indexer.config_obj,
+					"BulkIndexer Error %v during: %v", res.Error.Reason,
+					string(serialized))
 			},
 		})
 }
 
+// Creating a record with an explicit id that already exists fails
+// with a conflict. Callers use deterministic ids (e.g. derived from
+// an idempotency key) to make retried writes safe so this is not an
+// error. Such ids must be collision resistant (see
+// CollisionResistantIds) or different records sharing an id are
+// lost.
+func isDuplicateCreate(action BulkUpdateType, id string,
+	res opensearchutil.BulkIndexerResponseItem) bool {
+	return action == BulkUpdateCreate && id != DocIdRandom &&
+		res.Status == http.StatusConflict
+}
+
 func SetElasticIndex(ctx context.Context,
 	org_id, index, id string, record interface{}) error {
+	_, err := SetElasticIndexWithMeta(ctx, org_id, index, id, record)
+	return err
+}
+
+// The version of a document after it was written. SeqNo and
+// PrimaryTerm can be passed to a conditional update (IfSeqNo and
+// IfPrimaryTerm) so it only applies if nobody changed the document
+// in the meantime.
+type DocumentMeta struct {
+	Index       string `json:"_index"`
+	Id          string `json:"_id"`
+	Version     int64  `json:"_version"`
+	SeqNo       int    `json:"_seq_no"`
+	PrimaryTerm int    `json:"_primary_term"`
+}
+
+// Like SetElasticIndex but returns the version of the written
+// document so it can be updated without reading it back first.
+func SetElasticIndexWithMeta(ctx context.Context,
+	org_id, index, id string, record interface{}) (*DocumentMeta, error) {
 	defer Instrument("SetElasticIndex")()
 	defer Debug("SetElasticIndex %v %v", index, id)()
 
-	return retry(func() error {
-		return _SetElasticIndex(ctx, org_id, index, id, record)
+	ctx, cancel := withOperationTimeout(ctx, "SetElasticIndex")
+	defer cancel()
+
+	err := checkWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	org_id, index, id, record, err = quarantineInvalidOrg(
+		org_id, index, id, record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkDocumentId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta *DocumentMeta
+	err = retry(func() error {
+		meta, err = _SetElasticIndex(ctx, org_id, index, id, record)
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
 }
 
 func _SetElasticIndex(
-	ctx context.Context, org_id, index, id string, record interface{}) error {
-	serialized := json.MustMarshalIndent(record)
+	ctx context.Context, org_id, index, id string, record interface{}) (
+	*DocumentMeta, error) {
+	serialized, err := marshalRecord(org_id, index, id, record)
+	if err != nil {
+		return nil, err
+	}
+
 	client, err := GetElasticClient()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	es_req := opensearchapi.IndexRequest{
 		Index:      GetIndex(org_id, index),
 		DocumentID: id,
 		Body:       bytes.NewReader(serialized),
-		Refresh:    "true",
+		Refresh:    getWriteRefresh(ctx, GetIndex(org_id, index)),
+		Pipeline:   getIngestPipeline(ctx),
+
+		WaitForActiveShards: getWaitForActiveShards(ctx),
 	}
 
 	res, err := es_req.Do(ctx, client)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer res.Body.Close()
 
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// All is well we dont need to parse the results
-	if !res.IsError() {
-		return nil
+	if res.IsError() {
+		return nil, makeElasticError(data)
 	}
 
-	return makeElasticError(data)
+	meta := &DocumentMeta{}
+	err = json.Unmarshal(data, meta)
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
 }
 
 type _ElasticTotal struct {
@@ -377,9 +549,12 @@ type _ElasticTotal struct {
 }
 
 type _ElasticHit struct {
-	Index  string          `json:"_index"`
-	Source json.RawMessage `json:"_source"`
-	Id     string          `json:"_id"`
+	Index     string              `json:"_index"`
+	Source    json.RawMessage     `json:"_source"`
+	Id        string              `json:"_id"`
+	Score     float64             `json:"_score"`
+	Sort      []json.RawMessage   `json:"sort"`
+	Highlight map[string][]string `json:"highlight"`
 }
 
 type _ElasticHits struct {
@@ -387,24 +562,9 @@ type _ElasticHits struct {
 	Total _ElasticTotal `json:"total"`
 }
 
-type _AggBucket struct {
-	Key   interface{} `json:"key"`
-	Count int         `json:"doc_count"`
-}
-
-type _AggResults struct {
-	Buckets []_AggBucket `json:"buckets"`
-	Value   interface{}  `json:"value"`
-}
-
-type _ElasticAgg struct {
-	Results _AggResults `json:"genres"`
-}
-
 type _ElasticResponse struct {
-	Took         int          `json:"took"`
-	Hits         _ElasticHits `json:"hits"`
-	Aggregations _ElasticAgg  `json:"aggregations"`
+	Took int          `json:"took"`
+	Hits _ElasticHits `json:"hits"`
 }
 
 // Gets a single elastic record by id.
@@ -413,6 +573,9 @@ func GetElasticRecordByQuery(
 	defer Debug("GetElasticRecordByQuery %v %v", index_suffix, query)()
 	defer Instrument("GetElasticRecordByQuery")()
 
+	ctx, cancel := withOperationTimeout(ctx, "GetElasticRecordByQuery")
+	defer cancel()
+
 	client, err := GetElasticClient()
 	if err != nil {
 		return nil, err
@@ -437,7 +600,7 @@ func GetElasticRecordByQuery(
 		hit := &_ElasticResponse{}
 		err := json.Unmarshal(data, hit)
 		if hit.Hits.Total.Value > 0 {
-			return hit.Hits.Hits[0].Source, err
+			return decompressSource(hit.Hits.Hits[0].Source), err
 		} else {
 			return nil, err
 		}
@@ -463,9 +626,40 @@ func GetElasticRecordByQuery(
 // Gets a single elastic record by id.
 func GetElasticRecord(
 	ctx context.Context, org_id, index, id string) (json.RawMessage, error) {
+	return GetElasticRecordWithOptions(ctx, org_id, index, id, GetOptions{})
+}
+
+type GetOptions struct {
+	// If the record is not found refresh the index and look once
+	// more before returning os.ErrNotExist. A record written just
+	// before may not have reached the node serving the read yet.
+	// Only use this to read records which should exist (e.g. right
+	// after writing them) as it slows down genuine misses.
+	RefreshOnNotFound bool
+}
+
+func GetElasticRecordWithOptions(
+	ctx context.Context, org_id, index, id string,
+	options GetOptions) (json.RawMessage, error) {
 	defer Debug("GetElasticRecord %v %v", index, id)()
 	defer Instrument("GetElasticRecord")()
 
+	ctx, cancel := withOperationTimeout(ctx, "GetElasticRecord")
+	defer cancel()
+
+	result, err := getElasticRecord(ctx, org_id, index, id)
+	if !options.RefreshOnNotFound || !errors.Is(err, os.ErrNotExist) {
+		return result, err
+	}
+
+	// A failed refresh (e.g. the index does not exist) is not
+	// reported - the second read tells us what is there.
+	_ = refreshIndexes(ctx, GetIndex(org_id, index))
+	return getElasticRecord(ctx, org_id, index, id)
+}
+
+func getElasticRecord(
+	ctx context.Context, org_id, index, id string) (json.RawMessage, error) {
 	client, err := GetElasticClient()
 	if err != nil {
 		return nil, err
@@ -489,7 +683,7 @@ func GetElasticRecord(
 	if !res.IsError() {
 		hit := &_ElasticHit{}
 		err := json.Unmarshal(data, hit)
-		return hit.Source, err
+		return decompressSource(hit.Source), err
 	}
 
 	response := ordereddict.NewDict()
@@ -531,6 +725,9 @@ func GetMultipleElasticRecords(
 
 	defer Instrument("GetMultipleElasticRecords")()
 
+	ctx, cancel := withOperationTimeout(ctx, "GetMultipleElasticRecords")
+	defer cancel()
+
 	if len(ids) == 0 {
 		return nil, nil
 	}
@@ -575,7 +772,7 @@ func GetMultipleElasticRecords(
 
 		result := make([]json.RawMessage, 0, len(hit.Docs))
 		for _, h := range hit.Docs {
-			result = append(result, h.Source)
+			result = append(result, decompressSource(h.Source))
 		}
 
 		return result, nil
@@ -598,13 +795,35 @@ func GetMultipleElasticRecords(
 	return nil, makeReadElasticError(data)
 }
 
+// Set the unique field used to break ties between documents with
+// the same sort value when paging. An empty field restores the
+// default.
+func SetSortTiebreaker(field string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if field == "" {
+		field = DefaultSortTiebreaker
+	}
+	sort_tiebreaker = field
+}
+
+func getSortTiebreaker() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return sort_tiebreaker
+}
+
 // Automatically take care of paging by returning a channel.  Query
 // should be a JSON query **without** a sorting clause, or "size"
 // clause.
 // This function will modify the query to add a sorting column and
 // automatically apply the search_after to page through the
-// results. Currently we do not take a point in time snapshot so
-// results are approximate.
+// results. If no sort field is given we sort by index order (_doc)
+// which is the cheapest stable sort for scanning. Ties are broken by
+// the sort tiebreaker field. Currently we do not take a point in time
+// snapshot so results are approximate.
 func QueryChan(
 	ctx context.Context,
 	config_obj *config_proto.Config,
@@ -616,64 +835,61 @@ func QueryChan(
 
 	output_chan := make(chan json.RawMessage)
 
-	query = strings.TrimSpace(query)
-	var part_query string
-	if sort_field != "" {
-		part_query = json.Format(`{"sort":[{%q: "asc"}], "size":%q,`,
-			sort_field, page_size)
-	} else {
-		part_query = json.Format(`{"size":%q,`, page_size)
+	if sort_field == NoSortField {
+		sort_field = "_doc"
 	}
-	part_query += query[1:]
 
-	part, _, err := QueryElasticRaw(ctx, org_id, index, part_query)
+	// Many documents may have the same value of the sort field
+	// (e.g. the same timestamp). To avoid skipping or repeating them
+	// at page boundaries we break ties with a unique field.
+	sort_clause := json.Format(`[{%q: "asc"}]`, sort_field)
+	tiebreaker := getSortTiebreaker()
+	if sort_field != tiebreaker {
+		sort_clause = json.Format(`[{%q: "asc"}, {%q: "asc"}]`,
+			sort_field, tiebreaker)
+	}
+
+	query = strings.TrimSpace(query)
+	part_query := fmt.Sprintf(`{"sort":%s, "size":%d,`,
+		sort_clause, page_size) + query[1:]
+
+	hits, _, err := queryElasticHits(
+		ctx, org_id, index, part_query, QueryOptions{})
 	if err != nil {
 		close(output_chan)
 		return output_chan, err
 	}
 
-	var search_after interface{}
-	var pres bool
-
 	go func() {
 		defer close(output_chan)
 
 		for {
-			if len(part) == 0 {
+			if len(hits) == 0 {
 				return
 			}
-			for idx, p := range part {
+			for _, hit := range hits {
 				select {
 				case <-ctx.Done():
 					return
-				case output_chan <- p:
+				case output_chan <- hit.Source:
 				}
+			}
 
-				// On the last row we look at the result so we can get
-				// the next part.
-				if idx == len(part)-1 {
-					row := ordereddict.NewDict()
-					err := row.UnmarshalJSON(p)
-					if err != nil {
-						logger := logging.GetLogger(config_obj,
-							&logging.FrontendComponent)
-						logger.Error("QueryChan: %v", err)
-						return
-					}
-
-					search_after, pres = row.Get(sort_field)
-					if !pres {
-						return
-					}
-				}
+			// The sort values of the last hit are the cursor for the
+			// next part.
+			search_after := hits[len(hits)-1].Sort
+			if len(search_after) == 0 {
+				return
 			}
 
 			// Form the next query using the search_after value.
-			part_query := json.Format(`
-{"sort":[{%q: "asc"}], "size":%q,"search_after": [%q],`,
-				sort_field, page_size, search_after) + query[1:]
+			part_query := fmt.Sprintf(`
+{"sort":%s, "size":%d,"search_after": %s,`,
+				sort_clause, page_size,
+				json.MustMarshalString(search_after)) + query[1:]
 
-			part, _, err = QueryElasticRaw(ctx, org_id, index, part_query)
+			hits, err = queryPageResuming(ctx, config_obj,
+				org_id, index, part_query, search_after)
 			if err != nil {
 				logger := logging.GetLogger(config_obj,
 					&logging.FrontendComponent)
@@ -686,18 +902,67 @@ func QueryChan(
 	return output_chan, nil
 }
 
+// The cluster drops the search context of a page when it is not
+// fetched in time (e.g. a node restarted between the query and
+// fetch phases). QueryChan keeps its own search_after cursor so
+// the page can simply be requested again rather than aborting the
+// scan.
+func queryPageResuming(
+	ctx context.Context, config_obj *config_proto.Config,
+	org_id, index, query string,
+	search_after []json.RawMessage) ([]_ElasticHit, error) {
+	for attempt := 0; ; attempt++ {
+		hits, _, err := queryElasticHits(
+			ctx, org_id, index, query, QueryOptions{})
+		if err == nil || attempt >= maxSearchContextRetries ||
+			!isSearchContextMissing(err) {
+			return hits, err
+		}
+
+		logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+		logger.Info("QueryChan: Search context of %v expired, resuming after %v",
+			index, json.MustMarshalString(search_after))
+
+		// Give the cluster time to recover (e.g. the node to
+		// restart) before trying again.
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// The cluster reports a lost search context either directly or as
+// the root cause of a failed search phase.
+func isSearchContextMissing(err error) bool {
+	var elastic_err *ElasticError
+	return errors.As(err, &elastic_err) &&
+		elastic_err.HasCause("search_context_missing_exception")
+}
+
 func DeleteByQuery(
 	ctx context.Context, org_id, index, query string) error {
 
 	defer Instrument("DeleteByQuery")()
 
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return err
+	}
+
 	client, err := GetElasticClient()
 	if err != nil {
 		return err
 	}
 
 	res, err := opensearchapi.DeleteByQueryRequest{
-		Index:   []string{GetIndex(org_id, index)},
+		Index:   GetIndexes(org_id, index),
 		Body:    strings.NewReader(query),
 		Refresh: &TRUE,
 	}.Do(ctx, client)
@@ -721,20 +986,26 @@ func DeleteByQuery(
 
 func QueryElasticAggregations(
 	ctx context.Context, org_id, index, query string) ([]string, error) {
+	return QueryElasticAggregationsWithOptions(
+		ctx, org_id, index, query, QueryOptions{})
+}
+
+func QueryElasticAggregationsWithOptions(
+	ctx context.Context, org_id, index, query string,
+	options QueryOptions) ([]string, error) {
 
 	defer Instrument("QueryElasticAggregations")()
 	defer Debug("QueryElasticAggregations %v", index)()
 
+	ctx, cancel := withOperationTimeout(ctx, "QueryElasticAggregations")
+	defer cancel()
+
 	es, err := GetElasticClient()
 	if err != nil {
 		return nil, err
 	}
 	res, err := es.Search(
-		es.Search.WithContext(ctx),
-		es.Search.WithIndex(GetIndex(org_id, index)),
-		es.Search.WithBody(strings.NewReader(query)),
-		es.Search.WithPretty(),
-	)
+		options.searchOptions(ctx, es, org_id, index, query)...)
 	if err != nil {
 		return nil, err
 	}
@@ -750,26 +1021,51 @@ func QueryElasticAggregations(
 		return nil, makeReadElasticError(data)
 	}
 
-	parsed := &_ElasticResponse{}
-	err = json.Unmarshal(data, &parsed)
+	aggs, err := ParseAggregations(data)
 	if err != nil {
 		return nil, makeReadElasticError(data)
 	}
 
+	name := options.aggregationName()
+	genres, pres := aggs[name]
+	if !pres {
+		// No shards were searched (e.g. the index pattern did not
+		// match anything) so there is nothing to aggregate.
+		if searchedNoShards(data) {
+			return nil, nil
+		}
+
+		// Otherwise the query probably used the wrong name or
+		// an unsupported aggregation type.
+		return nil, fmt.Errorf(
+			"QueryElasticAggregations: aggregation %v missing from response",
+			name)
+	}
+
 	var results []string
 	// Handle value aggregates
-	if !utils.IsNil(parsed.Aggregations.Results.Value) {
-		results = append(results, to_string(parsed.Aggregations.Results.Value))
+	if !utils.IsNil(genres.Value) {
+		results = append(results, to_string(genres.Value))
 		return results, nil
 	}
 
-	for _, hit := range parsed.Aggregations.Results.Buckets {
-		results = append(results, to_string(hit.Key))
+	for _, bucket := range genres.Buckets {
+		results = append(results, to_string(bucket.Key))
 	}
 
 	return results, nil
 }
 
+func searchedNoShards(data []byte) bool {
+	response := &struct {
+		Shards *struct {
+			Total int `json:"total"`
+		} `json:"_shards"`
+	}{}
+	err := json.Unmarshal(data, response)
+	return err == nil && response.Shards != nil && response.Shards.Total == 0
+}
+
 func to_string(a interface{}) string {
 	switch t := a.(type) {
 	case string:
@@ -783,20 +1079,45 @@ func to_string(a interface{}) string {
 func QueryElasticRaw(
 	ctx context.Context,
 	org_id, index, query string) ([]json.RawMessage, int, error) {
+	return QueryElasticRawWithOptions(
+		ctx, org_id, index, query, QueryOptions{})
+}
+
+func QueryElasticRawWithOptions(
+	ctx context.Context,
+	org_id, index, query string,
+	options QueryOptions) ([]json.RawMessage, int, error) {
 
 	defer Instrument("QueryElasticRaw")()
 	defer Debug("QueryElasticRaw %v", index)()
 
+	ctx, cancel := withOperationTimeout(ctx, "QueryElasticRaw")
+	defer cancel()
+
+	hits, total, err := queryElasticHits(ctx, org_id, index, query, options)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var results []json.RawMessage
+	for _, hit := range hits {
+		results = append(results, hit.Source)
+	}
+
+	return results, total, nil
+}
+
+func queryElasticHits(
+	ctx context.Context,
+	org_id, index, query string,
+	options QueryOptions) ([]_ElasticHit, int, error) {
+
 	es, err := GetElasticClient()
 	if err != nil {
 		return nil, 0, err
 	}
 	res, err := es.Search(
-		es.Search.WithContext(ctx),
-		es.Search.WithIndex(GetIndex(org_id, index)),
-		es.Search.WithBody(strings.NewReader(query)),
-		es.Search.WithPretty(),
-	)
+		options.searchOptions(ctx, es, org_id, index, query)...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -818,12 +1139,11 @@ func QueryElasticRaw(
 		return nil, 0, makeReadElasticError(data)
 	}
 
-	var results []json.RawMessage
-	for _, hit := range parsed.Hits.Hits {
-		results = append(results, hit.Source)
+	for i := range parsed.Hits.Hits {
+		parsed.Hits.Hits[i].Source = decompressSource(parsed.Hits.Hits[i].Source)
 	}
 
-	return results, parsed.Hits.Total.Value, nil
+	return parsed.Hits.Hits, parsed.Hits.Total.Value, nil
 }
 
 // Return only Ids of matching documents.
@@ -834,6 +1154,10 @@ func QueryElasticIds(
 	org_id, index, query string) (ids []string, total int, err error) {
 
 	defer Instrument("QueryElasticIds")()
+
+	ctx, cancel := withOperationTimeout(ctx, "QueryElasticIds")
+	defer cancel()
+
 	es, err := GetElasticClient()
 	if err != nil {
 		return nil, 0, err
@@ -884,6 +1208,9 @@ func QueryElastic(
 
 	defer Instrument("QueryElastic")()
 
+	ctx, cancel := withOperationTimeout(ctx, "QueryElastic")
+	defer cancel()
+
 	es, err := GetElasticClient()
 	if err != nil {
 		return nil, err
@@ -918,7 +1245,7 @@ func QueryElastic(
 	var results []Result
 	for _, hit := range parsed.Hits.Hits {
 		results = append(results, Result{
-			JSON: hit.Source,
+			JSON: decompressSource(hit.Source),
 			Id:   hit.Id,
 		})
 	}
@@ -926,12 +1253,69 @@ func QueryElastic(
 	return results, nil
 }
 
+// A search hit with its metadata.
+type FullResult struct {
+	Id    string
+	Index string
+
+	// The score is 0 if the query did not compute scores (e.g. it
+	// was sorted by another field).
+	Score  float64
+	Source json.RawMessage
+
+	// The sort values of the hit (which may be used to page with
+	// search_after) and the highlighted fragments by field, if the
+	// query asked for them.
+	Sort      []json.RawMessage
+	Highlight map[string][]string
+}
+
+// Like QueryElastic but keeps the metadata of each hit. Also returns
+// the total number of matching documents.
+func QueryElasticFull(
+	ctx context.Context,
+	org_id, index, query string) ([]FullResult, int, error) {
+	return QueryElasticFullWithOptions(
+		ctx, org_id, index, query, QueryOptions{})
+}
+
+func QueryElasticFullWithOptions(
+	ctx context.Context,
+	org_id, index, query string,
+	options QueryOptions) ([]FullResult, int, error) {
+
+	defer Instrument("QueryElasticFull")()
+	defer Debug("QueryElasticFull %v", index)()
+
+	ctx, cancel := withOperationTimeout(ctx, "QueryElasticFull")
+	defer cancel()
+
+	hits, total, err := queryElasticHits(ctx, org_id, index, query, options)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results := make([]FullResult, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, FullResult{
+			Id:        hit.Id,
+			Index:     hit.Index,
+			Score:     hit.Score,
+			Source:    hit.Source,
+			Sort:      hit.Sort,
+			Highlight: hit.Highlight,
+		})
+	}
+
+	return results, total, nil
+}
+
 func GetElasticClient() (*opensearch.Client, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
 	if gElasticClient == nil {
-		return nil, errors.New("Elastic configuration not initialized")
+		return nil, ErrClientNotInitialized
 	}
 
 	return gElasticClient, nil
@@ -942,18 +1326,53 @@ func SetElasticClient(c *opensearch.Client) {
 	defer mu.Unlock()
 
 	gElasticClient = c
+	gTransport = nil
 }
 
+func setElasticClientWithTransport(
+	c *opensearch.Client, transport *http.Transport) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	gElasticClient = c
+	gTransport = transport
+}
+
+// Close the idle connections of the global client and uninstall
+// it. Further calls will fail until a new client is installed.
+func CloseElasticClient() {
+	mu.Lock()
+	transport := gTransport
+	gElasticClient = nil
+	gTransport = nil
+	mu.Unlock()
+
+	if transport != nil {
+		transport.CloseIdleConnections()
+	}
+}
+
+// Install the logger used by Debug(). Nothing is logged unless the
+// logger is at the debug level.
 func SetDebugLogger(config_obj *config_proto.Config) {
 	mu.Lock()
 	defer mu.Unlock()
 
 	logger = logging.GetLogger(config_obj, &logging.FrontendComponent)
+	if logger != nil && logger.IsLevelEnabled(logrus.DebugLevel) {
+		debug_logger.Store(logger)
+	} else {
+		debug_logger.Store((*logging.LogContext)(nil))
+	}
 }
 
 func StartElasticSearchService(ctx context.Context, config_obj *cloud_velo_config.Config) error {
 	cfg := opensearch.Config{
 		Addresses: config_obj.Cloud.Addresses,
+
+		// Tag our requests so ListTasks can find the tasks we
+		// started.
+		Header: http.Header{"X-Opaque-Id": []string{cloudveloOpaqueId}},
 	}
 
 	CA_Pool := x509.NewCertPool()
@@ -964,7 +1383,7 @@ func StartElasticSearchService(ctx context.Context, config_obj *cloud_velo_confi
 		return errors.New("cloud ingestion: Unable to add root certs")
 	}
 
-	cfg.Transport = &http.Transport{
+	transport := &http.Transport{
 		MaxIdleConnsPerHost:   10,
 		ResponseHeaderTimeout: 100 * time.Second,
 		TLSClientConfig: &tls.Config{
@@ -974,6 +1393,7 @@ func StartElasticSearchService(ctx context.Context, config_obj *cloud_velo_confi
 		},
 		//DisableCompression: true,
 	}
+	cfg.Transport = transport
 
 	if config_obj.Cloud.Username != "" && config_obj.Cloud.Password != "" {
 		cfg.Username = config_obj.Cloud.Username
@@ -992,6 +1412,28 @@ func StartElasticSearchService(ctx context.Context, config_obj *cloud_velo_confi
 		return err
 	}
 
+	err = SetIdStrategy(config_obj.Cloud.IdStrategy)
+	if err != nil {
+		return err
+	}
+	err = SetTimeSeriesIndexes(config_obj.Cloud.TimeSeriesIndexes)
+	if err != nil {
+		return err
+	}
+	SetSortTiebreaker(config_obj.Cloud.SortTiebreakerField)
+	SetQuarantineIndex(config_obj.Cloud.QuarantineIndex)
+	SetDeleteBatchSize(config_obj.Cloud.DeleteBatchSize)
+	SetRefreshTimeout(time.Duration(
+		config_obj.Cloud.RefreshTimeoutSeconds) * time.Second)
+	err = SetOperationTimeouts(config_obj.Cloud.OperationTimeoutSeconds)
+	if err != nil {
+		return err
+	}
+	err = SetImportantWriteShards(config_obj.Cloud.WaitForActiveShards)
+	if err != nil {
+		return err
+	}
+
 	// Fetch info immediately to verify that we can actually connect
 	// to the server.
 	res, err := client.Info()
@@ -1002,7 +1444,45 @@ func StartElasticSearchService(ctx context.Context, config_obj *cloud_velo_confi
 	defer res.Body.Close()
 
 	// Set the global elastic client
-	SetElasticClient(client)
+	setElasticClientWithTransport(client, transport)
+	SetRetryBudget(config_obj.Cloud.RetryBudget,
+		config_obj.Cloud.RetryBudgetPerSecond)
+	setClientInfo(newElasticClientInfo(cfg, config_obj.Cloud.RootCerts != ""))
+
+	SetRejectWritesWhenRed(config_obj.Cloud.RejectWritesWhenRed)
+	period := getClusterHealthPollPeriod(&config_obj.Cloud)
+	if period > 0 {
+		StartClusterHealthPoller(ctx, period)
+	}
+
+	StartExpirySweeper(ctx, config_obj.VeloConf(), time.Duration(
+		config_obj.Cloud.ExpirySweepSeconds)*time.Second,
+		"persisted", "transient")
+
+	err = InstallIngestPipelines(ctx, config_obj.Cloud.IngestPipelines)
+	if err != nil {
+		return err
+	}
+
+	// Remote clusters are optional so failing to register them
+	// should not prevent us from starting.
+	err = RegisterRemoteClusters(ctx, config_obj.Cloud.RemoteClusters)
+	if err != nil {
+		logger := logging.GetLogger(
+			config_obj.VeloConf(), &logging.FrontendComponent)
+		logger.Error("Unable to register remote clusters: %v", err)
+	}
+
+	// Raise the keyword limits on the existing indexes of all orgs.
+	SetKeywordIgnoreAbove(config_obj.Cloud.KeywordIgnoreAbove)
+	for _, index := range []string{"persisted", "transient"} {
+		err = UpdateKeywordIgnoreAbove(ctx, "*"+index)
+		if err != nil {
+			logger := logging.GetLogger(
+				config_obj.VeloConf(), &logging.FrontendComponent)
+			logger.Error("Unable to update keyword ignore_above: %v", err)
+		}
+	}
 
 	return nil
 }
@@ -1020,7 +1500,7 @@ func makeElasticError(data []byte) error {
 		return fmt.Errorf("Elastic Error: %v: %v", err_type, err_reason)
 	}
 
-	return fmt.Errorf("Elastic Error: %v", response)
+	return newElasticError(response, err_type)
 }
 
 func makeReadElasticError(data []byte) error {
@@ -1045,14 +1525,56 @@ func makeReadElasticError(data []byte) error {
 		return fmt.Errorf("Elastic Error: %v: %v", err_type, err_reason)
 	}
 
-	return fmt.Errorf("Elastic Error: %v", response)
+	return newElasticError(response, err_type)
+}
+
+// An error response from the cluster.
+type ElasticError struct {
+	// The HTTP status of the response (0 if not known) and the type
+	// of the error (e.g. version_conflict_engine_exception).
+	Status int
+	Type   string
+
+	response *ordereddict.Dict
+}
+
+func newElasticError(response *ordereddict.Dict, err_type string) error {
+	result := &ElasticError{Type: err_type, response: response}
+	status, _ := response.Get("status")
+	switch t := status.(type) {
+	case int64:
+		result.Status = int(t)
+	case float64:
+		result.Status = int(t)
+	}
+	return result
+}
+
+func (self *ElasticError) Error() string {
+	return fmt.Sprintf("Elastic Error: %v", self.response)
 }
 
-// Convert the item into a unique document ID - This is needed when
-// the item can be longer than the maximum 512 bytes.
-func MakeId(item string) string {
-	hash := sha1.Sum([]byte(item))
-	return hex.EncodeToString(hash[:])
+// Is the error, or one of its root causes, of this type?
+func (self *ElasticError) HasCause(err_type string) bool {
+	if self.Type == err_type {
+		return true
+	}
+
+	error_any, _ := self.response.Get("error")
+	error_dict, ok := error_any.(*ordereddict.Dict)
+	if !ok {
+		return false
+	}
+
+	root_causes_any, _ := error_dict.Get("root_cause")
+	root_causes, _ := root_causes_any.([]interface{})
+	for _, cause := range root_causes {
+		cause_dict, ok := cause.(*ordereddict.Dict)
+		if ok && utils.GetString(cause_dict, "type") == err_type {
+			return true
+		}
+	}
+	return false
 }
 
 type BulkIndexer struct {
@@ -1062,14 +1584,211 @@ type BulkIndexer struct {
 	mu         sync.Mutex
 
 	indexes map[string]bool
+
+	// Indexes we already made sure exist (or are checking now). When
+	// many orgs write at the same time, creating new indexes in the
+	// middle of a bulk request causes a lot of mapping updates on
+	// the cluster. Indexes which could not be checked are not
+	// checked again until their retry time. Guarded by ensure_mu, not
+	// mu, so the checks never block other writers.
+	ensure_mu    sync.Mutex
+	ensured      map[string]bool
+	ensure_retry map[string]time.Time
+
+	// Unix nano time the oldest buffered item was added, or 0 if
+	// nothing is waiting. Accessed atomically because the flush
+	// hooks run on the indexer's workers while Add() holds mu.
+	pending_since int64
+
+	// Items not yet acknowledged by the server.
+	pending pendingItems
+
+	// Set atomically once the shutdown flush starts.
+	shutting_down int32
+
+	// Flush as soon as this many items are buffered for any single
+	// index. Many tiny documents (e.g. pings) would otherwise wait
+	// for the flush interval since they do not reach the byte
+	// threshold. 0 disables this.
+	flush_count      int
+	new_bulk_indexer func() (opensearchutil.BulkIndexer, error)
+
+	// Indexers swapped out by flushLocked which are still being
+	// flushed.
+	flushes sync.WaitGroup
+
+	// Items added per index since the last flush. This has its own
+	// lock because the flush hooks reset it while Add() holds mu.
+	counts_mu sync.Mutex
+	counts    map[string]int
 }
 
 func (self *BulkIndexer) Add(ctx context.Context, item opensearchutil.BulkIndexerItem) error {
+	self.ensureIndex(item.Index)
+
 	self.mu.Lock()
 	defer self.mu.Unlock()
 
 	self.indexes[item.Index] = true
-	return self.BulkIndexer.Add(ctx, item)
+	item = self.pending.track(item)
+	atomic.CompareAndSwapInt64(&self.pending_since, 0,
+		utils.GetTime().Now().UnixNano())
+	err := self.BulkIndexer.Add(ctx, item)
+	if err != nil {
+		return err
+	}
+
+	if self.countItem(item.Index) {
+		self.flushLocked()
+	}
+	return nil
+}
+
+// Make sure the index exists before the first item is written to
+// it. Only the first writer to a new index checks it - the others
+// go ahead and the bulk request creates the index if needed. No
+// locks are held during the check.
+func (self *BulkIndexer) ensureIndex(index string) {
+	now := utils.GetTime().Now()
+
+	self.ensure_mu.Lock()
+	if self.ensured == nil {
+		self.ensured = make(map[string]bool)
+	}
+	if self.ensured[index] || now.Before(self.ensure_retry[index]) {
+		self.ensure_mu.Unlock()
+		return
+	}
+	self.ensured[index] = true
+	self.ensure_mu.Unlock()
+
+	ctx := self.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	sub_ctx, cancel := context.WithTimeout(ctx, ensureIndexTimeout)
+	defer cancel()
+
+	err := EnsureIndex(sub_ctx, index)
+	if err != nil {
+		// Not fatal - the bulk request will create the index.
+		logger := logging.GetLogger(self.config_obj, &logging.FrontendComponent)
+		logger.Error("BulkIndexer: EnsureIndex %v: %v", index, err)
+
+		self.ensure_mu.Lock()
+		defer self.ensure_mu.Unlock()
+
+		if self.ensure_retry == nil {
+			self.ensure_retry = make(map[string]time.Time)
+		}
+		delete(self.ensured, index)
+		self.ensure_retry[index] = utils.GetTime().Now().Add(ensureIndexBackoff)
+	}
+}
+
+// Count an added item. Returns true if the index reached the flush
+// count.
+func (self *BulkIndexer) countItem(index string) bool {
+	if self.flush_count <= 0 || self.new_bulk_indexer == nil {
+		return false
+	}
+
+	self.counts_mu.Lock()
+	defer self.counts_mu.Unlock()
+
+	if self.counts == nil {
+		self.counts = make(map[string]int)
+	}
+	self.counts[index]++
+	return self.counts[index] >= self.flush_count
+}
+
+func (self *BulkIndexer) resetCounts() {
+	self.counts_mu.Lock()
+	defer self.counts_mu.Unlock()
+
+	self.counts = nil
+}
+
+// Flush the buffered items now by swapping in a new indexer and
+// closing the old one in the background, so writers are not blocked
+// while it is sent. The items were already accepted so errors are
+// only logged. Must be called with mu held.
+func (self *BulkIndexer) flushLocked() {
+	new_bulk_indexer, err := self.new_bulk_indexer()
+	if err != nil {
+		logBulkError(self.config_obj, "BulkIndexer: flush: %v", err)
+		return
+	}
+
+	old_bulk_indexer := self.BulkIndexer
+	self.BulkIndexer = new_bulk_indexer
+	self.resetCounts()
+
+	self.flushes.Add(1)
+	go func() {
+		defer self.flushes.Done()
+
+		err := old_bulk_indexer.Close(context.Background())
+		if err != nil {
+			logBulkError(self.config_obj, "BulkIndexer: flush: %v", err)
+		}
+	}()
+}
+
+type flushStartKey int
+type flushMarkKey int
+
+// Record how long items were buffered before this flush started.
+func (self *BulkIndexer) onFlushStart(ctx context.Context) context.Context {
+	logger := logging.GetLogger(self.config_obj, &logging.FrontendComponent)
+	logger.Debug("Flushing bulk indexer.")
+
+	// Everything buffered so far is being flushed.
+	self.resetCounts()
+
+	now := utils.GetTime().Now()
+	pending_since := atomic.SwapInt64(&self.pending_since, 0)
+	if pending_since > 0 {
+		BulkIndexerLagHistogram.Observe(
+			now.Sub(time.Unix(0, pending_since)).Seconds())
+	}
+
+	// Everything tracked so far may be part of this flush.
+	ctx = context.WithValue(ctx, flushMarkKey(0), self.pending.mark())
+	return context.WithValue(ctx, flushStartKey(0), now)
+}
+
+// A flush failed outright and the bulk indexer dropped its items
+// without calling their callbacks. Stop tracking them so they are not
+// resubmitted on shutdown after newer writes to the same documents.
+// Items buffered for other flushes may be forgotten too, which only
+// means they are not spilled. The shutdown flush keeps them so they
+// can be retried and spilled.
+func (self *BulkIndexer) onFlushError(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+
+	logBulkError(self.config_obj, "BulkIndexerConfig: %v", err)
+
+	if atomic.LoadInt32(&self.shutting_down) != 0 {
+		return
+	}
+
+	mark, ok := ctx.Value(flushMarkKey(0)).(uint64)
+	if ok {
+		self.pending.dropUpTo(mark)
+	}
+}
+
+// Record how long the flush itself took.
+func (self *BulkIndexer) onFlushEnd(ctx context.Context) {
+	start, ok := ctx.Value(flushStartKey(0)).(time.Time)
+	if ok {
+		OpensearchHistorgram.WithLabelValues("BulkFlush").Observe(
+			utils.GetTime().Now().Sub(start).Seconds())
+	}
 }
 
 func (self *BulkIndexer) Close() error {
@@ -1086,17 +1805,9 @@ func (self *BulkIndexer) Close() error {
 			Client:        elastic_client,
 			Refresh:       "true",
 			FlushInterval: time.Second * 10,
-			OnFlushStart: func(ctx context.Context) context.Context {
-				logger := logging.GetLogger(self.config_obj, &logging.FrontendComponent)
-				logger.Debug("Flushing bulk indexer.")
-				return ctx
-			},
-			OnError: func(ctx context.Context, err error) {
-				if err != nil {
-					logger := logging.GetLogger(self.config_obj, &logging.FrontendComponent)
-					logger.Error("BulkIndexerConfig: %v", err)
-				}
-			},
+			OnFlushStart:  self.onFlushStart,
+			OnFlushEnd:    self.onFlushEnd,
+			OnError:       self.onFlushError,
 		})
 	if err != nil {
 		return err
@@ -1104,25 +1815,20 @@ func (self *BulkIndexer) Close() error {
 
 	ctx := context.Background()
 	err = self.BulkIndexer.Close(ctx)
+
+	// Wait for earlier flushes so the refresh below covers them.
+	self.flushes.Wait()
 	if err != nil {
 		return err
 	}
 
+	self.BulkIndexer = new_bulk_indexer
+
 	indexes := []string{}
 	for i := range self.indexes {
 		indexes = append(indexes, i)
 	}
-	res, err := opensearchapi.IndicesRefreshRequest{
-		Index: indexes,
-	}.Do(ctx, elastic_client)
-	if err != nil {
-		return err
-	}
-
-	defer res.Body.Close()
-
-	self.BulkIndexer = new_bulk_indexer
-	return nil
+	return refreshIndexes(ctx, indexes...)
 }
 
 func FlushBulkIndexer() error {
@@ -1145,44 +1851,61 @@ func StartBulkIndexService(
 		return err
 	}
 
-	new_bulk_indexer, err := opensearchutil.NewBulkIndexer(
-		opensearchutil.BulkIndexerConfig{
-			Client:        elastic_client,
-			FlushInterval: time.Second * 2,
-			OnFlushStart: func(ctx context.Context) context.Context {
-				logger := logging.GetLogger(
-					config_obj.VeloConf(), &logging.FrontendComponent)
-				logger.Debug("Flushing bulk indexer.")
-				return ctx
-			},
-			OnError: func(ctx context.Context, err error) {
-				if err != nil {
-					logger := logging.GetLogger(
-						config_obj.VeloConf(), &logging.FrontendComponent)
-					logger.Error("BulkIndexerConfig: %v", err)
-				}
-			},
-		})
-	if err != nil {
-		return err
-	}
+	SetBulkErrorSampling(config_obj.Cloud.BulkErrorLogFirst,
+		config_obj.Cloud.BulkErrorLogEvery)
+	startBulkErrorSummary(ctx, config_obj.VeloConf(), time.Duration(
+		config_obj.Cloud.BulkErrorSummarySeconds)*time.Second)
 
-	mu.Lock()
-	bulk_indexer = &BulkIndexer{
-		BulkIndexer: new_bulk_indexer,
+	indexer := &BulkIndexer{
 		config_obj:  config_obj.VeloConf(),
 		ctx:         ctx,
 		indexes:     make(map[string]bool),
+		ensured:     make(map[string]bool),
+		flush_count: config_obj.Cloud.BulkFlushDocuments,
+	}
+
+	indexer.new_bulk_indexer = func() (opensearchutil.BulkIndexer, error) {
+		return opensearchutil.NewBulkIndexer(
+			opensearchutil.BulkIndexerConfig{
+				Client:        elastic_client,
+				FlushInterval: time.Second * 2,
+				OnFlushStart:  indexer.onFlushStart,
+				OnFlushEnd:    indexer.onFlushEnd,
+				OnError:       indexer.onFlushError,
+			})
+	}
+
+	new_bulk_indexer, err := indexer.new_bulk_indexer()
+	if err != nil {
+		return err
 	}
+
+	indexer.BulkIndexer = new_bulk_indexer
+
+	mu.Lock()
+	bulk_indexer = indexer
 	mu.Unlock()
 
-	// Ensure we flush the indexer before we exit.
+	// Resubmit anything we could not flush on the last shutdown.
+	err = replaySpillFile(config_obj.VeloConf(), indexer,
+		config_obj.Cloud.BulkSpillFile)
+	if err != nil {
+		logger := logging.GetLogger(
+			config_obj.VeloConf(), &logging.FrontendComponent)
+		logger.Error("BulkIndexer: replaying %v: %v",
+			config_obj.Cloud.BulkSpillFile, err)
+	}
+
+	// Ensure we flush the indexer before we exit. The bulk indexer
+	// is the last user of the client so we can close it after.
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		<-ctx.Done()
 
-		FlushBulkIndexer()
+		shutdownBulkIndexer(config_obj.VeloConf(),
+			bulkShutdownTimeout, config_obj.Cloud.BulkSpillFile)
+		CloseElasticClient()
 	}()
 
 	return err
@@ -0,0 +1,388 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	cloud_velo_config "www.velocidex.com/golang/cloudvelo/config"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+// Repeatedly starting and closing the client should not leave any
+// connections open on the server.
+func TestCloseElasticClient(t *testing.T) {
+	var mu sync.Mutex
+	open := make(map[net.Conn]bool)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"hits": {"total": {"value": 0}, "hits": []}}`))
+		}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch state {
+		case http.StateNew:
+			open[conn] = true
+		case http.StateClosed, http.StateHijacked:
+			delete(open, conn)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	old_client, _ := GetElasticClient()
+	defer SetElasticClient(old_client)
+
+	config_obj := &cloud_velo_config.Config{}
+	config_obj.Cloud.Addresses = []string{server.URL}
+	config_obj.Cloud.Username = "user"
+	config_obj.Cloud.Password = "pass"
+
+	open_connections := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(open)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		err := StartElasticSearchService(ctx, config_obj)
+		assert.NoError(t, err)
+
+		// Leave an idle connection in the pool.
+		_, _, err = QueryElasticRaw(ctx, "test", "persisted",
+			`{"query": {"match_all": {}}}`)
+		assert.NoError(t, err)
+
+		CloseElasticClient()
+
+		_, err = GetElasticClient()
+		assert.True(t, errors.Is(err, ErrClientNotInitialized))
+
+		// The server notices the closed connections asynchronously.
+		deadline := time.Now().Add(5 * time.Second)
+		for open_connections() > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, 0, open_connections())
+	}
+}
+
+func lagSamples(t *testing.T) (uint64, float64) {
+	metric := &dto.Metric{}
+	err := BulkIndexerLagHistogram.Write(metric)
+	assert.NoError(t, err)
+	return metric.Histogram.GetSampleCount(), metric.Histogram.GetSampleSum()
+}
+
+func TestBulkIndexerLag(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"errors": false, "items": [{"index": {"status": 201}}]}`))
+	})
+	defer closer()
+
+	clock := &utils.MockClock{MockNow: time.Unix(100, 0)}
+	defer utils.MockTime(clock)()
+
+	client, err := GetElasticClient()
+	assert.NoError(t, err)
+
+	indexer := &BulkIndexer{
+		config_obj: &config_proto.Config{},
+		ctx:        context.Background(),
+		indexes:    make(map[string]bool),
+		ensured:    make(map[string]bool),
+	}
+	indexer.BulkIndexer, err = opensearchutil.NewBulkIndexer(
+		opensearchutil.BulkIndexerConfig{
+			Client:        client,
+			FlushInterval: time.Hour,
+			OnFlushStart:  indexer.onFlushStart,
+			OnFlushEnd:    indexer.onFlushEnd,
+		})
+	assert.NoError(t, err)
+
+	count, sum := lagSamples(t)
+
+	err = indexer.Add(context.Background(), opensearchutil.BulkIndexerItem{
+		Index:  "test_persisted",
+		Action: "index",
+	})
+	assert.NoError(t, err)
+
+	// The item waits 5 seconds before it is flushed.
+	clock.MockNow = time.Unix(105, 0)
+	err = indexer.BulkIndexer.Close(context.Background())
+	assert.NoError(t, err)
+
+	new_count, new_sum := lagSamples(t)
+	assert.Equal(t, count+1, new_count)
+	assert.InDelta(t, float64(5), new_sum-sum, 1e-6)
+}
+
+func TestBulkIndexerFlushCount(t *testing.T) {
+	var mu sync.Mutex
+	var batches []int
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		// Each item is an action line and a document line.
+		lines := strings.Count(string(body), "\n")
+		mu.Lock()
+		batches = append(batches, lines/2)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"errors": false, "items": []}`))
+	})
+	defer closer()
+
+	client, err := GetElasticClient()
+	assert.NoError(t, err)
+
+	indexer := &BulkIndexer{
+		config_obj:  &config_proto.Config{},
+		ctx:         context.Background(),
+		indexes:     make(map[string]bool),
+		ensured:     map[string]bool{"test_transient": true, "test_persisted": true},
+		flush_count: 10,
+	}
+	indexer.new_bulk_indexer = func() (opensearchutil.BulkIndexer, error) {
+		return opensearchutil.NewBulkIndexer(
+			opensearchutil.BulkIndexerConfig{
+				Client:        client,
+				NumWorkers:    1,
+				FlushInterval: time.Hour,
+				OnFlushStart:  indexer.onFlushStart,
+			})
+	}
+	indexer.BulkIndexer, err = indexer.new_bulk_indexer()
+	assert.NoError(t, err)
+
+	add := func(index string, count int) {
+		for i := 0; i < count; i++ {
+			err := indexer.Add(context.Background(), opensearchutil.BulkIndexerItem{
+				Index:  index,
+				Action: "index",
+				Body:   strings.NewReader(`{}`),
+			})
+			assert.NoError(t, err)
+		}
+	}
+
+	getBatches := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int{}, batches...)
+	}
+
+	// Below the count for each index - nothing is flushed.
+	add("test_persisted", 9)
+	add("test_transient", 9)
+	assert.Equal(t, []int{}, getBatches())
+
+	// The tenth tiny item of one index flushes everything. The
+	// flush happens in the background.
+	add("test_transient", 1)
+	indexer.flushes.Wait()
+	assert.Equal(t, []int{19}, getBatches())
+
+	// The counts start again after the flush.
+	add("test_persisted", 25)
+	indexer.flushes.Wait()
+	assert.Equal(t, []int{19, 10, 10}, getBatches())
+
+	err = indexer.BulkIndexer.Close(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []int{19, 10, 10, 5}, getBatches())
+}
+
+func TestQueryChanResumesLostSearchContext(t *testing.T) {
+	old_delay := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = old_delay }()
+
+	var mu sync.Mutex
+	var search_afters []string
+	failed := false
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		request := &struct {
+			SearchAfter []interface{} `json:"search_after"`
+		}{}
+		json.Unmarshal(body, request)
+		search_after := fmt.Sprintf("%v", request.SearchAfter)
+		search_afters = append(search_afters, search_after)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch search_after {
+		case "[]":
+			w.Write([]byte(`{"hits": {"hits": [
+  {"_id": "1", "_source": {"id": 1}, "sort": [1]},
+  {"_id": "2", "_source": {"id": 2}, "sort": [2]}]}}`))
+
+		case "[2]":
+			// The context is lost the first time the second page
+			// is fetched.
+			if !failed {
+				failed = true
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error": {"type": "search_phase_execution_exception", "reason": "all shards failed", "root_cause": [{"type": "search_context_missing_exception", "reason": "No search context found for id [1]"}]}, "status": 404}`))
+				return
+			}
+			w.Write([]byte(`{"hits": {"hits": [
+  {"_id": "3", "_source": {"id": 3}, "sort": [3]}]}}`))
+
+		default:
+			w.Write([]byte(`{"hits": {"hits": []}}`))
+		}
+	})
+	defer closer()
+
+	output_chan, err := QueryChan(context.Background(), &config_proto.Config{},
+		2, "test", "transient", `{"query": {"match_all": {}}}`, "_id")
+	assert.NoError(t, err)
+
+	var ids []string
+	for hit := range output_chan {
+		ids = append(ids, string(hit))
+	}
+
+	// The scan completes without skipping or repeating documents.
+	assert.Equal(t, []string{`{"id": 1}`, `{"id": 2}`, `{"id": 3}`}, ids)
+	assert.Equal(t, []string{"[]", "[2]", "[2]", "[3]"}, search_afters)
+}
+
+func TestQueryElasticFull(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hits": {"total": {"value": 5}, "hits": [{
+  "_index": "o123_transient", "_id": "doc1", "_score": 1.5,
+  "_source": {"client_id": "C.1"},
+  "sort": [1700000000, "doc1"],
+  "highlight": {"client_id": ["<em>C.1</em>"]}
+}]}}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	hits, total, err := QueryElasticFull(ctx, "O123", "transient",
+		`{"query": {"match": {"client_id": "C.1"}}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, total)
+	assert.Equal(t, 1, len(hits))
+
+	hit := hits[0]
+	assert.Equal(t, "doc1", hit.Id)
+	assert.Equal(t, "o123_transient", hit.Index)
+	assert.Equal(t, 1.5, hit.Score)
+	assert.JSONEq(t, `{"client_id": "C.1"}`, string(hit.Source))
+	assert.Equal(t, 2, len(hit.Sort))
+	assert.Equal(t, "1700000000", string(hit.Sort[0]))
+	assert.Equal(t, `"doc1"`, string(hit.Sort[1]))
+	assert.Equal(t, map[string][]string{
+		"client_id": {"<em>C.1</em>"},
+	}, hit.Highlight)
+}
+
+func TestSetElasticIndexWithMeta(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"_index": "test_persisted", "_id": "1", "_version": 3,
+  "result": "updated", "_seq_no": 7, "_primary_term": 2}`))
+	})
+	defer closer()
+
+	meta, err := SetElasticIndexWithMeta(context.Background(),
+		"test", "persisted", "1", map[string]int{"A": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, &DocumentMeta{
+		Index:       "test_persisted",
+		Id:          "1",
+		Version:     3,
+		SeqNo:       7,
+		PrimaryTerm: 2,
+	}, meta)
+}
+
+func TestGetElasticRecordRefreshOnNotFound(t *testing.T) {
+	var requests []string
+	visible := false
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/test_persisted/_refresh":
+			w.Write([]byte(`{"_shards": {"total": 1, "successful": 1, "failed": 0}}`))
+
+		case "/test_persisted/_doc/written":
+			// The write only becomes visible after a refresh.
+			if visible {
+				w.Write([]byte(`{"_index": "test_persisted", "_id": "written",
+  "found": true, "_source": {"A": 1}}`))
+				return
+			}
+			visible = true
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"_index": "test_persisted", "_id": "written", "found": false}`))
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"_index": "test_persisted", "_id": "missing", "found": false}`))
+		}
+	})
+	defer closer()
+
+	ctx := context.Background()
+	options := GetOptions{RefreshOnNotFound: true}
+
+	// The transient miss is retried after a refresh.
+	record, err := GetElasticRecordWithOptions(ctx,
+		"test", "persisted", "written", options)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"A": 1}`, string(record))
+	assert.Equal(t, []string{
+		"GET /test_persisted/_doc/written",
+		"POST /test_persisted/_refresh",
+		"GET /test_persisted/_doc/written"}, requests)
+
+	// Genuinely missing records are still not found, only once
+	// more slowly.
+	requests = nil
+	_, err = GetElasticRecordWithOptions(ctx,
+		"test", "persisted", "missing", options)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Equal(t, 3, len(requests))
+
+	// Without the option a miss is reported immediately.
+	requests = nil
+	_, err = GetElasticRecord(ctx, "test", "persisted", "missing")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.Equal(t, []string{"GET /test_persisted/_doc/missing"}, requests)
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+const (
+	// How long the bulk indexer waits for an index check, and how
+	// long it waits before checking an index again after a failure.
+	ensureIndexTimeout = 10 * time.Second
+	ensureIndexBackoff = 30 * time.Second
+)
+
+// Make sure the index exists, creating it from the matching index
+// template if needed. The index takes its settings (e.g. the index
+// sort of time series) from the template, indexes whose template is
+// a data stream template are created as data streams. New indexes
+// get the configured keyword ignore_above limits and are ready to be
+// written to when this returns.
+func EnsureIndex(ctx context.Context, index string) error {
+	defer Instrument("EnsureIndex")()
+	defer Debug("EnsureIndex %v", index)()
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IndicesExistsRequest{
+		Index: []string{index},
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		return makeElasticError([]byte(res.String()))
+	}
+
+	res, err = opensearchapi.IndicesCreateRequest{
+		Index: index,
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !res.IsError() ||
+		// Someone else created it first.
+		strings.Contains(string(data), "resource_already_exists_exception"):
+
+	// The template only allows data streams.
+	case strings.Contains(string(data), "create data stream api"):
+		err = createDataStream(ctx, index)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return makeElasticError(data)
+	}
+
+	err = UpdateKeywordIgnoreAbove(ctx, index)
+	if err != nil {
+		return err
+	}
+
+	// Writes to the new index fail until its primary shards are
+	// allocated.
+	return WaitForIndexReady(ctx, index, IndexStatusYellow)
+}
+
+func createDataStream(ctx context.Context, name string) error {
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IndicesCreateDataStreamRequest{
+		Name: name,
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if !res.IsError() ||
+		strings.Contains(string(data), "resource_already_exists_exception") {
+		return nil
+	}
+
+	return makeElasticError(data)
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchutil"
+	"github.com/stretchr/testify/assert"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+)
+
+func TestBulkIndexerEnsuresIndexOnce(t *testing.T) {
+	var mu sync.Mutex
+	exists_calls := make(map[string]int)
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// Index exists check
+		if r.Method == http.MethodHead {
+			mu.Lock()
+			exists_calls[strings.TrimPrefix(r.URL.Path, "/")]++
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		w.Write([]byte(`{"took": 1, "errors": false, "items": []}`))
+	})
+	defer closer()
+
+	client, err := GetElasticClient()
+	assert.NoError(t, err)
+
+	delegate, err := opensearchutil.NewBulkIndexer(
+		opensearchutil.BulkIndexerConfig{Client: client})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	indexer := &BulkIndexer{
+		BulkIndexer: delegate,
+		ctx:         ctx,
+		config_obj:  &config_proto.Config{},
+		indexes:     make(map[string]bool),
+		ensured:     make(map[string]bool),
+	}
+
+	for i := 0; i < 5; i++ {
+		for _, index := range []string{"org1_persisted", "org2_persisted"} {
+			err := indexer.Add(ctx, opensearchutil.BulkIndexerItem{
+				Index:  index,
+				Action: "index",
+				Body:   strings.NewReader(`{}`),
+			})
+			assert.NoError(t, err)
+		}
+	}
+
+	assert.NoError(t, delegate.Close(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{
+		"org1_persisted": 1,
+		"org2_persisted": 1,
+	}, exists_calls)
+}
+
+func TestBulkIndexerBacksOffFailedEnsure(t *testing.T) {
+	var mu sync.Mutex
+	exists_calls := 0
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		// The cluster can not answer the exists check.
+		if r.Method == http.MethodHead {
+			mu.Lock()
+			exists_calls++
+			mu.Unlock()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte(`{"took": 1, "errors": false, "items": []}`))
+	})
+	defer closer()
+
+	client, err := GetElasticClient()
+	assert.NoError(t, err)
+
+	delegate, err := opensearchutil.NewBulkIndexer(
+		opensearchutil.BulkIndexerConfig{Client: client})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	indexer := &BulkIndexer{
+		BulkIndexer: delegate,
+		ctx:         ctx,
+		config_obj:  &config_proto.Config{},
+		indexes:     make(map[string]bool),
+	}
+
+	for i := 0; i < 5; i++ {
+		err := indexer.Add(ctx, opensearchutil.BulkIndexerItem{
+			Index:  "org1_persisted",
+			Action: "index",
+			Body:   strings.NewReader(`{}`),
+		})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, delegate.Close(ctx))
+
+	// The failure is remembered until the backoff expires.
+	mu.Lock()
+	assert.Equal(t, 1, exists_calls)
+	mu.Unlock()
+
+	indexer.ensure_mu.Lock()
+	assert.False(t, indexer.ensured["org1_persisted"])
+	assert.True(t, indexer.ensure_retry["org1_persisted"].After(time.Now()))
+	indexer.ensure_mu.Unlock()
+}
+
+func TestCheckTemplateMappingDrift(t *testing.T) {
+	template := `{"index_patterns": ["*persisted"], "version": 1,
+  "template": {"mappings": {"properties": {
+  "client_id": {"type": "keyword"},
+  "timestamp": {"type": "long"},
+  "labels": {"type": "keyword"}}}}}`
+
+	live_mapping := `{"org1_persisted": {"mappings": {"properties": {
+  "client_id": {"type": "keyword"},
+  "timestamp": {"type": "text"},
+  "extra": {"type": "keyword"}}}}}`
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/org1_persisted/_mapping", "/*persisted/_mapping":
+			w.Write([]byte(live_mapping))
+
+		default:
+			t.Errorf("Unexpected request %v %v", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer closer()
+
+	ctx := context.Background()
+	mismatches, err := CheckIndexMapping(ctx, "org1_persisted", template)
+	assert.NoError(t, err)
+
+	// Fields only in the index are not drift.
+	assert.Equal(t, []MappingMismatch{
+		{Field: "labels", Expected: "keyword"},
+		{Field: "timestamp", Expected: "long", Actual: "text"},
+	}, mismatches)
+
+	err = CheckTemplateMappingDrift(ctx, template)
+	assert.ErrorIs(t, err, ErrMappingDrift)
+	assert.Contains(t, err.Error(),
+		"*persisted: labels (missing, expected keyword), timestamp (text, expected long)")
+
+	// A matching index is fine.
+	live_mapping = `{"org1_persisted": {"mappings": {"properties": {
+  "client_id": {"type": "keyword"},
+  "timestamp": {"type": "long"},
+  "labels": {"type": "keyword"}}}}}`
+	assert.NoError(t, CheckTemplateMappingDrift(ctx, template))
+}
+
+func TestEnsureIndexWaitsForNewIndex(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path+
+			" "+r.URL.Query().Get("wait_for_status"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+
+		case r.URL.Path == "/_cluster/health/org1_persisted":
+			w.Write([]byte(`{"status": "yellow", "timed_out": false}`))
+
+		default:
+			w.Write([]byte(`{"acknowledged": true}`))
+		}
+	})
+	defer closer()
+
+	err := EnsureIndex(context.Background(), "org1_persisted")
+	assert.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{
+		"HEAD /org1_persisted ",
+		"PUT /org1_persisted ",
+		"GET /_cluster/health/org1_persisted yellow",
+	}, requests)
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type _MgetFound struct {
+	Docs []struct {
+		Id    string `json:"_id"`
+		Found bool   `json:"found"`
+	} `json:"docs"`
+}
+
+// Return the ids which do not exist in the index, in the order they
+// were given. Callers can use this to skip ingesting documents which
+// are already present. Only the document metadata is fetched so this
+// is cheap even for large documents.
+func FilterExistingIds(
+	ctx context.Context, org_id, index string, ids []string) (
+	missing []string, err error) {
+
+	defer Instrument("FilterExistingIds")()
+	defer Debug("FilterExistingIds %v %v", index, len(ids))()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := opensearchapi.MgetRequest{
+		Index:  GetIndex(org_id, index),
+		Body:   strings.NewReader(json.Format(`{"ids": %q}`, ids)),
+		Source: false,
+	}.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.IsError() {
+		// If the index does not exist then none of the ids exist.
+		err = makeReadElasticError(data)
+		if err != nil {
+			return nil, err
+		}
+		return ids, nil
+	}
+
+	response := &_MgetFound{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	for _, doc := range response.Docs {
+		if doc.Found {
+			found[doc.Id] = true
+		}
+	}
+
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
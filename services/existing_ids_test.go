@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterExistingIds(t *testing.T) {
+	var source, body string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		source = r.URL.Query().Get("_source")
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"docs": [
+  {"_index": "test_persisted", "_id": "A", "found": true},
+  {"_index": "test_persisted", "_id": "B", "found": false},
+  {"_index": "test_persisted", "_id": "C", "found": true},
+  {"_index": "test_persisted", "_id": "D", "found": false}
+]}`))
+	})
+	defer closer()
+
+	missing, err := FilterExistingIds(context.Background(),
+		"test", "persisted", []string{"A", "B", "C", "D"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B", "D"}, missing)
+
+	// Only the metadata is fetched.
+	assert.Equal(t, "false", source)
+	assert.JSONEq(t, `{"ids": ["A", "B", "C", "D"]}`, body)
+}
+
+func TestFilterExistingIdsMissingIndex(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"type": "index_not_found_exception", "reason": "no such index"}, "status": 404}`))
+	})
+	defer closer()
+
+	missing, err := FilterExistingIds(context.Background(),
+		"test", "persisted", []string{"A", "B"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A", "B"}, missing)
+}
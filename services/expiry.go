@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	// Documents with this field (in epoch seconds) are removed by the
+	// expiry sweeper some time after it passes. OpenSearch has no
+	// native TTL so this is done with a periodic delete by query.
+	ExpiryField = "expires"
+
+	deleteExpiredQuery = `
+{
+  "query": {
+    "range": {%q: {"lte": %q}}
+  }
+}
+`
+)
+
+// The expiry time for a document which should live for ttl.
+func ExpiryTime(ttl time.Duration) int64 {
+	return utils.GetTime().Now().Add(ttl).Unix()
+}
+
+// Remove all documents in the index whose expiry time has passed.
+func DeleteExpired(ctx context.Context, org_id, index string) error {
+	defer Instrument("DeleteExpired")()
+	defer Debug("DeleteExpired %v", index)()
+
+	return DeleteByQuery(ctx, org_id, index, json.Format(deleteExpiredQuery,
+		ExpiryField, utils.GetTime().Now().Unix()))
+}
+
+// Periodically remove expired documents from the indexes of all
+// orgs. Disabled if period is 0.
+func StartExpirySweeper(ctx context.Context,
+	config_obj *config_proto.Config,
+	period time.Duration, indexes ...string) {
+	if period == 0 {
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(period):
+			}
+
+			for _, index := range indexes {
+				// Match the index in every org.
+				err := DeleteExpired(AllowGlobalWrites(ctx), "", "*"+index)
+				if err != nil {
+					logger := logging.GetLogger(
+						config_obj, &logging.FrontendComponent)
+					logger.Error("ExpirySweeper: %v: %v", index, err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	missingFieldQuery = `
+{
+  "query": {
+    "bool": {
+      "must": [%s]
+    }
+  },
+  "size": 10000
+}
+`
+)
+
+// A filter clause matching documents which have a value for the
+// field. It can be embedded into any bool query.
+func ExistsFilter(field string) string {
+	return json.Format(`{"exists": {"field": %q}}`, field)
+}
+
+// A filter clause matching documents which do not have a value for
+// the field (or where the field is not indexed in the mapping).
+func MissingFilter(field string) string {
+	return fmt.Sprintf(`{"bool": {"must_not": [%s]}}`, ExistsFilter(field))
+}
+
+// Find all the documents in the index that lack the field.
+func QueryElasticMissingField(
+	ctx context.Context,
+	org_id, index, field string) ([]json.RawMessage, int, error) {
+	return QueryElasticRaw(ctx, org_id, index,
+		fmt.Sprintf(missingFieldQuery, MissingFilter(field)))
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	stdjson "encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Project the columns from the source of each hit into a table row,
+// e.g. for exporting to CSV. Columns name nested fields in dotted
+// notation (e.g. "os_info.hostname") and array elements by position
+// (e.g. "labels.0"). Fields which are missing (or hits which can not
+// be parsed) give empty cells. Objects and arrays are written as
+// JSON.
+func FlattenResults(results []Result, columns []string) [][]string {
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		var source interface{}
+		decoder := stdjson.NewDecoder(bytes.NewReader(result.JSON))
+		decoder.UseNumber()
+		err := decoder.Decode(&source)
+		if err != nil {
+			source = nil
+		}
+
+		row := make([]string, 0, len(columns))
+		for _, column := range columns {
+			value, pres := lookupField(source, column)
+			if !pres {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, formatField(value))
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// Write the flattened results as CSV with a header row of the
+// column names.
+func WriteResultsCSV(
+	writer io.Writer, results []Result, columns []string) error {
+	csv_writer := csv.NewWriter(writer)
+
+	err := csv_writer.Write(columns)
+	if err != nil {
+		return err
+	}
+
+	err = csv_writer.WriteAll(FlattenResults(results, columns))
+	if err != nil {
+		return err
+	}
+
+	return csv_writer.Error()
+}
+
+func lookupField(value interface{}, path string) (interface{}, bool) {
+	switch t := value.(type) {
+	case map[string]interface{}:
+		// Field names may contain dots themselves.
+		item, pres := t[path]
+		if pres {
+			return item, true
+		}
+
+		head, tail, ok := strings.Cut(path, ".")
+		if !ok {
+			return nil, false
+		}
+
+		item, pres = t[head]
+		if !pres {
+			return nil, false
+		}
+		return lookupField(item, tail)
+
+	case []interface{}:
+		head, tail, ok := strings.Cut(path, ".")
+		idx, err := strconv.Atoi(head)
+		if err != nil || idx < 0 || idx >= len(t) {
+			return nil, false
+		}
+		if !ok {
+			return t[idx], true
+		}
+		return lookupField(t[idx], tail)
+	}
+
+	return nil, false
+}
+
+func formatField(value interface{}) string {
+	switch t := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case stdjson.Number:
+		return t.String()
+	case bool:
+		return strconv.FormatBool(t)
+	}
+
+	serialized, err := stdjson.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(serialized)
+}
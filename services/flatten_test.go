@@ -0,0 +1,39 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestFlattenResults(t *testing.T) {
+	results := []Result{{
+		Id:   "C.1",
+		JSON: json.RawMessage(`{"client_id": "C.1", "os_info": {"hostname": "host1", "release": "10"}, "labels": ["a", "b"], "ping": 1669000000000000, "first_seen_at.raw": "x"}`),
+	}, {
+		// Missing nested fields.
+		Id:   "C.2",
+		JSON: json.RawMessage(`{"client_id": "C.2", "os_info": {}}`),
+	}, {
+		Id:   "C.3",
+		JSON: json.RawMessage(`not json`),
+	}}
+
+	columns := []string{"client_id", "os_info.hostname", "labels.1",
+		"labels", "ping", "first_seen_at.raw", "os_info"}
+
+	rows := FlattenResults(results, columns)
+	assert.Equal(t, [][]string{
+		{"C.1", "host1", "b", `["a","b"]`, "1669000000000000", "x",
+			`{"hostname":"host1","release":"10"}`},
+		{"C.2", "", "", "", "", "", "{}"},
+		{"", "", "", "", "", "", ""},
+	}, rows)
+
+	buf := &bytes.Buffer{}
+	err := WriteResultsCSV(buf, results[:2], []string{"client_id", "os_info.hostname"})
+	assert.NoError(t, err)
+	assert.Equal(t, "client_id,os_info.hostname\nC.1,host1\nC.2,\n", buf.String())
+}
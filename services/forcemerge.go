@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type ShardStats struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+}
+
+type _ForceMergeResponse struct {
+	Shards ShardStats `json:"_shards"`
+}
+
+// Merge the index segments down to at most maxSegments (or let the
+// cluster decide if maxSegments is 0). This consolidates the many
+// small segments created by a large bulk load so queries are faster.
+//
+// NOTE: Force merging is very expensive - it rewrites the index and
+// blocks until complete. It should only be run after a bulk
+// ingestion window on indexes that are no longer written to.
+func ForceMerge(ctx context.Context, index string, maxSegments int) error {
+	_, err := ForceMergeWithStats(ctx, index, maxSegments)
+	return err
+}
+
+func ForceMergeWithStats(
+	ctx context.Context, index string, maxSegments int) (*ShardStats, error) {
+	defer Instrument("ForceMerge")()
+	defer Debug("ForceMerge %v %v", index, maxSegments)()
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+
+	req := opensearchapi.IndicesForcemergeRequest{
+		Index: []string{index},
+	}
+	if maxSegments > 0 {
+		req.MaxNumSegments = &maxSegments
+	}
+
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.IsError() {
+		return nil, makeElasticError(data)
+	}
+
+	response := &_ForceMergeResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Shards.Failed > 0 {
+		return &response.Shards, fmt.Errorf(
+			"ForceMerge %v: %v of %v shards failed", index,
+			response.Shards.Failed, response.Shards.Total)
+	}
+
+	return &response.Shards, nil
+}
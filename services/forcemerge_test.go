@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForceMerge(t *testing.T) {
+	var path, max_segments string
+	failed := 0
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		max_segments = r.URL.Query().Get("max_num_segments")
+
+		w.Header().Set("Content-Type", "application/json")
+		if failed > 0 {
+			w.Write([]byte(`{"_shards": {"total": 2, "successful": 1, "failed": 1}}`))
+			return
+		}
+		w.Write([]byte(`{"_shards": {"total": 2, "successful": 2, "failed": 0}}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	stats, err := ForceMergeWithStats(ctx, "test_transient", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "/test_transient/_forcemerge", path)
+	assert.Equal(t, "1", max_segments)
+	assert.Equal(t, &ShardStats{Total: 2, Successful: 2}, stats)
+
+	// Let the cluster decide the number of segments.
+	err = ForceMerge(ctx, "test_transient", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "", max_segments)
+
+	// Shard failures are reported.
+	failed = 1
+	err = ForceMerge(ctx, "test_transient", 1)
+	assert.Error(t, err)
+}
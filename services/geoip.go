@@ -0,0 +1,40 @@
+package services
+
+import (
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	geoDistanceQuery = `
+{
+  "query": {
+    "bool": {
+      "filter": [
+        {"geo_distance": {"distance": %q, %q: %q}}
+      ]
+    }
+  },
+  "size": %q
+}
+`
+)
+
+// Stored in a geo_point field.
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// The location of an IP address resolved from the GeoIP database.
+type GeoLocation struct {
+	IP       string   `json:"ip"`
+	Country  string   `json:"country,omitempty"`
+	Location GeoPoint `json:"location"`
+}
+
+// Build a search for documents with a point in the geo_point field
+// (e.g. geo.location) within distance (e.g. "100km") of origin.
+func GeoDistanceQuery(
+	field string, origin GeoPoint, distance string, size int) string {
+	return json.Format(geoDistanceQuery, distance, field, origin, size)
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrGlobalWrite = errors.New(
+		"Refusing to write to the global index without an org id")
+)
+
+type allowGlobalWritesKey int
+
+// Writes with an empty org id go to the bare index names which are
+// shared by all orgs. This is almost always a bug (e.g. an org id
+// which was never set), so such writes are refused unless the
+// context explicitly allows them. Writes for the root org should
+// use the "root" org id.
+func AllowGlobalWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allowGlobalWritesKey(0), true)
+}
+
+func checkOrgWrite(ctx context.Context, org_id string) error {
+	if org_id != "" {
+		return nil
+	}
+
+	allowed, _ := ctx.Value(allowGlobalWritesKey(0)).(bool)
+	if !allowed {
+		return ErrGlobalWrite
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchutil"
+	"github.com/stretchr/testify/assert"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+)
+
+func TestGlobalWritesRefused(t *testing.T) {
+	var paths []string
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "created"}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	record := map[string]string{"doc_type": "test"}
+
+	// A missing org id is refused without a round trip.
+	err := SetElasticIndex(ctx, "", "persisted", "doc", record)
+	assert.True(t, errors.Is(err, ErrGlobalWrite))
+
+	err = DeleteDocument(ctx, "", "persisted", "doc", false)
+	assert.True(t, errors.Is(err, ErrGlobalWrite))
+
+	err = SetElasticIndexAsync(ctx, "", "persisted", "doc", BulkUpdateIndex, record)
+	assert.True(t, errors.Is(err, ErrGlobalWrite))
+	assert.Equal(t, 0, len(paths))
+
+	// The root org and explicitly allowed global writes go ahead.
+	err = SetElasticIndex(ctx, "root", "persisted", "doc", record)
+	assert.NoError(t, err)
+
+	err = SetElasticIndex(AllowGlobalWrites(ctx), "", "persisted", "doc", record)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"/persisted/_doc/doc", "/persisted/_doc/doc"}, paths)
+}
+
+func TestGlobalAsyncWritesAllowed(t *testing.T) {
+	var paths []string
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"errors": false, "items": []}`))
+	})
+	defer closer()
+
+	client, err := GetElasticClient()
+	assert.NoError(t, err)
+
+	indexer := &BulkIndexer{
+		config_obj: &config_proto.Config{},
+		ctx:        context.Background(),
+		indexes:    make(map[string]bool),
+		ensured:    map[string]bool{"persisted": true},
+	}
+	indexer.BulkIndexer, err = opensearchutil.NewBulkIndexer(
+		opensearchutil.BulkIndexerConfig{
+			Client:        client,
+			FlushInterval: time.Hour,
+		})
+	assert.NoError(t, err)
+
+	mu.Lock()
+	old_indexer := bulk_indexer
+	bulk_indexer = indexer
+	mu.Unlock()
+
+	defer func() {
+		mu.Lock()
+		bulk_indexer = old_indexer
+		mu.Unlock()
+	}()
+
+	// The caller's context is used to allow the global write.
+	ctx := AllowGlobalWrites(context.Background())
+	err = SetElasticIndexAsync(ctx, "", "persisted", "doc",
+		BulkUpdateIndex, map[string]string{"doc_type": "test"})
+	assert.NoError(t, err)
+
+	err = indexer.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/_bulk", "/persisted/_refresh"}, paths)
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	cloud_velo_config "www.velocidex.com/golang/cloudvelo/config"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	IndexStatusGreen  = "green"
+	IndexStatusYellow = "yellow"
+	IndexStatusRed    = "red"
+
+	defaultIndexReadyTimeout = 30 * time.Second
+
+	defaultClusterHealthPollPeriod = 10 * time.Second
+)
+
+type _ClusterHealth struct {
+	Status   string `json:"status"`
+	TimedOut bool   `json:"timed_out"`
+}
+
+// Block until the index reaches at least the required status
+// (green or yellow) so it can be queried. Newly created indexes may
+// not have their shards allocated yet.
+func WaitForIndexReady(ctx context.Context, index string, status string) error {
+	defer Instrument("WaitForIndexReady")()
+	defer Debug("WaitForIndexReady %v %v", index, status)()
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	// Wait until the context deadline if there is one.
+	timeout := defaultIndexReadyTimeout
+	deadline, ok := ctx.Deadline()
+	if ok {
+		timeout = time.Until(deadline)
+	}
+
+	res, err := opensearchapi.ClusterHealthRequest{
+		Index:         []string{index},
+		WaitForStatus: status,
+		Timeout:       timeout,
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	health := &_ClusterHealth{}
+	err = json.Unmarshal(data, health)
+	if err != nil {
+		return makeElasticError(data)
+	}
+
+	// The cluster returns a 408 with timed_out set when the status
+	// was not reached in time.
+	if health.TimedOut {
+		return fmt.Errorf("WaitForIndexReady: timed out waiting for %v to be %v (currently %v)",
+			index, status, health.Status)
+	}
+
+	if res.IsError() {
+		return makeElasticError(data)
+	}
+
+	return nil
+}
+
+var (
+	ErrClusterUnavailable = errors.New("Cluster is unavailable (status red)")
+
+	health_mu sync.Mutex
+
+	// The last cluster status seen by the health poller.
+	cluster_status string
+
+	// When set, writes fail fast while the cluster is red.
+	reject_writes_when_red bool
+
+	clusterHealthPollFailures = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opensearch_cluster_health_poll_failures",
+			Help: "Number of cluster health polls which could not reach the cluster.",
+		})
+)
+
+func setClusterStatus(status string) {
+	health_mu.Lock()
+	defer health_mu.Unlock()
+
+	cluster_status = status
+}
+
+// The cached cluster status from the health poller. Empty if the
+// poller is not running.
+func GetClusterStatus() string {
+	health_mu.Lock()
+	defer health_mu.Unlock()
+
+	return cluster_status
+}
+
+func SetRejectWritesWhenRed(enabled bool) {
+	health_mu.Lock()
+	defer health_mu.Unlock()
+
+	reject_writes_when_red = enabled
+}
+
+// Writes are rejected without a round trip when the cluster is known
+// to be red. Reads are still allowed since they may return stale
+// data.
+func checkWritable() error {
+	health_mu.Lock()
+	defer health_mu.Unlock()
+
+	if reject_writes_when_red && cluster_status == IndexStatusRed {
+		return ErrClusterUnavailable
+	}
+	return nil
+}
+
+func getClusterHealth(ctx context.Context) (string, error) {
+	client, err := GetElasticClient()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := opensearchapi.ClusterHealthRequest{}.Do(ctx, client)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.IsError() {
+		return "", makeElasticError(data)
+	}
+
+	health := &_ClusterHealth{}
+	err = json.Unmarshal(data, health)
+	if err != nil {
+		return "", err
+	}
+
+	return health.Status, nil
+}
+
+// How often the health poller should run, or 0 if it is not
+// needed. The poller is needed to reject writes while the cluster is
+// red and to keep the connections alive.
+func getClusterHealthPollPeriod(
+	config_obj *cloud_velo_config.ElasticConfiguration) time.Duration {
+	var period time.Duration
+	if config_obj.RejectWritesWhenRed {
+		period = time.Duration(
+			config_obj.ClusterHealthPollSeconds) * time.Second
+		if period == 0 {
+			period = defaultClusterHealthPollPeriod
+		}
+	}
+
+	keepalive := time.Duration(config_obj.KeepaliveSeconds) * time.Second
+	if keepalive > 0 && (period == 0 || keepalive < period) {
+		period = keepalive
+	}
+	return period
+}
+
+// Periodically poll the cluster health and cache the status. The
+// first poll is made immediately which also warms up a connection to
+// the cluster, and each poll keeps it from going idle.
+func StartClusterHealthPoller(ctx context.Context, period time.Duration) {
+	go func() {
+		for {
+			status, err := getClusterHealth(ctx)
+			switch {
+			case errors.Is(err, ErrClientNotInitialized):
+				// The client is not there (e.g. during shutdown)
+				// which tells us nothing about the cluster.
+
+			case err != nil:
+				// If we can not reach the cluster at all, treat it as
+				// red.
+				Debug("ClusterHealthPoller: %v", err)()
+				clusterHealthPollFailures.Inc()
+				setClusterStatus(IndexStatusRed)
+
+			default:
+				setClusterStatus(status)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(period):
+			}
+		}
+	}()
+}
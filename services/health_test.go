@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	cloud_velo_config "www.velocidex.com/golang/cloudvelo/config"
+)
+
+func TestRejectWritesWhenRed(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`{"_id": "1", "found": true, "_source": {"A": 1}}`))
+		default:
+			w.Write([]byte(`{"result": "created"}`))
+		}
+	})
+	defer closer()
+
+	SetRejectWritesWhenRed(true)
+	defer SetRejectWritesWhenRed(false)
+	defer setClusterStatus("")
+
+	ctx := context.Background()
+	record := map[string]int{"A": 1}
+
+	setClusterStatus(IndexStatusYellow)
+	err := SetElasticIndex(ctx, "test", "persisted", "1", record)
+	assert.NoError(t, err)
+
+	// Writes fail fast while the cluster is red.
+	setClusterStatus(IndexStatusRed)
+	err = SetElasticIndex(ctx, "test", "persisted", "1", record)
+	assert.ErrorIs(t, err, ErrClusterUnavailable)
+
+	err = UpdateIndex(ctx, "test", "persisted", "1", `{"doc": {"A": 2}}`)
+	assert.ErrorIs(t, err, ErrClusterUnavailable)
+
+	err = DeleteDocument(ctx, "test", "persisted", "1", SyncDelete)
+	assert.ErrorIs(t, err, ErrClusterUnavailable)
+
+	err = RestoreSnapshot(ctx, "backups", "snap1", []string{"test_persisted"})
+	assert.ErrorIs(t, err, ErrClusterUnavailable)
+
+	// Reads are still allowed.
+	serialized, err := GetElasticRecord(ctx, "test", "persisted", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"A": 1}`, string(serialized))
+}
+
+func TestClusterHealthKeepalive(t *testing.T) {
+	var pings int64
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_cluster/health" {
+			atomic.AddInt64(&pings, 1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "green"}`))
+	})
+	defer closer()
+	defer setClusterStatus("")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first ping warms up the connection straight away, then
+	// one is sent every period.
+	StartClusterHealthPoller(ctx, 50*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&pings) == 1
+	}, time.Second, time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&pings) >= 3
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, IndexStatusGreen, GetClusterStatus())
+
+	// No more pings once the context is done. A ping which was
+	// already on its way may still arrive.
+	cancel()
+	count := atomic.LoadInt64(&pings)
+	assert.Never(t, func() bool {
+		return atomic.LoadInt64(&pings) > count+1
+	}, 200*time.Millisecond, 10*time.Millisecond)
+}
+
+func TestClusterHealthPollPeriod(t *testing.T) {
+	for _, c := range []struct {
+		config   cloud_velo_config.ElasticConfiguration
+		expected time.Duration
+	}{
+		// Nothing needs the poller.
+		{cloud_velo_config.ElasticConfiguration{}, 0},
+
+		{cloud_velo_config.ElasticConfiguration{
+			RejectWritesWhenRed: true}, 10 * time.Second},
+
+		{cloud_velo_config.ElasticConfiguration{
+			KeepaliveSeconds: 30}, 30 * time.Second},
+
+		// The shorter period wins.
+		{cloud_velo_config.ElasticConfiguration{
+			RejectWritesWhenRed: true, KeepaliveSeconds: 30}, 10 * time.Second},
+		{cloud_velo_config.ElasticConfiguration{
+			RejectWritesWhenRed: true, ClusterHealthPollSeconds: 60,
+			KeepaliveSeconds: 30}, 30 * time.Second},
+	} {
+		assert.Equal(t, c.expected, getClusterHealthPollPeriod(&c.config))
+	}
+}
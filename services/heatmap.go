@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	// The timestamp field is a long so we bucket with a numeric
+	// histogram in the field's own units rather than a
+	// date_histogram (which assumes milliseconds).
+	clientActivityQuery = `
+{
+  "size": 0,
+  "query": {
+    "bool": {
+      "filter": [
+        %s,
+        {"range": {%q: {"gte": %q, "lt": %q}}}
+      ]
+    }
+  },
+  "aggs": {
+    "clients": {
+      "terms": {"field": "client_id", "size": %q},
+      "aggs": {
+        "activity": {
+          "histogram": {"field": %q, "interval": %q}
+        }
+      }
+    }
+  }
+}
+`
+)
+
+type HeatmapOptions struct {
+	// Only count documents matching this query clause (default all).
+	Query string
+
+	// The timestamp field (default "timestamp") and the duration of
+	// one of its units (default time.Second - use time.Nanosecond
+	// for event rows).
+	Field string
+	Unit  time.Duration
+
+	// The width of each time bucket (default 1 hour).
+	Interval time.Duration
+
+	// The time range to cover (default the last 24 hours).
+	Start time.Time
+	End   time.Time
+
+	// The most active clients to include (default 100).
+	MaxClients int
+}
+
+// Counts of documents for each client in each time bucket.
+type ActivityHeatmap struct {
+	// The rows, most active client first.
+	ClientIds []string
+
+	// The start of each column.
+	Buckets []time.Time
+
+	// Counts[i][j] is the count for ClientIds[i] in Buckets[j].
+	Counts [][]int
+}
+
+// Fold the time buckets into the hour of the day (in loc) they
+// start in. Returns a row of 24 counts for each client.
+func (self *ActivityHeatmap) ByHourOfDay(loc *time.Location) [][]int {
+	result := make([][]int, 0, len(self.Counts))
+	for _, row := range self.Counts {
+		hours := make([]int, 24)
+		for j, count := range row {
+			hours[self.Buckets[j].In(loc).Hour()] += count
+		}
+		result = append(result, hours)
+	}
+	return result
+}
+
+// Build a heatmap of the activity of the most active clients in the
+// index over time, e.g. to spot clients active at unusual hours.
+func ClientActivityHeatmap(
+	ctx context.Context, org_id, index string,
+	options HeatmapOptions) (*ActivityHeatmap, error) {
+
+	defer Instrument("ClientActivityHeatmap")()
+
+	if options.Query == "" {
+		options.Query = `{"match_all": {}}`
+	}
+	if options.Field == "" {
+		options.Field = "timestamp"
+	}
+	if options.Unit == 0 {
+		options.Unit = time.Second
+	}
+	if options.Interval == 0 {
+		options.Interval = time.Hour
+	}
+	if options.End.IsZero() {
+		options.End = utils.GetTime().Now()
+	}
+	if options.Start.IsZero() {
+		options.Start = options.End.Add(-24 * time.Hour)
+	}
+	if options.MaxClients == 0 {
+		options.MaxClients = 100
+	}
+
+	interval := int64(options.Interval / options.Unit)
+	if interval <= 0 {
+		return nil, fmt.Errorf(
+			"ClientActivityHeatmap: interval %v is shorter than the unit %v",
+			options.Interval, options.Unit)
+	}
+
+	// Histogram buckets start on multiples of the interval.
+	start := options.Start.UnixNano() / int64(options.Unit)
+	start -= start % interval
+	end := options.End.UnixNano() / int64(options.Unit)
+
+	result := &ActivityHeatmap{}
+	for t := start; t < end; t += interval {
+		result.Buckets = append(result.Buckets,
+			time.Unix(0, t*int64(options.Unit)).UTC())
+	}
+
+	aggs, err := QueryElasticAggregationTree(ctx, org_id, index,
+		json.Format(clientActivityQuery, options.Query,
+			options.Field, start, end, options.MaxClients,
+			options.Field, interval))
+	if err != nil {
+		return nil, err
+	}
+
+	clients, pres := aggs["clients"]
+	if !pres {
+		return result, nil
+	}
+
+	for _, client := range clients.Buckets {
+		row := make([]int, len(result.Buckets))
+
+		activity, pres := client.Aggregations["activity"]
+		if pres {
+			for _, bucket := range activity.Buckets {
+				key, ok := bucket.Key.(float64)
+				if !ok {
+					continue
+				}
+
+				column := int(math.Round((key - float64(start)) /
+					float64(interval)))
+				if column >= 0 && column < len(row) {
+					row[column] += bucket.Count
+				}
+			}
+		}
+
+		result.ClientIds = append(result.ClientIds, fmt.Sprintf("%v", client.Key))
+		result.Counts = append(result.Counts, row)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// Hourly buckets in epoch seconds from 2022-08-25T00:00:00Z.
+const heatmapResponse = `
+{
+  "hits": {"total": {"value": 6}, "hits": []},
+  "aggregations": {
+    "clients": {
+      "buckets": [
+        {
+          "key": "C.1",
+          "doc_count": 4,
+          "activity": {
+            "buckets": [
+              {"key": 1661385600, "doc_count": 3},
+              {"key": 1661389200, "doc_count": 0},
+              {"key": 1661392800, "doc_count": 1}
+            ]
+          }
+        },
+        {
+          "key": "C.2",
+          "doc_count": 2,
+          "activity": {
+            "buckets": [
+              {"key": 1661389200, "doc_count": 2}
+            ]
+          }
+        }
+      ]
+    }
+  }
+}
+`
+
+func TestClientActivityHeatmap(t *testing.T) {
+	var query string
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		query = string(data)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(heatmapResponse))
+	})
+	defer closer()
+
+	start := time.Unix(1661385600, 0).UTC()
+
+	// Start in the middle of the first hour - the buckets are
+	// aligned to the hour.
+	heatmap, err := ClientActivityHeatmap(context.Background(),
+		"test", "transient", HeatmapOptions{
+			Start: start.Add(10 * time.Minute),
+			End:   start.Add(4 * time.Hour),
+		})
+	assert.NoError(t, err)
+
+	parsed := &struct {
+		Aggs struct {
+			Clients struct {
+				Aggs struct {
+					Activity struct {
+						Histogram struct {
+							Interval int64 `json:"interval"`
+						} `json:"histogram"`
+					} `json:"activity"`
+				} `json:"aggs"`
+			} `json:"clients"`
+		} `json:"aggs"`
+	}{}
+	assert.NoError(t, json.Unmarshal([]byte(query), parsed))
+	assert.Equal(t, int64(3600), parsed.Aggs.Clients.Aggs.Activity.Histogram.Interval)
+
+	assert.Equal(t, []string{"C.1", "C.2"}, heatmap.ClientIds)
+	assert.Equal(t, []time.Time{
+		start, start.Add(time.Hour),
+		start.Add(2 * time.Hour), start.Add(3 * time.Hour),
+	}, heatmap.Buckets)
+	assert.Equal(t, [][]int{
+		{3, 0, 1, 0},
+		{0, 2, 0, 0},
+	}, heatmap.Counts)
+
+	by_hour := heatmap.ByHourOfDay(time.UTC)
+	assert.Equal(t, 2, len(by_hour))
+	assert.Equal(t, 24, len(by_hour[0]))
+	assert.Equal(t, 3, by_hour[0][0])
+	assert.Equal(t, 1, by_hour[0][2])
+	assert.Equal(t, 2, by_hour[1][1])
+}
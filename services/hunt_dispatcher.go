@@ -8,15 +8,22 @@ import (
 	"www.velocidex.com/golang/velociraptor/services"
 )
 
-type HuntSearchOptions int
+type HuntSearchFilter int
 
 const (
-	AllHunts HuntSearchOptions = iota
+	AllHunts HuntSearchFilter = iota
 
 	// Only visit non expired hunts
 	OnlyRunningHunts
 )
 
+type HuntSearchOptions struct {
+	Filter HuntSearchFilter
+
+	// Stop after visiting this many hunts. 0 visits all the hunts.
+	MaxResults int
+}
+
 // Add new methods that will be merged in Velociraptor 0.72 sync but
 // for now they are separate.
 type HuntDispatcherV2 interface {
@@ -0,0 +1,125 @@
+package hunt_dispatcher
+
+import (
+	"context"
+	"errors"
+
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/services"
+)
+
+var (
+	ErrAdminRequired = errors.New(
+		"PermissionDenied: Only admins may list hunts across orgs")
+)
+
+type AllOrgsHuntOptions struct {
+	// The caller must explicitly assert that the user is a
+	// deployment admin. Hunts in other orgs are otherwise not
+	// visible.
+	Admin bool
+
+	// The orgs to enumerate, by default all orgs.
+	OrgIds []string
+}
+
+// One hunt from a stream of hunts in a single org. Hunt ids start
+// with their creation time so sorting the stream by hunt id sorts it
+// by creation time.
+type orgHuntStream struct {
+	org_id string
+	out    chan json.RawMessage
+	head   *api_proto.Hunt
+}
+
+// Advance to the next hunt in the stream. Returns false when the
+// stream is exhausted.
+func (self *orgHuntStream) next() bool {
+	for hit := range self.out {
+		entry := &HuntEntry{}
+		err := json.Unmarshal(hit, entry)
+		if err != nil {
+			continue
+		}
+
+		hunt_obj, err := entry.GetHunt()
+		if err != nil {
+			continue
+		}
+
+		self.head = hunt_obj
+		return true
+	}
+
+	self.head = nil
+	return false
+}
+
+// Enumerate the hunts in all orgs, oldest first. The hunts of each
+// org are streamed from their own index and merged by creation time
+// so memory use does not depend on the number of hunts.
+func ApplyFuncOnHuntsInAllOrgs(
+	ctx context.Context,
+	config_obj *config_proto.Config,
+	options AllOrgsHuntOptions,
+	cb func(org_id string, hunt *api_proto.Hunt) error) error {
+
+	if !options.Admin {
+		return ErrAdminRequired
+	}
+
+	org_ids := options.OrgIds
+	if len(org_ids) == 0 {
+		org_manager, err := services.GetOrgManager()
+		if err != nil {
+			return err
+		}
+
+		for _, org_record := range org_manager.ListOrgs() {
+			org_ids = append(org_ids, org_record.Id)
+		}
+	}
+
+	sub_ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	streams := make([]*orgHuntStream, 0, len(org_ids))
+	for _, org_id := range org_ids {
+		out, err := cvelo_services.QueryChan(
+			sub_ctx, config_obj, huntPageSize, org_id,
+			"persisted", getAllHunts, "hunt_id")
+		if err != nil {
+			return err
+		}
+
+		stream := &orgHuntStream{org_id: org_id, out: out}
+		if stream.next() {
+			streams = append(streams, stream)
+		}
+	}
+
+	for len(streams) > 0 {
+		// Few orgs are expected so a linear scan is enough.
+		oldest := 0
+		for i, stream := range streams[1:] {
+			if stream.head.CreateTime < streams[oldest].head.CreateTime {
+				oldest = i + 1
+			}
+		}
+
+		stream := streams[oldest]
+		err := cb(stream.org_id, stream.head)
+		if err != nil {
+			return err
+		}
+
+		if !stream.next() {
+			streams = append(streams[:oldest], streams[oldest+1:]...)
+		}
+	}
+
+	return nil
+}
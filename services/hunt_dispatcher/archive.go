@@ -0,0 +1,235 @@
+package hunt_dispatcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	// Archived hunts are moved here from the persisted index.
+	ArchivedHuntsIndex = "archived_hunts"
+
+	defaultArchivedHuntRetention = 30 * 24 * time.Hour
+
+	// Archived hunts which were archived before the cutoff, or
+	// which do not have an archive time yet.
+	getOldArchivedHunts = `
+{
+    "query": {
+        "bool": {
+            "must": [
+                {
+                    "match": {
+                        "doc_type": "hunts"
+                    }
+                },
+                {
+                    "match": {
+                        "state": "ARCHIVED"
+                    }
+                }
+            ],
+            "should": [
+                {
+                    "range": {
+                        "archived": {"lt": %q}
+                    }
+                },
+                {
+                    "bool": {
+                        "must_not": {
+                            "exists": {"field": "archived"}
+                        }
+                    }
+                }
+            ],
+            "minimum_should_match": 1
+        }
+    }
+}
+`
+
+	setArchivedTime = `{"doc": {"archived": %q}}`
+
+	// Archive the hunt whatever state it is in.
+	archiveHuntPainless = `
+if (ctx._source.state != 'ARCHIVED') {
+  ctx._source.state = 'ARCHIVED';
+  ctx._source.archived = params.now;
+} else {
+  ctx.op = 'none';
+}
+`
+	archiveHuntQuery = `
+{
+  "script" : {
+    "source": %q,
+    "lang": "painless",
+    "params": {
+      "now": %q
+    }
+  }
+}
+`
+)
+
+// Archive the hunt immediately (e.g. when it is deleted), bypassing
+// the hunt state machine. Hunts already compacted into the archived
+// hunts index are left alone.
+func (self HuntDispatcher) ArchiveHunt(
+	ctx context.Context, hunt_id string) error {
+	_, err := cvelo_services.UpdateIndexWithResult(
+		cvelo_services.ImportantWrites(ctx),
+		self.config_obj.OrgId, "persisted", hunt_id,
+		json.Format(archiveHuntQuery, archiveHuntPainless,
+			utils.GetTime().Now().Unix()))
+	if err == nil {
+		return nil
+	}
+
+	_, archived_err := cvelo_services.GetElasticRecord(ctx,
+		self.config_obj.OrgId, ArchivedHuntsIndex, hunt_id)
+	if archived_err == nil {
+		return nil
+	}
+	return err
+}
+
+// Move the hunts archived more than the retention period ago from
+// the persisted index to the archived hunts index, so ListHunts does
+// not have to scan them. GetHunt still finds them. Returns the number
+// of hunts moved.
+//
+// Hunts archived before their archive time was recorded are given
+// the current time, so they are moved once the retention period
+// passed from now.
+func (self HuntDispatcher) CompactArchivedHunts(
+	ctx context.Context, retention time.Duration) (int, error) {
+	if retention <= 0 {
+		retention = defaultArchivedHuntRetention
+	}
+
+	now := utils.GetTime().Now()
+	cutoff := now.Add(-retention).Unix()
+
+	// Collect the ids first - moving the hunts while paging
+	// through them would change the pages.
+	out, err := cvelo_services.QueryChan(
+		ctx, self.config_obj, huntPageSize, self.config_obj.OrgId,
+		"persisted", json.Format(getOldArchivedHunts, cutoff), "hunt_id")
+	if err != nil {
+		return 0, err
+	}
+
+	var ids, unstamped []string
+	for hit := range out {
+		entry := &HuntEntry{}
+		err := json.Unmarshal(hit, entry)
+		if err != nil || entry.HuntId == "" {
+			continue
+		}
+
+		if entry.Archived == 0 {
+			unstamped = append(unstamped, entry.HuntId)
+			continue
+		}
+		ids = append(ids, entry.HuntId)
+	}
+
+	for _, hunt_id := range unstamped {
+		err = cvelo_services.UpdateIndex(
+			cvelo_services.ImportantWrites(ctx), self.config_obj.OrgId,
+			"persisted", hunt_id, json.Format(setArchivedTime, now.Unix()))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Archived hunts are final so nothing else writes them while
+	// they are moved.
+	moved := 0
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > huntPageSize {
+			batch = batch[:huntPageSize]
+		}
+		ids = ids[len(batch):]
+
+		err = cvelo_services.MoveDocuments(ctx, self.config_obj.OrgId,
+			"persisted", ArchivedHuntsIndex, batch)
+		if err != nil {
+			return moved, err
+		}
+		moved += len(batch)
+	}
+
+	return moved, nil
+}
+
+// Compact the archived hunts of every org each period. Disabled if
+// period is 0.
+func StartArchivedHuntCompactor(
+	ctx context.Context, wg *sync.WaitGroup,
+	period, retention time.Duration) {
+	if period == 0 {
+		return
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(period):
+			}
+
+			compactAllOrgs(ctx, retention)
+		}
+	}()
+}
+
+func compactAllOrgs(ctx context.Context, retention time.Duration) {
+	org_manager, err := services.GetOrgManager()
+	if err != nil {
+		return
+	}
+
+	for _, org := range org_manager.ListOrgs() {
+		org_config_obj, err := org_manager.GetOrgConfig(org.OrgId)
+		if err != nil {
+			continue
+		}
+
+		hunt_dispatcher, err := services.GetHuntDispatcher(org_config_obj)
+		if err != nil {
+			continue
+		}
+
+		dispatcher, ok := hunt_dispatcher.(*HuntDispatcher)
+		if !ok {
+			continue
+		}
+
+		logger := logging.GetLogger(org_config_obj, &logging.FrontendComponent)
+		moved, err := dispatcher.CompactArchivedHunts(ctx, retention)
+		if err != nil {
+			logger.Error("ArchivedHuntCompactor: %v", err)
+			continue
+		}
+
+		if moved > 0 {
+			logger.Info("ArchivedHuntCompactor: Moved %v archived hunts to %v",
+				moved, ArchivedHuntsIndex)
+		}
+	}
+}
@@ -118,7 +118,8 @@ func (self HuntDispatcher) CreateHunt(
 		return "", err
 	}
 
-	err = cvelo_services.SetElasticIndex(ctx,
+	err = cvelo_services.SetElasticIndex(
+		cvelo_services.ImportantWrites(ctx),
 		self.config_obj.OrgId,
 		"persisted", hunt_id,
 		&HuntEntry{
@@ -127,6 +128,7 @@ func (self HuntDispatcher) CreateHunt(
 			Hunt:      string(serialized),
 			State:     hunt.State.String(),
 			DocType:   "hunts",
+			Creator:   hunt.Creator,
 		})
 
 	// The actual hunt scheduling is done by the foreman.
@@ -24,6 +24,14 @@ const (
 `
 )
 
+// The Status of a HuntFlowEntry. An entry is written when the flow
+// starts and another when it completes.
+const (
+	HuntFlowStarted   = "started"
+	HuntFlowCompleted = "completed"
+	HuntFlowError     = "error"
+)
+
 type HuntFlowEntry struct {
 	HuntId    string `json:"hunt_id"`
 	Timestamp int64  `json:"timestamp"`
@@ -3,6 +3,8 @@ package hunt_dispatcher
 import (
 	"context"
 	"errors"
+	"fmt"
+	"os"
 	"sync"
 
 	"google.golang.org/protobuf/encoding/protojson"
@@ -10,7 +12,9 @@ import (
 	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
 	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/logging"
 	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
 )
 
 type HuntEntry struct {
@@ -22,6 +26,10 @@ type HuntEntry struct {
 	Hunt      string `json:"hunt"`
 	State     string `json:"state"`
 	DocType   string `json:"doc_type"`
+	Creator   string `json:"creator,omitempty"`
+
+	// When the hunt was archived (in seconds).
+	Archived int64 `json:"archived,omitempty"`
 }
 
 func (self *HuntEntry) GetHunt() (*api_proto.Hunt, error) {
@@ -75,7 +83,7 @@ func (self HuntDispatcher) ApplyFuncOnHuntsWithOptions(
 	defer cancel()
 
 	var query string
-	switch options {
+	switch options.Filter {
 	case cvelo_services.AllHunts:
 		query = getAllHunts
 	case cvelo_services.OnlyRunningHunts:
@@ -84,13 +92,22 @@ func (self HuntDispatcher) ApplyFuncOnHuntsWithOptions(
 		return errors.New("HuntSearchOptions not supported")
 	}
 
+	// Do not fetch more than we need.
+	page_size := huntPageSize
+	if options.MaxResults > 0 && options.MaxResults < page_size {
+		page_size = options.MaxResults
+	}
+
+	// Always page through the results - the queries have no size
+	// clause so a plain search would only return the first 10 hunts.
 	out, err := cvelo_services.QueryChan(
-		sub_ctx, self.config_obj, 1000, self.config_obj.OrgId,
+		sub_ctx, self.config_obj, page_size, self.config_obj.OrgId,
 		"persisted", query, "hunt_id")
 	if err != nil {
 		return err
 	}
 
+	count := 0
 	for hit := range out {
 		entry := &HuntEntry{}
 		err := json.Unmarshal(hit, entry)
@@ -108,6 +125,11 @@ func (self HuntDispatcher) ApplyFuncOnHuntsWithOptions(
 		if err != nil {
 			return err
 		}
+
+		count++
+		if options.MaxResults > 0 && count >= options.MaxResults {
+			break
+		}
 	}
 
 	return nil
@@ -128,11 +150,20 @@ func (self HuntDispatcher) SetHunt(hunt *api_proto.Hunt) error {
 		return err
 	}
 
+	// The timestamp is the creation time in seconds.
 	record := &HuntEntry{
-		HuntId:  hunt_id,
-		Hunt:    string(serialized),
-		State:   hunt.State.String(),
-		DocType: "hunts",
+		HuntId:    hunt_id,
+		Timestamp: int64(hunt.CreateTime / 1000000),
+		Hunt:      string(serialized),
+		State:     hunt.State.String(),
+		DocType:   "hunts",
+		Creator:   hunt.Creator,
+	}
+
+	// Archived hunts are moved out of the persisted index some time
+	// after they were archived.
+	if hunt.State == api_proto.Hunt_ARCHIVED {
+		record.Archived = utils.GetTime().Now().Unix()
 	}
 
 	if hunt.Stats != nil {
@@ -141,21 +172,37 @@ func (self HuntDispatcher) SetHunt(hunt *api_proto.Hunt) error {
 		record.Errors = hunt.Stats.TotalClientsWithErrors
 	}
 
-	return cvelo_services.SetElasticIndex(self.ctx,
+	return cvelo_services.SetElasticIndex(
+		cvelo_services.ImportantWrites(self.ctx),
 		self.config_obj.OrgId,
 		"persisted", hunt.HuntId,
 		record)
 }
 
-func (self HuntDispatcher) GetHunt(hunt_id string) (*api_proto.Hunt, bool) {
-	serialized, err := cvelo_services.GetElasticRecord(context.Background(),
+// Read the hunt's entry from the persisted index, or from the
+// archived hunts index if it was compacted.
+func (self HuntDispatcher) getHuntEntry(
+	ctx context.Context, hunt_id string) (*HuntEntry, error) {
+	serialized, err := cvelo_services.GetElasticRecord(ctx,
 		self.config_obj.OrgId, "persisted", hunt_id)
+	if errors.Is(err, os.ErrNotExist) {
+		serialized, err = cvelo_services.GetElasticRecord(ctx,
+			self.config_obj.OrgId, ArchivedHuntsIndex, hunt_id)
+	}
 	if err != nil {
-		return nil, false
+		return nil, err
 	}
 
 	hunt_entry := &HuntEntry{}
 	err = json.Unmarshal(serialized, hunt_entry)
+	if err != nil {
+		return nil, err
+	}
+	return hunt_entry, nil
+}
+
+func (self HuntDispatcher) GetHunt(hunt_id string) (*api_proto.Hunt, bool) {
+	hunt_entry, err := self.getHuntEntry(context.Background(), hunt_id)
 	if err != nil {
 		return nil, false
 	}
@@ -165,8 +212,17 @@ func (self HuntDispatcher) GetHunt(hunt_id string) (*api_proto.Hunt, bool) {
 		return nil, false
 	}
 
-	hunt_info.Stats.AvailableDownloads, _ = availableHuntDownloadFiles(
+	// Leave AvailableDownloads unset if the downloads can not be
+	// listed, so the GUI can tell this apart from a hunt without
+	// downloads (which has an empty list).
+	available_downloads, err := availableHuntDownloadFiles(
 		self.config_obj, hunt_id)
+	if err != nil {
+		logger := logging.GetLogger(self.config_obj, &logging.FrontendComponent)
+		logger.Error("HuntDispatcher.GetHunt: %v", err)
+	} else {
+		hunt_info.Stats.AvailableDownloads = available_downloads
+	}
 
 	return hunt_info, true
 }
@@ -186,22 +242,15 @@ func (self HuntDispatcher) Refresh(
 
 func (self HuntDispatcher) Close(config_obj *config_proto.Config) {}
 
-// TODO add sort and from/size clause
 const (
-	getAllHuntsQuery = `
-{
-    "query": {
-      "bool": {
-        "must": [{"match": {
-                    "doc_type": "hunts"
-                 }}]
-      }
-    },"sort": [{
-    "hunt_id": {"order": "desc", "unmapped_type": "keyword"}
-}],
- "from": %q, "size": %q
-}
-`
+	huntPageSize = 1000
+
+	// ListHunts shows the newest hunts first.
+	listHuntsSort = `[{"hunt_id": {"order": "desc", "unmapped_type": "keyword"}}]`
+
+	// The following queries are used with QueryChan and
+	// QueryElasticPage which add the sort and size clauses, so they
+	// must not specify their own.
 	getAllActiveHunts = `
 {
     "query": {
@@ -236,30 +285,68 @@ const (
         }
     }
 }
+`
+	getAllHuntsForCreator = `
+{
+    "query": {
+        "bool": {
+            "must": [
+                {
+                    "match": {
+                        "doc_type": "hunts"
+                    }
+                },
+                {
+                    "bool": {
+                        "should": [%s, %s]
+                    }
+                }
+            ]
+        }
+    }
+}
 `
 )
 
+// Build the query for ListHunts. User supplied values must only be
+// inserted with json.Format which encodes them as JSON strings, so
+// they can not change the structure of the query.
+func listHuntsQuery(in *api_proto.ListHuntsRequest) string {
+	if in.UserFilter == "" {
+		return getAllHunts
+	}
+
+	// Hunts written before we stored the creator are matched too
+	// and filtered by ListHunts.
+	return fmt.Sprintf(getAllHuntsForCreator,
+		json.Format(`{"term": {"creator": %q}}`, in.UserFilter),
+		cvelo_services.MissingFilter("creator"))
+}
+
 // TODO: Deprecated...
 func (self HuntDispatcher) ListHunts(
 	ctx context.Context, config_obj *config_proto.Config,
 	in *api_proto.ListHuntsRequest) (
 	*api_proto.ListHuntsResponse, error) {
 
-	hits, _, err := cvelo_services.QueryElasticRaw(
-		ctx, self.config_obj.OrgId,
-		"persisted", json.Format(getAllHuntsQuery, in.Offset, in.Count))
+	// Offsets beyond the max result window are paged with
+	// search_after.
+	hits, _, err := cvelo_services.QueryElasticPage(
+		ctx, self.config_obj.OrgId, "persisted", listHuntsQuery(in),
+		listHuntsSort, int(in.Offset), int(in.Count))
 	if err != nil {
 		return nil, err
 	}
 
-	result := &api_proto.ListHuntsResponse{}
-	for _, hit := range hits {
-		entry := &HuntEntry{}
-		err = json.Unmarshal(hit, entry)
-		if err != nil {
-			continue
-		}
+	// Skip entries which can not be parsed.
+	entries, err := cvelo_services.UnmarshalHits[HuntEntry](hits)
+	unmarshal_errors := &cvelo_services.UnmarshalErrors{}
+	if err != nil && !errors.As(err, &unmarshal_errors) {
+		return nil, err
+	}
 
+	result := &api_proto.ListHuntsResponse{}
+	for _, entry := range entries {
 		hunt_info, err := entry.GetHunt()
 		if err != nil {
 			continue
@@ -0,0 +1,466 @@
+package hunt_dispatcher_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/protobuf/encoding/protojson"
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	"www.velocidex.com/golang/cloudvelo/services/hunt_dispatcher"
+	"www.velocidex.com/golang/cloudvelo/testsuite"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	"www.velocidex.com/golang/velociraptor/services"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+type HuntDispatcherTestSuite struct {
+	*testsuite.CloudTestSuite
+}
+
+func (self *HuntDispatcherTestSuite) getDispatcher() *hunt_dispatcher.HuntDispatcher {
+	hunt_service, err := services.GetHuntDispatcher(self.ConfigObj.VeloConf())
+	assert.NoError(self.T(), err)
+
+	return hunt_service.(*hunt_dispatcher.HuntDispatcher)
+}
+
+func (self *HuntDispatcherTestSuite) TestGetHuntStatsTotals() {
+	dispatcher := self.getDispatcher()
+
+	for i := uint64(1); i <= 3; i++ {
+		err := dispatcher.SetHunt(&api_proto.Hunt{
+			HuntId: fmt.Sprintf("H.%d", i),
+			State:  api_proto.Hunt_RUNNING,
+			Stats: &api_proto.HuntStats{
+				TotalClientsScheduled:   10 * i,
+				TotalClientsWithResults: 5 * i,
+				TotalClientsWithErrors:  i,
+			},
+		})
+		assert.NoError(self.T(), err)
+	}
+
+	totals, err := dispatcher.GetHuntStatsTotals(self.Ctx)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), uint64(60), totals.TotalClientsScheduled)
+	assert.Equal(self.T(), uint64(30), totals.TotalClientsWithResults)
+	assert.Equal(self.T(), uint64(6), totals.TotalClientsWithErrors)
+}
+
+func (self *HuntDispatcherTestSuite) TestApplyFuncOnRunningHunts() {
+	dispatcher := self.getDispatcher()
+
+	// More than the default search size of 10.
+	for i := 0; i < 25; i++ {
+		err := dispatcher.SetHunt(&api_proto.Hunt{
+			HuntId: fmt.Sprintf("H.%02d", i),
+			State:  api_proto.Hunt_RUNNING,
+		})
+		assert.NoError(self.T(), err)
+	}
+
+	// Stopped hunts should not be enumerated.
+	err := dispatcher.SetHunt(&api_proto.Hunt{
+		HuntId: "H.Stopped",
+		State:  api_proto.Hunt_STOPPED,
+	})
+	assert.NoError(self.T(), err)
+
+	seen := make(map[string]bool)
+	err = dispatcher.ApplyFuncOnHuntsWithOptions(self.Ctx,
+		cvelo_services.HuntSearchOptions{
+			Filter: cvelo_services.OnlyRunningHunts,
+		},
+		func(hunt *api_proto.Hunt) error {
+			assert.Equal(self.T(), api_proto.Hunt_RUNNING, hunt.State)
+			seen[hunt.HuntId] = true
+			return nil
+		})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 25, len(seen))
+}
+
+func (self *HuntDispatcherTestSuite) TestApplyFuncOnHuntsMaxResults() {
+	dispatcher := self.getDispatcher()
+
+	for i := 0; i < 25; i++ {
+		err := dispatcher.SetHunt(&api_proto.Hunt{
+			HuntId: fmt.Sprintf("H.%02d", i),
+			State:  api_proto.Hunt_RUNNING,
+		})
+		assert.NoError(self.T(), err)
+	}
+
+	for _, max_results := range []int{1, 10, 20} {
+		count := 0
+		err := dispatcher.ApplyFuncOnHuntsWithOptions(self.Ctx,
+			cvelo_services.HuntSearchOptions{
+				Filter:     cvelo_services.AllHunts,
+				MaxResults: max_results,
+			},
+			func(hunt *api_proto.Hunt) error {
+				count++
+				return nil
+			})
+		assert.NoError(self.T(), err)
+		assert.Equal(self.T(), max_results, count)
+	}
+
+	// A limit above the number of hunts visits them all.
+	count := 0
+	err := dispatcher.ApplyFuncOnHuntsWithOptions(self.Ctx,
+		cvelo_services.HuntSearchOptions{MaxResults: 100},
+		func(hunt *api_proto.Hunt) error {
+			count++
+			return nil
+		})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 25, count)
+}
+
+func (self *HuntDispatcherTestSuite) TestListHuntsUserFilter() {
+	dispatcher := self.getDispatcher()
+
+	// A creator name crafted to break out of the query string.
+	evil := `mike"}}, {"match_all": {}}, {"term": {"creator": "\x`
+
+	for hunt_id, creator := range map[string]string{
+		"H.1": "mike",
+		"H.2": evil,
+		"H.3": "fred",
+	} {
+		err := dispatcher.SetHunt(&api_proto.Hunt{
+			HuntId:  hunt_id,
+			Creator: creator,
+			State:   api_proto.Hunt_RUNNING,
+		})
+		assert.NoError(self.T(), err)
+	}
+
+	for _, creator := range []string{"mike", evil} {
+		result, err := dispatcher.ListHunts(self.Ctx,
+			self.ConfigObj.VeloConf(), &api_proto.ListHuntsRequest{
+				Count:      100,
+				UserFilter: creator,
+			})
+		assert.NoError(self.T(), err)
+		assert.Equal(self.T(), 1, len(result.Items))
+		assert.Equal(self.T(), creator, result.Items[0].Creator)
+	}
+}
+
+func (self *HuntDispatcherTestSuite) TestTransitionHunt() {
+	dispatcher := self.getDispatcher()
+
+	err := dispatcher.SetHunt(&api_proto.Hunt{
+		HuntId: "H.1",
+		State:  api_proto.Hunt_PAUSED,
+	})
+	assert.NoError(self.T(), err)
+
+	for _, state := range []api_proto.Hunt_State{
+		api_proto.Hunt_RUNNING,
+		api_proto.Hunt_PAUSED,
+		api_proto.Hunt_RUNNING,
+		api_proto.Hunt_STOPPED,
+		api_proto.Hunt_ARCHIVED,
+	} {
+		err := dispatcher.TransitionHunt(self.Ctx, "H.1", state)
+		assert.NoError(self.T(), err)
+
+		hunt, pres := dispatcher.GetHunt("H.1")
+		assert.True(self.T(), pres)
+		assert.Equal(self.T(), state, hunt.State)
+	}
+
+	// Archived hunts can not be restarted.
+	err = dispatcher.TransitionHunt(self.Ctx, "H.1", api_proto.Hunt_RUNNING)
+	assert.True(self.T(), errors.Is(err, hunt_dispatcher.ErrInvalidHuntTransition))
+
+	hunt, pres := dispatcher.GetHunt("H.1")
+	assert.True(self.T(), pres)
+	assert.Equal(self.T(), api_proto.Hunt_ARCHIVED, hunt.State)
+}
+
+func TestValidHuntTransition(t *testing.T) {
+	for _, c := range []struct {
+		from, to api_proto.Hunt_State
+		valid    bool
+	}{
+		{api_proto.Hunt_RUNNING, api_proto.Hunt_PAUSED, true},
+		{api_proto.Hunt_PAUSED, api_proto.Hunt_RUNNING, true},
+		{api_proto.Hunt_RUNNING, api_proto.Hunt_STOPPED, true},
+		{api_proto.Hunt_PAUSED, api_proto.Hunt_STOPPED, true},
+		{api_proto.Hunt_STOPPED, api_proto.Hunt_ARCHIVED, true},
+		{api_proto.Hunt_ARCHIVED, api_proto.Hunt_RUNNING, false},
+		{api_proto.Hunt_ARCHIVED, api_proto.Hunt_STOPPED, false},
+		{api_proto.Hunt_STOPPED, api_proto.Hunt_RUNNING, false},
+		{api_proto.Hunt_RUNNING, api_proto.Hunt_ARCHIVED, false},
+	} {
+		assert.Equal(t, c.valid,
+			hunt_dispatcher.ValidHuntTransition(c.from, c.to),
+			"%v -> %v", c.from, c.to)
+	}
+}
+
+func (self *HuntDispatcherTestSuite) TestApplyFuncOnHuntsInAllOrgs() {
+	// Hunts in two orgs created at interleaved times. Like real hunt
+	// ids, the ids sort by creation time.
+	for i, org_id := range []string{"test", "test2", "test", "test2"} {
+		hunt := &api_proto.Hunt{
+			HuntId:     fmt.Sprintf("H.%d", i),
+			CreateTime: uint64(1000 + 100*i),
+			State:      api_proto.Hunt_RUNNING,
+		}
+		serialized, err := protojson.Marshal(hunt)
+		assert.NoError(self.T(), err)
+
+		err = cvelo_services.SetElasticIndex(self.Ctx,
+			org_id, "persisted", hunt.HuntId, &hunt_dispatcher.HuntEntry{
+				HuntId:  hunt.HuntId,
+				Hunt:    string(serialized),
+				State:   hunt.State.String(),
+				DocType: "hunts",
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	options := hunt_dispatcher.AllOrgsHuntOptions{
+		OrgIds: []string{"test", "test2"},
+	}
+	cb := func(org_id string, hunt *api_proto.Hunt) error {
+		return nil
+	}
+
+	// Only admins may see all orgs.
+	err := hunt_dispatcher.ApplyFuncOnHuntsInAllOrgs(self.Ctx,
+		self.ConfigObj.VeloConf(), options, cb)
+	assert.ErrorIs(self.T(), err, hunt_dispatcher.ErrAdminRequired)
+
+	seen := []string{}
+	options.Admin = true
+	err = hunt_dispatcher.ApplyFuncOnHuntsInAllOrgs(self.Ctx,
+		self.ConfigObj.VeloConf(), options,
+		func(org_id string, hunt *api_proto.Hunt) error {
+			seen = append(seen, org_id+"/"+hunt.HuntId)
+			return nil
+		})
+	assert.NoError(self.T(), err)
+
+	// Oldest first.
+	assert.Equal(self.T(), []string{
+		"test/H.0", "test2/H.1", "test/H.2", "test2/H.3"}, seen)
+}
+
+func (self *HuntDispatcherTestSuite) addHuntFlow(
+	hunt_id, flow_id, status string) {
+	err := cvelo_services.SetElasticIndex(self.Ctx,
+		self.ConfigObj.OrgId, "transient", cvelo_services.DocIdRandom,
+		&hunt_dispatcher.HuntFlowEntry{
+			HuntId:   hunt_id,
+			ClientId: "C.1",
+			FlowId:   flow_id,
+			Status:   status,
+			DocType:  "hunt_flow",
+		})
+	assert.NoError(self.T(), err)
+}
+
+func (self *HuntDispatcherTestSuite) TestReconcileHuntStats() {
+	dispatcher := self.getDispatcher()
+
+	// The stats have drifted from the flows. The scheduled count
+	// includes flows without a hunt_flow entry.
+	err := dispatcher.SetHunt(&api_proto.Hunt{
+		HuntId: "H.1",
+		State:  api_proto.Hunt_RUNNING,
+		Stats: &api_proto.HuntStats{
+			TotalClientsScheduled: 10,
+		},
+	})
+	assert.NoError(self.T(), err)
+
+	for _, entry := range []struct {
+		flow_id, status string
+	}{
+		{"F.1.H", hunt_dispatcher.HuntFlowStarted},
+		{"F.2.H", hunt_dispatcher.HuntFlowStarted},
+		{"F.3.H", hunt_dispatcher.HuntFlowStarted},
+
+		// A retransmitted start is only counted once.
+		{"F.3.H", hunt_dispatcher.HuntFlowStarted},
+		{"F.1.H", hunt_dispatcher.HuntFlowCompleted},
+		{"F.2.H", hunt_dispatcher.HuntFlowError},
+	} {
+		self.addHuntFlow("H.1", entry.flow_id, entry.status)
+	}
+
+	corrected, err := dispatcher.ReconcileHuntStats(self.Ctx, 10)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, corrected)
+
+	// Counts are raised but never lowered.
+	hunt, pres := dispatcher.GetHunt("H.1")
+	assert.True(self.T(), pres)
+	assert.Equal(self.T(), uint64(10), hunt.Stats.TotalClientsScheduled)
+	assert.Equal(self.T(), uint64(1), hunt.Stats.TotalClientsWithResults)
+	assert.Equal(self.T(), uint64(1), hunt.Stats.TotalClientsWithErrors)
+
+	// Nothing to correct the second time.
+	corrected, err = dispatcher.ReconcileHuntStats(self.Ctx, 10)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 0, corrected)
+}
+
+func (self *HuntDispatcherTestSuite) TestReconcileHuntStatsPages() {
+	dispatcher := self.getDispatcher()
+
+	// Each hunt lost the increment of its only flow.
+	for _, hunt_id := range []string{"H.A", "H.B", "H.C"} {
+		err := dispatcher.SetHunt(&api_proto.Hunt{
+			HuntId: hunt_id,
+			State:  api_proto.Hunt_RUNNING,
+		})
+		assert.NoError(self.T(), err)
+
+		self.addHuntFlow(hunt_id, "F.1."+hunt_id, hunt_dispatcher.HuntFlowStarted)
+	}
+
+	// Each run continues where the last one stopped.
+	for _, expected := range []int{2, 1, 0} {
+		corrected, err := dispatcher.ReconcileHuntStats(self.Ctx, 2)
+		assert.NoError(self.T(), err)
+		assert.Equal(self.T(), expected, corrected)
+	}
+
+	for _, hunt_id := range []string{"H.A", "H.B", "H.C"} {
+		hunt, pres := dispatcher.GetHunt(hunt_id)
+		assert.True(self.T(), pres)
+		assert.Equal(self.T(), uint64(1), hunt.Stats.TotalClientsScheduled)
+	}
+}
+
+func (self *HuntDispatcherTestSuite) TestCompactArchivedHunts() {
+	dispatcher := self.getDispatcher()
+
+	clock := &utils.MockClock{MockNow: time.Unix(1661391000, 0)}
+	defer utils.MockTime(clock)()
+
+	for _, hunt := range []*api_proto.Hunt{
+		{HuntId: "H.Archived", State: api_proto.Hunt_ARCHIVED},
+		{HuntId: "H.Stopped", State: api_proto.Hunt_STOPPED},
+	} {
+		err := dispatcher.SetHunt(hunt)
+		assert.NoError(self.T(), err)
+	}
+
+	// A hunt archived before its archive time was recorded, without
+	// a creation time.
+	err := cvelo_services.SetElasticIndex(self.Ctx,
+		self.ConfigObj.OrgId, "persisted", "H.Legacy",
+		&hunt_dispatcher.HuntEntry{
+			HuntId:  "H.Legacy",
+			Hunt:    `{"huntId": "H.Legacy"}`,
+			State:   "ARCHIVED",
+			DocType: "hunts",
+		})
+	assert.NoError(self.T(), err)
+
+	// Nothing was archived long enough ago. The legacy hunt is
+	// given the current time.
+	moved, err := dispatcher.CompactArchivedHunts(self.Ctx, 24*time.Hour)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 0, moved)
+
+	clock.MockNow = clock.MockNow.Add(12 * time.Hour)
+	err = dispatcher.TransitionHunt(self.Ctx, "H.Stopped", api_proto.Hunt_ARCHIVED)
+	assert.NoError(self.T(), err)
+
+	// The retention is counted from when each hunt was archived.
+	clock.MockNow = clock.MockNow.Add(13 * time.Hour)
+	moved, err = dispatcher.CompactArchivedHunts(self.Ctx, 24*time.Hour)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 2, moved)
+
+	// The old archived hunts are no longer in the hot index.
+	_, err = cvelo_services.GetElasticRecord(self.Ctx,
+		self.ConfigObj.OrgId, "persisted", "H.Archived")
+	assert.True(self.T(), errors.Is(err, os.ErrNotExist))
+
+	seen := []string{}
+	err = dispatcher.ApplyFuncOnHuntsWithOptions(self.Ctx,
+		cvelo_services.HuntSearchOptions{
+			Filter: cvelo_services.AllHunts,
+		},
+		func(hunt *api_proto.Hunt) error {
+			seen = append(seen, hunt.HuntId)
+			return nil
+		})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string{"H.Stopped"}, seen)
+
+	// But they can still be looked up explicitly and deleted.
+	for _, hunt_id := range []string{"H.Archived", "H.Legacy"} {
+		hunt, pres := dispatcher.GetHunt(hunt_id)
+		assert.True(self.T(), pres)
+		assert.Equal(self.T(), api_proto.Hunt_ARCHIVED, hunt.State)
+
+		err = dispatcher.ArchiveHunt(self.Ctx, hunt_id)
+		assert.NoError(self.T(), err)
+
+		err = dispatcher.TransitionHunt(self.Ctx, hunt_id, api_proto.Hunt_ARCHIVED)
+		assert.NoError(self.T(), err)
+
+		err = dispatcher.TransitionHunt(self.Ctx, hunt_id, api_proto.Hunt_RUNNING)
+		assert.True(self.T(), errors.Is(err, hunt_dispatcher.ErrInvalidHuntTransition))
+	}
+
+	clock.MockNow = clock.MockNow.Add(12 * time.Hour)
+	moved, err = dispatcher.CompactArchivedHunts(self.Ctx, 24*time.Hour)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, moved)
+}
+
+func (self *HuntDispatcherTestSuite) TestArchiveHunt() {
+	dispatcher := self.getDispatcher()
+
+	clock := &utils.MockClock{MockNow: time.Unix(1661391000, 0)}
+	defer utils.MockTime(clock)()
+
+	err := dispatcher.SetHunt(&api_proto.Hunt{
+		HuntId: "H.Running",
+		State:  api_proto.Hunt_RUNNING,
+	})
+	assert.NoError(self.T(), err)
+
+	// Deleting a hunt archives it whatever state it is in.
+	err = dispatcher.ArchiveHunt(self.Ctx, "H.Running")
+	assert.NoError(self.T(), err)
+
+	hunt, pres := dispatcher.GetHunt("H.Running")
+	assert.True(self.T(), pres)
+	assert.Equal(self.T(), api_proto.Hunt_ARCHIVED, hunt.State)
+
+	// It is compacted once the retention passed.
+	clock.MockNow = clock.MockNow.Add(25 * time.Hour)
+	moved, err := dispatcher.CompactArchivedHunts(self.Ctx, 24*time.Hour)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, moved)
+
+	// Unknown hunts can not be archived.
+	err = dispatcher.ArchiveHunt(self.Ctx, "H.Missing")
+	assert.Error(self.T(), err)
+}
+
+func TestHuntDispatcher(t *testing.T) {
+	suite.Run(t, &HuntDispatcherTestSuite{
+		CloudTestSuite: &testsuite.CloudTestSuite{
+			Indexes: []string{"persisted", "transient"},
+		},
+	})
+}
@@ -1,14 +1,38 @@
 package hunt_dispatcher
 
 import (
+	"fmt"
+
 	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
 	"www.velocidex.com/golang/velociraptor/paths"
 	"www.velocidex.com/golang/velociraptor/reporting"
 )
 
+var (
+	// Replaced in tests to simulate storage errors.
+	getAvailableDownloadFiles = reporting.GetAvailableDownloadFiles
+)
+
+// The downloads of a hunt could not be listed (e.g. the storage is
+// unavailable). This is different from a hunt without downloads.
+type HuntDownloadsError struct {
+	HuntId string
+	Err    error
+}
+
+func (self *HuntDownloadsError) Error() string {
+	return fmt.Sprintf("Unable to list downloads for hunt %v: %v",
+		self.HuntId, self.Err)
+}
+
+func (self *HuntDownloadsError) Unwrap() error {
+	return self.Err
+}
+
 // availableHuntDownloadFiles returns the prepared zip downloads available to
-// be fetched by the user at this moment.
+// be fetched by the user at this moment. Failures are returned as a
+// *HuntDownloadsError.
 func availableHuntDownloadFiles(config_obj *config_proto.Config,
 	hunt_id string) (*api_proto.AvailableDownloads, error) {
 
@@ -16,5 +40,14 @@ func availableHuntDownloadFiles(config_obj *config_proto.Config,
 	download_file := hunt_path_manager.GetHuntDownloadsFile(false, "", false)
 	download_path := download_file.Dir()
 
-	return reporting.GetAvailableDownloadFiles(config_obj, download_path)
+	result, err := getAvailableDownloadFiles(config_obj, download_path)
+	if err != nil {
+		return nil, &HuntDownloadsError{HuntId: hunt_id, Err: err}
+	}
+
+	if result == nil {
+		result = &api_proto.AvailableDownloads{}
+	}
+
+	return result, nil
 }
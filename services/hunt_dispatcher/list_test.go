@@ -0,0 +1,42 @@
+package hunt_dispatcher
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/file_store/api"
+)
+
+func TestAvailableHuntDownloadFilesError(t *testing.T) {
+	storage_err := errors.New("S3 unavailable")
+	old := getAvailableDownloadFiles
+	defer func() { getAvailableDownloadFiles = old }()
+
+	getAvailableDownloadFiles = func(config_obj *config_proto.Config,
+		download_path api.FSPathSpec) (*api_proto.AvailableDownloads, error) {
+		return nil, storage_err
+	}
+
+	config_obj := &config_proto.Config{}
+	downloads, err := availableHuntDownloadFiles(config_obj, "H.1234")
+	assert.Nil(t, downloads)
+
+	downloads_err := &HuntDownloadsError{}
+	assert.True(t, errors.As(err, &downloads_err))
+	assert.Equal(t, "H.1234", downloads_err.HuntId)
+	assert.True(t, errors.Is(err, storage_err))
+
+	// A hunt without downloads has an empty list.
+	getAvailableDownloadFiles = func(config_obj *config_proto.Config,
+		download_path api.FSPathSpec) (*api_proto.AvailableDownloads, error) {
+		return nil, nil
+	}
+
+	downloads, err = availableHuntDownloadFiles(config_obj, "H.1234")
+	assert.NoError(t, err)
+	assert.NotNil(t, downloads)
+	assert.Equal(t, 0, len(downloads.Files))
+}
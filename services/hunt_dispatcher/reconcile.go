@@ -0,0 +1,295 @@
+package hunt_dispatcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/services"
+)
+
+const (
+	defaultReconcileMaxHunts = 100
+
+	// Count the distinct flows of the hunt by outcome. Clients may
+	// retransmit so the same flow can have several entries.
+	// Cardinality is exact up to the precision threshold.
+	getHuntFlowOutcomesQuery = `
+{
+  "size": 0,
+  "query": {
+    "bool": {
+      "must": [
+        {"match": {"hunt_id": %q}},
+        {"match": {"doc_type": "hunt_flow"}}
+      ]
+    }
+  },
+  "aggs": {
+    "scheduled": {
+      "cardinality": {"field": "flow_id", "precision_threshold": 40000}
+    },
+    "outcomes": {
+      "terms": {"field": "status", "size": 10},
+      "aggs": {
+        "flows": {
+          "cardinality": {"field": "flow_id", "precision_threshold": 40000}
+        }
+      }
+    }
+  }
+}
+`
+
+	// Only ever raise the counters. Flows the ingestor counted but
+	// which have no hunt_flow entry (e.g. they started before the
+	// entries were written) would otherwise be lost. Increments made
+	// since the flows were counted are kept.
+	raiseHuntStatsPainless = `
+def changed = false;
+for (def field : ['scheduled', 'completed', 'errors']) {
+  def current = ctx._source[field] == null ? 0 : ctx._source[field];
+  if (params[field] > current) {
+    ctx._source[field] = params[field];
+    changed = true;
+  }
+}
+if (!changed) {
+  ctx.op = 'none';
+}
+`
+	raiseHuntStatsQuery = `
+{
+  "script": {
+    "source": %q,
+    "lang": "painless",
+    "params": {
+      "scheduled": %q,
+      "completed": %q,
+      "errors": %q
+    }
+  }
+}
+`
+
+	// Each run checks the next max_hunts running hunts, continuing
+	// from where the previous run stopped.
+	reconcileCursorId      = "hunt_stats_reconcile_cursor"
+	reconcileCursorDocType = "hunt_stats_reconcile_cursor"
+	reconcileSort          = `[{"hunt_id": "asc"}]`
+)
+
+type reconcileCursor struct {
+	// Empty to start from the first hunt.
+	PageToken string `json:"page_token"`
+	DocType   string `json:"doc_type"`
+}
+
+type huntFlowOutcomes struct {
+	Scheduled struct {
+		Value uint64 `json:"value"`
+	} `json:"scheduled"`
+	Outcomes struct {
+		Buckets []struct {
+			Key   string `json:"key"`
+			Flows struct {
+				Value uint64 `json:"value"`
+			} `json:"flows"`
+		} `json:"buckets"`
+	} `json:"outcomes"`
+}
+
+// Count the hunt's flows from the hunt_flow entries written by the
+// ingestor.
+func (self HuntDispatcher) countHuntFlows(
+	ctx context.Context, hunt_id string) (*api_proto.HuntStats, error) {
+	aggregations, err := cvelo_services.QueryElasticRawAggregations(ctx,
+		self.config_obj.OrgId, "transient",
+		json.Format(getHuntFlowOutcomesQuery, hunt_id))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &api_proto.HuntStats{}
+
+	// The index does not exist yet.
+	if len(aggregations) == 0 {
+		return result, nil
+	}
+
+	parsed := &huntFlowOutcomes{}
+	err = json.Unmarshal(aggregations, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	result.TotalClientsScheduled = parsed.Scheduled.Value
+	for _, bucket := range parsed.Outcomes.Buckets {
+		switch bucket.Key {
+		case HuntFlowCompleted:
+			result.TotalClientsWithResults = bucket.Flows.Value
+		case HuntFlowError:
+			result.TotalClientsWithErrors = bucket.Flows.Value
+		}
+	}
+
+	return result, nil
+}
+
+// The hunt stats are maintained by incrementing counters as flows
+// start and complete, so they drift if an increment is lost.
+// Recount the flows of the next max_hunts running hunts and raise
+// the stats which are too low. Counts are never lowered: hunts
+// started before the hunt_flow entries were written only have
+// entries for some of their flows. This means stats which are too
+// high (e.g. a flow completion counted twice when the stats were
+// replayed) are not corrected by reconciliation. Returns the number
+// of hunts corrected.
+//
+// The position in the running hunts is stored in the persisted index
+// so successive runs (even on different frontends) work through all
+// the hunts, starting again from the first hunt after the last.
+func (self HuntDispatcher) ReconcileHuntStats(
+	ctx context.Context, max_hunts int) (int, error) {
+	if max_hunts <= 0 {
+		max_hunts = defaultReconcileMaxHunts
+	}
+
+	cursor, err := self.getReconcileCursor(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	hits, next_token, err := cvelo_services.QueryPage(ctx,
+		self.config_obj.OrgId, "persisted", getAllActiveHunts,
+		reconcileSort, cursor.PageToken, max_hunts)
+	if err != nil {
+		return 0, err
+	}
+
+	corrected := 0
+	for _, hit := range hits {
+		entry := &HuntEntry{}
+		err := json.Unmarshal(hit, entry)
+		if err != nil || entry.HuntId == "" {
+			continue
+		}
+
+		actual, err := self.countHuntFlows(ctx, entry.HuntId)
+		if err != nil {
+			return corrected, err
+		}
+
+		if actual.TotalClientsScheduled <= entry.Scheduled &&
+			actual.TotalClientsWithResults <= entry.Completed &&
+			actual.TotalClientsWithErrors <= entry.Errors {
+			continue
+		}
+
+		res, err := cvelo_services.UpdateIndexWithResult(ctx,
+			self.config_obj.OrgId, "persisted", entry.HuntId,
+			json.Format(raiseHuntStatsQuery, raiseHuntStatsPainless,
+				actual.TotalClientsScheduled,
+				actual.TotalClientsWithResults,
+				actual.TotalClientsWithErrors))
+		if err != nil {
+			return corrected, err
+		}
+
+		if res.Result != cvelo_services.UpdateResultNoop {
+			corrected++
+		}
+	}
+
+	cursor.PageToken = next_token
+	err = cvelo_services.SetElasticIndex(ctx, self.config_obj.OrgId,
+		"persisted", reconcileCursorId, cursor)
+	return corrected, err
+}
+
+func (self HuntDispatcher) getReconcileCursor(
+	ctx context.Context) (*reconcileCursor, error) {
+	result := &reconcileCursor{DocType: reconcileCursorDocType}
+
+	serialized, err := cvelo_services.GetElasticRecord(ctx,
+		self.config_obj.OrgId, "persisted", reconcileCursorId)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(serialized, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Reconcile the hunt stats of every org each period. Disabled if
+// period is 0.
+func StartHuntStatsReconciler(
+	ctx context.Context, wg *sync.WaitGroup,
+	period time.Duration, max_hunts int) {
+	if period == 0 {
+		return
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(period):
+			}
+
+			reconcileAllOrgs(ctx, max_hunts)
+		}
+	}()
+}
+
+func reconcileAllOrgs(ctx context.Context, max_hunts int) {
+	org_manager, err := services.GetOrgManager()
+	if err != nil {
+		return
+	}
+
+	for _, org := range org_manager.ListOrgs() {
+		org_config_obj, err := org_manager.GetOrgConfig(org.OrgId)
+		if err != nil {
+			continue
+		}
+
+		hunt_dispatcher, err := services.GetHuntDispatcher(org_config_obj)
+		if err != nil {
+			continue
+		}
+
+		dispatcher, ok := hunt_dispatcher.(*HuntDispatcher)
+		if !ok {
+			continue
+		}
+
+		logger := logging.GetLogger(org_config_obj, &logging.FrontendComponent)
+		corrected, err := dispatcher.ReconcileHuntStats(ctx, max_hunts)
+		if err != nil {
+			logger.Error("HuntStatsReconciler: %v", err)
+			continue
+		}
+
+		if corrected > 0 {
+			logger.Info("HuntStatsReconciler: Corrected the stats of %v hunts",
+				corrected)
+		}
+	}
+}
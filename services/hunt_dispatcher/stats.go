@@ -0,0 +1,40 @@
+package hunt_dispatcher
+
+import (
+	"context"
+
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+)
+
+const getHuntStatsTotalsQuery = `
+{
+  "size": 0,
+  "query": {
+    "bool": {
+      "must": [{"match": {"doc_type": "hunts"}}]
+    }
+  },
+  "aggs": {
+    "scheduled": {"sum": {"field": "scheduled"}},
+    "completed": {"sum": {"field": "completed"}},
+    "errors": {"sum": {"field": "errors"}}
+  }
+}
+`
+
+// Get the total stats across all hunts in a single query.
+func (self HuntDispatcher) GetHuntStatsTotals(
+	ctx context.Context) (*api_proto.HuntStats, error) {
+	totals, err := cvelo_services.QueryElasticMetrics(ctx,
+		self.config_obj.OrgId, "persisted", getHuntStatsTotalsQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api_proto.HuntStats{
+		TotalClientsScheduled:   uint64(totals["scheduled"]),
+		TotalClientsWithResults: uint64(totals["completed"]),
+		TotalClientsWithErrors:  uint64(totals["errors"]),
+	}, nil
+}
@@ -0,0 +1,114 @@
+package hunt_dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	api_proto "www.velocidex.com/golang/velociraptor/api/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+var (
+	ErrInvalidHuntTransition = errors.New("Invalid hunt state transition")
+	ErrHuntModified          = errors.New("Hunt was modified concurrently")
+
+	// The states a hunt may move to from each state. Archived hunts
+	// are final.
+	huntTransitions = map[api_proto.Hunt_State][]api_proto.Hunt_State{
+		api_proto.Hunt_UNSET: {
+			api_proto.Hunt_PAUSED, api_proto.Hunt_RUNNING, api_proto.Hunt_STOPPED},
+		api_proto.Hunt_PAUSED: {
+			api_proto.Hunt_RUNNING, api_proto.Hunt_STOPPED},
+		api_proto.Hunt_RUNNING: {
+			api_proto.Hunt_PAUSED, api_proto.Hunt_STOPPED},
+		api_proto.Hunt_STOPPED: {
+			api_proto.Hunt_ARCHIVED},
+	}
+)
+
+const (
+	// Only change the state if nobody else changed it since we
+	// looked.
+	transitionHuntPainless = `
+def current = ctx._source.state == null ? '' : ctx._source.state;
+if (current == params.from) {
+  ctx._source.state = params.to;
+  if (params.to == 'ARCHIVED') {
+    ctx._source.archived = params.now;
+  }
+} else {
+  ctx.op = 'none';
+}
+`
+	transitionHuntQuery = `
+{
+  "script" : {
+    "source": %q,
+    "lang": "painless",
+    "params": {
+      "from": %q,
+      "to": %q,
+      "now": %q
+    }
+  }
+}
+`
+)
+
+// Is a hunt allowed to move between these states?
+func ValidHuntTransition(from, to api_proto.Hunt_State) bool {
+	for _, allowed := range huntTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Move the hunt to a new state. Transitions not allowed by the hunt
+// state machine are rejected with ErrInvalidHuntTransition. The
+// state is only changed if it was not changed by someone else in
+// the meantime, otherwise ErrHuntModified is returned.
+func (self HuntDispatcher) TransitionHunt(
+	ctx context.Context, hunt_id string, to api_proto.Hunt_State) error {
+
+	// Compacted hunts are archived so can not change state any
+	// more.
+	entry, err := self.getHuntEntry(ctx, hunt_id)
+	if err != nil {
+		return err
+	}
+
+	from := api_proto.Hunt_UNSET
+	value, pres := api_proto.Hunt_State_value[entry.State]
+	if pres {
+		from = api_proto.Hunt_State(value)
+	}
+
+	if from == to {
+		return nil
+	}
+
+	if !ValidHuntTransition(from, to) {
+		return fmt.Errorf("%w: %v from %v to %v",
+			ErrInvalidHuntTransition, hunt_id, from, to)
+	}
+
+	res, err := cvelo_services.UpdateIndexWithResult(
+		cvelo_services.ImportantWrites(ctx),
+		self.config_obj.OrgId, "persisted", hunt_id,
+		json.Format(transitionHuntQuery, transitionHuntPainless,
+			entry.State, to.String(), utils.GetTime().Now().Unix()))
+	if err != nil {
+		return err
+	}
+
+	if res.Result == cvelo_services.UpdateResultNoop {
+		return fmt.Errorf("%w: %v", ErrHuntModified, hunt_id)
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+var (
+	// Keyword values longer than ignore_above are stored but not
+	// indexed, so term queries on them silently find nothing. Keyed
+	// by the (dotted) field name.
+	keyword_ignore_above map[string]int
+)
+
+// Set the ignore_above limit for keyword fields in the managed
+// indexes. Fields not listed keep the limit from the template.
+func SetKeywordIgnoreAbove(fields map[string]int) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	keyword_ignore_above = make(map[string]int)
+	for field, limit := range fields {
+		keyword_ignore_above[field] = limit
+	}
+}
+
+func getKeywordIgnoreAbove() map[string]int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return keyword_ignore_above
+}
+
+// Apply the configured ignore_above limits to the keyword fields of
+// all indexes matching index. Only fields which are already mapped
+// as keyword are changed.
+//
+// Raising the limit only affects documents indexed afterwards:
+// values which were already skipped stay unsearchable until their
+// documents are indexed again (e.g. by reindexing).
+func UpdateKeywordIgnoreAbove(ctx context.Context, index string) error {
+	defer Instrument("UpdateKeywordIgnoreAbove")()
+	defer Debug("UpdateKeywordIgnoreAbove %v", index)()
+
+	fields := getKeywordIgnoreAbove()
+	if len(fields) == 0 {
+		return nil
+	}
+
+	mappings, err := getMappingProperties(ctx, index)
+	if err != nil {
+		return err
+	}
+
+	// Update the indexes in a stable order.
+	names := make([]string, 0, len(mappings))
+	for name := range mappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		properties := ordereddict.NewDict()
+		err = properties.UnmarshalJSON(mappings[name])
+		if err != nil {
+			continue
+		}
+
+		body := ignoreAboveMapping(properties, fields)
+		if body == "" {
+			continue
+		}
+
+		err = putMapping(ctx, name, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Build a mapping update setting ignore_above on the keyword fields
+// of properties which need a new limit. Returns an empty string if
+// nothing needs to change.
+func ignoreAboveMapping(
+	properties *ordereddict.Dict, fields map[string]int) string {
+	update := ordereddict.NewDict()
+
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	for _, field := range names {
+		limit := fields[field]
+		current := properties
+		parts := strings.Split(field, ".")
+		path := make([]string, 0, 2*len(parts))
+
+		for i, part := range parts {
+			child_any, pres := current.Get(part)
+			if !pres {
+				break
+			}
+			child, ok := child_any.(*ordereddict.Dict)
+			if !ok {
+				break
+			}
+			path = append(path, part)
+
+			// Not the leaf - descend into the object properties.
+			if i < len(parts)-1 {
+				properties_any, _ := child.Get("properties")
+				current, ok = properties_any.(*ordereddict.Dict)
+				if !ok {
+					break
+				}
+				path = append(path, "properties")
+				continue
+			}
+
+			field_type, _ := child.GetString("type")
+			if field_type != "keyword" {
+				break
+			}
+
+			existing, _ := child.GetInt64("ignore_above")
+			if existing == int64(limit) {
+				break
+			}
+
+			setNested(update, path, ordereddict.NewDict().
+				Set("type", "keyword").
+				Set("ignore_above", limit))
+		}
+	}
+
+	if update.Len() == 0 {
+		return ""
+	}
+
+	return json.MustMarshalString(ordereddict.NewDict().
+		Set("properties", update))
+}
+
+// Set value at the path within dict, creating intermediate dicts.
+func setNested(dict *ordereddict.Dict, path []string, value interface{}) {
+	for _, part := range path[:len(path)-1] {
+		child_any, _ := dict.Get(part)
+		child, ok := child_any.(*ordereddict.Dict)
+		if !ok {
+			child = ordereddict.NewDict()
+			dict.Set(part, child)
+		}
+		dict = child
+	}
+	dict.Set(path[len(path)-1], value)
+}
+
+// Get the mapped properties of each index matching the pattern, by
+// index name.
+func getMappingProperties(ctx context.Context, pattern string) (
+	map[string]json.RawMessage, error) {
+	client, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := opensearchapi.IndicesGetMappingRequest{
+		Index: []string{pattern},
+	}.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.IsError() {
+		return nil, makeElasticError(data)
+	}
+
+	mappings := make(map[string]struct {
+		Mappings struct {
+			Properties json.RawMessage `json:"properties"`
+		} `json:"mappings"`
+	})
+	err = json.Unmarshal(data, &mappings)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]json.RawMessage)
+	for name, mapping := range mappings {
+		result[name] = mapping.Mappings.Properties
+	}
+	return result, nil
+}
+
+func putMapping(ctx context.Context, index, body string) error {
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IndicesPutMappingRequest{
+		Index: []string{index},
+		Body:  strings.NewReader(body),
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		data, _ := ioutil.ReadAll(res.Body)
+		return makeElasticError(data)
+	}
+
+	return nil
+}
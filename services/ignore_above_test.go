@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/Velocidex/ordereddict"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreAboveMapping(t *testing.T) {
+	properties := ordereddict.NewDict()
+	err := properties.UnmarshalJSON([]byte(`{
+  "client_id": {"type": "keyword"},
+  "timestamp": {"type": "long"},
+  "vfs_path": {"type": "keyword", "ignore_above": 1024},
+  "context": {"properties": {
+    "artifact": {"type": "keyword", "ignore_above": 256}
+  }}
+}`))
+	assert.NoError(t, err)
+
+	body := ignoreAboveMapping(properties, map[string]int{
+		"client_id":        512,
+		"context.artifact": 2048,
+
+		// Not a keyword.
+		"timestamp": 512,
+
+		// Already has this limit.
+		"vfs_path": 1024,
+
+		// Not mapped.
+		"missing": 512,
+	})
+	assert.Equal(t, `{"properties":{"client_id":{"type":"keyword","ignore_above":512},"context":{"properties":{"artifact":{"type":"keyword","ignore_above":2048}}}}}`, body)
+
+	// Nothing to change.
+	body = ignoreAboveMapping(properties, map[string]int{"vfs_path": 1024})
+	assert.Equal(t, "", body)
+}
@@ -79,8 +79,9 @@ func (self *Indexer) searchWithNames(
 
 	query := json.Format(getAllClientsAgg, field, label,
 		field, offset, limit+1)
-	hits, err := cvelo_services.QueryElasticAggregations(
-		ctx, config_obj.OrgId, "persisted", query)
+	hits, err := cvelo_services.QueryElasticAggregationsWithOptions(
+		ctx, config_obj.OrgId, "persisted", query,
+		cvelo_services.QueryOptions{RequestCache: true})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	cloud_velo_config "www.velocidex.com/golang/cloudvelo/config"
+)
+
+type ingestPipelineKey int
+
+// Documents written with the returned context (by SetElasticIndex
+// and the bulk helpers) are processed by the ingest pipeline on the
+// cluster before they are indexed. SetElasticIndexAsync does not
+// use a pipeline.
+func WithIngestPipeline(ctx context.Context, pipeline string) context.Context {
+	return context.WithValue(ctx, ingestPipelineKey(0), pipeline)
+}
+
+func getIngestPipeline(ctx context.Context) string {
+	pipeline, _ := ctx.Value(ingestPipelineKey(0)).(string)
+	return pipeline
+}
+
+// Install the configured ingest pipelines, replacing existing
+// pipelines with the same name.
+func InstallIngestPipelines(ctx context.Context,
+	pipelines []cloud_velo_config.IngestPipeline) error {
+	for _, pipeline := range pipelines {
+		err := PutIngestPipeline(ctx, pipeline.Name, string(pipeline.Definition))
+		if err != nil {
+			return fmt.Errorf("Ingest pipeline %v: %w", pipeline.Name, err)
+		}
+	}
+	return nil
+}
+
+// Create or update an ingest pipeline from its definition.
+func PutIngestPipeline(ctx context.Context, name, definition string) error {
+	defer Instrument("PutIngestPipeline")()
+	defer Debug("PutIngestPipeline %v", name)()
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IngestPutPipelineRequest{
+		PipelineID: name,
+		Body:       strings.NewReader(definition),
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return makeElasticError(data)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	cloud_velo_config "www.velocidex.com/golang/cloudvelo/config"
+)
+
+func TestIngestPipeline(t *testing.T) {
+	var requests []string
+	var pipelines []string
+	var definition string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		pipelines = append(pipelines, r.URL.Query().Get("pipeline"))
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodPut {
+			data, _ := ioutil.ReadAll(r.Body)
+			definition = string(data)
+			w.Write([]byte(`{"acknowledged": true}`))
+			return
+		}
+		w.Write([]byte(`{"_index": "test_persisted", "_id": "1", "_version": 1,
+  "errors": false, "items": []}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	err := InstallIngestPipelines(ctx, []cloud_velo_config.IngestPipeline{{
+		Name:       "parse_dates",
+		Definition: []byte(`{"processors": [{"date": {"field": "ts", "formats": ["ISO8601"]}}]}`),
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"processors": [{"date": {"field": "ts", "formats": ["ISO8601"]}}]}`,
+		definition)
+
+	// Writes only go through the pipeline when asked to.
+	pipeline_ctx := WithIngestPipeline(ctx, "parse_dates")
+	err = SetElasticIndex(pipeline_ctx, "test", "persisted", "1",
+		map[string]string{"ts": "2024-01-01T00:00:00Z"})
+	assert.NoError(t, err)
+
+	err = SetElasticIndex(ctx, "test", "persisted", "1",
+		map[string]string{"ts": "2024-01-01T00:00:00Z"})
+	assert.NoError(t, err)
+
+	err = doBulk(pipeline_ctx, []bulkItem{{
+		id:     "1",
+		action: `{"index": {"_index": "test_persisted", "_id": "1"}}`,
+		source: []byte(`{"ts": "2024-01-01T00:00:00Z"}`),
+	}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"PUT /_ingest/pipeline/parse_dates",
+		"PUT /test_persisted/_doc/1",
+		"PUT /test_persisted/_doc/1",
+		"POST /_bulk"}, requests)
+	assert.Equal(t, []string{"", "parse_dates", "", "parse_dates"}, pipelines)
+}
@@ -16,6 +16,14 @@ var (
 		},
 		[]string{"operation"},
 	)
+
+	BulkIndexerLagHistogram = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "opensearch_bulk_indexer_lag",
+			Help:    "Time documents wait in the bulk indexer before being flushed.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+		},
+	)
 )
 
 func Instrument(operation string) func() time.Duration {
@@ -10,6 +10,7 @@ import (
 	"google.golang.org/protobuf/encoding/protojson"
 	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
 	"www.velocidex.com/golang/cloudvelo/services/client_info"
+	cvelo_launcher "www.velocidex.com/golang/cloudvelo/services/launcher"
 	"www.velocidex.com/golang/cloudvelo/testsuite"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
 	crypto_proto "www.velocidex.com/golang/velociraptor/crypto/proto"
@@ -178,6 +179,47 @@ sources:
 	assert.Equal(self.T(), "F.1234second", flows.Items[0].SessionId)
 }
 
+func (self *LauncherTestSuite) TestGetRecentFlows() {
+	config_obj := self.ConfigObj.VeloConf()
+
+	launcher, err := services.GetLauncher(config_obj)
+	assert.NoError(self.T(), err)
+
+	// Flows are written in this order. F.1 is written again last so
+	// it is the most recent flow of C.1.
+	for _, flow := range []struct{ client_id, flow_id string }{
+		{"C.1", "F.1"},
+		{"C.1", "F.2"},
+		{"C.2", "F.4"},
+		{"C.1", "F.3"},
+		{"C.1", "F.1"},
+	} {
+		err := launcher.Storage().WriteFlow(self.Ctx, config_obj,
+			&flows_proto.ArtifactCollectorContext{
+				ClientId:  flow.client_id,
+				SessionId: flow.flow_id,
+			}, nil)
+		assert.NoError(self.T(), err)
+	}
+
+	recent, err := launcher.(*cvelo_launcher.Launcher).GetRecentFlows(
+		self.Ctx, config_obj, []string{"C.1", "C.2", "C.3"}, 2)
+	assert.NoError(self.T(), err)
+
+	flow_ids := make(map[string][]string)
+	for client_id, flows := range recent {
+		for _, flow := range flows {
+			assert.Equal(self.T(), client_id, flow.ClientId)
+			flow_ids[client_id] = append(flow_ids[client_id], flow.SessionId)
+		}
+	}
+
+	assert.Equal(self.T(), map[string][]string{
+		"C.1": {"F.1", "F.3"},
+		"C.2": {"F.4"},
+	}, flow_ids)
+}
+
 func TestLauncher(t *testing.T) {
 	suite.Run(t, &LauncherTestSuite{
 		CloudTestSuite: &testsuite.CloudTestSuite{
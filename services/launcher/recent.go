@@ -0,0 +1,127 @@
+package launcher
+
+import (
+	"context"
+	"fmt"
+
+	cvelo_schema_api "www.velocidex.com/golang/cloudvelo/schema/api"
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	flows_proto "www.velocidex.com/golang/velociraptor/flows/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	// Each flow has a main record for every time it was written, so
+	// bucket by session and keep the latest record of the most
+	// recently written sessions.
+	getRecentFlowsQuery = `
+{
+  "query": {
+     "bool": {
+       "must": [
+           {"terms": {"client_id" : %q}},
+           {"match": {"type": "main"}},
+           {"match": {"doc_type": "collection"}}
+       ]}
+  },
+  "size": 0,
+  "aggs": {
+    "clients": {
+      "terms": {"field": "client_id", "size": %q},
+      "aggs": {
+        "flows": {
+          "terms": {
+            "field": "session_id",
+            "size": %q,
+            "order": {"latest": "desc"}
+          },
+          "aggs": {
+            "latest": {"max": {"field": "timestamp"}},
+            "record": {
+              "top_hits": {
+                "size": 1,
+                "sort": [{"timestamp": {"order": "desc"}}]
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
+)
+
+type recentFlowsAggregations struct {
+	Clients struct {
+		Buckets []struct {
+			Key   string `json:"key"`
+			Flows struct {
+				Buckets []struct {
+					Record struct {
+						Hits struct {
+							Hits []struct {
+								Source json.RawMessage `json:"_source"`
+							} `json:"hits"`
+						} `json:"hits"`
+					} `json:"record"`
+				} `json:"buckets"`
+			} `json:"flows"`
+		} `json:"buckets"`
+	} `json:"clients"`
+}
+
+// Get the count most recent flows of each client in a single query,
+// newest first. Clients without flows are not in the result.
+func (self Launcher) GetRecentFlows(
+	ctx context.Context,
+	config_obj *config_proto.Config,
+	client_ids []string, count int) (
+	map[string][]*flows_proto.ArtifactCollectorContext, error) {
+
+	result := make(map[string][]*flows_proto.ArtifactCollectorContext)
+	if len(client_ids) == 0 || count <= 0 {
+		return result, nil
+	}
+
+	aggregations, err := cvelo_services.QueryElasticRawAggregations(ctx,
+		config_obj.OrgId, "transient",
+		json.Format(getRecentFlowsQuery, client_ids, len(client_ids), count))
+	if err != nil {
+		return nil, err
+	}
+
+	// The index does not exist yet.
+	if len(aggregations) == 0 {
+		return result, nil
+	}
+
+	parsed := &recentFlowsAggregations{}
+	err = json.Unmarshal(aggregations, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("GetRecentFlows: %w", err)
+	}
+
+	for _, client := range parsed.Clients.Buckets {
+		for _, flow := range client.Flows.Buckets {
+			for _, hit := range flow.Record.Hits.Hits {
+				item := &cvelo_schema_api.ArtifactCollectorRecord{}
+				err = json.Unmarshal(hit.Source, item)
+				if err != nil {
+					continue
+				}
+
+				collection_context, err := item.ToProto()
+				if err != nil {
+					continue
+				}
+
+				result[client.Key] = append(
+					result[client.Key], collection_context)
+			}
+		}
+	}
+
+	return result, nil
+}
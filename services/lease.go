@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	// The fields of a work document which hold its lease.
+	LeaseOwnerField   = "lease_owner"
+	LeaseExpiresField = "lease_expires" // Epoch seconds
+
+	setLeaseQuery = `{"doc": {%q: %q, %q: %q}}`
+)
+
+type leaseDocument struct {
+	Found       bool `json:"found"`
+	SeqNo       int  `json:"_seq_no"`
+	PrimaryTerm int  `json:"_primary_term"`
+	Source      struct {
+		Owner   string `json:"lease_owner"`
+		Expires int64  `json:"lease_expires"`
+	} `json:"_source"`
+}
+
+// Claim the work document for owner for the next ttl so that only
+// one worker (e.g. on one of several frontends) processes it.
+// Returns true if the claim succeeded: the document was not leased,
+// its lease expired, or owner already held it (which extends the
+// lease). The claim is only written if the document did not change
+// since it was read, so of several workers claiming at the same
+// time exactly one succeeds.
+//
+// Returns os.ErrNotExist if there is no such document.
+func ClaimLease(ctx context.Context,
+	org_id, index, id, owner string, ttl time.Duration) (bool, error) {
+	defer Instrument("ClaimLease")()
+	defer Debug("ClaimLease %v %v %v", index, id, owner)()
+
+	now := utils.GetTime().Now()
+	return setLease(ctx, org_id, index, id,
+		func(current *leaseDocument) bool {
+			return current.Source.Owner == "" ||
+				current.Source.Owner == owner ||
+				current.Source.Expires <= now.Unix()
+		}, owner, now.Add(ttl).Unix())
+}
+
+// Give up the lease so another worker can claim the document
+// straight away. Returns false if owner does not hold the lease.
+func ReleaseLease(ctx context.Context,
+	org_id, index, id, owner string) (bool, error) {
+	defer Instrument("ReleaseLease")()
+	defer Debug("ReleaseLease %v %v %v", index, id, owner)()
+
+	return setLease(ctx, org_id, index, id,
+		func(current *leaseDocument) bool {
+			return current.Source.Owner == owner
+		}, "", 0)
+}
+
+// Set the lease if allowed by the current lease, as long as the
+// document is not changed by someone else in the meantime.
+func setLease(ctx context.Context, org_id, index, id string,
+	allowed func(current *leaseDocument) bool,
+	owner string, expires int64) (bool, error) {
+
+	err := checkWritable()
+	if err != nil {
+		return false, err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := opensearchapi.GetRequest{
+		Index:      GetIndex(org_id, index),
+		DocumentID: id,
+	}.Do(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return false, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, os.ErrNotExist
+	}
+
+	if res.IsError() {
+		return false, makeReadElasticError(data)
+	}
+
+	current := &leaseDocument{}
+	err = json.Unmarshal(data, current)
+	if err != nil {
+		return false, err
+	}
+
+	if !current.Found {
+		return false, os.ErrNotExist
+	}
+
+	if !allowed(current) {
+		return false, nil
+	}
+
+	update_res, err := opensearchapi.UpdateRequest{
+		Index:      GetIndex(org_id, index),
+		DocumentID: id,
+		Body: strings.NewReader(json.Format(setLeaseQuery,
+			LeaseOwnerField, owner, LeaseExpiresField, expires)),
+		IfSeqNo:       &current.SeqNo,
+		IfPrimaryTerm: &current.PrimaryTerm,
+	}.Do(ctx, client)
+	if err != nil {
+		return false, err
+	}
+	defer update_res.Body.Close()
+
+	data, err = ioutil.ReadAll(update_res.Body)
+	if err != nil {
+		return false, err
+	}
+
+	// Someone else changed the document first.
+	if update_res.StatusCode == http.StatusConflict {
+		return false, nil
+	}
+
+	if update_res.IsError() {
+		return false, makeElasticError(data)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// A single document which enforces if_seq_no like the cluster does.
+type mockLeaseDocument struct {
+	mu      sync.Mutex
+	seq_no  int
+	owner   string
+	expires int64
+}
+
+func (self *mockLeaseDocument) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == http.MethodGet {
+		w.Write([]byte(json.Format(
+			`{"found": true, "_seq_no": %q, "_primary_term": 1, "_source": {"lease_owner": %q, "lease_expires": %q}}`,
+			self.seq_no, self.owner, self.expires)))
+		return
+	}
+
+	if r.URL.Query().Get("if_seq_no") != strconv.Itoa(self.seq_no) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": {"type": "version_conflict_engine_exception"}, "status": 409}`))
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	update := &struct {
+		Doc struct {
+			Owner   string `json:"lease_owner"`
+			Expires int64  `json:"lease_expires"`
+		} `json:"doc"`
+	}{}
+	json.Unmarshal(body, update)
+
+	self.seq_no++
+	self.owner = update.Doc.Owner
+	self.expires = update.Doc.Expires
+	w.Write([]byte(`{"result": "updated"}`))
+}
+
+func TestClaimLeaseConcurrent(t *testing.T) {
+	doc := &mockLeaseDocument{}
+	closer := installMockClient(t, doc.ServeHTTP)
+	defer closer()
+
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var winners []string
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			<-start
+
+			claimed, err := ClaimLease(ctx, "test", "persisted", "work",
+				owner, time.Minute)
+			assert.NoError(t, err)
+			if claimed {
+				mu.Lock()
+				winners = append(winners, owner)
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("worker%d", i))
+	}
+	close(start)
+	wg.Wait()
+
+	// Only one claimant gets the lease.
+	assert.Equal(t, 1, len(winners))
+	assert.Equal(t, winners[0], doc.owner)
+
+	// Others can not claim it while it is held.
+	claimed, err := ClaimLease(ctx, "test", "persisted", "work",
+		"late_worker", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+
+	// The holder can extend it.
+	claimed, err = ClaimLease(ctx, "test", "persisted", "work",
+		winners[0], time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// Once released anyone can claim it.
+	released, err := ReleaseLease(ctx, "test", "persisted", "work",
+		"late_worker")
+	assert.NoError(t, err)
+	assert.False(t, released)
+
+	released, err = ReleaseLease(ctx, "test", "persisted", "work",
+		winners[0])
+	assert.NoError(t, err)
+	assert.True(t, released)
+
+	claimed, err = ClaimLease(ctx, "test", "persisted", "work",
+		"late_worker", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestClaimExpiredLease(t *testing.T) {
+	doc := &mockLeaseDocument{
+		owner:   "dead_worker",
+		expires: time.Now().Add(-time.Minute).Unix(),
+	}
+	closer := installMockClient(t, doc.ServeHTTP)
+	defer closer()
+
+	claimed, err := ClaimLease(context.Background(), "test", "persisted",
+		"work", "new_worker", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.Equal(t, "new_worker", doc.owner)
+}
@@ -0,0 +1,442 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	cloud_velo_config "www.velocidex.com/golang/cloudvelo/config"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+type LifecycleState string
+
+const (
+	// The write index of its data stream or alias.
+	LifecycleHot LifecycleState = "hot"
+
+	// Rolled over so no longer written, but not merged yet.
+	LifecycleRolled LifecycleState = "rolled"
+
+	// Merged and read only.
+	LifecycleMerged LifecycleState = "merged"
+
+	LifecycleDeleted LifecycleState = "deleted"
+
+	defaultLifecyclePeriod = time.Hour
+)
+
+// An index managed by a lifecycle policy.
+type LifecycleIndex struct {
+	Index string
+
+	// The data stream or alias which is rolled over.
+	Target       string
+	IsWriteIndex bool
+	ReadOnly     bool
+
+	Created   time.Time
+	DocCount  int64
+	SizeBytes int64
+}
+
+func (self *LifecycleIndex) State() LifecycleState {
+	switch {
+	case self.IsWriteIndex:
+		return LifecycleHot
+	case self.ReadOnly:
+		return LifecycleMerged
+	}
+	return LifecycleRolled
+}
+
+// The state the index should move to next under the policy. Returns
+// the current state if there is nothing to do.
+func NextLifecycleState(
+	policy *cloud_velo_config.IndexLifecyclePolicy,
+	index *LifecycleIndex, now time.Time) LifecycleState {
+	state := index.State()
+
+	// The write index can not be deleted - it must be rolled over
+	// first.
+	if state == LifecycleHot {
+		if shouldRollover(policy, index, now) {
+			return LifecycleRolled
+		}
+		return state
+	}
+
+	retention := time.Duration(policy.RetentionSeconds) * time.Second
+	if retention > 0 && now.Sub(index.Created) >= retention {
+		return LifecycleDeleted
+	}
+
+	if state == LifecycleRolled {
+		return LifecycleMerged
+	}
+	return state
+}
+
+func shouldRollover(policy *cloud_velo_config.IndexLifecyclePolicy,
+	index *LifecycleIndex, now time.Time) bool {
+	// Rolling over an empty index just replaces it with another
+	// empty index.
+	if index.DocCount == 0 {
+		return false
+	}
+
+	max_age := time.Duration(policy.RolloverMaxAgeSeconds) * time.Second
+	return (max_age > 0 && now.Sub(index.Created) >= max_age) ||
+		(policy.RolloverMaxDocs > 0 && index.DocCount >= policy.RolloverMaxDocs) ||
+		(policy.RolloverMaxSizeBytes > 0 &&
+			index.SizeBytes >= policy.RolloverMaxSizeBytes)
+}
+
+type LifecycleTransition struct {
+	Index string
+	From  LifecycleState
+	To    LifecycleState
+}
+
+// Applies the configured lifecycle policies to the indexes of all
+// orgs.
+type LifecycleManager struct {
+	policies []cloud_velo_config.IndexLifecyclePolicy
+}
+
+func NewLifecycleManager(
+	policies []cloud_velo_config.IndexLifecyclePolicy) *LifecycleManager {
+	return &LifecycleManager{policies: policies}
+}
+
+// Move every index covered by the policies to its next state and
+// return the transitions made. An index which fails to move does not
+// stop the others - the first error is returned.
+func (self *LifecycleManager) RunOnce(
+	ctx context.Context) ([]LifecycleTransition, error) {
+	defer Instrument("LifecycleManager")()
+
+	err := checkWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	now := utils.GetTime().Now()
+
+	var result []LifecycleTransition
+	var first_err error
+	for i := range self.policies {
+		policy := &self.policies[i]
+
+		indexes, err := listLifecycleIndexes(ctx, policy.Pattern)
+		if err != nil {
+			if first_err == nil {
+				first_err = err
+			}
+			continue
+		}
+
+		for _, index := range indexes {
+			from := index.State()
+			to := NextLifecycleState(policy, index, now)
+			if from == to {
+				continue
+			}
+
+			err = applyLifecycleState(ctx, policy, index, to)
+			if err != nil {
+				if first_err == nil {
+					first_err = fmt.Errorf("%v: %v -> %v: %w",
+						index.Index, from, to, err)
+				}
+				continue
+			}
+
+			result = append(result, LifecycleTransition{
+				Index: index.Index, From: from, To: to,
+			})
+		}
+	}
+
+	return result, first_err
+}
+
+func applyLifecycleState(ctx context.Context,
+	policy *cloud_velo_config.IndexLifecyclePolicy,
+	index *LifecycleIndex, state LifecycleState) error {
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	var res *opensearchapi.Response
+	switch state {
+	case LifecycleRolled:
+		res, err = opensearchapi.IndicesRolloverRequest{
+			Alias: index.Target,
+		}.Do(ctx, client)
+
+	case LifecycleMerged:
+		segments := policy.ForceMergeSegments
+		if segments == 0 {
+			segments = 1
+		}
+
+		err = ForceMerge(ctx, index.Index, segments)
+		if err != nil {
+			return err
+		}
+
+		res, err = opensearchapi.IndicesPutSettingsRequest{
+			Index: []string{index.Index},
+			Body:  strings.NewReader(`{"index.blocks.write": true}`),
+		}.Do(ctx, client)
+
+	case LifecycleDeleted:
+		res, err = opensearchapi.IndicesDeleteRequest{
+			Index: []string{index.Index},
+		}.Do(ctx, client)
+
+	default:
+		return fmt.Errorf("Unknown lifecycle state %v", state)
+	}
+
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return makeElasticError(data)
+	}
+	return nil
+}
+
+type _DataStreams struct {
+	DataStreams []struct {
+		Name    string `json:"name"`
+		Indices []struct {
+			IndexName string `json:"index_name"`
+		} `json:"indices"`
+	} `json:"data_streams"`
+}
+
+type _IndexAliases map[string]struct {
+	Aliases map[string]struct {
+		IsWriteIndex bool `json:"is_write_index"`
+	} `json:"aliases"`
+}
+
+type _CatIndexCreation struct {
+	Index        string `json:"index"`
+	DocsCount    string `json:"docs.count"`
+	StoreSize    string `json:"store.size"`
+	CreationDate string `json:"creation.date"`
+}
+
+type _IndexWriteBlock map[string]struct {
+	Settings struct {
+		Index struct {
+			Blocks struct {
+				Write string `json:"write"`
+			} `json:"blocks"`
+		} `json:"index"`
+	} `json:"settings"`
+}
+
+// Find the indexes behind the data streams and write aliases (with
+// is_write_index set) matching the pattern.
+func listLifecycleIndexes(
+	ctx context.Context, pattern string) ([]*LifecycleIndex, error) {
+	indexes := make(map[string]*LifecycleIndex)
+
+	streams := &_DataStreams{}
+	err := getLifecycleJSON(ctx, opensearchapi.IndicesGetDataStreamRequest{
+		Name: pattern,
+	}.Do, streams)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stream := range streams.DataStreams {
+		// The last backing index is the write index.
+		for i, backing := range stream.Indices {
+			indexes[backing.IndexName] = &LifecycleIndex{
+				Index:        backing.IndexName,
+				Target:       stream.Name,
+				IsWriteIndex: i == len(stream.Indices)-1,
+			}
+		}
+	}
+
+	aliases := make(_IndexAliases)
+	err = getLifecycleJSON(ctx, opensearchapi.IndicesGetAliasRequest{
+		Name: []string{pattern},
+	}.Do, &aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only aliases with a write index can be rolled over.
+	write_aliases := make(map[string]bool)
+	for _, index := range aliases {
+		for alias, details := range index.Aliases {
+			if details.IsWriteIndex {
+				write_aliases[alias] = true
+			}
+		}
+	}
+
+	for name, index := range aliases {
+		for alias, details := range index.Aliases {
+			if write_aliases[alias] {
+				indexes[name] = &LifecycleIndex{
+					Index:        name,
+					Target:       alias,
+					IsWriteIndex: details.IsWriteIndex,
+				}
+			}
+		}
+	}
+
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stats := []*_CatIndexCreation{}
+	err = getLifecycleJSON(ctx, opensearchapi.CatIndicesRequest{
+		Index:  names,
+		Format: "json",
+		Bytes:  "b",
+		H:      []string{"index", "docs.count", "store.size", "creation.date"},
+	}.Do, &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range stats {
+		index, pres := indexes[s.Index]
+		if !pres {
+			continue
+		}
+
+		index.DocCount, _ = strconv.ParseInt(s.DocsCount, 10, 64)
+		index.SizeBytes, _ = strconv.ParseInt(s.StoreSize, 10, 64)
+		created, _ := strconv.ParseInt(s.CreationDate, 10, 64)
+		index.Created = time.UnixMilli(created)
+	}
+
+	settings := make(_IndexWriteBlock)
+	err = getLifecycleJSON(ctx, opensearchapi.IndicesGetSettingsRequest{
+		Index: names,
+		Name:  []string{"index.blocks.write"},
+	}.Do, &settings)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, s := range settings {
+		index, pres := indexes[name]
+		if pres {
+			index.ReadOnly = s.Settings.Index.Blocks.Write == "true"
+		}
+	}
+
+	result := make([]*LifecycleIndex, 0, len(names))
+	for _, name := range names {
+		result = append(result, indexes[name])
+	}
+	return result, nil
+}
+
+// Run the request and parse its response. Nothing matching the
+// request is not an error.
+func getLifecycleJSON(ctx context.Context,
+	do func(context.Context, opensearchapi.Transport) (*opensearchapi.Response, error),
+	target interface{}) error {
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	if res.IsError() {
+		return makeReadElasticError(data)
+	}
+
+	return json.Unmarshal(data, target)
+}
+
+// Apply the policies every period (default 1 hour). This should only
+// run on one frontend.
+func StartLifecycleManager(ctx context.Context, wg *sync.WaitGroup,
+	config_obj *config_proto.Config, period time.Duration,
+	policies []cloud_velo_config.IndexLifecyclePolicy) {
+	if len(policies) == 0 {
+		return
+	}
+
+	if period == 0 {
+		period = defaultLifecyclePeriod
+	}
+
+	manager := NewLifecycleManager(policies)
+	logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(period):
+			}
+
+			transitions, err := manager.RunOnce(ctx)
+			for _, t := range transitions {
+				logger.Info("LifecycleManager: %v moved from %v to %v",
+					t.Index, t.From, t.To)
+			}
+			if err != nil {
+				logger.Error("LifecycleManager: %v", err)
+			}
+		}
+	}()
+}
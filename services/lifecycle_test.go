@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	cloud_velo_config "www.velocidex.com/golang/cloudvelo/config"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const lifecycleDay = 24 * time.Hour
+
+func TestLifecycleAging(t *testing.T) {
+	policy := &cloud_velo_config.IndexLifecyclePolicy{
+		RolloverMaxDocs:  100,
+		RetentionSeconds: 30 * 24 * 3600,
+	}
+
+	created := time.Unix(1600000000, 0)
+	index := &LifecycleIndex{
+		Index:        ".ds-test_transient-000001",
+		Target:       "test_transient",
+		IsWriteIndex: true,
+		Created:      created,
+		DocCount:     10,
+	}
+
+	// Still being filled.
+	assert.Equal(t, LifecycleHot, NextLifecycleState(policy, index, created))
+
+	// Full so it is rolled over.
+	index.DocCount = 100
+	assert.Equal(t, LifecycleRolled,
+		NextLifecycleState(policy, index, created.Add(lifecycleDay)))
+
+	// Once another index is written it is merged.
+	index.IsWriteIndex = false
+	assert.Equal(t, LifecycleRolled, index.State())
+	assert.Equal(t, LifecycleMerged,
+		NextLifecycleState(policy, index, created.Add(lifecycleDay)))
+
+	// Merged indexes are kept until they expire.
+	index.ReadOnly = true
+	assert.Equal(t, LifecycleMerged, index.State())
+	assert.Equal(t, LifecycleMerged,
+		NextLifecycleState(policy, index, created.Add(29*lifecycleDay)))
+	assert.Equal(t, LifecycleDeleted,
+		NextLifecycleState(policy, index, created.Add(30*lifecycleDay)))
+
+	// Expired indexes are deleted without merging them first.
+	index.ReadOnly = false
+	assert.Equal(t, LifecycleDeleted,
+		NextLifecycleState(policy, index, created.Add(31*lifecycleDay)))
+
+	// The write index is only rolled over, never deleted.
+	hot := &LifecycleIndex{IsWriteIndex: true, Created: created}
+	assert.Equal(t, LifecycleHot,
+		NextLifecycleState(policy, hot, created.Add(31*lifecycleDay)))
+
+	// Rolling over on age does not apply to empty indexes.
+	policy.RolloverMaxAgeSeconds = 7 * 24 * 3600
+	assert.Equal(t, LifecycleHot,
+		NextLifecycleState(policy, hot, created.Add(8*lifecycleDay)))
+	hot.DocCount = 1
+	assert.Equal(t, LifecycleRolled,
+		NextLifecycleState(policy, hot, created.Add(8*lifecycleDay)))
+}
+
+func TestLifecycleManagerRunOnce(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/_data_stream/"):
+			w.Write([]byte(`{"data_streams": [{"name": "test_transient", "indices": [
+  {"index_name": ".ds-test_transient-000001"},
+  {"index_name": ".ds-test_transient-000002"},
+  {"index_name": ".ds-test_transient-000003"}
+]}]}`))
+
+		case strings.HasPrefix(r.URL.Path, "/_alias/"):
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "alias [*transient] missing", "status": 404}`))
+
+		case strings.HasPrefix(r.URL.Path, "/_cat/indices/"):
+			// 000001 was created 40 days ago, the others yesterday.
+			w.Write([]byte(`[
+  {"index": ".ds-test_transient-000001", "docs.count": "50", "store.size": "1000", "creation.date": "1596544000000"},
+  {"index": ".ds-test_transient-000002", "docs.count": "50", "store.size": "1000", "creation.date": "1599913600000"},
+  {"index": ".ds-test_transient-000003", "docs.count": "500", "store.size": "1000", "creation.date": "1599913600000"}
+]`))
+
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/_settings/index.blocks.write"):
+			w.Write([]byte(`{
+  ".ds-test_transient-000001": {"settings": {"index": {"blocks": {"write": "true"}}}},
+  ".ds-test_transient-000002": {"settings": {}},
+  ".ds-test_transient-000003": {"settings": {}}
+}`))
+
+		case strings.HasSuffix(r.URL.Path, "/_forcemerge"):
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			w.Write([]byte(`{"_shards": {"total": 1, "successful": 1, "failed": 0}}`))
+
+		default:
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			w.Write([]byte(`{"acknowledged": true}`))
+		}
+	})
+	defer closer()
+
+	clock := &utils.MockClock{MockNow: time.Unix(1600000000, 0)}
+	defer utils.MockTime(clock)()
+
+	manager := NewLifecycleManager([]cloud_velo_config.IndexLifecyclePolicy{{
+		Pattern:          "*transient",
+		RolloverMaxDocs:  100,
+		RetentionSeconds: 30 * 24 * 3600,
+	}})
+
+	transitions, err := manager.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []LifecycleTransition{
+		{".ds-test_transient-000001", LifecycleMerged, LifecycleDeleted},
+		{".ds-test_transient-000002", LifecycleRolled, LifecycleMerged},
+		{".ds-test_transient-000003", LifecycleHot, LifecycleRolled},
+	}, transitions)
+
+	assert.Equal(t, []string{
+		"DELETE /.ds-test_transient-000001",
+		"POST /.ds-test_transient-000002/_forcemerge",
+		"PUT /.ds-test_transient-000002/_settings",
+		"POST /test_transient/_rollover",
+	}, requests)
+}
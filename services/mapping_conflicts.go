@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// A field which is mapped with different types in different
+// indexes.
+type Conflict struct {
+	Field string
+
+	// The indexes using each type.
+	Types map[string][]string
+}
+
+type mappingProperty struct {
+	Type       string                      `json:"type"`
+	Properties map[string]*mappingProperty `json:"properties"`
+}
+
+// Indexes are created per org and documents are not checked against
+// a schema, so the same field may be mapped differently in different
+// orgs (e.g. by dynamic mapping). Such fields break queries across
+// orgs. Compare the mappings of each org's copy of the base index
+// (e.g. "persisted") and report the fields with differing types.
+func DetectMappingConflicts(
+	ctx context.Context, base_index string) ([]Conflict, error) {
+
+	defer Instrument("DetectMappingConflicts")()
+	defer Debug("DetectMappingConflicts %v", base_index)()
+
+	mappings, err := getMappingProperties(ctx, "*"+base_index)
+	if err != nil {
+		return nil, err
+	}
+
+	// Field -> type -> indexes
+	types := make(map[string]map[string][]string)
+	for name, properties := range mappings {
+		if !isOrgIndex(name, base_index) || len(properties) == 0 {
+			continue
+		}
+
+		parsed := make(map[string]*mappingProperty)
+		err := json.Unmarshal(properties, &parsed)
+		if err != nil {
+			return nil, err
+		}
+
+		flattenMapping("", parsed, func(field, field_type string) {
+			by_type, pres := types[field]
+			if !pres {
+				by_type = make(map[string][]string)
+				types[field] = by_type
+			}
+			by_type[field_type] = append(by_type[field_type], name)
+		})
+	}
+
+	result := []Conflict{}
+	for field, by_type := range types {
+		if len(by_type) < 2 {
+			continue
+		}
+
+		for _, indexes := range by_type {
+			sort.Strings(indexes)
+		}
+		result = append(result, Conflict{Field: field, Types: by_type})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Field < result[j].Field
+	})
+
+	return result, nil
+}
+
+// Is this index (or data stream backing index) an org's copy of the
+// base index?
+func isOrgIndex(name, base_index string) bool {
+	match := backingIndexRegex.FindStringSubmatch(name)
+	if match != nil {
+		name = match[1]
+	}
+	return name == base_index || strings.HasSuffix(name, "_"+base_index)
+}
+
+// Call cb with the dotted name and type of each mapped field.
+func flattenMapping(prefix string,
+	properties map[string]*mappingProperty, cb func(field, field_type string)) {
+	for name, property := range properties {
+		if property == nil {
+			continue
+		}
+
+		field := prefix + name
+		field_type := property.Type
+		if field_type == "" && property.Properties != nil {
+			field_type = "object"
+		}
+		cb(field, field_type)
+
+		if property.Properties != nil {
+			flattenMapping(field+".", property.Properties, cb)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectMappingConflicts(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/*transient/_mapping", r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+  ".ds-org1_transient-000001": {"mappings": {"properties": {
+     "client_id": {"type": "keyword"},
+     "data": {"properties": {"size": {"type": "long"}}}
+  }}},
+  ".ds-org2_transient-000002": {"mappings": {"properties": {
+     "client_id": {"type": "keyword"},
+     "data": {"properties": {"size": {"type": "text",
+        "fields": {"keyword": {"type": "keyword"}}}}}
+  }}},
+  "transient": {"mappings": {"properties": {
+     "client_id": {"type": "text"}
+  }}},
+  "org3_nottransient": {"mappings": {"properties": {
+     "client_id": {"type": "long"}
+  }}},
+  "org4_transient": {"mappings": {}}
+}`))
+	})
+	defer closer()
+
+	conflicts, err := DetectMappingConflicts(context.Background(), "transient")
+	assert.NoError(t, err)
+	assert.Equal(t, []Conflict{{
+		Field: "client_id",
+		Types: map[string][]string{
+			"keyword": {".ds-org1_transient-000001", ".ds-org2_transient-000002"},
+			"text":    {"transient"},
+		},
+	}, {
+		Field: "data.size",
+		Types: map[string][]string{
+			"long": {".ds-org1_transient-000001"},
+			"text": {".ds-org2_transient-000002"},
+		},
+	}}, conflicts)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+var ErrMappingDrift = errors.New("Index mapping differs from its template")
+
+// A field whose live mapping differs from the mapping the index
+// template would give it.
+type MappingMismatch struct {
+	Field    string
+	Expected string
+
+	// Empty if the field is not mapped in the index.
+	Actual string
+}
+
+func (self MappingMismatch) String() string {
+	actual := self.Actual
+	if actual == "" {
+		actual = "missing"
+	}
+	return fmt.Sprintf("%v (%v, expected %v)", self.Field, actual, self.Expected)
+}
+
+// Compare the live mapping of the existing indexes matching index
+// (which may be a pattern) with the mapping the index template (the
+// template body as installed by the schema) gives them. Indexes keep
+// the mapping they were created with and an updated template only
+// adds new fields to them - the returned fields are mapped
+// differently or not at all in the index. Fields only mapped in the
+// index are not reported. Nothing is changed: incompatible types can
+// only be fixed by reindexing.
+func CheckIndexMapping(
+	ctx context.Context, index, template string) ([]MappingMismatch, error) {
+
+	defer Instrument("CheckIndexMapping")()
+
+	desired, err := getTemplateMapping(template)
+	if err != nil {
+		return nil, err
+	}
+
+	// The template does not map anything.
+	if len(desired) == 0 {
+		return nil, nil
+	}
+
+	expected := make(map[string]string)
+	flattenMapping("", desired, func(field, field_type string) {
+		expected[field] = field_type
+	})
+
+	mappings, err := getMappingProperties(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []MappingMismatch{}
+	seen := make(map[string]bool)
+
+	// A data stream has several backing indexes.
+	for _, properties := range mappings {
+		actual := make(map[string]string)
+		if len(properties) > 0 {
+			parsed := make(map[string]*mappingProperty)
+			err := json.Unmarshal(properties, &parsed)
+			if err != nil {
+				return nil, err
+			}
+
+			flattenMapping("", parsed, func(field, field_type string) {
+				actual[field] = field_type
+			})
+		}
+
+		for field, field_type := range expected {
+			if actual[field] == field_type || seen[field] {
+				continue
+			}
+			seen[field] = true
+			result = append(result, MappingMismatch{
+				Field:    field,
+				Expected: field_type,
+				Actual:   actual[field],
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Field < result[j].Field
+	})
+
+	return result, nil
+}
+
+// Check the existing indexes matching the template against it.
+// Returns an ErrMappingDrift error listing the mismatched fields of
+// each index pattern, or nil if the indexes match the template.
+func CheckTemplateMappingDrift(ctx context.Context, template string) error {
+	parsed := &indexTemplate{}
+	err := json.Unmarshal([]byte(template), parsed)
+	if err != nil {
+		return err
+	}
+
+	var drift []string
+	for _, pattern := range parsed.IndexPatterns {
+		mismatches, err := CheckIndexMapping(ctx, pattern, template)
+		if err != nil {
+			return err
+		}
+
+		if len(mismatches) == 0 {
+			continue
+		}
+
+		fields := make([]string, 0, len(mismatches))
+		for _, mismatch := range mismatches {
+			fields = append(fields, mismatch.String())
+		}
+		drift = append(drift, fmt.Sprintf("%v: %v", pattern,
+			strings.Join(fields, ", ")))
+	}
+
+	if len(drift) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %v", ErrMappingDrift, strings.Join(drift, "; "))
+}
+
+// The mapping properties given by the index template body.
+func getTemplateMapping(template string) (
+	map[string]*mappingProperty, error) {
+
+	parsed := &struct {
+		Template struct {
+			Mappings struct {
+				Properties map[string]*mappingProperty `json:"properties"`
+			} `json:"mappings"`
+		} `json:"template"`
+	}{}
+	err := json.Unmarshal([]byte(template), parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed.Template.Mappings.Properties, nil
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type _MetricAgg struct {
+	Value *float64 `json:"value"`
+}
+
+type _MetricAggResponse struct {
+	Aggregations map[string]_MetricAgg `json:"aggregations"`
+}
+
+// Run a query with one or more single value metric aggregations
+// (e.g. sum, max, avg) and return the value of each aggregation by
+// name. Aggregations without a value (e.g. max over no documents) are
+// omitted.
+func QueryElasticMetrics(
+	ctx context.Context, org_id, index, query string) (map[string]float64, error) {
+
+	defer Instrument("QueryElasticMetrics")()
+	defer Debug("QueryElasticMetrics %v", index)()
+
+	es, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+	res, err := es.Search(
+		QueryOptions{}.searchOptions(ctx, es, org_id, index, query)...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// There was an error so we need to relay it
+	if res.IsError() {
+		return nil, makeReadElasticError(data)
+	}
+
+	parsed := &_MetricAggResponse{}
+	err = json.Unmarshal(data, &parsed)
+	if err != nil {
+		return nil, makeReadElasticError(data)
+	}
+
+	results := make(map[string]float64)
+	for name, agg := range parsed.Aggregations {
+		if agg.Value != nil {
+			results[name] = *agg.Value
+		}
+	}
+
+	return results, nil
+}
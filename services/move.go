@@ -0,0 +1,158 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	stdjson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type _MgetDocs struct {
+	Docs []struct {
+		Id          string          `json:"_id"`
+		Found       bool            `json:"found"`
+		SeqNo       int64           `json:"_seq_no"`
+		PrimaryTerm int64           `json:"_primary_term"`
+		Source      json.RawMessage `json:"_source"`
+	} `json:"docs"`
+}
+
+type _BulkResponse struct {
+	Errors bool                                   `json:"errors"`
+	Items  []map[string]*_BulkResponseItemDetails `json:"items"`
+}
+
+type _BulkResponseItemDetails struct {
+	Id     string          `json:"_id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// Move the documents with the given ids from the source index to the
+// destination index (e.g. to move a client's data to another
+// tier). The documents are only removed from the source once they
+// were all written to the destination. If any destination write
+// fails nothing is deleted so the move can simply be retried. Ids
+// which do not exist in the source are ignored.
+//
+// A source document which changed after it was copied is not
+// deleted (the delete fails with a version conflict) so the update
+// is not lost - retrying the move copies the new version.
+func MoveDocuments(
+	ctx context.Context, org_id, src_index, dst_index string,
+	ids []string) error {
+
+	defer Instrument("MoveDocuments")()
+	defer Debug("MoveDocuments %v -> %v: %v", src_index, dst_index, len(ids))()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return err
+	}
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.MgetRequest{
+		Index: GetIndex(org_id, src_index),
+		Body:  strings.NewReader(json.Format(`{"ids": %q}`, ids)),
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return makeReadElasticError(data)
+	}
+
+	docs := &_MgetDocs{}
+	err = json.Unmarshal(data, docs)
+	if err != nil {
+		return err
+	}
+
+	// Copy the documents to the destination.
+	var index_items, delete_items []bulkItem
+	dst := GetIndex(org_id, dst_index)
+	src := GetIndex(org_id, src_index)
+	for _, doc := range docs.Docs {
+		if !doc.Found {
+			continue
+		}
+		// Each document must be on a single line.
+		source := &bytes.Buffer{}
+		err = stdjson.Compact(source, doc.Source)
+		if err != nil {
+			return err
+		}
+
+		index_items = append(index_items, bulkItem{
+			id: doc.Id,
+			action: json.Format(
+				`{"index": {"_index": %q, "_id": %q}}`, dst, doc.Id),
+			source: source.Bytes(),
+		})
+
+		delete_items = append(delete_items, bulkItem{
+			id: doc.Id,
+			action: json.Format(
+				`{"delete": {"_index": %q, "_id": %q, "if_seq_no": %q, "if_primary_term": %q}}`,
+				src, doc.Id, doc.SeqNo, doc.PrimaryTerm),
+		})
+	}
+
+	if len(index_items) == 0 {
+		return nil
+	}
+
+	err = doBulk(ctx, index_items)
+	if err != nil {
+		// Leave the source intact - nothing is lost.
+		return fmt.Errorf("MoveDocuments: writing to %v: %w", dst, err)
+	}
+
+	err = doBulk(ctx, delete_items)
+	if err != nil {
+		return fmt.Errorf("MoveDocuments: deleting from %v: %w", src, err)
+	}
+	return nil
+}
+
+// Send a bulk request and fail if any of the items failed. Items
+// rejected with a transient error are retried first.
+func doBulk(ctx context.Context, items []bulkItem) error {
+	results, err := submitBulk(ctx, "", items, "true")
+	if err != nil {
+		return err
+	}
+
+	for _, details := range results {
+		if details.Status >= 300 {
+			return fmt.Errorf("Bulk request for %v failed: %v",
+				details.Id, string(details.Error))
+		}
+	}
+	return nil
+}
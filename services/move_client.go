@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+var (
+	ErrClientExists = errors.New("Client already exists in the destination org")
+
+	// The indexes which hold the client's documents.
+	clientIndexes = []string{"persisted", "transient"}
+)
+
+const (
+	clientDocsQuery = `{"query": {"match": {"client_id": %q}}}`
+
+	countClientDocsQuery = `
+{"size": 0, "track_total_hits": true,
+ "query": {"match": {"client_id": %q}}}
+`
+
+	// The transient index is a data stream which only accepts
+	// creates. The destination has no documents for the client so
+	// creating them is safe for the other indexes as well.
+	reindexClientQuery = `
+{
+  "source": {
+    "index": %q,
+    "query": {"match": {"client_id": %q}}
+  },
+  "dest": {"index": %q, "op_type": "create"}
+}
+`
+)
+
+type _ReindexResponse struct {
+	Total    int               `json:"total"`
+	Created  int               `json:"created"`
+	Failures []json.RawMessage `json:"failures"`
+}
+
+// Move all the client's documents from the indexes of src_org to
+// the indexes of dst_org (e.g. when reorganizing tenants).
+//
+// The client's documents are first copied to all the destination
+// indexes and only deleted from the source once every copy
+// succeeded. If any copy fails the copies made so far are removed
+// again so the client remains in the source org only. Fails with
+// ErrClientExists if the destination org already has documents for
+// the client.
+func MoveClientToOrg(
+	ctx context.Context, client_id, src_org, dst_org string) error {
+
+	defer Instrument("MoveClientToOrg")()
+	defer Debug("MoveClientToOrg %v: %v -> %v", client_id, src_org, dst_org)()
+
+	if GetIndex(src_org, "") == GetIndex(dst_org, "") {
+		return fmt.Errorf("MoveClientToOrg: %v is already in org %v",
+			client_id, dst_org)
+	}
+
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	for _, org_id := range []string{src_org, dst_org} {
+		err = checkOrgWrite(ctx, org_id)
+		if err != nil {
+			return err
+		}
+	}
+
+	indexes, err := getClientIndexes(ctx, src_org)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		_, total, err := QueryElasticRaw(ctx, dst_org, index,
+			json.Format(countClientDocsQuery, client_id))
+		if err != nil {
+			return err
+		}
+		if total > 0 {
+			return fmt.Errorf("MoveClientToOrg: %w: %v in %v",
+				ErrClientExists, client_id, GetIndex(dst_org, index))
+		}
+	}
+
+	for i, index := range indexes {
+		err = reindexClientDocs(ctx, client_id,
+			GetIndex(src_org, index), GetIndex(dst_org, index))
+		if err != nil {
+			// Undo the partial move.
+			for _, copied := range indexes[:i+1] {
+				rollback_err := DeleteByQuery(ctx, dst_org, copied,
+					json.Format(clientDocsQuery, client_id))
+				if rollback_err != nil {
+					return fmt.Errorf(
+						"MoveClientToOrg: %w (and removing the copies from %v failed: %v)",
+						err, GetIndex(dst_org, copied), rollback_err)
+				}
+			}
+			return fmt.Errorf("MoveClientToOrg: %w", err)
+		}
+	}
+
+	// The client is fully in the destination now. If removing the
+	// source fails the client is in both orgs and the source
+	// documents should be removed by hand.
+	for _, index := range indexes {
+		err = DeleteByQuery(ctx, src_org, index,
+			json.Format(clientDocsQuery, client_id))
+		if err != nil {
+			return fmt.Errorf("MoveClientToOrg: copied %v but removing it from %v failed: %w",
+				client_id, GetIndex(src_org, index), err)
+		}
+	}
+
+	return nil
+}
+
+// The indexes which hold the client's documents in the org. If the
+// client's event rows are written to time bucketed indexes, each of
+// the org's buckets is included.
+func getClientIndexes(ctx context.Context, org_id string) ([]string, error) {
+	result := append([]string{}, clientIndexes...)
+	prefix := GetIndex(org_id, "")
+
+	for _, part := range strings.Split(
+		ResolveIndexPattern("transient", MonitoringDocType), ",") {
+		// The default index is already included.
+		if !strings.Contains(part, "*") {
+			continue
+		}
+
+		names, err := ListIndexesMatching(ctx, GetIndex(org_id, part))
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(names)
+		for _, name := range names {
+			result = append(result, strings.TrimPrefix(name, prefix))
+		}
+	}
+
+	return result, nil
+}
+
+// Copy the client's documents and fail unless all of them were
+// copied.
+func reindexClientDocs(ctx context.Context, client_id, src, dst string) error {
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.ReindexRequest{
+		Body: strings.NewReader(json.Format(
+			reindexClientQuery, src, client_id, dst)),
+		Refresh:           &TRUE,
+		WaitForCompletion: &TRUE,
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		// A missing source index has nothing to copy.
+		return makeReadElasticError(data)
+	}
+
+	response := &_ReindexResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return err
+	}
+
+	if len(response.Failures) > 0 || response.Created != response.Total {
+		return fmt.Errorf("Copying %v to %v: created %v of %v documents: %v",
+			src, dst, response.Created, response.Total,
+			string(data))
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoveClientToOrg(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	fail_transient := true
+	dst_total := 0
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		data, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_search"):
+			w.Write([]byte(fmt.Sprintf(
+				`{"hits": {"total": {"value": %d}, "hits": []}}`, dst_total)))
+
+		case r.URL.Path == "/_reindex":
+			src := "persisted"
+			if strings.Contains(string(data), `"src_transient"`) {
+				src = "transient"
+			}
+			requests = append(requests, "reindex "+src)
+
+			if src == "transient" && fail_transient {
+				w.Write([]byte(`{"total": 2, "created": 1, "failures": [{"status": 429}]}`))
+				return
+			}
+			w.Write([]byte(`{"total": 2, "created": 2, "failures": []}`))
+
+		case strings.HasSuffix(r.URL.Path, "/_delete_by_query"):
+			requests = append(requests, "delete "+
+				strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/_delete_by_query"), "/"))
+			w.Write([]byte(`{"deleted": 2}`))
+		}
+	})
+	defer closer()
+
+	ctx := context.Background()
+
+	// Copying the transient documents fails so the persisted
+	// copies are removed from the destination again and the
+	// source is untouched.
+	err := MoveClientToOrg(ctx, "C.1", "src", "dst")
+	assert.Error(t, err)
+	assert.Equal(t, []string{
+		"reindex persisted",
+		"reindex transient",
+		"delete dst_persisted",
+		"delete dst_transient",
+	}, requests)
+
+	// Now all the copies succeed and the source is removed.
+	requests = nil
+	fail_transient = false
+	err = MoveClientToOrg(ctx, "C.1", "src", "dst")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"reindex persisted",
+		"reindex transient",
+		"delete src_persisted",
+		"delete src_transient",
+	}, requests)
+
+	// Refuse to overwrite a client which is already in the
+	// destination.
+	requests = nil
+	dst_total = 1
+	err = MoveClientToOrg(ctx, "C.1", "src", "dst")
+	assert.ErrorIs(t, err, ErrClientExists)
+	assert.Equal(t, 0, len(requests))
+}
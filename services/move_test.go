@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const moveMgetResponse = `{"docs": [
+  {"_index": "test_src", "_id": "A", "found": true, "_seq_no": 5, "_primary_term": 1, "_source": {"client_id": "A"}},
+  {"_index": "test_src", "_id": "B", "found": true, "_seq_no": 7, "_primary_term": 2, "_source": {"client_id": "B"}},
+  {"_index": "test_src", "_id": "C", "found": false}
+]}`
+
+func TestMoveDocuments(t *testing.T) {
+	var mu sync.Mutex
+	var bulk_requests []string
+	fail_writes := true
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if strings.HasSuffix(r.URL.Path, "/_mget") {
+			w.Write([]byte(moveMgetResponse))
+			return
+		}
+
+		data, _ := ioutil.ReadAll(r.Body)
+		bulk_requests = append(bulk_requests, string(data))
+
+		if fail_writes && strings.Contains(string(data), `"index"`) {
+			w.Write([]byte(`{"errors": true, "items": [
+  {"index": {"_id": "A", "status": 201}},
+  {"index": {"_id": "B", "status": 400, "error": {"type": "mapper_parsing_exception"}}}
+]}`))
+			return
+		}
+		w.Write([]byte(`{"errors": false, "items": []}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	ids := []string{"A", "B", "C"}
+
+	// The destination write fails so the source must not be
+	// deleted.
+	err := MoveDocuments(ctx, "test", "src", "dst", ids)
+	assert.Error(t, err)
+	assert.Equal(t, 1, len(bulk_requests))
+	assert.NotContains(t, bulk_requests[0], `"delete"`)
+
+	// Now the move succeeds - only found documents are moved.
+	bulk_requests = nil
+	fail_writes = false
+	err = MoveDocuments(ctx, "test", "src", "dst", ids)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(bulk_requests))
+	assert.Equal(t, `{"index": {"_index": "test_dst", "_id": "A"}}
+{"client_id":"A"}
+{"index": {"_index": "test_dst", "_id": "B"}}
+{"client_id":"B"}
+`, bulk_requests[0])
+
+	// Documents changed since they were copied are not deleted.
+	assert.Equal(t, `{"delete": {"_index": "test_src", "_id": "A", "if_seq_no": 5, "if_primary_term": 1}}
+{"delete": {"_index": "test_src", "_id": "B", "if_seq_no": 7, "if_primary_term": 2}}
+`, bulk_requests[1])
+}
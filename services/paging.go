@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// The default index.max_result_window. Queries with from + size
+// beyond this are rejected by the server.
+const MaxResultWindow = 10000
+
+var ErrResultWindowExceeded = errors.New(
+	"from + size exceeds the max result window: use search_after paging")
+
+// Check a from/size pair against the max result window.
+func CheckResultWindow(from, size int) error {
+	if from < 0 || size < 0 {
+		return fmt.Errorf("Invalid from %v or size %v", from, size)
+	}
+
+	if from+size > MaxResultWindow {
+		return fmt.Errorf("%w: from %v size %v",
+			ErrResultWindowExceeded, from, size)
+	}
+	return nil
+}
+
+// Return a page of results for the query, sorted by the sort clause
+// (a JSON array as in the search API). The query must not contain
+// its own sort, from or size clauses.
+//
+// Pages within the max result window are fetched directly with
+// from/size. Deeper pages transparently walk the results with
+// search_after, which is slower but has no limit.
+func QueryElasticPage(
+	ctx context.Context,
+	org_id, index, query, sort string,
+	from, size int) ([]json.RawMessage, int, error) {
+
+	defer Instrument("QueryElasticPage")()
+	defer Debug("QueryElasticPage %v", index)()
+
+	err := CheckResultWindow(from, size)
+	if err == nil {
+		return QueryElasticRaw(ctx, org_id, index,
+			withPaging(query, fmt.Sprintf(`"sort": %s, "from": %d, "size": %d`,
+				sort, from, size)))
+	}
+
+	if !errors.Is(err, ErrResultWindowExceeded) {
+		return nil, 0, err
+	}
+
+	// search_after needs a total order so nothing is skipped or
+	// repeated between pages.
+	sort = addTiebreaker(sort, getSortTiebreaker())
+
+	var results []json.RawMessage
+	var search_after []json.RawMessage
+	total := 0
+	skip := from
+
+	for len(results) < size {
+		page_size := MaxResultWindow
+		if skip == 0 && size-len(results) < page_size {
+			page_size = size - len(results)
+		}
+
+		paging := fmt.Sprintf(`"sort": %s, "size": %d`, sort, page_size)
+		if search_after != nil {
+			paging += `, "search_after": ` + json.MustMarshalString(search_after)
+		}
+
+		hits, hits_total, err := queryElasticHits(ctx, org_id, index,
+			withPaging(query, paging), QueryOptions{})
+		if err != nil {
+			return nil, 0, err
+		}
+		total = hits_total
+
+		if len(hits) == 0 {
+			break
+		}
+
+		for _, hit := range hits {
+			if skip > 0 {
+				skip--
+				continue
+			}
+
+			if len(results) < size {
+				results = append(results, hit.Source)
+			}
+		}
+
+		search_after = hits[len(hits)-1].Sort
+		if len(search_after) == 0 || len(hits) < page_size {
+			break
+		}
+	}
+
+	return results, total, nil
+}
+
+// Return a page of results for the query sorted by the sort clause,
+// continuing from the page token returned with the previous page
+// (empty for the first page). The returned token is empty when there
+// are no more results. Unlike QueryElasticPage this is not limited
+// by the max result window.
+func QueryPage(
+	ctx context.Context,
+	org_id, index, query, sort, page_token string,
+	size int) ([]json.RawMessage, string, error) {
+
+	defer Instrument("QueryPage")()
+	defer Debug("QueryPage %v", index)()
+
+	// search_after needs a total order so nothing is skipped or
+	// repeated between pages.
+	sort = addTiebreaker(sort, getSortTiebreaker())
+	paging := fmt.Sprintf(`"sort": %s, "size": %d`, sort, size)
+
+	if page_token != "" {
+		cursor, err := DecodeCursor(page_token)
+		if err != nil {
+			return nil, "", err
+		}
+		// Encode with the standard library which knows to write
+		// json.Number values as is.
+		serialized, err := stdjson.Marshal(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		paging += `, "search_after": ` + string(serialized)
+	}
+
+	hits, _, err := queryElasticHits(ctx, org_id, index,
+		withPaging(query, paging), QueryOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	results := make([]json.RawMessage, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, hit.Source)
+	}
+
+	// A short page means there is nothing more.
+	if len(hits) < size || len(hits[len(hits)-1].Sort) == 0 {
+		return results, "", nil
+	}
+
+	cursor := []interface{}{}
+	for _, value := range hits[len(hits)-1].Sort {
+		cursor = append(cursor, value)
+	}
+
+	next_token, err := EncodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return results, next_token, nil
+}
+
+// Insert the paging clauses into the query object.
+func withPaging(query, paging string) string {
+	query = strings.TrimSpace(query)
+	return "{" + paging + "," + query[1:]
+}
+
+// Append an ascending tiebreaker to a JSON sort array.
+func addTiebreaker(sort, tiebreaker string) string {
+	sort = strings.TrimSpace(sort)
+	if strings.Contains(sort, fmt.Sprintf("%q", tiebreaker)) {
+		return sort
+	}
+	return sort[:len(sort)-1] +
+		json.Format(`, {%q: "asc"}]`, tiebreaker)
+}
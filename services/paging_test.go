@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// Serve an index of total documents numbered by their position,
+// enforcing the max result window like the server does.
+func mockPagedIndex(t *testing.T, total int, requests *[]string) func() {
+	var mu sync.Mutex
+
+	return installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		*requests = append(*requests, string(body))
+		mu.Unlock()
+
+		query := &struct {
+			From        int           `json:"from"`
+			Size        int           `json:"size"`
+			SearchAfter []interface{} `json:"search_after"`
+		}{}
+		err := json.Unmarshal(body, query)
+		assert.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		if query.From+query.Size > MaxResultWindow {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"type": "illegal_argument_exception", "reason": "Result window is too large"}}`))
+			return
+		}
+
+		start := query.From
+		if len(query.SearchAfter) > 0 {
+			start = int(query.SearchAfter[0].(float64)) + 1
+		}
+
+		hits := []string{}
+		for i := start; i < total && i < start+query.Size; i++ {
+			hits = append(hits, fmt.Sprintf(
+				`{"_id": "%d", "_source": {"i": %d}, "sort": [%d, "%d"]}`,
+				i, i, i, i))
+		}
+
+		fmt.Fprintf(w, `{"hits": {"total": {"value": %d}, "hits": [%s]}}`,
+			total, strings.Join(hits, ","))
+	})
+}
+
+func pageValues(t *testing.T, hits []json.RawMessage) []int {
+	result := []int{}
+	for _, hit := range hits {
+		item := &struct {
+			I int `json:"i"`
+		}{}
+		err := json.Unmarshal(hit, item)
+		assert.NoError(t, err)
+		result = append(result, item.I)
+	}
+	return result
+}
+
+func TestCheckResultWindow(t *testing.T) {
+	assert.NoError(t, CheckResultWindow(0, MaxResultWindow))
+	assert.NoError(t, CheckResultWindow(9990, 10))
+
+	err := CheckResultWindow(9995, 10)
+	assert.True(t, errors.Is(err, ErrResultWindowExceeded))
+
+	assert.Error(t, CheckResultWindow(-1, 10))
+}
+
+func TestQueryElasticPageBeyondWindow(t *testing.T) {
+	var requests []string
+	closer := mockPagedIndex(t, MaxResultWindow+20, &requests)
+	defer closer()
+
+	ctx := context.Background()
+	query := `{"query": {"match_all": {}}}`
+	sort := `[{"i": "asc"}]`
+
+	// Within the window from/size is used directly.
+	hits, total, err := QueryElasticPage(ctx, "test", "persisted",
+		query, sort, 5, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxResultWindow+20, total)
+	assert.Equal(t, []int{5, 6, 7}, pageValues(t, hits))
+	assert.Equal(t, 1, len(requests))
+	assert.Contains(t, requests[0], `"from": 5`)
+
+	// Beyond the window we switch to search_after.
+	requests = nil
+	hits, total, err = QueryElasticPage(ctx, "test", "persisted",
+		query, sort, MaxResultWindow+5, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, MaxResultWindow+20, total)
+	assert.Equal(t, []int{
+		10005, 10006, 10007, 10008, 10009,
+		10010, 10011, 10012, 10013, 10014}, pageValues(t, hits))
+	assert.Equal(t, 2, len(requests))
+	assert.NotContains(t, requests[0], `"from"`)
+	assert.Contains(t, requests[1], `"search_after"`)
+
+	// The sort is made total with the tiebreaker.
+	assert.Contains(t, requests[0], DefaultSortTiebreaker)
+
+	// Paging off the end returns what is there.
+	hits, _, err = QueryElasticPage(ctx, "test", "persisted",
+		query, sort, MaxResultWindow+15, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10015, 10016, 10017, 10018, 10019},
+		pageValues(t, hits))
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+var (
+	ErrInvalidOrgId = errors.New("Org id is not a valid index prefix")
+
+	// Index names may not contain most punctuation and must be
+	// lower case (GetIndex lower cases the org id).
+	validOrgIdRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+	// Guarded by mu
+	quarantine_index string
+)
+
+// Records written for an invalid org id are kept here with their
+// original org id so they can be triaged.
+type quarantinedRecord struct {
+	OrgId     string      `json:"org_id"`
+	Index     string      `json:"index"`
+	Id        string      `json:"id"`
+	Record    interface{} `json:"record"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// Write records for org ids which do not make a valid index name to
+// this index instead of failing the write. Disabled if empty.
+func SetQuarantineIndex(index string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	quarantine_index = index
+}
+
+func getQuarantineIndex() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return quarantine_index
+}
+
+// Redirect a record for an invalid org id to the quarantine index.
+// Returns the org id, index, id and record to write instead, or
+// ErrInvalidOrgId if there is no quarantine index. An empty org id
+// is left to checkOrgWrite.
+func quarantineInvalidOrg(org_id, index, id string, record interface{}) (
+	string, string, string, interface{}, error) {
+	if org_id == "" || validOrgIdRegex.MatchString(org_id) {
+		return org_id, index, id, record, nil
+	}
+
+	quarantine := getQuarantineIndex()
+	if quarantine == "" {
+		return "", "", "", nil, fmt.Errorf("%w: %q", ErrInvalidOrgId, org_id)
+	}
+
+	// Keep deterministic ids deterministic so retried writes are
+	// still idempotent, without colliding with other orgs.
+	quarantine_id := id
+	if id != DocIdRandom {
+		quarantine_id = MakeId(org_id + "/" + index + "/" + id)
+	}
+
+	// The root org's indexes have no prefix.
+	return "root", quarantine, quarantine_id, &quarantinedRecord{
+		OrgId:     org_id,
+		Index:     index,
+		Id:        id,
+		Record:    record,
+		Timestamp: utils.GetTime().Now().Unix(),
+	}, nil
+}
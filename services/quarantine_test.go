@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestQuarantineInvalidOrg(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	var bodies []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		paths = append(paths, r.URL.Path)
+		bodies = append(bodies, string(body))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "created"}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	record := map[string]string{"client_id": "C.1"}
+
+	// Valid org ids are written as usual.
+	err := SetElasticIndex(ctx, "O123", "persisted", "C.1", record)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/o123_persisted/_doc/C.1"}, paths)
+
+	// Without a quarantine index invalid org ids are refused.
+	paths = nil
+	err = SetElasticIndex(ctx, "bad org/..", "persisted", "C.1", record)
+	assert.ErrorIs(t, err, ErrInvalidOrgId)
+	assert.Equal(t, 0, len(paths))
+
+	SetQuarantineIndex("unknown_org")
+	defer SetQuarantineIndex("")
+
+	err = SetElasticIndex(ctx, "bad org/..", "persisted", "C.1", record)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/unknown_org/_doc/" +
+		MakeId("bad org/../persisted/C.1")}, paths)
+
+	stored := &quarantinedRecord{}
+	err = json.Unmarshal([]byte(bodies[len(bodies)-1]), stored)
+	assert.NoError(t, err)
+	assert.Equal(t, "bad org/..", stored.OrgId)
+	assert.Equal(t, "persisted", stored.Index)
+	assert.Equal(t, "C.1", stored.Id)
+	assert.Equal(t, map[string]interface{}{"client_id": "C.1"}, stored.Record)
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/Velocidex/ordereddict"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type CostCategory string
+
+const (
+	CostLow    CostCategory = "low"
+	CostMedium CostCategory = "medium"
+	CostHigh   CostCategory = "high"
+
+	// Total shard time thresholds for the cost categories.
+	costMediumShardTime = 100 * time.Millisecond
+	costHighShardTime   = time.Second
+)
+
+// A rough estimate of how expensive a query is for the cluster.
+type CostEstimate struct {
+	Category CostCategory `json:"category"`
+
+	// The time spent on all shards searching and aggregating. This
+	// is the load on the cluster - the query may return sooner as
+	// shards are searched in parallel.
+	ShardTime time.Duration `json:"shard_time"`
+	Shards    int           `json:"shards"`
+
+	// The time the profiled query took.
+	Took time.Duration `json:"took"`
+
+	// Whether the results could be served from the shard request
+	// cache when the query is repeated. Profiled queries always
+	// bypass the cache so the estimate is for a cache miss.
+	Cacheable bool `json:"cacheable"`
+}
+
+type _ProfileTime struct {
+	TimeInNanos int64 `json:"time_in_nanos"`
+}
+
+type _ProfileResponse struct {
+	Took    int64 `json:"took"`
+	Profile struct {
+		Shards []struct {
+			Searches []struct {
+				Query       []_ProfileTime `json:"query"`
+				RewriteTime int64          `json:"rewrite_time"`
+				Collector   []_ProfileTime `json:"collector"`
+			} `json:"searches"`
+			Aggregations []_ProfileTime `json:"aggregations"`
+		} `json:"shards"`
+	} `json:"profile"`
+}
+
+// Estimate the cost of a query (e.g. an aggregation before the GUI
+// runs it) by running it profiled without returning any hits. The
+// query is still executed so this is only cheaper than running it
+// when the hits themselves are expensive to fetch.
+func EstimateQueryCost(ctx context.Context,
+	org_id, index, query string) (CostEstimate, error) {
+
+	defer Instrument("EstimateQueryCost")()
+	defer Debug("EstimateQueryCost %v", index)()
+
+	body := ordereddict.NewDict()
+	err := body.UnmarshalJSON([]byte(query))
+	if err != nil {
+		return CostEstimate{}, fmt.Errorf(
+			"EstimateQueryCost: %w: %v", ErrInvalidQuery, err)
+	}
+	body.Set("size", 0)
+	body.Set("profile", true)
+
+	es, err := GetElasticClient()
+	if err != nil {
+		return CostEstimate{}, err
+	}
+	res, err := es.Search(QueryOptions{}.searchOptions(
+		ctx, es, org_id, index, json.MustMarshalString(body))...)
+	if err != nil {
+		return CostEstimate{}, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return CostEstimate{}, err
+	}
+
+	if res.IsError() {
+		return CostEstimate{}, makeReadElasticError(data)
+	}
+
+	response := &_ProfileResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return CostEstimate{}, err
+	}
+
+	// Child timings are included in their parents so only the top
+	// level is counted.
+	var shard_time int64
+	for _, shard := range response.Profile.Shards {
+		for _, search := range shard.Searches {
+			shard_time += search.RewriteTime
+			for _, item := range search.Query {
+				shard_time += item.TimeInNanos
+			}
+			for _, item := range search.Collector {
+				shard_time += item.TimeInNanos
+			}
+		}
+		for _, item := range shard.Aggregations {
+			shard_time += item.TimeInNanos
+		}
+	}
+
+	result := CostEstimate{
+		Category:  CostLow,
+		ShardTime: time.Duration(shard_time),
+		Shards:    len(response.Profile.Shards),
+		Took:      time.Duration(response.Took) * time.Millisecond,
+
+		// Queries relative to the current time are never cached.
+		Cacheable: !strings.Contains(query, `"now`),
+	}
+
+	switch {
+	case result.ShardTime >= costHighShardTime:
+		result.Category = CostHigh
+	case result.ShardTime >= costMediumShardTime:
+		result.Category = CostMedium
+	}
+
+	return result, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestEstimateQueryCost(t *testing.T) {
+	var body []byte
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+
+		// A terms aggregation over two shards which takes 1.5s of
+		// shard time. Child timings are part of their parent's.
+		w.Write([]byte(`{"took": 900, "hits": {"total": {"value": 1000000}, "hits": []},
+"profile": {"shards": [
+  {"id": "[n1][test_transient][0]",
+   "searches": [{"query": [{"type": "MatchAllDocsQuery", "time_in_nanos": 100000000}],
+                 "rewrite_time": 10000000,
+                 "collector": [{"name": "MultiCollector", "time_in_nanos": 40000000,
+                                "children": [{"time_in_nanos": 30000000}]}]}],
+   "aggregations": [{"type": "StringTermsAggregator", "time_in_nanos": 600000000,
+                     "children": [{"time_in_nanos": 500000000}]}]},
+  {"id": "[n1][test_transient][1]",
+   "searches": [{"query": [{"type": "MatchAllDocsQuery", "time_in_nanos": 100000000}],
+                 "rewrite_time": 10000000,
+                 "collector": [{"name": "MultiCollector", "time_in_nanos": 40000000}]}],
+   "aggregations": [{"type": "StringTermsAggregator", "time_in_nanos": 600000000}]}
+]}}`))
+	})
+	defer closer()
+
+	query := `{"query": {"match_all": {}}, "size": 100,
+"aggs": {"clients": {"terms": {"field": "client_id", "size": 100000}}}}`
+
+	estimate, err := EstimateQueryCost(context.Background(),
+		"test", "transient", query)
+	assert.NoError(t, err)
+	assert.Equal(t, CostEstimate{
+		Category:  CostHigh,
+		ShardTime: 1500 * time.Millisecond,
+		Shards:    2,
+		Took:      900 * time.Millisecond,
+		Cacheable: true,
+	}, estimate)
+
+	// The query was profiled without fetching any hits.
+	sent := &struct {
+		Size    int                    `json:"size"`
+		Profile bool                   `json:"profile"`
+		Aggs    map[string]interface{} `json:"aggs"`
+	}{}
+	err = json.Unmarshal(body, sent)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent.Size)
+	assert.True(t, sent.Profile)
+	assert.Equal(t, 1, len(sent.Aggs))
+
+	// Queries relative to now are not cached.
+	estimate, err = EstimateQueryCost(context.Background(), "test", "transient",
+		`{"query": {"range": {"timestamp": {"gte": "now-1d"}}}}`)
+	assert.NoError(t, err)
+	assert.False(t, estimate.Cacheable)
+
+	_, err = EstimateQueryCost(context.Background(), "test", "transient", `{`)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
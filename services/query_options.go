@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Velocidex/ordereddict"
+	opensearch "github.com/opensearch-project/opensearch-go/v2"
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+// Per call options which tune how a query is executed.
+type QueryOptions struct {
+	// Use the shard request cache for this query. This is only
+	// useful for read only aggregation queries (size 0) which are
+	// repeated often, e.g. by the GUI dashboards.
+	RequestCache bool
+
+	// Also search the same index on all registered remote clusters
+	// and merge the results.
+	CrossCluster bool
+
+	// The name of the aggregation QueryElasticAggregations extracts
+	// from the response (default "genres").
+	AggregationName string
+
+	// Drop hits scoring less than this (0 keeps all hits), e.g. to
+	// remove weak matches from keyword searches. Scores are tracked
+	// even when the query sorts by other fields so the threshold
+	// always applies.
+	MinScore float64
+
+	// What to do when some of the queried indexes do not exist
+	// (e.g. were deleted by retention).
+	MissingIndexes MissingIndexes
+}
+
+type MissingIndexes int
+
+const (
+	// Skip missing indexes when querying an index pattern (with
+	// wildcards or several indexes) and fail for a single index.
+	MissingIndexesDefault MissingIndexes = iota
+
+	// Return the results from the indexes which exist.
+	MissingIndexesIgnore
+
+	// Fail the query if any index is missing.
+	MissingIndexesFail
+)
+
+const DefaultAggregationName = "genres"
+
+func (self QueryOptions) aggregationName() string {
+	if self.AggregationName == "" {
+		return DefaultAggregationName
+	}
+	return self.AggregationName
+}
+
+// Build the search options required for this query.
+func (self QueryOptions) searchOptions(
+	ctx context.Context, es *opensearch.Client,
+	org_id, index, query string) []func(*opensearchapi.SearchRequest) {
+	options := []func(*opensearchapi.SearchRequest){
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(getSearchIndexes(org_id, index, self.CrossCluster)...),
+		es.Search.WithBody(strings.NewReader(self.body(query))),
+		es.Search.WithPretty(),
+	}
+
+	if self.RequestCache {
+		options = append(options, es.Search.WithRequestCache(true))
+	}
+
+	if self.ignoreUnavailable(index) {
+		options = append(options,
+			es.Search.WithIgnoreUnavailable(true),
+			es.Search.WithAllowNoIndices(true))
+	}
+	return options
+}
+
+func (self QueryOptions) ignoreUnavailable(index string) bool {
+	switch self.MissingIndexes {
+	case MissingIndexesIgnore:
+		return true
+	case MissingIndexesFail:
+		return false
+	}
+	return isIndexPattern(index)
+}
+
+func isIndexPattern(index string) bool {
+	return strings.ContainsAny(index, "*,")
+}
+
+func (self QueryOptions) body(query string) string {
+	if self.MinScore <= 0 {
+		return query
+	}
+
+	// Leave a malformed query for the server to reject.
+	parsed := ordereddict.NewDict()
+	err := parsed.UnmarshalJSON([]byte(query))
+	if err != nil {
+		return query
+	}
+
+	parsed.Set("min_score", self.MinScore)
+	parsed.Set("track_scores", true)
+
+	serialized, err := parsed.MarshalJSON()
+	if err != nil {
+		return query
+	}
+	return string(serialized)
+}
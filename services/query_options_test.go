@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	opensearch "github.com/opensearch-project/opensearch-go/v2"
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// Install a client which talks to a mock server for the duration of
+// the test.
+func installMockClient(t *testing.T, handler http.HandlerFunc) func() {
+	server := httptest.NewServer(handler)
+
+	client, err := opensearch.NewClient(opensearch.Config{
+		Addresses: []string{server.URL},
+	})
+	assert.NoError(t, err)
+
+	old_client, _ := GetElasticClient()
+	SetElasticClient(client)
+
+	return func() {
+		SetElasticClient(old_client)
+		server.Close()
+	}
+}
+
+func TestQueryRequestCache(t *testing.T) {
+	var mu sync.Mutex
+	var request_cache []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		request_cache = append(request_cache, r.URL.Query().Get("request_cache"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hits": {"hits": []}, "aggregations": {"genres": {"buckets": [{"key": "A", "doc_count": 1}]}}}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	query := `{"size": 0, "aggs": {"genres": {"terms": {"field": "doc_type"}}}}`
+
+	// Not set by default.
+	hits, err := QueryElasticAggregations(ctx, "test", "persisted", query)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, hits)
+
+	// Opt in to the request cache.
+	hits, err = QueryElasticAggregationsWithOptions(ctx, "test", "persisted",
+		query, QueryOptions{RequestCache: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, hits)
+
+	assert.Equal(t, []string{"", "true"}, request_cache)
+}
+
+func TestQueryCrossCluster(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hits": {"total": {"value": 2}, "hits": [{"_source": {"A": 1}}, {"_source": {"A": 2}}]}}`))
+	})
+	defer closer()
+
+	SetRemoteClusters([]string{"archive"})
+	defer SetRemoteClusters(nil)
+
+	ctx := context.Background()
+	query := `{"query": {"match_all": {}}}`
+
+	// Remote clusters are only searched when requested.
+	_, _, err := QueryElasticRaw(ctx, "test", "persisted", query)
+	assert.NoError(t, err)
+
+	hits, total, err := QueryElasticRawWithOptions(ctx, "test", "persisted",
+		query, QueryOptions{CrossCluster: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 2, len(hits))
+
+	assert.Equal(t, []string{
+		"/test_persisted/_search",
+		"/test_persisted,archive:test_persisted/_search",
+	}, paths)
+}
+
+func TestGetIndexes(t *testing.T) {
+	assert.Equal(t, []string{"o1_monitoring-*"},
+		GetIndexes("O1", "monitoring-*"))
+	assert.Equal(t, []string{"monitoring-*"},
+		GetIndexes("root", "monitoring-*"))
+	assert.Equal(t, []string{"o1_monitoring-*", "-o1_monitoring-000001"},
+		GetIndexes("O1", "monitoring-*, -monitoring-000001"))
+
+	SetRemoteClusters([]string{"archive"})
+	defer SetRemoteClusters(nil)
+
+	assert.Equal(t, []string{
+		"o1_monitoring-*", "-o1_monitoring-000001",
+		"archive:o1_monitoring-*", "-archive:o1_monitoring-000001",
+	}, getSearchIndexes("O1", "monitoring-*,-monitoring-000001", true))
+}
+
+func TestQueryMinScore(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+
+	// Applies min_score like the server does.
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		data, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+
+		request := &struct {
+			MinScore float64 `json:"min_score"`
+		}{}
+		json.Unmarshal(data, request)
+
+		var hits []string
+		for i, score := range []float64{2.5, 1.2, 0.3} {
+			if score < request.MinScore {
+				continue
+			}
+			hits = append(hits, fmt.Sprintf(
+				`{"_id": "%d", "_score": %v, "_source": {}}`, i, score))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hits": {"hits": [` + strings.Join(hits, ",") + `]}}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	query := `{"query": {"match": {"hostname": "host"}}, "sort": [{"_score": "desc"}]}`
+
+	// All hits by default and the query is sent as is.
+	hits, err := QueryElasticScored(ctx, "test", "persisted", query)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(hits))
+	assert.Equal(t, query, bodies[0])
+
+	hits, err = QueryElasticScoredWithOptions(ctx, "test", "persisted",
+		query, QueryOptions{MinScore: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(hits))
+	for _, hit := range hits {
+		assert.True(t, hit.Score >= 1)
+	}
+
+	// The sort is kept and scores are tracked.
+	assert.Contains(t, bodies[1], `"sort":[{"_score":"desc"}]`)
+	assert.Contains(t, bodies[1], `"min_score":1`)
+	assert.Contains(t, bodies[1], `"track_scores":true`)
+}
+
+func TestQueryMissingIndexes(t *testing.T) {
+	var mu sync.Mutex
+	var params []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		params = append(params, r.URL.Path+" "+
+			r.URL.Query().Get("ignore_unavailable")+" "+
+			r.URL.Query().Get("allow_no_indices"))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hits": {"total": {"value": 1}, "hits": [{"_source": {"A": 1}}]}}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	query := `{"query": {"match_all": {}}}`
+
+	for _, options := range []QueryOptions{
+		// Patterns skip missing indexes by default but single
+		// indexes do not.
+		{}, {MissingIndexes: MissingIndexesIgnore},
+		{MissingIndexes: MissingIndexesFail},
+	} {
+		for _, index := range []string{"persisted", "monitoring-*",
+			"transient,archived"} {
+			_, _, err := QueryElasticRawWithOptions(
+				ctx, "test", index, query, options)
+			assert.NoError(t, err)
+		}
+	}
+
+	assert.Equal(t, []string{
+		"/test_persisted/_search  ",
+		"/test_monitoring-*/_search true true",
+		"/test_transient,test_archived/_search true true",
+
+		"/test_persisted/_search true true",
+		"/test_monitoring-*/_search true true",
+		"/test_transient,test_archived/_search true true",
+
+		"/test_persisted/_search  ",
+		"/test_monitoring-*/_search  ",
+		"/test_transient,test_archived/_search  ",
+	}, params)
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+const (
+	defaultRefreshTimeout = 30 * time.Second
+)
+
+var (
+	ErrRefreshTimeout = errors.New("Index refresh timed out")
+
+	refresh_timeout = defaultRefreshTimeout
+)
+
+// Set the maximum time we wait for an index refresh. A zero timeout
+// restores the default.
+func SetRefreshTimeout(timeout time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if timeout == 0 {
+		timeout = defaultRefreshTimeout
+	}
+	refresh_timeout = timeout
+}
+
+func getRefreshTimeout() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return refresh_timeout
+}
+
+// Refresh the indexes so recent writes are visible. An unresponsive
+// cluster results in ErrRefreshTimeout rather than blocking the
+// caller forever.
+func refreshIndexes(ctx context.Context, indexes ...string) error {
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	timeout := getRefreshTimeout()
+	sub_ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := opensearchapi.IndicesRefreshRequest{
+		Index: indexes,
+	}.Do(sub_ctx, client)
+	if err != nil {
+		// Only our own deadline is a refresh timeout - the caller's
+		// context is reported as is.
+		if ctx.Err() == nil &&
+			errors.Is(sub_ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %v after %v", ErrRefreshTimeout,
+				indexes, timeout)
+		}
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return makeReadElasticError(data)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshTimeout(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// Simulate an unresponsive cluster.
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	})
+	defer closer()
+
+	SetRefreshTimeout(100 * time.Millisecond)
+	defer SetRefreshTimeout(0)
+
+	start := time.Now()
+	err := FlushIndex(context.Background(), "test", "persisted")
+	assert.True(t, errors.Is(err, ErrRefreshTimeout), err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// A cancelled caller context is not reported as a timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = FlushIndex(ctx, "test", "persisted")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrRefreshTimeout))
+}
@@ -1,29 +1,192 @@
 package services
 
 import (
+	"errors"
 	"regexp"
+	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"www.velocidex.com/golang/velociraptor/utils"
 )
 
 // Retry calls to the backend
 
+const (
+	defaultRetryBudget          = 100
+	defaultRetryBudgetPerSecond = 10
+
+	maxRetryAttempts = 10
+)
+
 var (
 	retriableErrors = regexp.MustCompile("version conflict")
+
+	retryDelay = time.Second
+
+	// All operations share the same retry budget so when the
+	// backend is struggling, retries are throttled collectively.
+	gRetryBudget = newRetryBudget(
+		defaultRetryBudget, defaultRetryBudgetPerSecond)
+
+	retryBudgetSaturation = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "opensearch_retry_budget_saturation",
+			Help: "Fraction of the retry budget currently used (0-1).",
+		})
+
+	retryBudgetExhausted = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "opensearch_retry_budget_exhausted",
+			Help: "Number of retries abandoned because the retry budget was exhausted.",
+		})
 )
 
-func retry(cb func() error) (err error) {
-	for i := 0; i < 10; i++ {
-		err = cb()
+// A token bucket which limits the rate of retries.
+type retryBudget struct {
+	mu sync.Mutex
+
+	tokens     float64
+	max_tokens float64
+	per_second float64
+	last       time.Time
+}
+
+func newRetryBudget(max_tokens, per_second int) *retryBudget {
+	return &retryBudget{
+		tokens:     float64(max_tokens),
+		max_tokens: float64(max_tokens),
+		per_second: float64(per_second),
+		last:       utils.GetTime().Now(),
+	}
+}
+
+// Take a token from the budget. Returns false if the budget is
+// exhausted and the caller should not retry.
+func (self *retryBudget) take() bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	now := utils.GetTime().Now()
+	self.tokens += now.Sub(self.last).Seconds() * self.per_second
+	if self.tokens > self.max_tokens {
+		self.tokens = self.max_tokens
+	}
+	self.last = now
+
+	if self.tokens < 1 {
+		retryBudgetSaturation.Set(1)
+		return false
+	}
+
+	self.tokens--
+	retryBudgetSaturation.Set(1 - self.tokens/self.max_tokens)
+	return true
+}
+
+// Configure the shared retry budget: at most max_tokens retries may
+// be issued in a burst, refilled at per_second.
+func SetRetryBudget(max_tokens, per_second int) {
+	if max_tokens <= 0 {
+		max_tokens = defaultRetryBudget
+	}
+
+	if per_second <= 0 {
+		per_second = defaultRetryBudgetPerSecond
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	gRetryBudget = newRetryBudget(max_tokens, per_second)
+}
+
+func getRetryBudget() *retryBudget {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return gRetryBudget
+}
+
+// The error of an operation which was retried, describing how it
+// failed so callers can tell transient failures from permanent ones.
+type RetryError struct {
+	Err error
+
+	// The number of times the operation was attempted.
+	Attempts int
+
+	// The error is transient and the operation may succeed later
+	// (it was retried until the retries or the retry budget ran
+	// out). Permanent errors are not retried.
+	Retryable bool
+
+	// The HTTP status of the last failure, or 0 if it did not come
+	// from the cluster.
+	LastStatus int
+}
+
+func (self *RetryError) Error() string {
+	return self.Err.Error()
+}
+
+func (self *RetryError) Unwrap() error {
+	return self.Err
+}
+
+// Get the retry metadata of an error returned by an operation which
+// is retried (e.g. SetElasticIndex).
+func GetRetryError(err error) (*RetryError, bool) {
+	var retry_err *RetryError
+	if errors.As(err, &retry_err) {
+		return retry_err, true
+	}
+	return nil, false
+}
+
+// Is the error transient? Errors without retry metadata are assumed
+// to be permanent.
+func IsRetryable(err error) bool {
+	retry_err, ok := GetRetryError(err)
+	return ok && retry_err.Retryable
+}
+
+func isRetryableError(err error) bool {
+	// Retrying will not help until the service is started.
+	return !errors.Is(err, ErrClientNotInitialized) &&
+		retriableErrors.MatchString(err.Error())
+}
+
+func retry(cb func() error) error {
+	result := &RetryError{}
+	for result.Attempts < maxRetryAttempts {
+		err := cb()
+		result.Attempts++
 		if err == nil {
-			return err
+			return nil
+		}
+
+		result.Err = err
+		result.Retryable = isRetryableError(err)
+		result.LastStatus = 0
+
+		var elastic_err *ElasticError
+		if errors.As(err, &elastic_err) {
+			result.LastStatus = elastic_err.Status
+		}
+
+		if !result.Retryable {
+			return result
 		}
 
-		if !retriableErrors.MatchString(err.Error()) {
-			return err
+		if !getRetryBudget().take() {
+			retryBudgetExhausted.Inc()
+			return result
 		}
 
-		time.Sleep(time.Second)
+		time.Sleep(retryDelay)
 	}
 
-	return err
+	return result
 }
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBudgetCapsRetries(t *testing.T) {
+	old_delay := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = old_delay }()
+
+	// Allow 5 retries and effectively no refill during the test.
+	SetRetryBudget(5, 1)
+	defer SetRetryBudget(defaultRetryBudget, defaultRetryBudgetPerSecond)
+	getRetryBudget().per_second = 0
+
+	var calls int64
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := retry(func() error {
+				atomic.AddInt64(&calls, 1)
+				return errors.New("version conflict")
+			})
+			assert.Error(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// Each operation is tried once, but only 5 retries are allowed
+	// in total.
+	assert.Equal(t, int64(20+5), atomic.LoadInt64(&calls))
+}
+
+func TestRetryErrorMetadata(t *testing.T) {
+	old_delay := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = old_delay }()
+
+	var mu sync.Mutex
+	var calls int
+	response := ""
+	status := 0
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	})
+	defer closer()
+
+	ctx := context.Background()
+
+	// A malformed document is a permanent error so it is not
+	// retried.
+	status = http.StatusBadRequest
+	response = `{"error": {"type": "mapper_parsing_exception", "reason": "failed to parse"}, "status": 400}`
+	err := SetElasticIndex(ctx, "test", "persisted", "doc", map[string]int{"A": 1})
+	assert.Error(t, err)
+	assert.False(t, IsRetryable(err))
+
+	retry_err, ok := GetRetryError(err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, retry_err.Attempts)
+	assert.Equal(t, http.StatusBadRequest, retry_err.LastStatus)
+	assert.Equal(t, 1, calls)
+
+	var elastic_err *ElasticError
+	assert.True(t, errors.As(err, &elastic_err))
+	assert.Equal(t, "mapper_parsing_exception", elastic_err.Type)
+
+	// A conflict is transient and retried until we give up.
+	calls = 0
+	status = http.StatusConflict
+	response = `{"error": {"type": "version_conflict_engine_exception", "reason": "version conflict"}, "status": 409}`
+	err = SetElasticIndex(ctx, "test", "persisted", "doc", map[string]int{"A": 1})
+	assert.Error(t, err)
+	assert.True(t, IsRetryable(err))
+
+	retry_err, ok = GetRetryError(err)
+	assert.True(t, ok)
+	assert.Equal(t, maxRetryAttempts, retry_err.Attempts)
+	assert.Equal(t, http.StatusConflict, retry_err.LastStatus)
+	assert.Equal(t, maxRetryAttempts, calls)
+
+	// Errors from operations which are not retried carry no
+	// metadata.
+	assert.False(t, IsRetryable(errors.New("version conflict")))
+}
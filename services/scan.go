@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// The number of hits fetched by each request of ScanIndex.
+var scanPageSize = 1000
+
+// A field to sort a scan by.
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// Build a JSON sort array from the fields. The sort tiebreaker is
+// added so search_after never skips or repeats documents.
+func buildSortClause(sort []SortField) string {
+	var parts []string
+	has_tiebreaker := false
+	tiebreaker := getSortTiebreaker()
+
+	for _, field := range sort {
+		order := "asc"
+		if field.Descending {
+			order = "desc"
+		}
+		parts = append(parts, json.Format(`{%q: %q}`, field.Field, order))
+
+		if field.Field == tiebreaker {
+			has_tiebreaker = true
+		}
+	}
+
+	if !has_tiebreaker {
+		parts = append(parts, json.Format(`{%q: "asc"}`, tiebreaker))
+	}
+
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// Call cb for every document matching the query in sort order (index
+// order if no sort fields are given). Unlike QueryChan the callback
+// is called synchronously so at most one page is held in memory and
+// the next page is only fetched once the callback has processed the
+// previous one - a slow callback simply slows the scan. An error
+// from the callback stops the scan and is returned.
+//
+// The query must not contain its own sort, size or search_after
+// clauses. The scan is not a point in time snapshot so documents
+// changed while scanning may or may not be seen.
+func ScanIndex(ctx context.Context,
+	org_id, index, query string, sort []SortField,
+	cb func(Result) error) error {
+
+	defer Instrument("ScanIndex")()
+	defer Debug("ScanIndex %v", index)()
+
+	if len(sort) == 0 {
+		sort = []SortField{{Field: "_doc"}}
+	}
+	sort_clause := buildSortClause(sort)
+
+	var search_after []json.RawMessage
+	for {
+		paging := fmt.Sprintf(`"sort": %s, "size": %d`,
+			sort_clause, scanPageSize)
+		if search_after != nil {
+			paging += `, "search_after": ` + json.MustMarshalString(search_after)
+		}
+
+		hits, _, err := queryElasticHits(ctx, org_id, index,
+			withPaging(query, paging), QueryOptions{})
+		if err != nil {
+			return err
+		}
+
+		for _, hit := range hits {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			err = cb(Result{JSON: hit.Source, Id: hit.Id})
+			if err != nil {
+				return err
+			}
+		}
+
+		// A short page means there is nothing more.
+		if len(hits) < scanPageSize {
+			return nil
+		}
+
+		search_after = hits[len(hits)-1].Sort
+		if len(search_after) == 0 {
+			return nil
+		}
+	}
+}
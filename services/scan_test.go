@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestScanIndex(t *testing.T) {
+	old_page_size := scanPageSize
+	scanPageSize = 10
+	defer func() { scanPageSize = old_page_size }()
+
+	var requests []string
+	closer := mockPagedIndex(t, 25, &requests)
+	defer closer()
+
+	ctx := context.Background()
+	query := `{"query": {"match_all": {}}}`
+	sort := []SortField{{Field: "i"}}
+
+	var values []int
+	err := ScanIndex(ctx, "test", "persisted", query, sort,
+		func(row Result) error {
+			// Only one page is requested ahead of the callback.
+			assert.Equal(t, len(values)/10+1, len(requests))
+			values = append(values, pageValues(t, []json.RawMessage{row.JSON})...)
+			return nil
+		})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 25, len(values))
+	for i, value := range values {
+		assert.Equal(t, i, value)
+	}
+	assert.Equal(t, 3, len(requests))
+	assert.Contains(t, requests[0], `"sort": [{"i": "asc"}, {"_id": "asc"}]`)
+	assert.Contains(t, requests[1], `"search_after": [9,"9"]`)
+
+	// An error from the callback stops the scan.
+	requests = nil
+	stop := errors.New("stop")
+	count := 0
+	err = ScanIndex(ctx, "test", "persisted", query, sort,
+		func(row Result) error {
+			count++
+			if count == 15 {
+				return stop
+			}
+			return nil
+		})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 15, count)
+	assert.Equal(t, 2, len(requests))
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+const (
+	// Written documents are stamped with the schema version in this
+	// field so readers can tell documents of different shapes apart
+	// during a rolling upgrade.
+	SchemaVersionField = "schema_version"
+
+	// Documents written before they were stamped.
+	SchemaVersionUnversioned = 0
+
+	// Bump this when the shape of a document changes, and teach the
+	// readers of the document to handle the previous version:
+	//
+	// 1: Documents are stamped with their schema version.
+	CurrentSchemaVersion = 1
+)
+
+var (
+	schemaVersionKey = []byte(`"` + SchemaVersionField + `":`)
+)
+
+// Can a reader of this build make sense of a document with this
+// schema version? Documents from a newer writer may have a shape we
+// do not know about.
+func IsSchemaVersionSupported(version int) bool {
+	return version <= CurrentSchemaVersion
+}
+
+// Add the current schema version to a serialized record, unless the
+// record sets its own version. Records which are not JSON objects
+// are left alone.
+func stampSchemaVersion(serialized []byte) []byte {
+	trimmed := bytes.TrimSpace(serialized)
+	if len(trimmed) < 2 || trimmed[0] != '{' ||
+		hasSchemaVersion(trimmed) {
+		return serialized
+	}
+
+	result := make([]byte, 0, len(trimmed)+len(schemaVersionKey)+4)
+	result = append(result, '{')
+	result = append(result, schemaVersionKey...)
+	result = strconv.AppendInt(result, CurrentSchemaVersion, 10)
+
+	rest := bytes.TrimSpace(trimmed[1:])
+	if len(rest) > 0 && rest[0] != '}' {
+		result = append(result, ',')
+	}
+	return append(result, rest...)
+}
+
+// Does the JSON object set the schema version itself? Only top level
+// keys count - a nested object (e.g. a collected row) may have a
+// schema_version field of its own.
+func hasSchemaVersion(serialized []byte) bool {
+	// Most records do not mention the field at all.
+	if !bytes.Contains(serialized, schemaVersionKey) {
+		return false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(serialized))
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('{') {
+		return false
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+
+		if token == SchemaVersionField {
+			return true
+		}
+
+		// Skip the value.
+		var value json.RawMessage
+		err = decoder.Decode(&value)
+		if err != nil {
+			return false
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStampSchemaVersion(t *testing.T) {
+	var body []byte
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "created"}`))
+	})
+	defer closer()
+
+	err := SetElasticIndex(context.Background(),
+		"test", "persisted", "id", map[string]string{"client_id": "C.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"schema_version":1,"client_id":"C.1"}`, string(body))
+
+	for _, c := range []struct {
+		serialized, expected string
+	}{
+		{`{}`, `{"schema_version":1}`},
+
+		// Records may set their own version.
+		{`{"schema_version":0,"a":1}`, `{"schema_version":0,"a":1}`},
+		{`{"a":1, "schema_version": 2}`, `{"a":1, "schema_version": 2}`},
+
+		// A nested schema_version is part of the record's data.
+		{`{"row":{"schema_version":3}}`,
+			`{"schema_version":1,"row":{"schema_version":3}}`},
+		{`{"a":"\"schema_version\":"}`,
+			`{"schema_version":1,"a":"\"schema_version\":"}`},
+
+		// Only objects are stamped.
+		{`[1,2]`, `[1,2]`},
+		{`"hello"`, `"hello"`},
+	} {
+		assert.Equal(t, c.expected,
+			string(stampSchemaVersion([]byte(c.serialized))))
+	}
+
+	assert.True(t, IsSchemaVersionSupported(SchemaVersionUnversioned))
+	assert.True(t, IsSchemaVersionSupported(CurrentSchemaVersion))
+	assert.False(t, IsSchemaVersionSupported(CurrentSchemaVersion+1))
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type DecayType string
+
+const (
+	DecayGauss  DecayType = "gauss"
+	DecayExp    DecayType = "exp"
+	DecayLinear DecayType = "linear"
+
+	functionScoreQuery = `
+{
+  "query": {
+    "function_score": {
+      "query": %s,
+      "functions": [%s],
+      "score_mode": "multiply",
+      "boost_mode": "multiply"
+    }
+  },
+  "sort": [{"_score": "desc"}],
+  "size": %d
+}
+`
+)
+
+// A search hit with its relevance score.
+type ScoredHit struct {
+	Id     string
+	Score  float64
+	Source json.RawMessage
+}
+
+// A scoring function which decays the score of documents as the
+// numeric field moves away from origin. A document scale away from
+// the origin scores decay (e.g. 0.5) times as much as one at the
+// origin. For example, to prefer recent documents use the current
+// time as the origin on the timestamp field.
+func DecayFunction(decay_type DecayType,
+	field string, origin, scale int64, decay float64) string {
+	return json.Format(`{%q: {%q: {"origin": %q, "scale": %q, "decay": %q}}}`,
+		decay_type, field, origin, scale, decay)
+}
+
+// A scoring function which multiplies the score by the value of the
+// numeric field (e.g. a severity), times factor. Documents missing
+// the field use the missing value instead.
+func FieldValueFactorFunction(field string, factor, missing float64) string {
+	return json.Format(
+		`{"field_value_factor": {"field": %q, "factor": %q, "missing": %q}}`,
+		field, factor, missing)
+}
+
+// Build a search which scores the documents matching query (a query
+// clause like {"match_all": {}}) by multiplying the scoring
+// functions and sorts them by score, best first.
+func FunctionScoreQuery(query string, size int, functions ...string) string {
+	return fmt.Sprintf(functionScoreQuery,
+		query, strings.Join(functions, ", "), size)
+}
+
+// Run a query and return the hits with their scores. The query
+// should be sorted by _score, e.g. built with FunctionScoreQuery.
+func QueryElasticScored(
+	ctx context.Context,
+	org_id, index, query string) ([]ScoredHit, error) {
+	return QueryElasticScoredWithOptions(
+		ctx, org_id, index, query, QueryOptions{})
+}
+
+func QueryElasticScoredWithOptions(
+	ctx context.Context,
+	org_id, index, query string,
+	options QueryOptions) ([]ScoredHit, error) {
+
+	defer Instrument("QueryElasticScored")()
+	defer Debug("QueryElasticScored %v", index)()
+
+	hits, _, err := queryElasticHits(ctx, org_id, index, query, options)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ScoredHit, 0, len(hits))
+	for _, hit := range hits {
+		result = append(result, ScoredHit{
+			Id:     hit.Id,
+			Score:  hit.Score,
+			Source: hit.Source,
+		})
+	}
+
+	return result, nil
+}
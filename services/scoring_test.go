@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestFunctionScoreQuery(t *testing.T) {
+	var body []byte
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hits": {"total": {"value": 1}, "hits": [
+  {"_id": "new", "_score": 0.9, "_source": {"timestamp": 1000}}]}}`))
+	})
+	defer closer()
+
+	query := FunctionScoreQuery(`{"match_all": {}}`, 10,
+		DecayFunction(DecayGauss, "timestamp", 1000, 100, 0.5),
+		FieldValueFactorFunction("severity", 1.5, 1))
+
+	hits, err := QueryElasticScored(context.Background(),
+		"org1", "persisted", query)
+	assert.NoError(t, err)
+
+	// The cluster is asked to score with both functions and sort
+	// by the score. The ranking itself is checked against a real
+	// cluster in servicestest.
+	sent := &struct {
+		Query struct {
+			FunctionScore struct {
+				Query     map[string]interface{} `json:"query"`
+				Functions []struct {
+					Gauss map[string]struct {
+						Origin int64   `json:"origin"`
+						Scale  int64   `json:"scale"`
+						Decay  float64 `json:"decay"`
+					} `json:"gauss"`
+					FieldValueFactor *struct {
+						Field   string  `json:"field"`
+						Factor  float64 `json:"factor"`
+						Missing float64 `json:"missing"`
+					} `json:"field_value_factor"`
+				} `json:"functions"`
+				ScoreMode string `json:"score_mode"`
+				BoostMode string `json:"boost_mode"`
+			} `json:"function_score"`
+		} `json:"query"`
+		Sort []map[string]string `json:"sort"`
+		Size int                 `json:"size"`
+	}{}
+	err = json.Unmarshal(body, sent)
+	assert.NoError(t, err)
+
+	function_score := sent.Query.FunctionScore
+	assert.Contains(t, function_score.Query, "match_all")
+	assert.Equal(t, "multiply", function_score.ScoreMode)
+	assert.Equal(t, "multiply", function_score.BoostMode)
+	assert.Equal(t, 2, len(function_score.Functions))
+
+	decay, pres := function_score.Functions[0].Gauss["timestamp"]
+	assert.True(t, pres)
+	assert.Equal(t, int64(1000), decay.Origin)
+	assert.Equal(t, int64(100), decay.Scale)
+	assert.Equal(t, 0.5, decay.Decay)
+
+	factor := function_score.Functions[1].FieldValueFactor
+	assert.NotNil(t, factor)
+	assert.Equal(t, "severity", factor.Field)
+	assert.Equal(t, 1.5, factor.Factor)
+	assert.Equal(t, float64(1), factor.Missing)
+
+	assert.Equal(t, []map[string]string{{"_score": "desc"}}, sent.Sort)
+	assert.Equal(t, 10, sent.Size)
+
+	// The hits carry their scores.
+	assert.Equal(t, 1, len(hits))
+	assert.Equal(t, "new", hits[0].Id)
+	assert.Equal(t, 0.9, hits[0].Score)
+	assert.Equal(t, `{"timestamp": 1000}`, string(hits[0].Source))
+}
@@ -0,0 +1,947 @@
+package servicestest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	"www.velocidex.com/golang/cloudvelo/testsuite"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+type ElasticTestSuite struct {
+	*testsuite.CloudTestSuite
+}
+
+func (self *ElasticTestSuite) TestListIndexesMatching() {
+	// The test suite removes all "test*" indexes on setup.
+	orgs := []string{"test", "test2", "test3"}
+	for _, org_id := range orgs {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			org_id, "persisted", "doc", map[string]string{
+				"doc_type": "test",
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	indexes, err := cvelo_services.ListIndexesMatching(
+		self.Ctx, "test*_persisted")
+	assert.NoError(self.T(), err)
+
+	sort.Strings(indexes)
+	assert.Equal(self.T(), []string{
+		"test2_persisted", "test3_persisted", "test_persisted"}, indexes)
+}
+
+func (self *ElasticTestSuite) TestQueryChanNoSortField() {
+	for i := 0; i < 25; i++ {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", fmt.Sprintf("doc%02d", i),
+			map[string]string{
+				"doc_type": "test",
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	// Page through the index in small pages without an explicit sort
+	// field - we should see all the documents exactly once.
+	output_chan, err := cvelo_services.QueryChan(self.Ctx,
+		self.ConfigObj.VeloConf(), 10, "test", "persisted",
+		`{"query": {"match": {"doc_type": "test"}}}`,
+		cvelo_services.NoSortField)
+	assert.NoError(self.T(), err)
+
+	count := 0
+	for range output_chan {
+		count++
+	}
+	assert.Equal(self.T(), 25, count)
+}
+
+func (self *ElasticTestSuite) TestQueryChanIdenticalSortValues() {
+	for i := 0; i < 25; i++ {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", fmt.Sprintf("doc%02d", i),
+			map[string]interface{}{
+				"doc_type":  "test",
+				"client_id": fmt.Sprintf("C.%02d", i),
+				"timestamp": 10,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	// All documents have the same timestamp so the page boundaries
+	// fall within a run of identical sort values.
+	output_chan, err := cvelo_services.QueryChan(self.Ctx,
+		self.ConfigObj.VeloConf(), 10, "test", "persisted",
+		`{"query": {"match": {"doc_type": "test"}}}`, "timestamp")
+	assert.NoError(self.T(), err)
+
+	seen := make(map[string]int)
+	for hit := range output_chan {
+		item := &struct {
+			ClientId string `json:"client_id"`
+		}{}
+		err = json.Unmarshal(hit, item)
+		assert.NoError(self.T(), err)
+		seen[item.ClientId]++
+	}
+
+	// Every document is seen exactly once.
+	assert.Equal(self.T(), 25, len(seen))
+	for client_id, count := range seen {
+		assert.Equal(self.T(), 1, count, client_id)
+	}
+}
+
+func (self *ElasticTestSuite) TestWaitForIndexReady() {
+	// Writing the first document creates the index from the template.
+	err := cvelo_services.SetElasticIndex(self.Ctx,
+		"test", "persisted", "doc", map[string]string{
+			"doc_type": "test",
+		})
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.WaitForIndexReady(self.Ctx,
+		cvelo_services.GetIndex("test", "persisted"),
+		cvelo_services.IndexStatusYellow)
+	assert.NoError(self.T(), err)
+
+	records, total, err := cvelo_services.QueryElasticRaw(self.Ctx,
+		"test", "persisted", `{"query": {"match": {"doc_type": "test"}}}`)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, total)
+	assert.Equal(self.T(), 1, len(records))
+}
+
+func (self *ElasticTestSuite) TestQueryMissingField() {
+	for _, client_id := range []string{"C.1", "C.2", "C.3"} {
+		record := map[string]interface{}{
+			"doc_type":  "clients",
+			"client_id": client_id,
+		}
+
+		// Only C.2 has labels
+		if client_id == "C.2" {
+			record["labels"] = []string{"Foo"}
+		}
+
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", client_id, record)
+		assert.NoError(self.T(), err)
+	}
+
+	records, total, err := cvelo_services.QueryElasticMissingField(
+		self.Ctx, "test", "persisted", "labels")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 2, total)
+
+	client_ids := []string{}
+	for _, record := range records {
+		item := &struct {
+			ClientId string `json:"client_id"`
+		}{}
+		err = json.Unmarshal(record, item)
+		assert.NoError(self.T(), err)
+		client_ids = append(client_ids, item.ClientId)
+	}
+	sort.Strings(client_ids)
+	assert.Equal(self.T(), []string{"C.1", "C.3"}, client_ids)
+}
+
+func (self *ElasticTestSuite) TestUpdateIndexNoop() {
+	err := cvelo_services.SetElasticIndex(self.Ctx,
+		"test", "persisted", "C.1", map[string]string{
+			"doc_type": "clients",
+			"hostname": "Host",
+		})
+	assert.NoError(self.T(), err)
+
+	res, err := cvelo_services.UpdateIndexWithResult(self.Ctx,
+		"test", "persisted", "C.1", `{"doc": {"hostname": "Host2"}}`)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), "updated", res.Result)
+	version := res.Version
+
+	// Writing the same value again does not bump the version.
+	res, err = cvelo_services.UpdateIndexWithResult(self.Ctx,
+		"test", "persisted", "C.1", `{"doc": {"hostname": "Host2"}}`)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), cvelo_services.UpdateResultNoop, res.Result)
+	assert.Equal(self.T(), version, res.Version)
+}
+
+func (self *ElasticTestSuite) TestSetElasticIndexWithMeta() {
+	record := map[string]string{
+		"doc_type": "clients",
+		"hostname": "Host",
+	}
+
+	meta, err := cvelo_services.SetElasticIndexWithMeta(self.Ctx,
+		"test", "persisted", "C.1", record)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), int64(1), meta.Version)
+
+	meta, err = cvelo_services.SetElasticIndexWithMeta(self.Ctx,
+		"test", "persisted", "C.1", record)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), "test_persisted", meta.Index)
+	assert.Equal(self.T(), "C.1", meta.Id)
+	assert.Equal(self.T(), int64(2), meta.Version)
+
+	client, err := cvelo_services.GetElasticClient()
+	assert.NoError(self.T(), err)
+
+	res, err := opensearchapi.GetRequest{
+		Index:      meta.Index,
+		DocumentID: "C.1",
+	}.Do(self.Ctx, client)
+	assert.NoError(self.T(), err)
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	assert.NoError(self.T(), err)
+
+	current := &cvelo_services.DocumentMeta{}
+	err = json.Unmarshal(data, current)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), meta, current)
+
+	// The document can be updated conditionally without reading it.
+	update_res, err := opensearchapi.UpdateRequest{
+		Index:         meta.Index,
+		DocumentID:    "C.1",
+		Body:          strings.NewReader(`{"doc": {"hostname": "Host2"}}`),
+		IfSeqNo:       &meta.SeqNo,
+		IfPrimaryTerm: &meta.PrimaryTerm,
+	}.Do(self.Ctx, client)
+	assert.NoError(self.T(), err)
+	update_res.Body.Close()
+	assert.False(self.T(), update_res.IsError())
+}
+
+func (self *ElasticTestSuite) TestTagByQuery() {
+	for _, client_id := range []string{"C.1", "C.2", "C.3"} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", client_id, map[string]interface{}{
+				"doc_type":  "clients",
+				"client_id": client_id,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	query := `{"query": {"terms": {"client_id": ["C.1", "C.2"]}}}`
+	count, err := cvelo_services.TagByQuery(self.Ctx,
+		"test", "persisted", query, "reviewed")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 2, count)
+
+	// Tagging again does nothing.
+	count, err = cvelo_services.TagByQuery(self.Ctx,
+		"test", "persisted", query, "reviewed")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 0, count)
+
+	records, total, err := cvelo_services.QueryElasticRaw(self.Ctx,
+		"test", "persisted", `{"query": {"term": {"tags": "reviewed"}}}`)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 2, total)
+
+	for _, record := range records {
+		item := &struct {
+			Tags []string `json:"tags"`
+		}{}
+		err = json.Unmarshal(record, item)
+		assert.NoError(self.T(), err)
+		assert.Equal(self.T(), []string{"reviewed"}, item.Tags)
+	}
+}
+
+func (self *ElasticTestSuite) TestEnsureIndexSorted() {
+	index := cvelo_services.GetIndex("test", "transient")
+	err := cvelo_services.EnsureIndex(self.Ctx, index)
+	assert.NoError(self.T(), err)
+
+	client, err := cvelo_services.GetElasticClient()
+	assert.NoError(self.T(), err)
+
+	res, err := opensearchapi.IndicesGetSettingsRequest{
+		Index: []string{index},
+		Name:  []string{"index.sort.*"},
+	}.Do(self.Ctx, client)
+	assert.NoError(self.T(), err)
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	assert.NoError(self.T(), err)
+	assert.False(self.T(), res.IsError(), string(data))
+
+	// The transient index is a data stream so the settings are on
+	// its backing index.
+	settings := make(map[string]struct {
+		Settings struct {
+			Index struct {
+				Sort struct {
+					Field interface{} `json:"field"`
+				} `json:"sort"`
+			} `json:"index"`
+		} `json:"settings"`
+	})
+	err = json.Unmarshal(data, &settings)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, len(settings))
+
+	for _, backing_index := range settings {
+		assert.Contains(self.T(), fmt.Sprintf("%v",
+			backing_index.Settings.Index.Sort.Field), "timestamp")
+	}
+}
+
+func (self *ElasticTestSuite) TestDeleteExpired() {
+	docs := map[string]interface{}{
+		"expired": cvelo_services.ExpiryTime(-time.Hour),
+		"live":    cvelo_services.ExpiryTime(time.Hour),
+
+		// Documents without an expiry live forever.
+		"forever": nil,
+	}
+
+	for id, expires := range docs {
+		record := map[string]interface{}{
+			"doc_type": "test",
+			"id":       id,
+		}
+		if expires != nil {
+			record[cvelo_services.ExpiryField] = expires
+		}
+
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", id, record)
+		assert.NoError(self.T(), err)
+	}
+
+	err := cvelo_services.DeleteExpired(self.Ctx, "test", "persisted")
+	assert.NoError(self.T(), err)
+
+	records, _, err := cvelo_services.QueryElasticRaw(self.Ctx,
+		"test", "persisted", `{"query": {"match": {"doc_type": "test"}}}`)
+	assert.NoError(self.T(), err)
+
+	ids := []string{}
+	for _, record := range records {
+		item := &struct {
+			Id string `json:"id"`
+		}{}
+		err = json.Unmarshal(record, item)
+		assert.NoError(self.T(), err)
+		ids = append(ids, item.Id)
+	}
+	sort.Strings(ids)
+	assert.Equal(self.T(), []string{"forever", "live"}, ids)
+}
+
+func (self *ElasticTestSuite) TestCountByDocType() {
+	for i, doc_type := range []string{
+		"clients", "clients", "clients", "hunts", "hunts", "notebooks"} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", fmt.Sprintf("doc%d", i),
+			map[string]string{
+				"doc_type": doc_type,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	counts, err := cvelo_services.CountByDocType(self.Ctx, "test", "persisted")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), map[string]int{
+		"clients":   3,
+		"hunts":     2,
+		"notebooks": 1,
+	}, counts)
+}
+
+func (self *ElasticTestSuite) TestKeywordIgnoreAbove() {
+	defer cvelo_services.SetKeywordIgnoreAbove(nil)
+
+	long_creator := strings.Repeat("A", 300)
+	query := json.Format(`{"query": {"term": {"creator": %q}}}`, long_creator)
+	index := func() {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", "long", map[string]string{
+				"doc_type": "test",
+				"creator":  long_creator,
+			})
+		assert.NoError(self.T(), err)
+	}
+	count := func() int {
+		records, _, err := cvelo_services.QueryElasticRaw(self.Ctx,
+			"test", "persisted", query)
+		assert.NoError(self.T(), err)
+		return len(records)
+	}
+
+	// Values longer than the limit are not indexed.
+	cvelo_services.SetKeywordIgnoreAbove(map[string]int{"creator": 256})
+	err := cvelo_services.UpdateKeywordIgnoreAbove(self.Ctx,
+		cvelo_services.GetIndex("test", "persisted"))
+	assert.NoError(self.T(), err)
+
+	index()
+	assert.Equal(self.T(), 0, count())
+
+	// Raising the limit only applies once the document is indexed
+	// again.
+	cvelo_services.SetKeywordIgnoreAbove(map[string]int{"creator": 1024})
+	err = cvelo_services.UpdateKeywordIgnoreAbove(self.Ctx,
+		cvelo_services.GetIndex("test", "persisted"))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 0, count())
+
+	index()
+	assert.Equal(self.T(), 1, count())
+}
+
+func (self *ElasticTestSuite) TestValidateQuery() {
+	err := cvelo_services.SetElasticIndex(self.Ctx,
+		"test", "persisted", "doc", map[string]string{
+			"doc_type": "test",
+		})
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.ValidateQuery(self.Ctx, "test", "persisted",
+		`{"query": {"term": {"doc_type": "test"}}}`)
+	assert.NoError(self.T(), err)
+
+	// An unknown query type.
+	err = cvelo_services.ValidateQuery(self.Ctx, "test", "persisted",
+		`{"query": {"no_such_query": {"doc_type": "test"}}}`)
+	assert.ErrorIs(self.T(), err, cvelo_services.ErrInvalidQuery)
+	assert.Contains(self.T(), err.Error(), "no_such_query")
+}
+
+func (self *ElasticTestSuite) TestTimeDecayScoring() {
+	now := utils.GetTime().Now().Unix()
+	for id, age := range map[string]int64{
+		"hour":   3600,
+		"minute": 60,
+		"day":    86400,
+	} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", id, map[string]interface{}{
+				"doc_type":  "test",
+				"timestamp": now - age,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	hits, err := cvelo_services.QueryElasticScored(self.Ctx, "test", "persisted",
+		cvelo_services.FunctionScoreQuery(
+			`{"term": {"doc_type": "test"}}`, 10,
+			cvelo_services.DecayFunction(cvelo_services.DecayExp,
+				"timestamp", now, 3600, 0.5)))
+	assert.NoError(self.T(), err)
+
+	ids := []string{}
+	for _, hit := range hits {
+		ids = append(ids, hit.Id)
+	}
+	assert.Equal(self.T(), []string{"minute", "hour", "day"}, ids)
+	assert.True(self.T(), hits[0].Score > hits[1].Score)
+}
+
+func (self *ElasticTestSuite) TestQueryIndexPattern() {
+	// Two rollover indexes behind the same alias.
+	for _, index := range []string{"monitoring-000001", "monitoring-000002"} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", index, "doc_"+index, map[string]string{
+				"doc_type": "test",
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	// Another org's indexes must not match.
+	err := cvelo_services.SetElasticIndex(self.Ctx,
+		"test2", "monitoring-000001", "other", map[string]string{
+			"doc_type": "test",
+		})
+	assert.NoError(self.T(), err)
+
+	_, total, err := cvelo_services.QueryElasticRaw(self.Ctx,
+		"test", "monitoring-*", `{"query": {"match_all": {}}}`)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 2, total)
+
+	_, total, err = cvelo_services.QueryElasticRaw(self.Ctx,
+		"test", "monitoring-*,-monitoring-000001",
+		`{"query": {"match_all": {}}}`)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, total)
+}
+
+func (self *ElasticTestSuite) TestDetectMappingConflicts() {
+	// This index has no template so the fields are mapped
+	// dynamically from the first document in each org.
+	for org_id, value := range map[string]interface{}{
+		"test":  1,
+		"test2": "one",
+	} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			org_id, "test_dynamic", "doc", map[string]interface{}{
+				"doc_type": "test",
+				"value":    value,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	conflicts, err := cvelo_services.DetectMappingConflicts(
+		self.Ctx, "test_dynamic")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []cvelo_services.Conflict{{
+		Field: "value",
+		Types: map[string][]string{
+			"long": {"test_test_dynamic"},
+			"text": {"test2_test_dynamic"},
+		},
+	}}, conflicts)
+}
+
+func (self *ElasticTestSuite) TestClaimLease() {
+	err := cvelo_services.SetElasticIndex(self.Ctx,
+		"test", "persisted", "work", map[string]string{
+			"doc_type": "test",
+		})
+	assert.NoError(self.T(), err)
+
+	claims := make(chan bool, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+
+			claimed, err := cvelo_services.ClaimLease(self.Ctx,
+				"test", "persisted", "work", owner, time.Minute)
+			assert.NoError(self.T(), err)
+			claims <- claimed
+		}(fmt.Sprintf("worker%d", i))
+	}
+	wg.Wait()
+	close(claims)
+
+	// Exactly one claimant gets the lease.
+	succeeded := 0
+	for claimed := range claims {
+		if claimed {
+			succeeded++
+		}
+	}
+	assert.Equal(self.T(), 1, succeeded)
+}
+
+func (self *ElasticTestSuite) TestQueryMinScore() {
+	for _, client_id := range []string{"C.1", "C.2", "C.3"} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", client_id, map[string]string{
+				"doc_type":  "clients",
+				"client_id": client_id,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	// C.1 scores 2, the others 1.
+	query := `{"query": {"bool": {"should": [
+  {"constant_score": {"filter": {"term": {"doc_type": "clients"}}, "boost": 1}},
+  {"constant_score": {"filter": {"term": {"client_id": "C.1"}}, "boost": 1}}
+]}}, "sort": [{"client_id": "asc"}]}`
+
+	hits, err := cvelo_services.QueryElasticScoredWithOptions(self.Ctx,
+		"test", "persisted", query, cvelo_services.QueryOptions{})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 3, len(hits))
+
+	hits, err = cvelo_services.QueryElasticScoredWithOptions(self.Ctx,
+		"test", "persisted", query, cvelo_services.QueryOptions{MinScore: 1.5})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, len(hits))
+	assert.Equal(self.T(), "C.1", hits[0].Id)
+	assert.Equal(self.T(), 2.0, hits[0].Score)
+}
+
+func (self *ElasticTestSuite) TestQueryMissingIndexes() {
+	err := cvelo_services.SetElasticIndex(self.Ctx,
+		"test", "persisted", "doc", map[string]string{
+			"doc_type": "test",
+		})
+	assert.NoError(self.T(), err)
+
+	// The pattern includes an index which does not exist (e.g. it
+	// was removed by retention).
+	query := `{"query": {"match": {"doc_type": "test"}}}`
+	records, total, err := cvelo_services.QueryElasticRaw(self.Ctx,
+		"test", "persisted,deleted", query)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 1, total)
+	assert.Equal(self.T(), 1, len(records))
+
+	// Unless asked to fail.
+	records, _, err = cvelo_services.QueryElasticRawWithOptions(self.Ctx,
+		"test", "persisted,deleted", query, cvelo_services.QueryOptions{
+			MissingIndexes: cvelo_services.MissingIndexesFail,
+		})
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 0, len(records))
+}
+
+func (self *ElasticTestSuite) TestClientActivityHeatmap() {
+	start := time.Unix(1661385600, 0).UTC()
+
+	// C.1 is active in the first and third hour, C.2 in the second.
+	for i, event := range []struct {
+		client_id string
+		offset    time.Duration
+	}{
+		{"C.1", 5 * time.Minute},
+		{"C.1", 30 * time.Minute},
+		{"C.2", 70 * time.Minute},
+		{"C.1", 150 * time.Minute},
+	} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", fmt.Sprintf("event%d", i),
+			map[string]interface{}{
+				"doc_type":  "test",
+				"client_id": event.client_id,
+				"timestamp": start.Add(event.offset).Unix(),
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	heatmap, err := cvelo_services.ClientActivityHeatmap(self.Ctx,
+		"test", "persisted", cvelo_services.HeatmapOptions{
+			Query: `{"match": {"doc_type": "test"}}`,
+			Start: start,
+			End:   start.Add(3 * time.Hour),
+		})
+	assert.NoError(self.T(), err)
+
+	assert.Equal(self.T(), []string{"C.1", "C.2"}, heatmap.ClientIds)
+	assert.Equal(self.T(), [][]int{
+		{2, 0, 1},
+		{0, 1, 0},
+	}, heatmap.Counts)
+}
+
+func (self *ElasticTestSuite) TestCountDistinctClients() {
+	// Several events for the same clients.
+	for i, client_id := range []string{"C.1", "C.2", "C.1", "C.3", "C.2", "C.1"} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", fmt.Sprintf("event%d", i),
+			map[string]interface{}{
+				"doc_type":  "test",
+				"client_id": client_id,
+				"timestamp": 100 + i,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	count, err := cvelo_services.CountDistinctClients(self.Ctx,
+		"test", "persisted", `{"match": {"doc_type": "test"}}`, 0)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), &cvelo_services.DistinctCount{Count: 3}, count)
+
+	// Only C.2 and C.1 are seen in the last two events.
+	count, err = cvelo_services.CountDistinctClients(self.Ctx,
+		"test", "persisted", `{"range": {"timestamp": {"gte": 104}}}`, 0)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), uint64(2), count.Count)
+}
+
+func (self *ElasticTestSuite) TestBackfillTimestamps() {
+	for id, created := range map[string]interface{}{
+		"seconds":     1700000000,
+		"millis":      1700000000123,
+		"rfc3339":     "2023-11-14T22:13:20.5Z",
+		"unparseable": "yesterday",
+	} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", id, map[string]interface{}{
+				"doc_type": "test",
+				"created":  created,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	// Already has the canonical field.
+	err := cvelo_services.SetElasticIndex(self.Ctx,
+		"test", "persisted", "current", map[string]interface{}{
+			"doc_type":  "test",
+			"created":   1,
+			"timestamp": 5,
+		})
+	assert.NoError(self.T(), err)
+
+	index := cvelo_services.GetIndex("test", "persisted")
+	count, err := cvelo_services.BackfillTimestamps(
+		self.Ctx, index, "created", "timestamp")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 3, count)
+
+	for id, expected := range map[string]int64{
+		"seconds": 1700000000000000000,
+		"millis":  1700000000123000000,
+		"rfc3339": 1700000000500000000,
+		"current": 5,
+	} {
+		serialized, err := cvelo_services.GetElasticRecord(
+			self.Ctx, "test", "persisted", id)
+		assert.NoError(self.T(), err)
+
+		item := &struct {
+			Timestamp int64 `json:"timestamp"`
+		}{}
+		err = json.Unmarshal(serialized, item)
+		assert.NoError(self.T(), err)
+		assert.Equal(self.T(), expected, item.Timestamp, id)
+	}
+
+	// Running it again changes nothing.
+	count, err = cvelo_services.BackfillTimestamps(
+		self.Ctx, index, "created", "timestamp")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), 0, count)
+}
+
+func (self *ElasticTestSuite) TestTextSearch() {
+	// Command lines are searched as text, images by their exact
+	// value.
+	index := cvelo_services.GetIndex("test", "search")
+	client, err := cvelo_services.GetElasticClient()
+	assert.NoError(self.T(), err)
+
+	res, err := opensearchapi.IndicesCreateRequest{
+		Index: index,
+		Body: strings.NewReader(`{"mappings": {"properties": {
+  "command_line": {"type": "text"},
+  "image": {"type": "keyword"}
+}}}`),
+	}.Do(self.Ctx, client)
+	assert.NoError(self.T(), err)
+	res.Body.Close()
+	assert.False(self.T(), res.IsError())
+
+	for id, doc := range map[string][]string{
+		"whoami":     {"cmd.exe /c whoami", "cmd.exe"},
+		"reversed":   {"whoami /c cmd.exe", "whoami.exe"},
+		"powershell": {"powershell.exe -enc AAAA", "powershell.exe"},
+	} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "search", id, map[string]string{
+				"command_line": doc[0],
+				"image":        doc[1],
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	search := func(clause string) []string {
+		hits, _, err := cvelo_services.QueryElasticFull(self.Ctx,
+			"test", "search", json.Format(`{"query": %s}`, clause))
+		assert.NoError(self.T(), err)
+
+		var ids []string
+		for _, hit := range hits {
+			ids = append(ids, hit.Id)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	// Both documents have the words but only one in this order.
+	assert.Equal(self.T(), []string{"reversed", "whoami"},
+		search(`{"match": {"command_line": "cmd.exe /c"}}`))
+	assert.Equal(self.T(), []string{"whoami"},
+		search(cvelo_services.MatchPhraseQuery("command_line", "cmd.exe /c", 0)))
+
+	// With slop the words may be further apart.
+	assert.Equal(self.T(), []string(nil),
+		search(cvelo_services.MatchPhraseQuery("command_line", "cmd.exe whoami", 0)))
+	assert.Equal(self.T(), []string{"whoami"},
+		search(cvelo_services.MatchPhraseQuery("command_line", "cmd.exe whoami", 1)))
+
+	// Wildcards match the whole value.
+	query, err := cvelo_services.WildcardQuery("image", "cmd*")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string{"whoami"}, search(query))
+
+	query, err = cvelo_services.WildcardQuery("image", "w?oami.exe")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string{"reversed"}, search(query))
+
+	query, err = cvelo_services.WildcardQuery("image",
+		cvelo_services.EscapeWildcard("cmd.exe?"))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string(nil), search(query))
+
+	query, err = cvelo_services.PrefixQuery("image", "power")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string{"powershell"}, search(query))
+
+	_, err = cvelo_services.WildcardQuery("image", "*.exe")
+	assert.ErrorIs(self.T(), err, cvelo_services.ErrLeadingWildcard)
+}
+
+func (self *ElasticTestSuite) TestFilteredAlias() {
+	for _, org_id := range []string{"test", "test2", "test3"} {
+		for _, doc_type := range []string{"clients", "hunts"} {
+			err := cvelo_services.SetElasticIndex(self.Ctx,
+				org_id, "persisted", doc_type, map[string]string{
+					"doc_type":  doc_type,
+					"client_id": org_id + "/" + doc_type,
+				})
+			assert.NoError(self.T(), err)
+		}
+	}
+
+	// The test suite removes the alias with the "test*" indexes.
+	alias := "test_admin_view"
+	read := func() []string {
+		hits, _, err := cvelo_services.QueryElasticFull(self.Ctx, "root",
+			alias, `{"query": {"match_all": {}}, "sort": [{"client_id": "asc"}]}`)
+		assert.NoError(self.T(), err)
+
+		var result []string
+		for _, hit := range hits {
+			item := &struct {
+				ClientId string `json:"client_id"`
+			}{}
+			err = json.Unmarshal(hit.Source, item)
+			assert.NoError(self.T(), err)
+			result = append(result, item.ClientId)
+		}
+		return result
+	}
+
+	// Only clients of the first two orgs are visible through the
+	// alias, whatever the query.
+	err := cvelo_services.CreateFilteredAlias(self.Ctx, alias,
+		[]string{"test", "test2"}, "persisted",
+		`{"term": {"doc_type": "clients"}}`)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string{"test/clients", "test2/clients"}, read())
+
+	// Changing the orgs replaces the view.
+	err = cvelo_services.CreateFilteredAlias(self.Ctx, alias,
+		[]string{"test3"}, "persisted", "")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), []string{"test3/clients", "test3/hunts"}, read())
+
+	err = cvelo_services.DeleteFilteredAlias(self.Ctx, alias)
+	assert.NoError(self.T(), err)
+
+	assert.Equal(self.T(), []string(nil), read())
+
+	// Deleting it again is fine.
+	err = cvelo_services.DeleteFilteredAlias(self.Ctx, alias)
+	assert.NoError(self.T(), err)
+}
+
+func (self *ElasticTestSuite) TestIngestPipeline() {
+	err := cvelo_services.PutIngestPipeline(self.Ctx, "test_set_source",
+		`{"processors": [{"set": {"field": "source", "value": "pipeline"}}]}`)
+	assert.NoError(self.T(), err)
+
+	ctx := cvelo_services.WithIngestPipeline(self.Ctx, "test_set_source")
+	err = cvelo_services.SetElasticIndex(ctx,
+		"test", "persisted", "with_pipeline", map[string]string{
+			"doc_type": "test",
+		})
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.SetElasticIndex(self.Ctx,
+		"test", "persisted", "without_pipeline", map[string]string{
+			"doc_type": "test",
+		})
+	assert.NoError(self.T(), err)
+
+	// Only the document written through the pipeline has the
+	// field set by the cluster.
+	for id, expected := range map[string]string{
+		"with_pipeline":    "pipeline",
+		"without_pipeline": "",
+	} {
+		serialized, err := cvelo_services.GetElasticRecord(
+			self.Ctx, "test", "persisted", id)
+		assert.NoError(self.T(), err)
+
+		item := &struct {
+			Source string `json:"source"`
+		}{}
+		err = json.Unmarshal(serialized, item)
+		assert.NoError(self.T(), err)
+		assert.Equal(self.T(), expected, item.Source, id)
+	}
+
+	// Writes through a pipeline which does not exist fail.
+	err = cvelo_services.SetElasticIndex(
+		cvelo_services.WithIngestPipeline(self.Ctx, "test_missing"),
+		"test", "persisted", "missing", map[string]string{
+			"doc_type": "test",
+		})
+	assert.Error(self.T(), err)
+}
+
+func (self *ElasticTestSuite) TestMoveClientToOrg() {
+	for _, client_id := range []string{"C.1", "C.2"} {
+		err := cvelo_services.SetElasticIndex(self.Ctx,
+			"test", "persisted", client_id, map[string]string{
+				"doc_type":  "clients",
+				"client_id": client_id,
+			})
+		assert.NoError(self.T(), err)
+	}
+
+	err := cvelo_services.MoveClientToOrg(self.Ctx, "C.1", "test", "test2")
+	assert.NoError(self.T(), err)
+
+	count := func(org_id, client_id string) int {
+		_, total, err := cvelo_services.QueryElasticRaw(self.Ctx,
+			org_id, "persisted", json.Format(
+				`{"query": {"match": {"client_id": %q}}}`, client_id))
+		assert.NoError(self.T(), err)
+		return total
+	}
+
+	assert.Equal(self.T(), 0, count("test", "C.1"))
+	assert.Equal(self.T(), 1, count("test2", "C.1"))
+
+	// Other clients stay where they are.
+	assert.Equal(self.T(), 1, count("test", "C.2"))
+	assert.Equal(self.T(), 0, count("test2", "C.2"))
+
+	// The client is already in the destination.
+	err = cvelo_services.SetElasticIndex(self.Ctx,
+		"test", "persisted", "C.1", map[string]string{
+			"doc_type":  "clients",
+			"client_id": "C.1",
+		})
+	assert.NoError(self.T(), err)
+
+	err = cvelo_services.MoveClientToOrg(self.Ctx, "C.1", "test", "test2")
+	assert.ErrorIs(self.T(), err, cvelo_services.ErrClientExists)
+	assert.Equal(self.T(), 1, count("test", "C.1"))
+}
+
+func TestElasticServices(t *testing.T) {
+	suite.Run(t, &ElasticTestSuite{
+		CloudTestSuite: &testsuite.CloudTestSuite{
+			Indexes: []string{"persisted"},
+		},
+	})
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+type SnapshotOptions struct {
+	// Return as soon as the snapshot (or restore) is started
+	// instead of waiting for it to complete. Failures of the
+	// operation itself are then not reported.
+	NoWait bool
+}
+
+type _SnapshotInfo struct {
+	Snapshot string     `json:"snapshot"`
+	State    string     `json:"state"`
+	Shards   ShardStats `json:"shards"`
+}
+
+type _SnapshotResponse struct {
+	Snapshot *_SnapshotInfo `json:"snapshot"`
+}
+
+type _SnapshotRequest struct {
+	Indices            string `json:"indices,omitempty"`
+	IncludeGlobalState bool   `json:"include_global_state"`
+}
+
+// Take a snapshot of the indices into the snapshot repository. The
+// repository must already be registered with the cluster. Waits for
+// the snapshot to complete.
+func SnapshotIndices(ctx context.Context,
+	repo, snapshot string, indices []string) error {
+	return SnapshotIndicesWithOptions(ctx, repo, snapshot, indices,
+		SnapshotOptions{})
+}
+
+func SnapshotIndicesWithOptions(ctx context.Context,
+	repo, snapshot string, indices []string, options SnapshotOptions) error {
+	defer Instrument("SnapshotIndices")()
+	defer Debug("SnapshotIndices %v/%v %v", repo, snapshot, indices)()
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	// The cluster state is not part of an org's data.
+	body := json.MustMarshalString(&_SnapshotRequest{
+		Indices: strings.Join(indices, ","),
+	})
+
+	wait := !options.NoWait
+	res, err := opensearchapi.SnapshotCreateRequest{
+		Repository:        repo,
+		Snapshot:          snapshot,
+		Body:              strings.NewReader(body),
+		WaitForCompletion: &wait,
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return makeElasticError(data)
+	}
+
+	if !wait {
+		return nil
+	}
+
+	response := &_SnapshotResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return err
+	}
+
+	if response.Snapshot == nil {
+		return fmt.Errorf("SnapshotIndices %v/%v: invalid response %v",
+			repo, snapshot, string(data))
+	}
+
+	// A PARTIAL snapshot is missing some shards so it can not be
+	// relied on for a backup.
+	if response.Snapshot.State != "SUCCESS" {
+		return fmt.Errorf("SnapshotIndices %v/%v: snapshot %v (%v of %v shards failed)",
+			repo, snapshot, response.Snapshot.State,
+			response.Snapshot.Shards.Failed, response.Snapshot.Shards.Total)
+	}
+
+	return nil
+}
+
+// Restore the indices from a snapshot in the snapshot repository (or
+// all the indices in the snapshot if none are given). The indices
+// must not exist or be closed. Waits for the restore to complete.
+func RestoreSnapshot(ctx context.Context,
+	repo, snapshot string, indices []string) error {
+	return RestoreSnapshotWithOptions(ctx, repo, snapshot, indices,
+		SnapshotOptions{})
+}
+
+func RestoreSnapshotWithOptions(ctx context.Context,
+	repo, snapshot string, indices []string, options SnapshotOptions) error {
+	defer Instrument("RestoreSnapshot")()
+	defer Debug("RestoreSnapshot %v/%v %v", repo, snapshot, indices)()
+
+	err := checkWritable()
+	if err != nil {
+		return err
+	}
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	body := json.MustMarshalString(&_SnapshotRequest{
+		Indices: strings.Join(indices, ","),
+	})
+
+	wait := !options.NoWait
+	res, err := opensearchapi.SnapshotRestoreRequest{
+		Repository:        repo,
+		Snapshot:          snapshot,
+		Body:              strings.NewReader(body),
+		WaitForCompletion: &wait,
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return makeElasticError(data)
+	}
+
+	if !wait {
+		return nil
+	}
+
+	response := &_SnapshotResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return err
+	}
+
+	if response.Snapshot != nil && response.Snapshot.Shards.Failed > 0 {
+		return fmt.Errorf("RestoreSnapshot %v/%v: %v of %v shards failed",
+			repo, snapshot, response.Snapshot.Shards.Failed,
+			response.Snapshot.Shards.Total)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotIndices(t *testing.T) {
+	var path, wait, body string
+	response := `{"snapshot": {"snapshot": "snap1", "state": "SUCCESS", "shards": {"total": 2, "successful": 2, "failed": 0}}}`
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		path = r.URL.Path
+		wait = r.URL.Query().Get("wait_for_completion")
+		body = string(data)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	err := SnapshotIndices(ctx, "backups", "snap1",
+		[]string{"o123_persisted", "o123_transient"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/_snapshot/backups/snap1", path)
+	assert.Equal(t, "true", wait)
+	assert.JSONEq(t, `{"indices": "o123_persisted,o123_transient", "include_global_state": false}`, body)
+
+	// Incomplete snapshots are failures.
+	response = `{"snapshot": {"snapshot": "snap1", "state": "PARTIAL", "shards": {"total": 2, "successful": 1, "failed": 1}}}`
+	err = SnapshotIndices(ctx, "backups", "snap1", []string{"o123_persisted"})
+	assert.Error(t, err)
+
+	// Without waiting the snapshot is only started.
+	response = `{"accepted": true}`
+	err = SnapshotIndicesWithOptions(ctx, "backups", "snap1",
+		[]string{"o123_persisted"}, SnapshotOptions{NoWait: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "false", wait)
+
+	response = `{"snapshot": {"snapshot": "snap1", "indices": ["o123_persisted"], "shards": {"total": 1, "successful": 1, "failed": 0}}}`
+	err = RestoreSnapshot(ctx, "backups", "snap1", []string{"o123_persisted"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/_snapshot/backups/snap1/_restore", path)
+	assert.Equal(t, "true", wait)
+	assert.JSONEq(t, `{"indices": "o123_persisted", "include_global_state": false}`, body)
+
+	response = `{"snapshot": {"snapshot": "snap1", "indices": ["o123_persisted"], "shards": {"total": 1, "successful": 0, "failed": 1}}}`
+	err = RestoreSnapshot(ctx, "backups", "snap1", []string{"o123_persisted"})
+	assert.Error(t, err)
+}
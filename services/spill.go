@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchutil"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/logging"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+const (
+	// How long we keep trying to flush the bulk indexer on shutdown.
+	bulkShutdownTimeout = 30 * time.Second
+)
+
+// Items are tracked from when they are added to the bulk indexer
+// until the server answers for them. When a flush fails outright the
+// bulk indexer drops the items without calling their callbacks. While
+// running these are forgotten (see onFlushError) - resubmitting them
+// later could overwrite newer writes to the same documents. During
+// the shutdown flush they stay here and are retried or spilled.
+type pendingItems struct {
+	mu    sync.Mutex
+	next  uint64
+	items map[uint64]opensearchutil.BulkIndexerItem
+}
+
+// Returns a copy of the item which removes itself from the pending
+// set when the server answers for it.
+func (self *pendingItems) track(
+	item opensearchutil.BulkIndexerItem) opensearchutil.BulkIndexerItem {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.items == nil {
+		self.items = make(map[uint64]opensearchutil.BulkIndexerItem)
+	}
+
+	self.next++
+	id := self.next
+	self.items[id] = item
+
+	tracked := item
+	tracked.OnSuccess = func(ctx context.Context,
+		i opensearchutil.BulkIndexerItem,
+		res opensearchutil.BulkIndexerResponseItem) {
+		self.done(id)
+		if item.OnSuccess != nil {
+			item.OnSuccess(ctx, i, res)
+		}
+	}
+	tracked.OnFailure = func(ctx context.Context,
+		i opensearchutil.BulkIndexerItem,
+		res opensearchutil.BulkIndexerResponseItem, err error) {
+		// The server rejected this item so retrying will not help.
+		self.done(id)
+		if item.OnFailure != nil {
+			item.OnFailure(ctx, i, res, err)
+		}
+	}
+
+	return tracked
+}
+
+func (self *pendingItems) done(id uint64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	delete(self.items, id)
+}
+
+// The id of the last item tracked so far.
+func (self *pendingItems) mark() uint64 {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	return self.next
+}
+
+// Forget the outstanding items tracked up to the mark.
+func (self *pendingItems) dropUpTo(mark uint64) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	for id := range self.items {
+		if id <= mark {
+			delete(self.items, id)
+		}
+	}
+}
+
+// Remove and return all the outstanding items in the order they
+// were added.
+func (self *pendingItems) take() []opensearchutil.BulkIndexerItem {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	ids := make([]uint64, 0, len(self.items))
+	for id := range self.items {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	result := make([]opensearchutil.BulkIndexerItem, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, self.items[id])
+	}
+	self.items = nil
+
+	return result
+}
+
+// A bulk indexer item as stored in the spill file.
+type spillRecord struct {
+	Index      string `json:"index"`
+	Action     string `json:"action"`
+	DocumentID string `json:"id,omitempty"`
+	Body       string `json:"body,omitempty"`
+}
+
+// Flush the bulk indexer on shutdown. Items the cluster did not
+// accept are resubmitted until the timeout expires and are then
+// written to the spill file (if configured) to be replayed on the
+// next start.
+func shutdownBulkIndexer(
+	config_obj *config_proto.Config,
+	timeout time.Duration, spill_file string) error {
+
+	mu.Lock()
+	b := bulk_indexer
+	mu.Unlock()
+
+	if b == nil {
+		return nil
+	}
+
+	// From now on items of failed flushes are kept for retrying.
+	atomic.StoreInt32(&b.shutting_down, 1)
+
+	logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+	deadline := utils.GetTime().Now().Add(timeout)
+	delay := time.Second
+
+	for {
+		err := b.Close()
+		if err != nil {
+			logger.Error("BulkIndexer: flush on shutdown: %v", err)
+		}
+
+		items := b.pending.take()
+		if len(items) == 0 {
+			return nil
+		}
+
+		remaining := deadline.Sub(utils.GetTime().Now())
+		if remaining <= 0 {
+			return spillItems(config_obj, spill_file, items)
+		}
+
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+		delay *= 2
+
+		for _, item := range items {
+			err := b.Add(context.Background(), item)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func spillItems(config_obj *config_proto.Config,
+	spill_file string, items []opensearchutil.BulkIndexerItem) error {
+
+	logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+	if spill_file == "" {
+		logger.Error("BulkIndexer: Lost %v items which could not be flushed",
+			len(items))
+		return fmt.Errorf("Unable to flush %v items", len(items))
+	}
+
+	fd, err := os.OpenFile(spill_file,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	for _, item := range items {
+		record := &spillRecord{
+			Index:      item.Index,
+			Action:     item.Action,
+			DocumentID: item.DocumentID,
+		}
+
+		if item.Body != nil {
+			_, err := item.Body.Seek(0, io.SeekStart)
+			if err != nil {
+				return err
+			}
+			body, err := ioutil.ReadAll(item.Body)
+			if err != nil {
+				return err
+			}
+			record.Body = string(body)
+		}
+
+		serialized, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		_, err = fd.Write(append(serialized, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info("BulkIndexer: Spilled %v items to %v", len(items), spill_file)
+	return nil
+}
+
+// Resubmit the items spilled on a previous shutdown. The spill file
+// is only removed once the cluster answered for all of them, so
+// nothing is lost if we crash or the cluster fails during the replay.
+func replaySpillFile(config_obj *config_proto.Config,
+	b *BulkIndexer, spill_file string) error {
+	if spill_file == "" {
+		return nil
+	}
+
+	fd, err := os.Open(spill_file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fd.Close()
+
+	// Items the server rejected are answered too - retrying them
+	// will not help.
+	var answered int64
+	on_success := func(ctx context.Context,
+		item opensearchutil.BulkIndexerItem,
+		res opensearchutil.BulkIndexerResponseItem) {
+		atomic.AddInt64(&answered, 1)
+	}
+	on_failure := func(ctx context.Context,
+		item opensearchutil.BulkIndexerItem,
+		res opensearchutil.BulkIndexerResponseItem, err error) {
+		atomic.AddInt64(&answered, 1)
+	}
+
+	count := 0
+	scanner := bufio.NewScanner(fd)
+	scanner.Buffer(nil, 100*1024*1024)
+	for scanner.Scan() {
+		record := &spillRecord{}
+		err := json.Unmarshal(scanner.Bytes(), record)
+		if err != nil {
+			return err
+		}
+
+		err = b.Add(context.Background(), opensearchutil.BulkIndexerItem{
+			Index:      record.Index,
+			Action:     record.Action,
+			DocumentID: record.DocumentID,
+			Body:       strings.NewReader(record.Body),
+			OnSuccess:  on_success,
+			OnFailure:  on_failure,
+		})
+		if err != nil {
+			return err
+		}
+		count++
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+
+	err = b.Close()
+	if err != nil {
+		return err
+	}
+
+	flushed := atomic.LoadInt64(&answered)
+	if flushed < int64(count) {
+		return fmt.Errorf("Only %v of %v spilled items were flushed, keeping %v",
+			flushed, count, spill_file)
+	}
+
+	logger := logging.GetLogger(config_obj, &logging.FrontendComponent)
+	logger.Info("BulkIndexer: Replayed %v spilled items from %v",
+		count, spill_file)
+
+	return os.Remove(spill_file)
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/v2/opensearchutil"
+	"github.com/stretchr/testify/assert"
+	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
+)
+
+func TestShutdownSpillsUnflushedItems(t *testing.T) {
+	var mu sync.Mutex
+	available := false
+	var bulk_bodies []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !available {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "unavailable"}`))
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, "_bulk") {
+			body, _ := ioutil.ReadAll(r.Body)
+			bulk_bodies = append(bulk_bodies, string(body))
+			w.Write([]byte(`{"errors": false, "items": [
+  {"index": {"status": 201}}, {"index": {"status": 201}}]}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	defer closer()
+
+	client, err := GetElasticClient()
+	assert.NoError(t, err)
+
+	config_obj := &config_proto.Config{}
+	newIndexer := func() *BulkIndexer {
+		indexer := &BulkIndexer{
+			config_obj: config_obj,
+			ctx:        context.Background(),
+			indexes:    make(map[string]bool),
+			ensured:    make(map[string]bool),
+		}
+		indexer.BulkIndexer, err = opensearchutil.NewBulkIndexer(
+			opensearchutil.BulkIndexerConfig{
+				Client:        client,
+				FlushInterval: time.Hour,
+			})
+		assert.NoError(t, err)
+
+		mu.Lock()
+		bulk_indexer = indexer
+		mu.Unlock()
+		return indexer
+	}
+
+	old_indexer := bulk_indexer
+	defer func() { bulk_indexer = old_indexer }()
+
+	indexer := newIndexer()
+	for _, id := range []string{"doc1", "doc2"} {
+		err := indexer.Add(context.Background(), opensearchutil.BulkIndexerItem{
+			Index:      "test_persisted",
+			Action:     "index",
+			DocumentID: id,
+			Body:       bytes.NewReader([]byte(`{"id":"` + id + `"}`)),
+		})
+		assert.NoError(t, err)
+	}
+
+	// The cluster stays down for the whole shutdown so the items are
+	// spilled.
+	spill_file := filepath.Join(t.TempDir(), "spill.jsonl")
+	err = shutdownBulkIndexer(config_obj, 1500*time.Millisecond, spill_file)
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadFile(spill_file)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"index":"test_persisted","action":"index","id":"doc1","body":"{\"id\":\"doc1\"}"}
+{"index":"test_persisted","action":"index","id":"doc2","body":"{\"id\":\"doc2\"}"}
+`, string(data))
+
+	// The spill file is kept if the replay can not be flushed.
+	indexer = newIndexer()
+	err = replaySpillFile(config_obj, indexer, spill_file)
+	assert.Error(t, err)
+
+	_, err = ioutil.ReadFile(spill_file)
+	assert.NoError(t, err)
+
+	// On the next start the spilled items are replayed.
+	mu.Lock()
+	available = true
+	mu.Unlock()
+
+	indexer = newIndexer()
+	err = replaySpillFile(config_obj, indexer, spill_file)
+	assert.NoError(t, err)
+
+	err = shutdownBulkIndexer(config_obj, time.Second, spill_file)
+	assert.NoError(t, err)
+
+	mu.Lock()
+	assert.Equal(t, 1, len(bulk_bodies))
+	assert.Contains(t, bulk_bodies[0], `{"id":"doc1"}`)
+	assert.Contains(t, bulk_bodies[0], `{"id":"doc2"}`)
+	mu.Unlock()
+
+	// The spill file is removed once replayed.
+	_, err = ioutil.ReadFile(spill_file)
+	assert.Error(t, err)
+}
+
+func TestFailedFlushIsNotResubmitted(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "unavailable"}`))
+	})
+	defer closer()
+
+	client, err := GetElasticClient()
+	assert.NoError(t, err)
+
+	indexer := &BulkIndexer{
+		config_obj: &config_proto.Config{},
+		ctx:        context.Background(),
+		indexes:    make(map[string]bool),
+		ensured:    map[string]bool{"test_persisted": true},
+	}
+	indexer.BulkIndexer, err = opensearchutil.NewBulkIndexer(
+		opensearchutil.BulkIndexerConfig{
+			Client:        client,
+			FlushInterval: time.Hour,
+			OnFlushStart:  indexer.onFlushStart,
+			OnError:       indexer.onFlushError,
+		})
+	assert.NoError(t, err)
+
+	err = indexer.Add(context.Background(), opensearchutil.BulkIndexerItem{
+		Index:      "test_persisted",
+		Action:     "index",
+		DocumentID: "doc1",
+		Body:       strings.NewReader(`{}`),
+	})
+	assert.NoError(t, err)
+
+	// The whole request fails while running - the item is dropped
+	// rather than kept for the shutdown flush.
+	err = indexer.BulkIndexer.Close(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(indexer.pending.take()))
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+var (
+	// Data stream backing indexes look like .ds-<name>-000001
+	backingIndexRegex = regexp.MustCompile(`^\.ds-(.+)-[0-9]+$`)
+
+	// The indexes of the root org.
+	rootIndexes = map[string]bool{
+		"persisted":      true,
+		"transient":      true,
+		"error":          true,
+		"archived_hunts": true,
+	}
+)
+
+type IndexStorageStats struct {
+	Index     string `json:"index"`
+	DocCount  int64  `json:"doc_count"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+type _CatIndexStats struct {
+	Index     string `json:"index"`
+	DocsCount string `json:"docs.count"`
+	StoreSize string `json:"store.size"`
+}
+
+// Get the total document count and storage size over all the org's
+// indexes.
+func GetOrgStorageStats(ctx context.Context, org_id string) (
+	doc_count int64, size_bytes int64, err error) {
+	stats, err := GetOrgIndexStorageStats(ctx, org_id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, s := range stats {
+		doc_count += s.DocCount
+		size_bytes += s.SizeBytes
+	}
+	return doc_count, size_bytes, nil
+}
+
+// Get the document count and storage size of each of the org's
+// indexes.
+func GetOrgIndexStorageStats(
+	ctx context.Context, org_id string) ([]*IndexStorageStats, error) {
+
+	defer Instrument("GetOrgIndexStorageStats")()
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// Root org indexes are not prefixed so we need to look at all
+	// indexes and filter them below.
+	is_root := GetIndex(org_id, "") == ""
+	pattern := "*"
+	if !is_root {
+		pattern = GetIndex(org_id, "*")
+	}
+
+	res, err := opensearchapi.CatIndicesRequest{
+		Index:  []string{pattern},
+		Format: "json",
+		Bytes:  "b",
+		H:      []string{"index", "docs.count", "store.size"},
+	}.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.IsError() {
+		return nil, makeReadElasticError(data)
+	}
+
+	indexes := []*_CatIndexStats{}
+	err = json.Unmarshal(data, &indexes)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*IndexStorageStats, 0, len(indexes))
+	for _, i := range indexes {
+		if is_root && !isRootIndex(i.Index) {
+			continue
+		}
+
+		// Closed indexes have no stats.
+		doc_count, _ := strconv.ParseInt(i.DocsCount, 10, 64)
+		size_bytes, _ := strconv.ParseInt(i.StoreSize, 10, 64)
+
+		result = append(result, &IndexStorageStats{
+			Index:     i.Index,
+			DocCount:  doc_count,
+			SizeBytes: size_bytes,
+		})
+	}
+
+	return result, nil
+}
+
+// Root org indexes have no org prefix (e.g. "persisted" rather than
+// "o123_persisted"), so they are recognized by name. Index names may
+// contain "_" themselves (e.g. "archived_hunts"), so an unprefixed
+// name can not be told apart from an org's index by its shape.
+func isRootIndex(index string) bool {
+	match := backingIndexRegex.FindStringSubmatch(index)
+	if match != nil {
+		index = match[1]
+	}
+
+	if rootIndexes[index] {
+		return true
+	}
+
+	quarantine := getQuarantineIndex()
+	if quarantine != "" && index == quarantine {
+		return true
+	}
+
+	// Time bucketed indexes of a time series (e.g.
+	// "monitoring-2024.06").
+	doc_type, _, found := strings.Cut(index, "-")
+	if !found {
+		return false
+	}
+	_, is_time_series := getTimeSeriesBucket(doc_type)
+	return doc_type == MonitoringDocType || is_time_series
+}
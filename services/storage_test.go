@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrgStorageStats(t *testing.T) {
+	var pattern string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		pattern = r.URL.Path
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+ {"index": "test_persisted", "docs.count": "10", "store.size": "1000"},
+ {"index": ".ds-test_transient-000001", "docs.count": "20", "store.size": "3000"},
+ {"index": ".ds-test_transient-000002", "docs.count": "5", "store.size": "500"}
+]`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	doc_count, size_bytes, err := GetOrgStorageStats(ctx, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, "/_cat/indices/test_*", pattern)
+	assert.Equal(t, int64(35), doc_count)
+	assert.Equal(t, int64(4500), size_bytes)
+
+	stats, err := GetOrgIndexStorageStats(ctx, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(stats))
+	assert.Equal(t, "test_persisted", stats[0].Index)
+	assert.Equal(t, int64(1000), stats[0].SizeBytes)
+}
+
+func TestIsRootIndex(t *testing.T) {
+	assert.True(t, isRootIndex("persisted"))
+	assert.True(t, isRootIndex(".ds-transient-000001"))
+	assert.False(t, isRootIndex("test_persisted"))
+	assert.False(t, isRootIndex(".ds-test_transient-000001"))
+	assert.False(t, isRootIndex(".opendistro-job-scheduler-lock"))
+
+	// Index names may contain "_".
+	assert.True(t, isRootIndex("archived_hunts"))
+	assert.False(t, isRootIndex("test_archived_hunts"))
+
+	assert.True(t, isRootIndex("monitoring-2024.06"))
+	assert.False(t, isRootIndex("test_monitoring-2024.06"))
+	assert.False(t, isRootIndex("security-auditlog-2024.06.01"))
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	// Append the tag to the tags field unless it is already there.
+	tagByQueryPainless = `
+if (ctx._source.tags == null) {
+  ctx._source.tags = [params.tag];
+} else if (ctx._source.tags instanceof String) {
+  if (ctx._source.tags == params.tag) {
+    ctx.op = 'noop';
+  } else {
+    ctx._source.tags = [ctx._source.tags, params.tag];
+  }
+} else if (ctx._source.tags.contains(params.tag)) {
+  ctx.op = 'noop';
+} else {
+  ctx._source.tags.add(params.tag);
+}
+`
+)
+
+type _UpdateByQueryResponse struct {
+	Updated int `json:"updated"`
+	Noops   int `json:"noops"`
+}
+
+// Add the tag to the tags field of all documents matching the
+// query. The query is a search body (e.g. {"query": {...}}).
+// Documents which already have the tag are left alone so tagging is
+// idempotent. Returns the number of documents that were updated.
+func TagByQuery(
+	ctx context.Context, org_id, index, query, tag string) (int, error) {
+	defer Instrument("TagByQuery")()
+	defer Debug("TagByQuery %v %v", index, tag)()
+
+	if tag == "" {
+		return 0, errors.New("TagByQuery: tag must be specified")
+	}
+
+	err := checkWritable()
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return 0, err
+	}
+
+	body := make(map[string]json.RawMessage)
+	err = json.Unmarshal([]byte(query), &body)
+	if err != nil {
+		return 0, err
+	}
+
+	body["script"] = json.RawMessage(json.Format(`{
+  "source": %q,
+  "lang": "painless",
+  "params": {"tag": %q}
+}`, tagByQueryPainless, tag))
+
+	serialized, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := opensearchapi.UpdateByQueryRequest{
+		Index:     GetIndexes(org_id, index),
+		Body:      strings.NewReader(string(serialized)),
+		Refresh:   &TRUE,
+		Conflicts: "proceed",
+	}.Do(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.IsError() {
+		return 0, makeElasticError(data)
+	}
+
+	response := &_UpdateByQueryResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return 0, err
+	}
+
+	return response.Updated, nil
+}
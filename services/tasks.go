@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	// Sent with all our requests so the cluster can tell which
+	// tasks we started.
+	cloudveloOpaqueId = "cloudvelo"
+)
+
+var (
+	// The long running operations we start.
+	taskActions = []string{
+		"indices:data/write/reindex",
+		"indices:data/write/update/byquery",
+		"indices:data/write/delete/byquery",
+	}
+)
+
+// A long running operation on the cluster.
+type TaskInfo struct {
+	// The id to cancel the task with (node:id).
+	Id          string        `json:"id"`
+	Action      string        `json:"action"`
+	Description string        `json:"description"`
+	StartTime   time.Time     `json:"start_time"`
+	RunningTime time.Duration `json:"running_time"`
+	Cancellable bool          `json:"cancellable"`
+}
+
+type _TaskDetails struct {
+	Node               string            `json:"node"`
+	Id                 int64             `json:"id"`
+	Action             string            `json:"action"`
+	Description        string            `json:"description"`
+	StartTimeInMillis  int64             `json:"start_time_in_millis"`
+	RunningTimeInNanos int64             `json:"running_time_in_nanos"`
+	Cancellable        bool              `json:"cancellable"`
+	ParentTaskId       string            `json:"parent_task_id"`
+	Headers            map[string]string `json:"headers"`
+}
+
+type _TasksResponse struct {
+	Tasks        []*_TaskDetails   `json:"tasks"`
+	NodeFailures []json.RawMessage `json:"node_failures"`
+	TaskFailures []json.RawMessage `json:"task_failures"`
+}
+
+// List the reindex, update by query and delete by query operations
+// we started which are still running, oldest first. Operations split
+// into slices are listed once.
+func ListTasks(ctx context.Context) ([]TaskInfo, error) {
+	defer Instrument("ListTasks")()
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := opensearchapi.TasksListRequest{
+		Actions:  taskActions,
+		Detailed: &TRUE,
+		GroupBy:  "none",
+	}.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.IsError() {
+		return nil, makeReadElasticError(data)
+	}
+
+	response := &_TasksResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []TaskInfo{}
+	for _, task := range response.Tasks {
+		// Slices are cancelled with their parent.
+		if task.ParentTaskId != "" ||
+			task.Headers["X-Opaque-Id"] != cloudveloOpaqueId {
+			continue
+		}
+
+		result = append(result, TaskInfo{
+			Id:          fmt.Sprintf("%v:%v", task.Node, task.Id),
+			Action:      task.Action,
+			Description: task.Description,
+			StartTime:   time.Unix(0, task.StartTimeInMillis*1000000).UTC(),
+			RunningTime: time.Duration(task.RunningTimeInNanos),
+			Cancellable: task.Cancellable,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+	return result, nil
+}
+
+// Cancel a running task (by the id from ListTasks). The task stops
+// at its next checkpoint - changes it already made are kept.
+func CancelTask(ctx context.Context, task_id string) error {
+	defer Instrument("CancelTask")()
+	defer Debug("CancelTask %v", task_id)()
+
+	if task_id == "" {
+		return errors.New("CancelTask: task id is required")
+	}
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.TasksCancelRequest{
+		TaskID: task_id,
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.IsError() {
+		return makeElasticError(data)
+	}
+
+	response := &_TasksResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return err
+	}
+
+	// E.g. the task is not cancellable or no longer exists.
+	for _, failures := range [][]json.RawMessage{
+		response.TaskFailures, response.NodeFailures} {
+		if len(failures) > 0 {
+			return fmt.Errorf("CancelTask %v: %v", task_id, string(failures[0]))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListAndCancelTasks(t *testing.T) {
+	var requests []string
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path == "/_tasks" {
+			assert.Equal(t, "none", r.URL.Query().Get("group_by"))
+
+			// Our reindex is split into a slice, someone else's
+			// delete by query is also running.
+			w.Write([]byte(`{"tasks": [
+  {"node": "n1", "id": 20, "action": "indices:data/write/delete/byquery",
+   "description": "delete-by-query [test_persisted]",
+   "start_time_in_millis": 1700000000000, "running_time_in_nanos": 5,
+   "cancellable": true, "headers": {"X-Opaque-Id": "someone_else"}},
+  {"node": "n1", "id": 11, "action": "indices:data/write/reindex",
+   "description": "reindex from [test_a] to [test_b]",
+   "start_time_in_millis": 1700000001000, "running_time_in_nanos": 2000000000,
+   "cancellable": true, "parent_task_id": "n1:10",
+   "headers": {"X-Opaque-Id": "cloudvelo"}},
+  {"node": "n1", "id": 10, "action": "indices:data/write/reindex",
+   "description": "reindex from [test_a] to [test_b]",
+   "start_time_in_millis": 1700000001000, "running_time_in_nanos": 2000000000,
+   "cancellable": true, "headers": {"X-Opaque-Id": "cloudvelo"}}
+]}`))
+			return
+		}
+
+		if r.URL.Path == "/_tasks/n1:404/_cancel" {
+			w.Write([]byte(`{"node_failures": [{"type": "failed_node_exception",
+  "reason": "task not found"}]}`))
+			return
+		}
+		w.Write([]byte(`{"nodes": {"n1": {"tasks": {"n1:10": {}}}}}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	tasks, err := ListTasks(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []TaskInfo{{
+		Id:          "n1:10",
+		Action:      "indices:data/write/reindex",
+		Description: "reindex from [test_a] to [test_b]",
+		StartTime:   time.Unix(1700000001, 0).UTC(),
+		RunningTime: 2 * time.Second,
+		Cancellable: true,
+	}}, tasks)
+
+	err = CancelTask(ctx, tasks[0].Id)
+	assert.NoError(t, err)
+
+	err = CancelTask(ctx, "n1:404")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "task not found")
+
+	assert.Equal(t, []string{
+		"GET /_tasks",
+		"POST /_tasks/n1:10/_cancel",
+		"POST /_tasks/n1:404/_cancel"}, requests)
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// The parts of an index template we need to keep existing indexes
+// in line with it.
+type indexTemplate struct {
+	IndexPatterns []string `json:"index_patterns"`
+	Version       int      `json:"version"`
+	Template      struct {
+		Mappings struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"mappings"`
+	} `json:"template"`
+}
+
+// The version of the template as given in its body. Templates
+// without a version have version 0.
+func GetTemplateBodyVersion(template string) (int, error) {
+	parsed := &indexTemplate{}
+	err := json.Unmarshal([]byte(template), parsed)
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Version, nil
+}
+
+// Get the version of the installed index template. exists is false
+// if the template is not installed.
+func GetTemplateVersion(ctx context.Context, name string) (
+	version int, exists bool, err error) {
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return 0, false, err
+	}
+
+	res, err := opensearchapi.IndicesGetIndexTemplateRequest{
+		Name: []string{name},
+	}.Do(ctx, client)
+	if err != nil {
+		return 0, false, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return 0, false, nil
+	}
+
+	if res.IsError() {
+		return 0, false, makeElasticError(data)
+	}
+
+	installed := &struct {
+		IndexTemplates []struct {
+			IndexTemplate indexTemplate `json:"index_template"`
+		} `json:"index_templates"`
+	}{}
+	err = json.Unmarshal(data, installed)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(installed.IndexTemplates) == 0 {
+		return 0, false, nil
+	}
+
+	return installed.IndexTemplates[0].IndexTemplate.Version, true, nil
+}
+
+// Replace an installed index template with a newer one. Templates
+// only apply when an index is created and the mappings are not
+// dynamic, so the fields the template adds are also mapped in the
+// existing indexes matching it - otherwise documents written to them
+// can not be searched by these fields. Fields which are already
+// mapped are left alone; changed types or settings (e.g. the index
+// sort) only apply to new indexes.
+func UpdateTemplate(ctx context.Context, name, template string) error {
+	defer Instrument("UpdateTemplate")()
+	defer Debug("UpdateTemplate %v", name)()
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IndicesPutIndexTemplateRequest{
+		Name: name,
+		Body: strings.NewReader(template),
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		data, _ := ioutil.ReadAll(res.Body)
+		return makeElasticError(data)
+	}
+
+	return addTemplateMappings(ctx, template)
+}
+
+// Map the template's fields which are missing in the existing
+// indexes matching it.
+func addTemplateMappings(ctx context.Context, template string) error {
+	parsed := &indexTemplate{}
+	err := json.Unmarshal([]byte(template), parsed)
+	if err != nil {
+		return err
+	}
+
+	desired := parsed.Template.Mappings.Properties
+	if len(desired) == 0 {
+		return nil
+	}
+
+	for _, pattern := range parsed.IndexPatterns {
+		mappings, err := getMappingProperties(ctx, pattern)
+		if err != nil {
+			return err
+		}
+
+		// Update the indexes in a stable order.
+		names := make([]string, 0, len(mappings))
+		for name := range mappings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			actual := make(map[string]json.RawMessage)
+			if len(mappings[name]) > 0 {
+				err = json.Unmarshal(mappings[name], &actual)
+				if err != nil {
+					return err
+				}
+			}
+
+			missing := missingProperties(desired, actual)
+			if len(missing) == 0 {
+				continue
+			}
+
+			err = putMapping(ctx, name, json.MustMarshalString(
+				map[string]interface{}{"properties": missing}))
+			if err != nil {
+				return err
+			}
+
+			// New keyword fields need the configured limits too.
+			err = UpdateKeywordIgnoreAbove(ctx, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// The properties in desired which are not mapped in actual,
+// including missing sub fields of mapped objects.
+func missingProperties(
+	desired, actual map[string]json.RawMessage) map[string]interface{} {
+	result := make(map[string]interface{})
+	for name, property := range desired {
+		existing, pres := actual[name]
+		if !pres {
+			result[name] = property
+			continue
+		}
+
+		desired_properties := getSubProperties(property)
+		if len(desired_properties) == 0 {
+			continue
+		}
+
+		missing := missingProperties(
+			desired_properties, getSubProperties(existing))
+		if len(missing) > 0 {
+			result[name] = map[string]interface{}{"properties": missing}
+		}
+	}
+	return result
+}
+
+func getSubProperties(property json.RawMessage) map[string]json.RawMessage {
+	parsed := &struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}{}
+	_ = json.Unmarshal(property, parsed)
+	return parsed.Properties
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestMissingProperties(t *testing.T) {
+	desired := make(map[string]json.RawMessage)
+	err := json.Unmarshal([]byte(`{
+  "client_id": {"type": "keyword"},
+  "expires": {"type": "long"},
+  "context": {"properties": {
+    "artifact": {"type": "keyword"},
+    "status": {"type": "keyword"}
+  }}
+}`), &desired)
+	assert.NoError(t, err)
+
+	actual := make(map[string]json.RawMessage)
+	err = json.Unmarshal([]byte(`{
+  "client_id": {"type": "keyword", "ignore_above": 512},
+  "context": {"properties": {
+    "artifact": {"type": "keyword"}
+  }}
+}`), &actual)
+	assert.NoError(t, err)
+
+	// Only the fields which are not mapped at all are added. The
+	// existing client_id mapping is left alone.
+	assert.Equal(t, `{"properties":{"context":{"properties":{"status":{"type":"keyword"}}},"expires":{"type":"long"}}}`,
+		json.MustMarshalString(map[string]interface{}{
+			"properties": missingProperties(desired, actual)}))
+
+	// Nothing is missing.
+	assert.Equal(t, 0, len(missingProperties(desired, desired)))
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+var (
+	// A pattern starting with a wildcard has to be compared with
+	// every term in the index which is very slow on large indexes.
+	ErrLeadingWildcard = errors.New("Leading wildcards are not allowed")
+
+	wildcardEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`)
+)
+
+// A query clause matching documents where the text field contains
+// the words of the phrase in order (e.g. "cmd.exe /c"). Slop is the
+// number of other words allowed between them (0 for an exact
+// sequence).
+func MatchPhraseQuery(field, phrase string, slop int) string {
+	return json.Format(`{"match_phrase": {%q: {"query": %q, "slop": %q}}}`,
+		field, phrase, slop)
+}
+
+// A query clause matching documents where the keyword field matches
+// the pattern: * matches any characters and ? a single one
+// (e.g. "cmd.exe*"). Patterns starting with a wildcard are rejected
+// with ErrLeadingWildcard.
+func WildcardQuery(field, pattern string) (string, error) {
+	if pattern == "" {
+		return "", errors.New("WildcardQuery: empty pattern")
+	}
+
+	if pattern[0] == '*' || pattern[0] == '?' {
+		return "", fmt.Errorf("WildcardQuery %v: %w", pattern, ErrLeadingWildcard)
+	}
+
+	return json.Format(`{"wildcard": {%q: {"value": %q}}}`,
+		field, pattern), nil
+}
+
+// A query clause matching documents where the keyword field starts
+// with the prefix. An empty prefix would match every term and is
+// rejected.
+func PrefixQuery(field, prefix string) (string, error) {
+	if prefix == "" {
+		return "", fmt.Errorf("PrefixQuery: %w", ErrLeadingWildcard)
+	}
+
+	return json.Format(`{"prefix": {%q: {"value": %q}}}`,
+		field, prefix), nil
+}
+
+// Escape the wildcard characters in user input so it can be used
+// literally in a WildcardQuery pattern.
+func EscapeWildcard(literal string) string {
+	return wildcardEscaper.Replace(literal)
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestMatchPhraseQuery(t *testing.T) {
+	query := MatchPhraseQuery("command_line", `cmd.exe /c "whoami"`, 0)
+
+	parsed := make(map[string]map[string]struct {
+		Query string `json:"query"`
+		Slop  int    `json:"slop"`
+	})
+	err := json.Unmarshal([]byte(query), &parsed)
+	assert.NoError(t, err)
+	assert.Equal(t, `cmd.exe /c "whoami"`,
+		parsed["match_phrase"]["command_line"].Query)
+	assert.Equal(t, 0, parsed["match_phrase"]["command_line"].Slop)
+}
+
+func TestWildcardQuery(t *testing.T) {
+	query, err := WildcardQuery("image", "cmd.exe*")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"wildcard": {"image": {"value": "cmd.exe*"}}}`, query)
+
+	query, err = PrefixQuery("image", "cmd")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"prefix": {"image": {"value": "cmd"}}}`, query)
+
+	// Leading wildcards scan every term so are rejected.
+	for _, pattern := range []string{"*.exe", "?md.exe"} {
+		_, err = WildcardQuery("image", pattern)
+		assert.True(t, errors.Is(err, ErrLeadingWildcard), pattern)
+	}
+
+	_, err = WildcardQuery("image", "")
+	assert.Error(t, err)
+
+	_, err = PrefixQuery("image", "")
+	assert.True(t, errors.Is(err, ErrLeadingWildcard))
+
+	// Escaped user input is matched literally.
+	query, err = WildcardQuery("image", EscapeWildcard(`what?\*`)+"*")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"wildcard": {"image": {"value": "what\\?\\\\\\**"}}}`, query)
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	// The canonical timestamp field of time series documents
+	// (nanoseconds).
+	timeRangeField = "timestamp"
+
+	// Beyond this many buckets we search all of them with a
+	// wildcard rather than listing them.
+	maxTimeRangeBuckets = 100
+
+	// The most results QueryTimeRange returns (the default
+	// max_result_window). Use QueryChan with GetTimeRangeIndexes to
+	// read more.
+	maxTimeRangeResults = 10000
+
+	timeRangeQuery = `
+{
+  "size": %q,
+  "sort": [{%q: "asc"}],
+  "query": {
+    "bool": {
+      "must": [%s],
+      "filter": [
+        {"range": {%q: {"gte": %q, "lt": %q}}}
+      ]
+    }
+  }
+}
+`
+)
+
+// The indexes which hold the documents of the doc type between from
+// (inclusive) and to (exclusive), as an index pattern. Like
+// ResolveIndexPattern this includes the default index as documents
+// written before the doc type became a time series are still there.
+// If the doc type is not a time series the index is returned as is.
+func GetTimeRangeIndexes(index, doc_type string, from, to time.Time) string {
+	bucket, pres := getTimeSeriesBucket(doc_type)
+	if !pres {
+		return index
+	}
+
+	layout, _ := getTimeBucketLayout(bucket)
+
+	indexes := []string{index}
+	start := getTimeBucketStart(bucket, from)
+	for start.Before(to) {
+		if len(indexes) > maxTimeRangeBuckets {
+			return ResolveIndexPattern(index, doc_type)
+		}
+		indexes = append(indexes, doc_type+"-"+start.Format(layout))
+		start = getNextTimeBucket(bucket, start)
+	}
+	return strings.Join(indexes, ",")
+}
+
+// Search the documents of the doc type whose canonical timestamp is
+// between from (inclusive) and to (exclusive). Only the default
+// index and the time bucketed indexes which overlap the range are
+// searched. The
+// query is a query clause (default all documents). Results are
+// sorted by time and at most maxTimeRangeResults are returned.
+func QueryTimeRange(ctx context.Context,
+	org_id, index, doc_type string, from, to time.Time,
+	query string) ([]Result, error) {
+
+	defer Instrument("QueryTimeRange")()
+	defer Debug("QueryTimeRange %v %v %v %v", index, doc_type, from, to)()
+
+	if to.Before(from) {
+		return nil, errors.New("QueryTimeRange: range ends before it starts")
+	}
+
+	if query == "" {
+		query = `{"match_all": {}}`
+	}
+
+	indexes := GetTimeRangeIndexes(index, doc_type, from, to)
+
+	// Buckets with no data were never created.
+	hits, _, err := queryElasticHits(ctx, org_id, indexes,
+		json.Format(timeRangeQuery, maxTimeRangeResults, timeRangeField,
+			query, timeRangeField, from.UnixNano(), to.UnixNano()),
+		QueryOptions{MissingIndexes: MissingIndexesIgnore})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, Result{
+			JSON: hit.Source,
+			Id:   hit.Id,
+		})
+	}
+	return results, nil
+}
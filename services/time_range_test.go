@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+func TestQueryTimeRange(t *testing.T) {
+	var path, ignore_unavailable string
+	var body []byte
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		path = r.URL.Path
+		ignore_unavailable = r.URL.Query().Get("ignore_unavailable")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hits": {"total": {"value": 2}, "hits": [
+  {"_index": "o123_monitoring-2024.06", "_id": "1", "_source": {"timestamp": 1}},
+  {"_index": "o123_monitoring-2024.07", "_id": "2", "_source": {"timestamp": 2}}
+]}}`))
+	})
+	defer closer()
+
+	err := SetTimeSeriesIndexes(map[string]string{"monitoring": TimeBucketMonth})
+	assert.NoError(t, err)
+	defer SetTimeSeriesIndexes(nil)
+
+	ctx := context.Background()
+	from := time.Date(2024, 6, 20, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 7, 10, 0, 0, 0, 0, time.UTC)
+
+	results, err := QueryTimeRange(ctx, "O123", "transient", "monitoring", from, to,
+		`{"match": {"client_id": "C.1"}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "1", results[0].Id)
+	assert.Equal(t, "2", results[1].Id)
+
+	// Only the default index and the buckets overlapping the range
+	// are searched.
+	assert.Equal(t,
+		"/o123_transient,o123_monitoring-2024.06,o123_monitoring-2024.07/_search", path)
+	assert.Equal(t, "true", ignore_unavailable)
+
+	request := &struct {
+		Query struct {
+			Bool struct {
+				Must   []json.RawMessage `json:"must"`
+				Filter []struct {
+					Range map[string]map[string]int64 `json:"range"`
+				} `json:"filter"`
+			} `json:"bool"`
+		} `json:"query"`
+	}{}
+	err = json.Unmarshal(body, request)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"match": {"client_id": "C.1"}}`,
+		string(request.Query.Bool.Must[0]))
+	assert.Equal(t, map[string]map[string]int64{
+		"timestamp": {"gte": from.UnixNano(), "lt": to.UnixNano()},
+	}, request.Query.Bool.Filter[0].Range)
+
+	// The end of the range is exclusive.
+	assert.Equal(t, "transient,monitoring-2024.06",
+		GetTimeRangeIndexes("transient", "monitoring", from,
+			time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)))
+
+	// Long ranges search all the buckets.
+	assert.Equal(t, "transient,monitoring-*", GetTimeRangeIndexes(
+		"transient", "monitoring", from, from.AddDate(20, 0, 0)))
+
+	// Other indexes are searched as is.
+	assert.Equal(t, "transient", GetTimeRangeIndexes(
+		"transient", "transient", from, to))
+
+	_, err = QueryTimeRange(ctx, "O123", "transient", "monitoring", to, from, "")
+	assert.Error(t, err)
+}
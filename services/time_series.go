@@ -0,0 +1,113 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// The doc type of client monitoring (event) rows, which may be
+	// configured as a time series.
+	MonitoringDocType = "monitoring"
+
+	TimeBucketDay   = "day"
+	TimeBucketMonth = "month"
+	TimeBucketYear  = "year"
+)
+
+var (
+	// The time bucket size by doc type. Guarded by mu
+	time_series_buckets = make(map[string]string)
+)
+
+func getTimeBucketLayout(bucket string) (string, error) {
+	switch bucket {
+	case TimeBucketDay:
+		return "2006.01.02", nil
+	case TimeBucketMonth:
+		return "2006.01", nil
+	case TimeBucketYear:
+		return "2006", nil
+	default:
+		return "", fmt.Errorf("Unknown time series bucket %v", bucket)
+	}
+}
+
+// The start of the bucket containing the time (in UTC).
+func getTimeBucketStart(bucket string, timestamp time.Time) time.Time {
+	timestamp = timestamp.UTC()
+	switch bucket {
+	case TimeBucketMonth:
+		return time.Date(timestamp.Year(), timestamp.Month(), 1,
+			0, 0, 0, 0, time.UTC)
+	case TimeBucketYear:
+		return time.Date(timestamp.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(timestamp.Year(), timestamp.Month(),
+			timestamp.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func getNextTimeBucket(bucket string, start time.Time) time.Time {
+	switch bucket {
+	case TimeBucketMonth:
+		return start.AddDate(0, 1, 0)
+	case TimeBucketYear:
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+func getTimeSeriesBucket(doc_type string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	bucket, pres := time_series_buckets[doc_type]
+	return bucket, pres
+}
+
+// Set the doc types which are written to time bucketed indexes, by
+// the size of the bucket (day, month or year).
+func SetTimeSeriesIndexes(buckets map[string]string) error {
+	result := make(map[string]string)
+	for doc_type, bucket := range buckets {
+		_, err := getTimeBucketLayout(bucket)
+		if err != nil {
+			return err
+		}
+		result[doc_type] = bucket
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	time_series_buckets = result
+	return nil
+}
+
+// Get the index a document of the doc type should be written to. If
+// the doc type is a time series it goes to the index for the time
+// bucket containing its timestamp (e.g. "monitoring-2024.06"),
+// otherwise to the default index. Old buckets can then be expired by
+// deleting their index.
+func ResolveIndex(index, doc_type string, timestamp time.Time) string {
+	bucket, pres := getTimeSeriesBucket(doc_type)
+	if !pres {
+		return index
+	}
+
+	layout, _ := getTimeBucketLayout(bucket)
+	return doc_type + "-" + timestamp.UTC().Format(layout)
+}
+
+// The index pattern to search for documents of the doc type. This
+// includes the default index as documents written before the doc
+// type became a time series are still there.
+func ResolveIndexPattern(index, doc_type string) string {
+	_, pres := getTimeSeriesBucket(doc_type)
+	if !pres {
+		return index
+	}
+	return index + "," + doc_type + "-*"
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeSeriesIndexes(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		paths = append(paths, r.URL.Path)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "created"}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	record := map[string]string{"client_id": "C.1"}
+	june := time.Date(2024, 6, 30, 23, 0, 0, 0, time.UTC)
+	july := june.Add(2 * time.Hour)
+
+	// Not configured: documents go to the default index.
+	index := ResolveIndex("transient", "monitoring", june)
+	assert.Equal(t, "transient", index)
+	assert.Equal(t, "transient", ResolveIndexPattern("transient", "monitoring"))
+
+	assert.Error(t, SetTimeSeriesIndexes(map[string]string{"monitoring": "hour"}))
+
+	err := SetTimeSeriesIndexes(map[string]string{"monitoring": TimeBucketMonth})
+	assert.NoError(t, err)
+	defer SetTimeSeriesIndexes(nil)
+
+	// Other doc types are not affected.
+	assert.Equal(t, "transient", ResolveIndex("transient", "logs", june))
+
+	for _, ts := range []time.Time{june, july} {
+		err = SetElasticIndex(ctx, "O123",
+			ResolveIndex("transient", "monitoring", ts), "1", record)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{
+		"/o123_monitoring-2024.06/_doc/1",
+		"/o123_monitoring-2024.07/_doc/1",
+	}, paths)
+
+	// Searches cover the old default index and all the buckets.
+	assert.Equal(t, []string{"o123_transient", "o123_monitoring-*"},
+		GetIndexes("O123", ResolveIndexPattern("transient", "monitoring")))
+
+	// Buckets are in UTC regardless of the timestamp's zone.
+	zone := time.FixedZone("east", 10*3600)
+	SetTimeSeriesIndexes(map[string]string{"monitoring": TimeBucketDay})
+	assert.Equal(t, "monitoring-2024.06.30",
+		ResolveIndex("transient", "monitoring", june.In(zone)))
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var (
+	// How long each operation may take (including retries) when the
+	// caller's context has no deadline. Keyed by the operation's
+	// metric name.
+	defaultOperationTimeouts = map[string]time.Duration{
+		"GetElasticRecord":          30 * time.Second,
+		"GetElasticRecordByQuery":   30 * time.Second,
+		"GetMultipleElasticRecords": 30 * time.Second,
+		"SetElasticIndex":           60 * time.Second,
+		"UpdateIndex":               60 * time.Second,
+		"DeleteDocument":            60 * time.Second,
+		"QueryElastic":              2 * time.Minute,
+		"QueryElasticRaw":           2 * time.Minute,
+		"QueryElasticFull":          2 * time.Minute,
+		"QueryElasticIds":           2 * time.Minute,
+		"QueryElasticAggregations":  2 * time.Minute,
+	}
+
+	operation_timeouts = defaultOperationTimeouts
+)
+
+// Override the default timeouts of the operations (in seconds, 0
+// keeps the default). Operations not given keep their default.
+func SetOperationTimeouts(timeouts map[string]int) error {
+	result := make(map[string]time.Duration)
+	for operation, timeout := range defaultOperationTimeouts {
+		result[operation] = timeout
+	}
+
+	for operation, seconds := range timeouts {
+		_, pres := defaultOperationTimeouts[operation]
+		if !pres {
+			return fmt.Errorf("SetOperationTimeouts: unknown operation %v",
+				operation)
+		}
+
+		if seconds < 0 {
+			return fmt.Errorf("SetOperationTimeouts: invalid timeout %v for %v",
+				seconds, operation)
+		}
+
+		if seconds > 0 {
+			result[operation] = time.Duration(seconds) * time.Second
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	operation_timeouts = result
+	return nil
+}
+
+func getOperationTimeout(operation string) time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return operation_timeouts[operation]
+}
+
+// Bound the operation by its timeout so a stuck cluster can not
+// block a caller without a deadline (e.g. context.Background())
+// forever. A deadline set by the caller is always respected as is.
+func withOperationTimeout(ctx context.Context, operation string) (
+	context.Context, func()) {
+	_, has_deadline := ctx.Deadline()
+	timeout := getOperationTimeout(operation)
+	if has_deadline || timeout == 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationTimeouts(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// Simulate a stuck cluster.
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	})
+	defer closer()
+
+	err := SetOperationTimeouts(map[string]int{"NoSuchOperation": 1})
+	assert.Error(t, err)
+
+	err = SetOperationTimeouts(map[string]int{
+		"GetElasticRecord": 1,
+		"UpdateIndex":      1,
+	})
+	assert.NoError(t, err)
+	defer SetOperationTimeouts(nil)
+
+	// A context without a deadline gets the operation's timeout.
+	start := time.Now()
+	_, err = GetElasticRecord(context.Background(), "test", "persisted", "1")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	start = time.Now()
+	err = UpdateIndex(context.Background(), "test", "persisted", "1",
+		`{"doc": {"x": 1}}`)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// The caller's deadline is used as is, even if it is shorter.
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+
+	start = time.Now()
+	_, err = GetElasticRecord(ctx, "test", "persisted", "1")
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
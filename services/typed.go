@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+// Some hits could not be unmarshalled. The other hits are still
+// returned.
+type UnmarshalErrors struct {
+	// By the position of the hit in the results.
+	Errors map[int]error
+}
+
+func (self *UnmarshalErrors) Error() string {
+	positions := make([]int, 0, len(self.Errors))
+	for i := range self.Errors {
+		positions = append(positions, i)
+	}
+	sort.Ints(positions)
+
+	messages := make([]string, 0, len(positions))
+	for _, i := range positions {
+		messages = append(messages, fmt.Sprintf("hit %v: %v", i, self.Errors[i]))
+	}
+
+	return fmt.Sprintf("Unable to unmarshal %v hits: %v",
+		len(positions), strings.Join(messages, ", "))
+}
+
+// Unmarshal each hit into a T. Hits which fail to unmarshal are
+// skipped and reported in an *UnmarshalErrors.
+func UnmarshalHits[T any](hits []json.RawMessage) ([]T, error) {
+	result := make([]T, 0, len(hits))
+	var unmarshal_errors *UnmarshalErrors
+
+	for i, hit := range hits {
+		var item T
+		err := json.Unmarshal(hit, &item)
+		if err != nil {
+			if unmarshal_errors == nil {
+				unmarshal_errors = &UnmarshalErrors{Errors: make(map[int]error)}
+			}
+			unmarshal_errors.Errors[i] = err
+			continue
+		}
+		result = append(result, item)
+	}
+
+	if unmarshal_errors != nil {
+		return result, unmarshal_errors
+	}
+	return result, nil
+}
+
+// Run the query and unmarshal the source of each hit into a T. If
+// only some hits fail to unmarshal the rest are returned together
+// with an *UnmarshalErrors.
+func QueryElasticTyped[T any](
+	ctx context.Context,
+	org_id, index, query string) ([]T, error) {
+
+	defer Instrument("QueryElasticTyped")()
+	defer Debug("QueryElasticTyped %v", index)()
+
+	hits, _, err := QueryElasticRaw(ctx, org_id, index, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return UnmarshalHits[T](hits)
+}
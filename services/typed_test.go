@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryElasticTyped(t *testing.T) {
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hits": {"total": {"value": 3}, "hits": [
+  {"_id": "1", "_source": {"client_id": "C.1", "hostname": "Host1"}},
+  {"_id": "2", "_source": {"client_id": 2, "hostname": "Host2"}},
+  {"_id": "3", "_source": {"client_id": "C.3", "hostname": "Host3"}}]}}`))
+	})
+	defer closer()
+
+	type clientRecord struct {
+		ClientId string `json:"client_id"`
+		Hostname string `json:"hostname"`
+	}
+
+	records, err := QueryElasticTyped[clientRecord](context.Background(),
+		"org1", "persisted", `{"query": {"match_all": {}}}`)
+
+	// The bad hit is reported but the others are still returned.
+	unmarshal_errors := &UnmarshalErrors{}
+	assert.True(t, errors.As(err, &unmarshal_errors))
+	assert.Equal(t, 1, len(unmarshal_errors.Errors))
+	assert.Error(t, unmarshal_errors.Errors[1])
+
+	assert.Equal(t, []clientRecord{
+		{ClientId: "C.1", Hostname: "Host1"},
+		{ClientId: "C.3", Hostname: "Host3"},
+	}, records)
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+const (
+	UpdateResultNoop = "noop"
+)
+
+// The outcome of an update request.
+type UpdateResult struct {
+	// One of "created", "updated" or "noop"
+	Result  string `json:"result"`
+	Version int64  `json:"_version"`
+}
+
+// Like UpdateIndex but reports the result of the update. Updates
+// which do not change the document are detected and the index is
+// only refreshed when the document actually changed. This avoids
+// version bumps and refreshes for idempotent updates.
+func UpdateIndexWithResult(
+	ctx context.Context, org_id, index, id string, query string) (
+	*UpdateResult, error) {
+	defer Instrument("UpdateIndex")()
+	defer Debug("UpdateIndexWithResult %v %v", index, id)()
+
+	ctx, cancel := withOperationTimeout(ctx, "UpdateIndex")
+	defer cancel()
+
+	err := checkWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkDocumentId(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkOrgWrite(ctx, org_id)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *UpdateResult
+	err = retry(func() error {
+		result, err = updateIndex(ctx, org_id, index, id, query, "false")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Result != UpdateResultNoop {
+		err = refreshAfterWrite(ctx, GetIndex(org_id, index))
+	}
+	return result, err
+}
+
+func updateIndex(
+	ctx context.Context, org_id, index, id, query, refresh string) (
+	*UpdateResult, error) {
+	client, err := GetElasticClient()
+	if err != nil {
+		return nil, err
+	}
+
+	es_req := opensearchapi.UpdateRequest{
+		Index:      GetIndex(org_id, index),
+		DocumentID: id,
+		Body:       strings.NewReader(withDetectNoop(query)),
+		Refresh:    refresh,
+
+		WaitForActiveShards: getWaitForActiveShards(ctx),
+	}
+
+	res, err := es_req.Do(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.IsError() {
+		return nil, makeElasticError(data)
+	}
+
+	result := &UpdateResult{}
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Partial document updates should not write anything if the
+// document is unchanged. Scripted updates signal this themselves by
+// setting ctx.op = 'none'.
+func withDetectNoop(query string) string {
+	body := make(map[string]json.RawMessage)
+	err := json.Unmarshal([]byte(query), &body)
+	if err != nil {
+		return query
+	}
+
+	_, has_doc := body["doc"]
+	_, has_detect_noop := body["detect_noop"]
+	if !has_doc || has_detect_noop {
+		return query
+	}
+
+	body["detect_noop"] = json.RawMessage("true")
+	serialized, err := json.Marshal(body)
+	if err != nil {
+		return query
+	}
+	return string(serialized)
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateIndexNoop(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	var body string
+	result := "noop"
+
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/_update/C.1") {
+			data, _ := ioutil.ReadAll(r.Body)
+			body = string(data)
+			assert.Equal(t, "false", r.URL.Query().Get("refresh"))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "` + result + `", "_version": 3}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	query := `{"doc": {"hostname": "Host"}}`
+
+	// A noop update does not refresh the index.
+	res, err := UpdateIndexWithResult(ctx, "test", "persisted", "C.1", query)
+	assert.NoError(t, err)
+	assert.Equal(t, &UpdateResult{Result: "noop", Version: 3}, res)
+	assert.Equal(t, []string{"POST /test_persisted/_update/C.1"}, requests)
+	assert.Contains(t, body, `"detect_noop":true`)
+
+	// A real update refreshes the index.
+	requests = nil
+	result = "updated"
+	res, err = UpdateIndexWithResult(ctx, "test", "persisted", "C.1", query)
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", res.Result)
+	assert.Equal(t, []string{
+		"POST /test_persisted/_update/C.1",
+		"POST /test_persisted/_refresh"}, requests)
+}
+
+func TestWithDetectNoop(t *testing.T) {
+	// Scripted updates are unchanged.
+	script := `{"script": {"source": "ctx.op = 'none'"}}`
+	assert.Equal(t, script, withDetectNoop(script))
+
+	// Explicit settings are respected.
+	explicit := `{"doc": {}, "detect_noop": false}`
+	assert.Equal(t, explicit, withDetectNoop(explicit))
+}
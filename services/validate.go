@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	opensearchapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+	"www.velocidex.com/golang/velociraptor/json"
+)
+
+var (
+	ErrInvalidQuery = errors.New("Invalid query")
+)
+
+type validateQueryResponse struct {
+	Valid        bool   `json:"valid"`
+	Error        string `json:"error"`
+	Explanations []struct {
+		Index string `json:"index"`
+		Valid bool   `json:"valid"`
+		Error string `json:"error"`
+	} `json:"explanations"`
+}
+
+// Check a query without running it. The query must only have a
+// "query" clause - the validate API does not accept sort, size etc.
+// Invalid queries return an ErrInvalidQuery with the explanation
+// from the server.
+func ValidateQuery(ctx context.Context, org_id, index, query string) error {
+	defer Instrument("ValidateQuery")()
+	defer Debug("ValidateQuery %v", index)()
+
+	// Catch syntax errors without a round trip.
+	if !stdjson.Valid([]byte(query)) {
+		return fmt.Errorf("%w: Malformed JSON", ErrInvalidQuery)
+	}
+
+	client, err := GetElasticClient()
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IndicesValidateQueryRequest{
+		Index:   GetIndexes(org_id, index),
+		Body:    strings.NewReader(query),
+		Explain: &TRUE,
+	}.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	// Queries which can not be parsed at all are rejected with an
+	// error status.
+	if res.StatusCode == http.StatusBadRequest {
+		return fmt.Errorf("%w: %v", ErrInvalidQuery, makeElasticError(data))
+	}
+
+	if res.IsError() {
+		return makeElasticError(data)
+	}
+
+	response := &validateQueryResponse{}
+	err = json.Unmarshal(data, response)
+	if err != nil {
+		return err
+	}
+
+	if response.Valid {
+		return nil
+	}
+
+	explanation := response.Error
+	for _, e := range response.Explanations {
+		if !e.Valid && e.Error != "" {
+			explanation = e.Error
+			break
+		}
+	}
+
+	return fmt.Errorf("%w: %v", ErrInvalidQuery, explanation)
+}
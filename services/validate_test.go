@@ -0,0 +1,48 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateQuery(t *testing.T) {
+	requests := 0
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/org1_persisted/_validate/query", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("explain"))
+
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "bogus") {
+			w.Write([]byte(`{"valid": false, "explanations": [{
+  "index": "org1_persisted", "valid": false,
+  "error": "[bogus] query malformed, no start_object after query name"}]}`))
+			return
+		}
+		w.Write([]byte(`{"valid": true, "explanations": [{
+  "index": "org1_persisted", "valid": true, "explanation": "*:*"}]}`))
+	})
+	defer closer()
+
+	ctx := context.Background()
+	err := ValidateQuery(ctx, "org1", "persisted",
+		`{"query": {"match_all": {}}}`)
+	assert.NoError(t, err)
+
+	err = ValidateQuery(ctx, "org1", "persisted",
+		`{"query": {"bogus": 1}}`)
+	assert.True(t, errors.Is(err, ErrInvalidQuery))
+	assert.Contains(t, err.Error(), "query malformed")
+
+	// Syntax errors are caught without asking the server.
+	err = ValidateQuery(ctx, "org1", "persisted", `{"query": {`)
+	assert.True(t, errors.Is(err, ErrInvalidQuery))
+	assert.Equal(t, 2, requests)
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+var (
+	// Guarded by mu
+	important_write_shards string
+)
+
+type importantWritesKey int
+
+// Writes made with the returned context are important (e.g. hunt
+// state changes) and wait for the configured number of active shard
+// copies (see SetImportantWriteShards) before they are made.
+func ImportantWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, importantWritesKey(0), true)
+}
+
+// The number of shard copies which must be active for important
+// writes to proceed: "all" or a number (e.g. a quorum of the
+// replicas). Otherwise the write fails after waiting instead of only
+// being made on the primary in a degraded cluster. Empty uses the
+// cluster default (the primary only).
+func SetImportantWriteShards(value string) error {
+	if value != "" && value != "all" {
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 1 {
+			return fmt.Errorf("Invalid wait_for_active_shards %q", value)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	important_write_shards = value
+	return nil
+}
+
+// The wait_for_active_shards parameter for a write.
+func getWaitForActiveShards(ctx context.Context) string {
+	important, _ := ctx.Value(importantWritesKey(0)).(bool)
+	if !important {
+		return ""
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	return important_write_shards
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportantWriteShards(t *testing.T) {
+	var requests []string
+	closer := installMockClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+
+			r.URL.Query().Get("wait_for_active_shards"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"_index": "test_persisted", "_id": "H.1", "_version": 1,
+  "result": "updated", "errors": false, "items": []}`))
+	})
+	defer closer()
+
+	defer SetImportantWriteShards("")
+
+	ctx := context.Background()
+	important := ImportantWrites(ctx)
+	record := map[string]string{"state": "RUNNING"}
+
+	// By default nothing waits.
+	err := SetElasticIndex(important, "test", "persisted", "H.1", record)
+	assert.NoError(t, err)
+
+	err = SetImportantWriteShards("all")
+	assert.NoError(t, err)
+
+	// Only important writes wait once configured.
+	err = SetElasticIndex(important, "test", "persisted", "H.1", record)
+	assert.NoError(t, err)
+
+	err = SetElasticIndex(ctx, "test", "persisted", "H.1", record)
+	assert.NoError(t, err)
+
+	_, err = UpdateIndexWithResult(important, "test", "persisted", "H.1", `{}`)
+	assert.NoError(t, err)
+
+	err = DeleteDocument(important, "test", "persisted", "H.1", false)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"PUT /test_persisted/_doc/H.1 ",
+		"PUT /test_persisted/_doc/H.1 all",
+		"PUT /test_persisted/_doc/H.1 ",
+		"POST /test_persisted/_update/H.1 all",
+		"POST /test_persisted/_refresh ",
+		"DELETE /test_persisted/_doc/H.1 all"}, requests)
+
+	for _, value := range []string{"0", "some", "-1"} {
+		assert.Error(t, SetImportantWriteShards(value), value)
+	}
+	assert.NoError(t, SetImportantWriteShards("2"))
+}
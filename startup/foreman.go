@@ -2,9 +2,12 @@ package startup
 
 import (
 	"context"
+	"time"
 
 	"www.velocidex.com/golang/cloudvelo/config"
 	"www.velocidex.com/golang/cloudvelo/foreman"
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	"www.velocidex.com/golang/cloudvelo/services/hunt_dispatcher"
 	"www.velocidex.com/golang/cloudvelo/services/orgs"
 	"www.velocidex.com/golang/velociraptor/api"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
@@ -39,6 +42,18 @@ func StartForeman(
 		return sm, err
 	}
 
+	hunt_dispatcher.StartHuntStatsReconciler(sm.Ctx, sm.Wg,
+		time.Duration(config_obj.Cloud.HuntStatsReconcileSeconds)*time.Second,
+		config_obj.Cloud.HuntStatsReconcileMaxHunts)
+
+	hunt_dispatcher.StartArchivedHuntCompactor(sm.Ctx, sm.Wg,
+		time.Duration(config_obj.Cloud.ArchivedHuntCompactSeconds)*time.Second,
+		time.Duration(config_obj.Cloud.ArchivedHuntRetentionSeconds)*time.Second)
+
+	cvelo_services.StartLifecycleManager(sm.Ctx, sm.Wg, config_obj.VeloConf(),
+		time.Duration(config_obj.Cloud.IndexLifecycleSeconds)*time.Second,
+		config_obj.Cloud.IndexLifecyclePolicies)
+
 	err = foreman.StartForemanService(sm.Ctx, sm.Wg, config_obj)
 	return sm, err
 }
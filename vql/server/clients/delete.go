@@ -64,7 +64,9 @@ func (self DeleteClientPlugin) Call(ctx context.Context,
 			return
 		}
 
-		indexes := []string{"transient", "persisted"}
+		// Include the time buckets of the client's event rows.
+		indexes := []string{cvelo_services.ResolveIndexPattern(
+			"transient", cvelo_services.MonitoringDocType), "persisted"}
 		for _, index := range indexes {
 			if arg.ReallyDoIt {
 				err = removeClientDocs(ctx, config_obj, index, arg.ClientId)
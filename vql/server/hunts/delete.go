@@ -4,7 +4,7 @@ import (
 	"context"
 
 	"github.com/Velocidex/ordereddict"
-	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	cvelo_hunt_dispatcher "www.velocidex.com/golang/cloudvelo/services/hunt_dispatcher"
 	"www.velocidex.com/golang/velociraptor/acls"
 	"www.velocidex.com/golang/velociraptor/services"
 	vql_subsystem "www.velocidex.com/golang/velociraptor/vql"
@@ -14,17 +14,6 @@ import (
 	_ "www.velocidex.com/golang/velociraptor/vql/server/hunts"
 )
 
-const (
-	archiveHuntScript = `
-{
-  "script" : {
-    "source": "ctx._source.state=\"ARCHIVED\";",
-    "lang": "painless"
-  }
-}
-`
-)
-
 type DeleteHuntArgs struct {
 	HuntId     string `vfilter:"required,field=hunt_id"`
 	ReallyDoIt bool   `vfilter:"optional,field=really_do_it"`
@@ -69,6 +58,12 @@ func (self DeleteHuntPlugin) Call(ctx context.Context,
 			return
 		}
 
+		hunt_dispatcher, err := services.GetHuntDispatcher(config_obj)
+		if err != nil {
+			scope.Log("hunt_delete: %s", err)
+			return
+		}
+
 		// Now remove the hunt from the database immediately so the
 		// GUI reflects the changes. We can not really delete the hunt
 		// because we might get updates for it for collections that
@@ -77,19 +72,15 @@ func (self DeleteHuntPlugin) Call(ctx context.Context,
 		// delete all the contents but the GUI should reflect the hunt
 		// is deleted immediately.
 		if arg.ReallyDoIt {
-			err := cvelo_services.UpdateIndex(
-				ctx, config_obj.OrgId, "persisted",
-				arg.HuntId, archiveHuntScript)
-			if err != nil {
-				scope.Log("hunt_delete: %v", err)
+			dispatcher, ok := hunt_dispatcher.(*cvelo_hunt_dispatcher.HuntDispatcher)
+			if ok {
+				err := dispatcher.ArchiveHunt(ctx, arg.HuntId)
+				if err != nil {
+					scope.Log("hunt_delete: %v", err)
+				}
 			}
 		}
 
-		hunt_dispatcher, err := services.GetHuntDispatcher(config_obj)
-		if err != nil {
-			scope.Log("hunt_delete: %s", err)
-			return
-		}
 		for flow_details := range hunt_dispatcher.GetFlows(
 			ctx, config_obj, scope, arg.HuntId, 0) {
 
@@ -2,6 +2,7 @@ package uploads
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"www.velocidex.com/golang/velociraptor/accessors"
@@ -31,6 +32,11 @@ type CloudUploader interface {
 	// final part.
 	Put(buf []byte) error
 
+	// Skip the start of the reader which was already uploaded by
+	// an interrupted upload of the same file and return the offset
+	// to continue from.
+	Resume(reader io.Reader) (uint64, error)
+
 	// Once the upload is successfull this should be called. If not a
 	// Close will cancel the upload.
 	Commit()
@@ -76,6 +76,24 @@ func (self *BufferedWriter) Copy(reader io.Reader, length uint64) error {
 	return nil
 }
 
+// Continue an interrupted upload. Must be called before any data is
+// copied.
+func (self *BufferedWriter) Resume(reader io.Reader) error {
+	offset, err := self.uploader.Resume(reader)
+	if err != nil {
+		return err
+	}
+
+	// Parts were already sent so we must complete the multipart
+	// upload.
+	if offset > 0 {
+		self.total = offset
+		self.sent_first_buffer = true
+	}
+
+	return nil
+}
+
 func NewBufferWriter(uploader CloudUploader) *BufferedWriter {
 	return &BufferedWriter{
 		buf:        make([]byte, BUFF_SIZE),
@@ -16,3 +16,10 @@ func SetUploaderService(uploader CloudUploader) error {
 	gUploaderFactory = uploader
 	return nil
 }
+
+func GetUploaderService() CloudUploader {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return gUploaderFactory
+}
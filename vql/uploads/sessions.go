@@ -0,0 +1,211 @@
+package uploads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	cvelo_services "www.velocidex.com/golang/cloudvelo/services"
+	"www.velocidex.com/golang/velociraptor/json"
+	"www.velocidex.com/golang/velociraptor/utils"
+)
+
+var (
+	ErrNotContiguous = errors.New("Upload part is not contiguous")
+)
+
+const (
+	sessionDocType = "upload_session"
+
+	// Sessions of uploads which are not resumed for this long are
+	// abandoned.
+	sessionTTL = 7 * 24 * time.Hour
+
+	// Append the part if it follows the previous part (or restarts
+	// the upload), otherwise leave the session alone. A missing
+	// session is created for the upload.
+	addPartPainless = `
+if (ctx._source.upload_id == null) {
+  ctx._source.key = params.key;
+  ctx._source.upload_id = params.upload_id;
+  ctx._source.offset = 0L;
+  ctx._source.parts = [];
+  ctx._source.doc_type = params.doc_type;
+}
+if (ctx._source.upload_id != params.upload_id) {
+  ctx.op = 'none';
+  return;
+}
+long offset = ((Number)params.offset).longValue();
+if (offset == 0L) {
+  ctx._source.offset = 0L;
+  ctx._source.parts = [];
+} else if (((Number)ctx._source.offset).longValue() != offset) {
+  ctx.op = 'none';
+  return;
+}
+if (ctx._source.parts == null) {
+  ctx._source.parts = [];
+}
+ctx._source.offset = ((Number)ctx._source.offset).longValue() +
+  ((Number)params.length).longValue();
+ctx._source.parts.add(params.part);
+ctx._source.timestamp = params.timestamp;
+ctx._source.expires = params.expires;
+`
+	addPartQuery = `
+{
+  "scripted_upsert": true,
+  "upsert": {},
+  "script": {
+    "source": %q,
+    "lang": "painless",
+    "params": {
+      "key": %q,
+      "upload_id": %q,
+      "offset": %q,
+      "length": %q,
+      "part": %q,
+      "timestamp": %q,
+      "expires": %q,
+      "doc_type": %q
+    }
+  }
+}
+`
+)
+
+// The progress of a multipart upload. This is kept in the persisted
+// index so an interrupted upload can resume at the correct offset
+// (even on a different frontend) rather than starting again.
+type UploadSession struct {
+	Key      string `json:"key"`
+	UploadId string `json:"upload_id"`
+
+	// The number of bytes uploaded so far - the next part must
+	// start here.
+	Offset uint64 `json:"offset"`
+
+	// The parts uploaded so far, in order.
+	Parts []*s3.CompletedPart `json:"parts"`
+
+	Timestamp int64  `json:"timestamp"`
+	DocType   string `json:"doc_type"`
+
+	// The session is abandoned after this time (in seconds) and
+	// removed by the expiry sweeper.
+	Expires int64 `json:"expires"`
+}
+
+type SessionTracker struct{}
+
+func NewSessionTracker() *SessionTracker {
+	return &SessionTracker{}
+}
+
+// There is at most one session for each upload key.
+func sessionId(key string) string {
+	return cvelo_services.MakeId("upload_session/" + key)
+}
+
+// Get the session of an upload which was started but not completed.
+// Returns os.ErrNotExist if there is no such upload, or it was
+// abandoned.
+func (self *SessionTracker) Get(
+	ctx context.Context, org_id, key string) (*UploadSession, error) {
+	serialized, err := cvelo_services.GetElasticRecord(
+		ctx, org_id, "persisted", sessionId(key))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UploadSession{}
+	err = json.Unmarshal(serialized, result)
+	if err != nil {
+		return nil, err
+	}
+
+	// The expiry sweeper may not have removed it yet.
+	if result.Expires > 0 &&
+		result.Expires <= utils.GetTime().Now().Unix() {
+		return nil, os.ErrNotExist
+	}
+
+	return result, nil
+}
+
+// Record a newly started upload.
+func (self *SessionTracker) Start(
+	ctx context.Context, org_id, key, upload_id string) error {
+	return self.set(ctx, org_id, &UploadSession{
+		Key:      key,
+		UploadId: upload_id,
+	})
+}
+
+// Record a part which was uploaded at offset. Parts must be
+// uploaded contiguously: offset must be where the previous part
+// ended, or 0 when the client restarts the upload from the
+// beginning.
+//
+// The check and the update are applied by the cluster in one
+// scripted update, so parts recorded at the same time (e.g. on
+// different frontends) can not overwrite each other.
+//
+// Older clients do not send the offset of their parts so every part
+// appears to be at offset 0. Their uploads can not be resumed, so
+// the session is dropped rather than restarted for each part.
+func (self *SessionTracker) AddPart(
+	ctx context.Context, org_id, key, upload_id string,
+	offset uint64, length int, part *s3.CompletedPart) error {
+	if offset == 0 && aws.Int64Value(part.PartNumber) > 1 {
+		return self.remove(ctx, org_id, key)
+	}
+
+	now := utils.GetTime().Now()
+	res, err := cvelo_services.UpdateIndexWithResult(ctx, org_id,
+		"persisted", sessionId(key), json.Format(addPartQuery,
+			addPartPainless, key, upload_id, offset, length, part,
+			now.Unix(), now.Add(sessionTTL).Unix(), sessionDocType))
+	if err != nil {
+		return err
+	}
+
+	if res.Result == cvelo_services.UpdateResultNoop {
+		return fmt.Errorf("%w: part at offset %v of upload %v",
+			ErrNotContiguous, offset, upload_id)
+	}
+	return nil
+}
+
+// The upload is finished and can not be resumed any more.
+func (self *SessionTracker) Complete(
+	ctx context.Context, org_id, key string) error {
+	return self.remove(ctx, org_id, key)
+}
+
+// The upload was abandoned. The next upload of the key starts a new
+// upload rather than resuming this one.
+func (self *SessionTracker) Abort(
+	ctx context.Context, org_id, key string) error {
+	return self.remove(ctx, org_id, key)
+}
+
+func (self *SessionTracker) remove(
+	ctx context.Context, org_id, key string) error {
+	return cvelo_services.DeleteDocument(
+		ctx, org_id, "persisted", sessionId(key), cvelo_services.NoSync)
+}
+
+func (self *SessionTracker) set(
+	ctx context.Context, org_id string, session *UploadSession) error {
+	session.Timestamp = utils.GetTime().Now().Unix()
+	session.Expires = cvelo_services.ExpiryTime(sessionTTL)
+	session.DocType = sessionDocType
+	return cvelo_services.SetElasticIndex(
+		ctx, org_id, "persisted", sessionId(session.Key), session)
+}
@@ -1,13 +1,21 @@
 package uploads_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"io/ioutil"
+	"os"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/Velocidex/ordereddict"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/sebdah/goldie"
 	"github.com/stretchr/testify/suite"
@@ -16,6 +24,7 @@ import (
 	"www.velocidex.com/golang/cloudvelo/server"
 	"www.velocidex.com/golang/cloudvelo/testsuite"
 	"www.velocidex.com/golang/cloudvelo/vql/uploads"
+	"www.velocidex.com/golang/velociraptor/accessors"
 	config_proto "www.velocidex.com/golang/velociraptor/config/proto"
 	"www.velocidex.com/golang/velociraptor/constants"
 	"www.velocidex.com/golang/velociraptor/crypto/client"
@@ -30,6 +39,7 @@ import (
 	"www.velocidex.com/golang/velociraptor/services"
 	"www.velocidex.com/golang/velociraptor/services/writeback"
 	velo_uploads "www.velocidex.com/golang/velociraptor/uploads"
+	"www.velocidex.com/golang/velociraptor/utils"
 	"www.velocidex.com/golang/velociraptor/vql/acl_managers"
 	"www.velocidex.com/golang/velociraptor/vtesting/assert"
 
@@ -274,6 +284,233 @@ func (self *UploaderTestSuite) TestSparseUploader() {
 
 }
 
+// Fails after reading limit bytes.
+type interruptedReader struct {
+	io.Reader
+	limit int
+}
+
+func (self *interruptedReader) Read(buf []byte) (int, error) {
+	if self.limit <= 0 {
+		return 0, errors.New("Connection reset")
+	}
+	if len(buf) > self.limit {
+		buf = buf[:self.limit]
+	}
+	n, err := self.Reader.Read(buf)
+	self.limit -= n
+	return n, err
+}
+
+func (self *UploaderTestSuite) TestResumeUpload() {
+	// S3 requires all parts but the last to be at least 5mb.
+	old_size := uploads.BUFF_SIZE
+	uploads.BUFF_SIZE = 5 * 1024 * 1024
+	defer func() { uploads.BUFF_SIZE = old_size }()
+
+	ctx := self.Sm.Ctx
+	wg := self.Sm.Wg
+
+	org_manager, err := services.GetOrgManager()
+	assert.NoError(self.T(), err)
+
+	org_config_obj, err := org_manager.GetOrgConfig(self.OrgId)
+	assert.NoError(self.T(), err)
+
+	request := &uploads.UploadRequest{
+		ClientId:   "C.1352adc54e292a23",
+		SessionId:  "F.1235",
+		Accessor:   "data",
+		Components: []string{"resume.txt"},
+	}
+	key := filestore.S3KeyForClientUpload(self.OrgId, request)
+	test_file := path_specs.NewSafeFilestorePath(
+		filestore.S3ComponentsForClientUpload(request)...).
+		SetType(api.PATH_TYPE_FILESTORE_ANY)
+	self.clearFilestorePath(org_config_obj, test_file)
+
+	self.startServerCommunicator(ctx, wg, org_config_obj)
+	self.startClientCommunicator(ctx, wg, org_config_obj)
+
+	resp := responder.TestResponderWithFlowId(
+		self.ConfigObj.VeloConf(), "F.1235")
+
+	builder := services.ScopeBuilder{
+		Config:       org_config_obj,
+		ClientConfig: org_config_obj.Client,
+		ACLManager:   acl_managers.NullACLManager{},
+		Logger: logging.NewPlainLogger(
+			org_config_obj, &logging.FrontendComponent),
+	}
+
+	manager, err := services.GetRepositoryManager(org_config_obj)
+	assert.NoError(self.T(), err)
+
+	scope := manager.BuildScope(builder)
+	defer scope.Close()
+
+	scope.SetContext(constants.SCOPE_RESPONDER_CONTEXT, resp)
+
+	// Two full parts and a short last part.
+	data := make([]byte, 2*uploads.BUFF_SIZE+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	part_size := uint64(uploads.BUFF_SIZE)
+
+	name := accessors.MustNewGenericOSPath("resume.txt")
+	newUploader := func() uploads.CloudUploader {
+		uploader, err := uploads.GetUploaderService().New(
+			ctx, scope, name, "data", name,
+			time.Time{}, time.Time{}, time.Time{}, time.Time{},
+			int64(len(data)), "")
+		assert.NoError(self.T(), err)
+		return uploader
+	}
+
+	// The client goes away after uploading the first part.
+	first := newUploader()
+	buffer := uploads.NewBufferWriter(first)
+	err = buffer.Resume(bytes.NewReader(data))
+	assert.NoError(self.T(), err)
+
+	err = buffer.Copy(&interruptedReader{
+		Reader: bytes.NewReader(data), limit: int(part_size) + 10},
+		uint64(len(data)))
+	assert.Error(self.T(), err)
+
+	// The server remembers where the upload got to.
+	tracker := uploads.NewSessionTracker()
+	session, err := tracker.Get(ctx, self.OrgId, key)
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), part_size, session.Offset)
+	assert.Equal(self.T(), 1, len(session.Parts))
+
+	// Uploading the file again continues after the first part.
+	second := newUploader()
+	offset, err := second.Resume(bytes.NewReader(data))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), part_size, offset)
+
+	// So does another upload of the file at the same time, but only
+	// one of them can upload the next part.
+	third := newUploader()
+	offset, err = third.Resume(bytes.NewReader(data))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), part_size, offset)
+
+	err = second.Put(data[part_size : 2*part_size])
+	assert.NoError(self.T(), err)
+
+	err = third.Put(data[part_size : 2*part_size])
+	assert.Error(self.T(), err)
+	assert.True(self.T(), strings.Contains(err.Error(), "409"))
+
+	err = second.Put(data[2*part_size:])
+	assert.NoError(self.T(), err)
+
+	second.Commit()
+	err = second.Close()
+	assert.NoError(self.T(), err)
+
+	// The hashes cover the whole file, including the part uploaded
+	// before the interruption.
+	sha_sum := sha256.Sum256(data)
+	upload_res := second.GetVQLResponse()
+	assert.Equal(self.T(), uint64(len(data)), upload_res.Size)
+	assert.Equal(self.T(), hex.EncodeToString(sha_sum[:]), upload_res.Sha256)
+
+	file_store_factory := file_store.GetFileStore(org_config_obj)
+	reader, err := file_store_factory.ReadFile(test_file)
+	assert.NoError(self.T(), err)
+
+	stored, err := ioutil.ReadAll(reader)
+	assert.NoError(self.T(), err)
+	assert.True(self.T(), bytes.Equal(data, stored))
+
+	// Once complete the upload can not be resumed.
+	_, err = tracker.Get(ctx, self.OrgId, key)
+	assert.True(self.T(), errors.Is(err, os.ErrNotExist))
+
+	// A session whose upload is gone from S3 is not resumed.
+	err = tracker.Start(ctx, self.OrgId, key, "U.Gone")
+	assert.NoError(self.T(), err)
+
+	fourth := newUploader()
+	offset, err = fourth.Resume(bytes.NewReader(data))
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), uint64(0), offset)
+}
+
+func (self *UploaderTestSuite) TestSessionTracker() {
+	ctx := self.Sm.Ctx
+
+	clock := &utils.MockClock{MockNow: time.Unix(1661391000, 0)}
+	defer utils.MockTime(clock)()
+
+	tracker := uploads.NewSessionTracker()
+	err := tracker.Start(ctx, "test", "Key.1", "U.1")
+	assert.NoError(self.T(), err)
+
+	for i, offset := range []uint64{0, 10} {
+		err = tracker.AddPart(ctx, "test", "Key.1", "U.1", offset, 10,
+			&s3.CompletedPart{PartNumber: aws.Int64(int64(i + 1))})
+		assert.NoError(self.T(), err)
+	}
+
+	// Parts which leave a gap are rejected.
+	err = tracker.AddPart(ctx, "test", "Key.1", "U.1", 30, 10,
+		&s3.CompletedPart{PartNumber: aws.Int64(4)})
+	assert.True(self.T(), errors.Is(err, uploads.ErrNotContiguous))
+
+	// So are parts of another upload of the key.
+	err = tracker.AddPart(ctx, "test", "Key.1", "U.2", 20, 10,
+		&s3.CompletedPart{PartNumber: aws.Int64(3)})
+	assert.True(self.T(), errors.Is(err, uploads.ErrNotContiguous))
+
+	session, err := tracker.Get(ctx, "test", "Key.1")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), uint64(20), session.Offset)
+	assert.Equal(self.T(), 2, len(session.Parts))
+
+	// Restarting the upload from the beginning resets it.
+	err = tracker.AddPart(ctx, "test", "Key.1", "U.1", 0, 10,
+		&s3.CompletedPart{PartNumber: aws.Int64(1)})
+	assert.NoError(self.T(), err)
+
+	session, err = tracker.Get(ctx, "test", "Key.1")
+	assert.NoError(self.T(), err)
+	assert.Equal(self.T(), uint64(10), session.Offset)
+	assert.Equal(self.T(), 1, len(session.Parts))
+
+	// Older clients send every part at offset 0 so their uploads
+	// are not tracked.
+	err = tracker.AddPart(ctx, "test", "Key.1", "U.1", 0, 10,
+		&s3.CompletedPart{PartNumber: aws.Int64(2)})
+	assert.NoError(self.T(), err)
+
+	_, err = tracker.Get(ctx, "test", "Key.1")
+	assert.True(self.T(), errors.Is(err, os.ErrNotExist))
+
+	// Aborted uploads can not be resumed.
+	err = tracker.Start(ctx, "test", "Key.2", "U.3")
+	assert.NoError(self.T(), err)
+
+	err = tracker.Abort(ctx, "test", "Key.2")
+	assert.NoError(self.T(), err)
+
+	_, err = tracker.Get(ctx, "test", "Key.2")
+	assert.True(self.T(), errors.Is(err, os.ErrNotExist))
+
+	// Neither can abandoned uploads.
+	err = tracker.Start(ctx, "test", "Key.3", "U.4")
+	assert.NoError(self.T(), err)
+
+	clock.MockNow = clock.MockNow.Add(8 * 24 * time.Hour)
+	_, err = tracker.Get(ctx, "test", "Key.3")
+	assert.True(self.T(), errors.Is(err, os.ErrNotExist))
+}
+
 func (self *UploaderTestSuite) checkForKey(filter string) []string {
 	// Check the actual path in the bucket we are in.
 	session, err := filestore.GetS3Session(self.ConfigObj)
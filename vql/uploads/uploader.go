@@ -17,6 +17,7 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -60,6 +61,11 @@ type UploadRequest struct {
 type UploadResponse struct {
 	Key      string `json:"key"`
 	UploadId string `json:"upload_id"`
+
+	// Set when resuming an interrupted upload: The next part should
+	// be uploaded from Offset, after the Parts already uploaded.
+	Offset uint64              `json:"offset,omitempty"`
+	Parts  []*s3.CompletedPart `json:"parts,omitempty"`
 }
 
 // PUT HTTP operations:
@@ -67,6 +73,9 @@ type UploadPutRequest struct {
 	Key      string `json:"key"`
 	UploadId string `json:"upload_id"`
 	Part     int    `json:"partNumber"`
+
+	// The offset in the file of the start of this part.
+	Offset uint64 `json:"offset"`
 }
 
 type UploadCompletionRequest struct {
@@ -75,6 +84,11 @@ type UploadCompletionRequest struct {
 	Parts    []*s3.CompletedPart `json:"parts"`
 }
 
+type UploadAbortRequest struct {
+	Key      string `json:"key"`
+	UploadId string `json:"upload_id"`
+}
+
 type VeloCloudUploader struct {
 	mu sync.Mutex
 
@@ -101,6 +115,10 @@ type VeloCloudUploader struct {
 
 	parts []*s3.CompletedPart
 
+	// Where an interrupted upload left off, from the server.
+	resume_offset uint64
+	resume_parts  []*s3.CompletedPart
+
 	Responder responder.Responder
 
 	// The token is used to authenticate to the upload endpoints. It
@@ -308,12 +326,35 @@ func (self *VeloCloudUploader) Start(ctx context.Context) error {
 	// Remember the key
 	self.key = upload_response.Key
 	self.upload_id = upload_response.UploadId
+	self.resume_offset = upload_response.Offset
+	self.resume_parts = upload_response.Parts
 
 	self.upload_number = self.Responder.NextUploadId()
 
 	return nil
 }
 
+// Continue an interrupted upload where it left off. The start of the
+// reader which was already uploaded is skipped (but still hashed).
+// If this is not called the upload restarts from the beginning.
+func (self *VeloCloudUploader) Resume(reader io.Reader) (uint64, error) {
+	if self.resume_offset == 0 {
+		return 0, nil
+	}
+
+	n, err := io.CopyN(io.MultiWriter(self.md5_sum, self.sha_sum),
+		reader, int64(self.resume_offset))
+	if err != nil {
+		return 0, err
+	}
+
+	self.offset = uint64(n)
+	self.parts = self.resume_parts
+	self.part = uint64(len(self.parts)) + 1
+
+	return self.offset, nil
+}
+
 func (self *VeloCloudUploader) PutWhole(buf []byte) error {
 	return self.Put(buf)
 }
@@ -326,6 +367,7 @@ func (self *VeloCloudUploader) Put(buf []byte) error {
 		Key:      self.key,
 		UploadId: self.upload_id,
 		Part:     int(self.part),
+		Offset:   self.offset,
 	}
 
 	// Write the buffer using a PUT request.
@@ -178,6 +178,14 @@ func (self UploadFunction) Upload(
 	// send them. This is managed by the BufferedWriter object which
 	// wraps the uploader.
 	buffer := NewBufferWriter(uploader)
+	err = buffer.Resume(reader)
+	if err != nil {
+		scope.Log("ERROR: Resuming %v: %v", dest, err)
+		return &uploads.UploadResponse{
+			Error: err.Error(),
+		}, nil
+	}
+
 	err = buffer.Copy(reader, MAX_FILE_LENGTH)
 	if err != nil {
 		scope.Log("ERROR: Finalizing %v: %v", dest, err)